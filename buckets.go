@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+
+	iradix "github.com/hashicorp/go-immutable-radix"
+)
+
+// HistogramBuckets configures the bucket boundaries sinks use when recording
+// samples into histograms. Overrides are keyed by metric prefix, with '.' as
+// the separator, and the longest matching prefix wins, so latency and size
+// distributions can use very different ranges.
+type HistogramBuckets struct {
+	defaultBuckets []float64
+	overrides      *iradix.Tree
+}
+
+// NewHistogramBuckets creates a HistogramBuckets which uses defaultBuckets for
+// every key that doesn't match one of the prefixes in overrides. If
+// defaultBuckets is nil, only keys matching an override are recorded as
+// histograms. Bucket boundaries are sorted in increasing order.
+func NewHistogramBuckets(defaultBuckets []float64, overrides map[string][]float64) *HistogramBuckets {
+	tree := iradix.New()
+	for prefix, buckets := range overrides {
+		tree, _, _ = tree.Insert([]byte(prefix), sortedBuckets(buckets))
+	}
+	return &HistogramBuckets{
+		defaultBuckets: sortedBuckets(defaultBuckets),
+		overrides:      tree,
+	}
+}
+
+// For returns the bucket upper bounds for the given key, or nil if the key
+// should not be recorded as a histogram.
+func (b *HistogramBuckets) For(key []string) []float64 {
+	if b.overrides.Len() == 0 {
+		return b.defaultBuckets
+	}
+	_, buckets, ok := b.overrides.Root().LongestPrefix([]byte(strings.Join(key, ".")))
+	if !ok {
+		return b.defaultBuckets
+	}
+	return buckets.([]float64)
+}
+
+// LinearBuckets returns count buckets, each width wide, the lowest of which
+// has an upper bound of start.
+func LinearBuckets(start, width float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start + float64(i)*width
+	}
+	return buckets
+}
+
+// ExponentialBuckets returns count buckets, the lowest of which has an upper
+// bound of start and each following one an upper bound of factor times the
+// previous one.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+func sortedBuckets(buckets []float64) []float64 {
+	if buckets == nil {
+		return nil
+	}
+	sorted := make([]float64, len(buckets))
+	copy(sorted, buckets)
+	sort.Float64s(sorted)
+	return sorted
+}
+
+// HistogramSink is implemented by sinks which can record samples into
+// histograms. New configures it with Config.HistogramBuckets.
+type HistogramSink interface {
+	MetricSink
+
+	// SetHistogramBuckets sets the buckets used for samples recorded from
+	// now on.
+	SetHistogramBuckets(buckets *HistogramBuckets)
+}
+
+// configureHistogramBuckets passes buckets to sink if it supports histograms
+func configureHistogramBuckets(sink MetricSink, buckets *HistogramBuckets) {
+	if hs, ok := sink.(HistogramSink); ok {
+		hs.SetHistogramBuckets(buckets)
+	}
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+)
+
+type mockHistogramSink struct {
+	MockSink
+	buckets *HistogramBuckets
+}
+
+func (m *mockHistogramSink) SetHistogramBuckets(buckets *HistogramBuckets) {
+	m.buckets = buckets
+}
+
+func TestHistogramBuckets_For(t *testing.T) {
+	b := NewHistogramBuckets([]float64{10, 1, 100}, map[string][]float64{
+		"http.response_size": ExponentialBuckets(1024, 4, 3),
+		"db":                 nil,
+	})
+
+	for _, tc := range []struct {
+		key    []string
+		expect []float64
+	}{
+		{[]string{"http", "latency"}, []float64{1, 10, 100}},
+		{[]string{"http", "response_size"}, []float64{1024, 4096, 16384}},
+		{[]string{"db", "query"}, nil},
+	} {
+		if got := b.For(tc.key); !reflect.DeepEqual(got, tc.expect) {
+			t.Fatalf("For(%v) = %v, want %v", tc.key, got, tc.expect)
+		}
+	}
+
+	onlyOverrides := NewHistogramBuckets(nil, map[string][]float64{"a": {1}})
+	if got := onlyOverrides.For([]string{"b"}); got != nil {
+		t.Fatalf("expected no buckets, got %v", got)
+	}
+}
+
+func TestLinearBuckets(t *testing.T) {
+	if got, want := LinearBuckets(5, 10, 3), []float64{5, 15, 25}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestNew_HistogramBuckets(t *testing.T) {
+	h := &mockHistogramSink{}
+	plain := &MockSink{}
+	buckets := NewHistogramBuckets(LinearBuckets(1, 1, 5), nil)
+
+	conf := DefaultConfig("")
+	conf.EnableRuntimeMetrics = false
+	conf.HistogramBuckets = buckets
+	if _, err := New(conf, FanoutSink{plain, h}); err != nil {
+		t.Fatal(err)
+	}
+	if h.buckets != buckets {
+		t.Fatalf("expected buckets to be configured on the histogram sink")
+	}
+
+	routed := &mockHistogramSink{}
+	r := NewRoutingSink()
+	r.Route("a", routed, routed)
+	if _, err := New(conf, r); err != nil {
+		t.Fatal(err)
+	}
+	if routed.buckets != buckets {
+		t.Fatalf("expected buckets to be configured on the routed sink")
+	}
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"runtime/debug"
+	"sync"
+)
+
+var (
+	buildInfoOnce   sync.Once
+	buildInfoLabels []Label
+)
+
+// EmitBuildInfo emits the build.info gauge with the value 1, labeled with
+// the build of the binary read from debug.ReadBuildInfo:
+//
+//	path - the path of the main module
+//	version - its version, (devel) unless built from a module download
+//	revision - the VCS revision it was built from, if known
+//	go_version - the version of Go it was built with
+//
+// It is emitted every ProfileInterval if EnableBuildInfo is set, so sinks
+// expiring gauges keep it. Nothing is emitted by binaries built without
+// module support.
+func (m *Metrics) EmitBuildInfo() {
+	buildInfoOnce.Do(func() {
+		bi, ok := debug.ReadBuildInfo()
+		if !ok {
+			return
+		}
+		revision := "unknown"
+		for _, s := range bi.Settings {
+			if s.Key == "vcs.revision" {
+				revision = s.Value
+			}
+		}
+		buildInfoLabels = []Label{
+			{Name: "path", Value: bi.Main.Path},
+			{Name: "version", Value: bi.Main.Version},
+			{Name: "revision", Value: revision},
+			{Name: "go_version", Value: bi.GoVersion},
+		}
+	})
+	if buildInfoLabels != nil {
+		m.SetGaugeWithLabels([]string{"build", "info"}, 1, buildInfoLabels)
+	}
+}
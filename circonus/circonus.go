@@ -3,22 +3,69 @@
 package circonus
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
 	"strings"
+	"time"
 
 	cgm "github.com/circonus-labs/circonus-gometrics"
+	iradix "github.com/hashicorp/go-immutable-radix"
 	"github.com/hashicorp/go-metrics"
 )
 
 // CirconusSink provides an interface to forward metrics to Circonus with
-// automatic check creation and metric management
+// automatic check creation and metric management. Samples, including timers,
+// are recorded into Circonus log-linear histograms, which are submitted as
+// such, so percentiles can be computed by Circonus over any period.
 type CirconusSink struct {
-	metrics *cgm.CirconusMetrics
+	metrics   *cgm.CirconusMetrics
+	sanitizer metrics.Sanitizer
+	filter    *iradix.Tree
+}
+
+// DefaultSanitizer is the Sanitizer used by a CirconusSink unless replaced
+// with SetSanitizer. It replaces spaces with underscores.
+var DefaultSanitizer metrics.Sanitizer = &metrics.RuneSanitizer{
+	Name: func(r rune) rune {
+		switch r {
+		case ' ':
+			return '_'
+		default:
+			return r
+		}
+	},
 }
 
 // Config options for CirconusSink
 // See https://github.com/circonus-labs/circonus-gometrics for configuration options
 type Config cgm.Config
 
+// SetTLSConfig makes the sink verify self-hosted brokers, and the API, with
+// tlsConfig, e.g. one trusting the CA of a private PKI, rather than the
+// certificate of the Circonus CA fetched from the API.
+func (c *Config) SetTLSConfig(tlsConfig *tls.Config) {
+	c.CheckManager.Broker.TLSConfig = tlsConfig
+	c.CheckManager.API.TLSConfig = tlsConfig
+}
+
+// LoadCAFile is SetTLSConfig with a configuration trusting the PEM encoded
+// CA certificates in the file at path.
+func (c *Config) LoadCAFile(path string) error {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no CA certificates found in %s", path)
+	}
+	c.SetTLSConfig(&tls.Config{RootCAs: pool})
+	return nil
+}
+
 // NewCirconusSink - create new metric sink for circonus
 //
 // one of the following must be supplied:
@@ -30,6 +77,10 @@ type Config cgm.Config
 //
 // Note: If submission url is supplied w/o an api token, the public circonus ca cert will be used
 // to verify the broker for metrics submission.
+//
+// When a check is searched for or created, CheckManager.Check.SearchTag and
+// InstanceID identify it, CheckManager.Check.Tags are added to a new check,
+// and CheckManager.Broker.ID or SelectTag choose the broker it is created on.
 func NewCirconusSink(cc *Config) (*CirconusSink, error) {
 	cfg := cgm.Config{}
 	if cc != nil {
@@ -46,6 +97,65 @@ func NewCirconusSink(cc *Config) (*CirconusSink, error) {
 	}, nil
 }
 
+func init() {
+	metrics.RegisterSinkScheme("circonus", NewCirconusSinkFromURL)
+}
+
+// NewCirconusSinkFromURL creates a CirconusSink from a URL and starts it.
+// Importing the package registers it as the "circonus" scheme of
+// metrics.NewMetricSinkFromURL.
+// The host and port are ignored, the configuration is taken from the query
+// parameters:
+//
+//    api_token, api_app, api_url - the API token, app and URL
+//    submission_url, check_id - an existing check to submit to
+//    instance_id, search_tag, display_name, target_host - the check to search
+//        for or create
+//    check_tags - comma separated tags of a created check, e.g. env:prod,dc:1
+//    broker_id, broker_select_tag - the broker of a created check
+//    ca_file - the PEM encoded CA certificates of brokers, see LoadCAFile
+//    allow, block - prefixes of the metrics to submit or not, see SetFilter
+//    interval - how often metrics are submitted, e.g. 10s
+func NewCirconusSinkFromURL(u *url.URL) (metrics.MetricSink, error) {
+	params := u.Query()
+
+	cfg := &Config{}
+	if v := params.Get("interval"); v != "" {
+		if _, err := time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("Bad 'interval' param: %s", err)
+		}
+		cfg.Interval = v
+	}
+	cfg.CheckManager.API.TokenKey = params.Get("api_token")
+	cfg.CheckManager.API.TokenApp = params.Get("api_app")
+	cfg.CheckManager.API.URL = params.Get("api_url")
+
+	check := &cfg.CheckManager.Check
+	check.SubmissionURL = params.Get("submission_url")
+	check.ID = params.Get("check_id")
+	check.InstanceID = params.Get("instance_id")
+	check.SearchTag = params.Get("search_tag")
+	check.DisplayName = params.Get("display_name")
+	check.TargetHost = params.Get("target_host")
+	check.Tags = params.Get("check_tags")
+
+	cfg.CheckManager.Broker.ID = params.Get("broker_id")
+	cfg.CheckManager.Broker.SelectTag = params.Get("broker_select_tag")
+	if v := params.Get("ca_file"); v != "" {
+		if err := cfg.LoadCAFile(v); err != nil {
+			return nil, fmt.Errorf("Bad 'ca_file' param: %s", err)
+		}
+	}
+
+	sink, err := NewCirconusSink(cfg)
+	if err != nil {
+		return nil, err
+	}
+	sink.SetFilter(params["allow"], params["block"])
+	sink.Start()
+	return sink, nil
+}
+
 // Start submitting metrics to Circonus (flush every SubmitInterval)
 func (s *CirconusSink) Start() {
 	s.metrics.Start()
@@ -58,12 +168,18 @@ func (s *CirconusSink) Flush() {
 
 // SetGauge sets value for a gauge metric
 func (s *CirconusSink) SetGauge(key []string, val float32) {
+	if !s.allowed(key) {
+		return
+	}
 	flatKey := s.flattenKey(key)
 	s.metrics.SetGauge(flatKey, int64(val))
 }
 
 // SetGaugeWithLabels sets value for a gauge metric with the given labels
 func (s *CirconusSink) SetGaugeWithLabels(key []string, val float32, labels []metrics.Label) {
+	if !s.allowed(key) {
+		return
+	}
 	flatKey := s.flattenKeyLabels(key, labels)
 	s.metrics.SetGauge(flatKey, int64(val))
 }
@@ -75,28 +191,58 @@ func (s *CirconusSink) EmitKey(key []string, val float32) {
 
 // IncrCounter increments a counter metric
 func (s *CirconusSink) IncrCounter(key []string, val float32) {
+	if !s.allowed(key) {
+		return
+	}
 	flatKey := s.flattenKey(key)
 	s.metrics.IncrementByValue(flatKey, uint64(val))
 }
 
 // IncrCounterWithLabels increments a counter metric with the given labels
 func (s *CirconusSink) IncrCounterWithLabels(key []string, val float32, labels []metrics.Label) {
+	if !s.allowed(key) {
+		return
+	}
 	flatKey := s.flattenKeyLabels(key, labels)
 	s.metrics.IncrementByValue(flatKey, uint64(val))
 }
 
 // AddSample adds a sample to a histogram metric
 func (s *CirconusSink) AddSample(key []string, val float32) {
+	if !s.allowed(key) {
+		return
+	}
 	flatKey := s.flattenKey(key)
 	s.metrics.RecordValue(flatKey, float64(val))
 }
 
 // AddSampleWithLabels adds a sample to a histogram metric with the given labels
 func (s *CirconusSink) AddSampleWithLabels(key []string, val float32, labels []metrics.Label) {
+	if !s.allowed(key) {
+		return
+	}
 	flatKey := s.flattenKeyLabels(key, labels)
 	s.metrics.RecordValue(flatKey, float64(val))
 }
 
+// AddPrecisionSample adds a float64 sample to a histogram metric
+func (s *CirconusSink) AddPrecisionSample(key []string, val float64) {
+	if !s.allowed(key) {
+		return
+	}
+	flatKey := s.flattenKey(key)
+	s.metrics.RecordValue(flatKey, val)
+}
+
+// AddPrecisionSampleWithLabels adds a float64 sample to a histogram metric with the given labels
+func (s *CirconusSink) AddPrecisionSampleWithLabels(key []string, val float64, labels []metrics.Label) {
+	if !s.allowed(key) {
+		return
+	}
+	flatKey := s.flattenKeyLabels(key, labels)
+	s.metrics.RecordValue(flatKey, val)
+}
+
 // Shutdown blocks while flushing metrics to the backend.
 func (s *CirconusSink) Shutdown() {
 	// The version of circonus metrics in go.mod (v2.3.1), and the current
@@ -106,17 +252,55 @@ func (s *CirconusSink) Shutdown() {
 	s.metrics.Flush()
 }
 
+// SetFilter limits the metrics submitted to Circonus, which bills for
+// every metric, to a curated subset, while they still reach the other sinks
+// of the application. Metrics are submitted if the key starts with one of
+// the allow prefixes, and not if it starts with one of the block prefixes.
+// Prefixes use '.' as the separator and the longest matching one wins, as
+// for the AllowedPrefixes and BlockedPrefixes of go-metrics' Config. Keys
+// matching neither are submitted only if allow is empty. It must be called
+// before any metrics are emitted.
+func (s *CirconusSink) SetFilter(allow, block []string) {
+	if len(allow) == 0 && len(block) == 0 {
+		s.filter = nil
+		return
+	}
+	filter := iradix.New()
+	for _, prefix := range allow {
+		filter, _, _ = filter.Insert([]byte(prefix), true)
+	}
+	for _, prefix := range block {
+		filter, _, _ = filter.Insert([]byte(prefix), false)
+	}
+	// The empty prefix matches the keys no other prefix does
+	if _, ok := filter.Get([]byte("")); !ok {
+		filter, _, _ = filter.Insert([]byte(""), len(allow) == 0)
+	}
+	s.filter = filter
+}
+
+// allowed returns whether the metric with key passes the filter
+func (s *CirconusSink) allowed(key []string) bool {
+	if s.filter == nil {
+		return true
+	}
+	_, allowed, _ := s.filter.Root().LongestPrefix([]byte(strings.Join(key, ".")))
+	return allowed.(bool)
+}
+
+// SetSanitizer replaces the DefaultSanitizer used to normalize metric names.
+// It must be called before any metrics are emitted.
+func (s *CirconusSink) SetSanitizer(sanitizer metrics.Sanitizer) {
+	s.sanitizer = sanitizer
+}
+
 // Flattens key to Circonus metric name
 func (s *CirconusSink) flattenKey(parts []string) string {
 	joined := strings.Join(parts, "`")
-	return strings.Map(func(r rune) rune {
-		switch r {
-		case ' ':
-			return '_'
-		default:
-			return r
-		}
-	}, joined)
+	if s.sanitizer == nil {
+		return DefaultSanitizer.SanitizeName(joined)
+	}
+	return s.sanitizer.SanitizeName(joined)
 }
 
 // Flattens the key along with labels for formatting, removes spaces
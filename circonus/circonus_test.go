@@ -1,11 +1,15 @@
 package circonus
 
 import (
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -34,6 +38,100 @@ func TestNewCirconusSink(t *testing.T) {
 	// see circonus-gometrics/checkmgr/checkmgr_test.go for testing of api token
 }
 
+func TestNewCirconusSinkFromURL(t *testing.T) {
+	_, err := NewCirconusSinkFromURL(&url.URL{RawQuery: "submission_url=http://127.0.0.1:43191/&interval=often"})
+	if err == nil || !strings.Contains(err.Error(), "Bad 'interval' param") {
+		t.Fatalf("Expected a bad interval error, got '%v'", err)
+	}
+
+	q := make(chan string)
+	server := fakeBroker(q)
+	defer server.Close()
+
+	params := url.Values{"submission_url": {server.URL}, "interval": {"0s"}, "check_tags": {"env:prod"}}
+	sink, err := NewCirconusSinkFromURL(&url.URL{Scheme: "circonus", RawQuery: params.Encode()})
+	if err != nil {
+		t.Fatalf("Expected no error, got '%v'", err)
+	}
+	cs := sink.(*CirconusSink)
+
+	go func() {
+		cs.IncrCounter([]string{"foo", "bar"}, 1)
+		cs.Flush()
+	}()
+
+	expect := "{\"foo`bar\":{\"_type\":\"L\",\"_value\":1}}"
+	if actual := <-q; actual != expect {
+		t.Errorf("Expected '%s', got '%s'", expect, actual)
+	}
+}
+
+func TestLoadCAFile(t *testing.T) {
+	q := make(chan string)
+	server := httptest.NewTLSServer(brokerHandler(q))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(path, cert, 0644); err != nil {
+		t.Fatalf("Expected no error, got '%v'", err)
+	}
+
+	cfg := &Config{}
+	cfg.CheckManager.Check.SubmissionURL = server.URL
+	if err := cfg.LoadCAFile(path); err != nil {
+		t.Fatalf("Expected no error, got '%v'", err)
+	}
+	cs, err := NewCirconusSink(cfg)
+	if err != nil {
+		t.Fatalf("Expected no error, got '%v'", err)
+	}
+
+	go func() {
+		cs.IncrCounter([]string{"foo", "bar"}, 1)
+		cs.Flush()
+	}()
+
+	expect := "{\"foo`bar\":{\"_type\":\"L\",\"_value\":1}}"
+	if actual := <-q; actual != expect {
+		t.Errorf("Expected '%s', got '%s'", expect, actual)
+	}
+
+	if err := cfg.LoadCAFile(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Fatalf("Expected an error for a missing file")
+	}
+}
+
+func TestSetFilter(t *testing.T) {
+	cs := &CirconusSink{}
+	cs.SetFilter([]string{"api", "db.query"}, []string{"api.debug"})
+	for _, tc := range []struct {
+		key    []string
+		expect bool
+	}{
+		{[]string{"api", "requests"}, true},
+		{[]string{"api", "debug", "timer"}, false},
+		{[]string{"db", "query", "time"}, true},
+		{[]string{"db", "pool"}, false},
+		{[]string{"runtime", "alloc_bytes"}, false},
+	} {
+		if actual := cs.allowed(tc.key); actual != tc.expect {
+			t.Errorf("allowed(%v) = %v, expected %v", tc.key, actual, tc.expect)
+		}
+	}
+
+	// Without allow prefixes everything not blocked is submitted
+	cs.SetFilter(nil, []string{"runtime"})
+	if !cs.allowed([]string{"api", "requests"}) || cs.allowed([]string{"runtime", "alloc_bytes"}) {
+		t.Errorf("block only filter failed")
+	}
+
+	cs.SetFilter(nil, nil)
+	if !cs.allowed([]string{"runtime", "alloc_bytes"}) {
+		t.Errorf("no filter failed")
+	}
+}
+
 func TestFlattenKey(t *testing.T) {
 	var testKeys = []struct {
 		input    []string
@@ -54,6 +152,11 @@ func TestFlattenKey(t *testing.T) {
 }
 
 func fakeBroker(q chan string) *httptest.Server {
+	return httptest.NewServer(brokerHandler(q))
+}
+
+// brokerHandler accepts submissions, passing their bodies to q
+func brokerHandler(q chan string) http.Handler {
 	handler := func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(200)
 		w.Header().Set("Content-Type", "application/json")
@@ -68,7 +171,7 @@ func fakeBroker(q chan string) *httptest.Server {
 		}
 	}
 
-	return httptest.NewServer(http.HandlerFunc(handler))
+	return http.HandlerFunc(handler)
 }
 
 func TestSetGauge(t *testing.T) {
@@ -155,6 +258,39 @@ func TestAddSample(t *testing.T) {
 	}
 }
 
+func TestAddSampleWithLabels(t *testing.T) {
+	q := make(chan string)
+
+	server := fakeBroker(q)
+	defer server.Close()
+
+	cfg := &Config{}
+	cfg.CheckManager.Check.SubmissionURL = server.URL
+
+	cs, err := NewCirconusSink(cfg)
+	if err != nil {
+		t.Errorf("Expected no error, got '%v'", err)
+	}
+
+	// All values go into the buckets of one histogram rather than replacing
+	// each other
+	go func() {
+		labels := []metrics.Label{{Name: "method", Value: "GET"}}
+		for _, v := range []float32{1, 1, 25, 250} {
+			cs.AddSampleWithLabels([]string{"foo", "bar"}, v, labels)
+		}
+		cs.Flush()
+	}()
+
+	expect := "{\"foo`bar`GET\":{\"_type\":\"n\",\"_value\":[\"H[1.0e+00]=2\",\"H[2.5e+01]=1\",\"H[2.5e+02]=1\"]}}"
+	actual := <-q
+
+	if actual != expect {
+		t.Errorf("Expected '%s', got '%s'", expect, actual)
+
+	}
+}
+
 func TestMetricSinkInterface(t *testing.T) {
 	var cs *CirconusSink
 	_ = metrics.MetricSink(cs)
@@ -0,0 +1,57 @@
+package metrics
+
+import "time"
+
+// Clock tells the time and paces periodic work, so tests can control the
+// time seen by Metrics and the InmemSink, see Config.Clock and
+// InmemSink.SetClock. SystemClock uses the time package.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+	After(d time.Duration) <-chan time.Time
+}
+
+// Ticker is a time.Ticker of a Clock
+type Ticker interface {
+	// C returns the channel the ticks are delivered on
+	C() <-chan time.Time
+	Stop()
+}
+
+// SystemClock is the Clock of the time package
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// clockValue wraps Clocks of different types in an atomic.Value
+type clockValue struct {
+	Clock
+}
+
+// clockOrSystem returns c, or SystemClock if c is nil
+func clockOrSystem(c Clock) Clock {
+	if c == nil {
+		return SystemClock
+	}
+	return c
+}
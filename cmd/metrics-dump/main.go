@@ -0,0 +1,181 @@
+// Command metrics-dump renders go-metrics interval summaries as a sorted
+// table, refreshing it as new intervals arrive, like a top(1) for metrics.
+//
+// It either polls an HTTP endpoint serving InmemSink.DisplayMetrics or
+// InmemSink.DisplayHandler:
+//
+//	metrics-dump -url http://localhost:8500/v1/agent/metrics
+//
+// or reads the JSON dumps of an InmemSignal using DumpFormatJSON from files or
+// standard input:
+//
+//	tail -f metrics.log | metrics-dump
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("metrics-dump", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	url := flags.String("url", "", "poll metrics from this HTTP endpoint instead of reading dumps")
+	refresh := flags.Duration("refresh", 2*time.Second, "how often to poll the endpoint")
+	once := flags.Bool("once", false, "render a single table and exit")
+	sortBy := flags.String("sort", "name", "sort rows by name, value or count")
+	filter := flags.String("filter", "", "only show metrics whose name contains this")
+	noColor := flags.Bool("no-color", false, "disable colors and screen clearing, which are only enabled on a terminal")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	opts := tableOptions{
+		sortBy: *sortBy,
+		filter: *filter,
+		color:  !*noColor && isTerminal(stdout),
+	}
+	if err := opts.validate(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	render := func(summary *metrics.MetricsSummary) {
+		w := bufio.NewWriter(stdout)
+		if opts.color && !*once {
+			// Clear the screen and move the cursor home
+			fmt.Fprint(w, "\x1b[H\x1b[2J")
+		}
+		writeTable(w, summary, opts)
+		w.Flush()
+	}
+
+	var err error
+	if *url != "" {
+		err = poll(*url, *refresh, *once, render, stderr, nil)
+	} else {
+		err = readDumps(stdin, flags.Args(), *once, render)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// poll fetches the summary from url every refresh, until stop is closed. If
+// once is set, it returns after the first fetch, or its error. Otherwise
+// failed fetches, e.g. while the target restarts, are reported to stderr below
+// the last table and retried.
+func poll(url string, refresh time.Duration, once bool, render func(*metrics.MetricsSummary), stderr io.Writer, stop <-chan struct{}) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	for {
+		summary, err := fetch(client, url)
+		switch {
+		case err != nil && once:
+			return err
+		case err != nil:
+			fmt.Fprintf(stderr, "%s: %v, retrying in %s\n", time.Now().Format("15:04:05"), err, refresh)
+		default:
+			render(summary)
+			if once {
+				return nil
+			}
+		}
+		select {
+		case <-time.After(refresh):
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// isTerminal returns whether w is a terminal, so colors and screen clearing
+// don't end up in files and pipes
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func fetch(client *http.Client, url string) (*metrics.MetricsSummary, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status from %s: %s", url, resp.Status)
+	}
+	return decodeSummary(json.NewDecoder(resp.Body))
+}
+
+// readDumps renders every summary read from the given files, or from stdin if
+// there are none, as it is read. If once is set only the first summary of
+// each is rendered.
+func readDumps(stdin io.Reader, paths []string, once bool, render func(*metrics.MetricsSummary)) error {
+	if len(paths) == 0 {
+		return readDump(stdin, once, render)
+	}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		err = readDump(f, once, render)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readDump(r io.Reader, once bool, render func(*metrics.MetricsSummary)) error {
+	dec := json.NewDecoder(r)
+	for {
+		summary, err := decodeSummary(dec)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		render(summary)
+		if once {
+			return nil
+		}
+	}
+}
+
+// decodeSummary decodes the next MetricsSummary from dec. It also accepts the
+// DisplaySnapshot served by InmemSink.DisplayHandler, returning its current
+// interval.
+func decodeSummary(dec *json.Decoder) (*metrics.MetricsSummary, error) {
+	var v struct {
+		metrics.MetricsSummary
+		Current *metrics.MetricsSummary
+	}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if v.Current != nil {
+		return v.Current, nil
+	}
+	return &v.MetricsSummary, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+func TestRun_Dump(t *testing.T) {
+	dump := `{"Timestamp":"2026-01-01 00:00:00 +0000 UTC","Gauges":[{"Name":"a.gauge","Value":3,"Labels":{"z":"1","b":"2"}}],` +
+		`"Counters":[{"Name":"b.counter","Count":2,"Sum":10,"Min":4,"Max":6,"Mean":5,"Labels":{}}],` +
+		`"Samples":[{"Name":"c.sample","Count":4,"Sum":20,"Min":1,"Max":9,"Mean":5,"P99":9,"Labels":{}}]}
+{"Timestamp":"ignored"}
+`
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-once", "-no-color", "-sort", "value"}, strings.NewReader(dump), &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("bad: %d %s", code, stderr.String())
+	}
+
+	expect := `2026-01-01 00:00:00 +0000 UTC  3 metrics
+
+TYPE     NAME       LABELS   VALUE  COUNT  MEAN  MIN  MAX  P99
+sample   c.sample            20     4      5     1    9    9
+counter  b.counter           10     2      5     4    6
+gauge    a.gauge    b=2,z=1  3
+`
+	if got := stdout.String(); got != expect {
+		t.Fatalf("bad output:\n%q\n%q", got, expect)
+	}
+}
+
+func TestRun_URL(t *testing.T) {
+	inm := metrics.NewInmemSink(time.Minute, time.Hour)
+	inm.IncrCounter([]string{"requests"}, 1)
+	inm.SetGauge([]string{"ignored"}, 1)
+	srv := httptest.NewServer(inm.DisplayHandler())
+	defer srv.Close()
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-once", "-no-color", "-filter", "req", "-url", srv.URL}, nil, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("bad: %d %s", code, stderr.String())
+	}
+	out := stdout.String()
+	if !strings.Contains(out, "1 metrics") || !strings.Contains(out, "counter  requests") {
+		t.Fatalf("bad output:\n%s", out)
+	}
+}
+
+func TestRun_BadSort(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-sort", "bogus"}, nil, &stdout, &stderr); code != 2 {
+		t.Fatalf("bad: %d", code)
+	}
+}
+
+func TestPoll_RetriesFailedFetches(t *testing.T) {
+	inm := metrics.NewInmemSink(time.Minute, time.Hour)
+	inm.IncrCounter([]string{"requests"}, 1)
+	handler := inm.DisplayHandler()
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			http.Error(resp, "restarting", http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(resp, req)
+	}))
+	defer srv.Close()
+
+	var stderr bytes.Buffer
+	stop := make(chan struct{})
+	var rendered int
+	err := poll(srv.URL, time.Millisecond, false, func(*metrics.MetricsSummary) {
+		rendered++
+		close(stop)
+	}, &stderr, stop)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if rendered != 1 || !strings.Contains(stderr.String(), "503 Service Unavailable, retrying") {
+		t.Fatalf("bad: %d %q", rendered, stderr.String())
+	}
+
+	// A single table fails on the first error
+	atomic.StoreInt32(&requests, 0)
+	if err := poll(srv.URL, time.Millisecond, true, nil, &stderr, nil); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestRun_NoColorWithoutTerminal(t *testing.T) {
+	inm := metrics.NewInmemSink(time.Minute, time.Hour)
+	inm.IncrCounter([]string{"requests"}, 1)
+	srv := httptest.NewServer(inm.DisplayHandler())
+	defer srv.Close()
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-once", "-url", srv.URL}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("bad: %d %s", code, stderr.String())
+	}
+	if strings.Contains(stdout.String(), "\x1b") {
+		t.Fatalf("unexpected escape sequences: %q", stdout.String())
+	}
+}
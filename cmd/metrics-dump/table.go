@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+// ANSI escape sequences used to colorize the table
+const (
+	colorReset  = "\x1b[0m"
+	colorBold   = "\x1b[1m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorCyan   = "\x1b[36m"
+	colorGray   = "\x1b[90m"
+)
+
+type tableOptions struct {
+	sortBy string
+	filter string
+	color  bool
+}
+
+func (o tableOptions) validate() error {
+	switch o.sortBy {
+	case "name", "value", "count":
+		return nil
+	default:
+		return fmt.Errorf("unknown sort order %q, expected name, value or count", o.sortBy)
+	}
+}
+
+// row is one metric of a summary, as rendered in the table
+type row struct {
+	typ    string
+	name   string
+	labels string
+	value  float64 // The gauge value, or the sum of counters and samples
+	count  int
+	mean   string
+	min    string
+	max    string
+	p99    string
+}
+
+// writeTable writes the metrics of summary to w as a table sorted by
+// opts.sortBy
+func writeTable(w io.Writer, summary *metrics.MetricsSummary, opts tableOptions) {
+	rows := summaryRows(summary, opts.filter)
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		switch {
+		case opts.sortBy == "value" && a.value != b.value:
+			return a.value > b.value
+		case opts.sortBy == "count" && a.count != b.count:
+			return a.count > b.count
+		case a.name != b.name:
+			return a.name < b.name
+		default:
+			return a.labels < b.labels
+		}
+	})
+
+	paint := func(color, s string) string {
+		if !opts.color {
+			return s
+		}
+		return color + s + colorReset
+	}
+
+	// Colors would throw off text/tabwriter, so cells are padded to the width
+	// of their column before they are painted
+	header := []string{"TYPE", "NAME", "LABELS", "VALUE", "COUNT", "MEAN", "MIN", "MAX", "P99"}
+	cells := [][]string{header}
+	for _, r := range rows {
+		count := ""
+		if r.typ != "gauge" {
+			count = strconv.Itoa(r.count)
+		}
+		cells = append(cells, []string{r.typ, r.name, r.labels, formatFloat(r.value), count, r.mean, r.min, r.max, r.p99})
+	}
+	widths := make([]int, len(header))
+	for _, line := range cells {
+		for c, cell := range line {
+			if len(cell) > widths[c] {
+				widths[c] = len(cell)
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "%s  %d metrics\n\n", paint(colorBold, summary.Timestamp), len(rows))
+	for n, line := range cells {
+		var buf strings.Builder
+		for c, cell := range line {
+			padded := cell
+			if c < len(line)-1 {
+				padded += strings.Repeat(" ", widths[c]-len(cell)+2)
+			}
+			switch {
+			case n == 0:
+				padded = paint(colorBold, padded)
+			case c == 0:
+				padded = paint(typeColor(cell), padded)
+			case c == 2:
+				padded = paint(colorGray, padded)
+			}
+			buf.WriteString(padded)
+		}
+		io.WriteString(w, strings.TrimRight(buf.String(), " ")+"\n")
+	}
+}
+
+func typeColor(typ string) string {
+	switch typ {
+	case "gauge":
+		return colorGreen
+	case "counter":
+		return colorYellow
+	default:
+		return colorCyan
+	}
+}
+
+// summaryRows returns a row for every metric of summary whose name contains
+// filter. Points are left out, since they don't reduce to one value.
+func summaryRows(summary *metrics.MetricsSummary, filter string) []row {
+	var rows []row
+	for _, g := range summary.Gauges {
+		if strings.Contains(g.Name, filter) {
+			rows = append(rows, row{typ: "gauge", name: g.Name, labels: formatLabels(g.DisplayLabels), value: float64(g.Value)})
+		}
+	}
+	add := func(typ string, values []metrics.SampledValue) {
+		for _, v := range values {
+			if !strings.Contains(v.Name, filter) || v.AggregateSample == nil {
+				continue
+			}
+			r := row{
+				typ:    typ,
+				name:   v.Name,
+				labels: formatLabels(v.DisplayLabels),
+				value:  v.Sum,
+				count:  v.Count,
+				mean:   formatFloat(v.Mean),
+				min:    formatFloat(v.Min),
+				max:    formatFloat(v.Max),
+			}
+			if typ == "sample" && v.P99 != nil {
+				r.p99 = formatFloat(*v.P99)
+			}
+			rows = append(rows, r)
+		}
+	}
+	add("counter", summary.Counters)
+	add("sample", summary.Samples)
+	return rows
+}
+
+func formatLabels(labels map[string]string) string {
+	parts := make([]string, 0, len(labels))
+	for name, value := range labels {
+		parts = append(parts, name+"="+value)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', 6, 64)
+}
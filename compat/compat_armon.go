@@ -0,0 +1,139 @@
+//go:build armonmetrics
+// +build armonmetrics
+
+package compat
+
+import (
+	"io"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/armon/go-metrics"
+)
+
+// The types of github.com/armon/go-metrics
+type (
+	AggregateSample = metrics.AggregateSample
+	BlackholeSink   = metrics.BlackholeSink
+	Config          = metrics.Config
+	Encoder         = metrics.Encoder
+	FanoutSink      = metrics.FanoutSink
+	GaugeValue      = metrics.GaugeValue
+	InmemSignal     = metrics.InmemSignal
+	InmemSink       = metrics.InmemSink
+	IntervalMetrics = metrics.IntervalMetrics
+	Label           = metrics.Label
+	MetricSink      = metrics.MetricSink
+	Metrics         = metrics.Metrics
+	MetricsSummary  = metrics.MetricsSummary
+	PointValue      = metrics.PointValue
+	SampledValue    = metrics.SampledValue
+	ShutdownSink    = metrics.ShutdownSink
+	StatsdSink      = metrics.StatsdSink
+	StatsiteSink    = metrics.StatsiteSink
+)
+
+func Default() *Metrics {
+	return metrics.Default()
+}
+
+func DefaultConfig(serviceName string) *Config {
+	return metrics.DefaultConfig(serviceName)
+}
+
+func New(conf *Config, sink MetricSink) (*Metrics, error) {
+	return metrics.New(conf, sink)
+}
+
+func NewGlobal(conf *Config, sink MetricSink) (*Metrics, error) {
+	return metrics.NewGlobal(conf, sink)
+}
+
+func SetGauge(key []string, val float32) {
+	metrics.SetGauge(key, val)
+}
+
+func SetGaugeWithLabels(key []string, val float32, labels []Label) {
+	metrics.SetGaugeWithLabels(key, val, labels)
+}
+
+func EmitKey(key []string, val float32) {
+	metrics.EmitKey(key, val)
+}
+
+func IncrCounter(key []string, val float32) {
+	metrics.IncrCounter(key, val)
+}
+
+func IncrCounterWithLabels(key []string, val float32, labels []Label) {
+	metrics.IncrCounterWithLabels(key, val, labels)
+}
+
+func AddSample(key []string, val float32) {
+	metrics.AddSample(key, val)
+}
+
+func AddSampleWithLabels(key []string, val float32, labels []Label) {
+	metrics.AddSampleWithLabels(key, val, labels)
+}
+
+func MeasureSince(key []string, start time.Time) {
+	metrics.MeasureSince(key, start)
+}
+
+func MeasureSinceWithLabels(key []string, start time.Time, labels []Label) {
+	metrics.MeasureSinceWithLabels(key, start, labels)
+}
+
+func UpdateFilter(allow, block []string) {
+	metrics.UpdateFilter(allow, block)
+}
+
+func UpdateFilterAndLabels(allow, block, allowedLabels, blockedLabels []string) {
+	metrics.UpdateFilterAndLabels(allow, block, allowedLabels, blockedLabels)
+}
+
+func Shutdown() {
+	metrics.Shutdown()
+}
+
+func NewInmemSink(interval, retain time.Duration) *InmemSink {
+	return metrics.NewInmemSink(interval, retain)
+}
+
+func NewInmemSinkFromURL(u *url.URL) (MetricSink, error) {
+	return metrics.NewInmemSinkFromURL(u)
+}
+
+func NewIntervalMetrics(intv time.Time) *IntervalMetrics {
+	return metrics.NewIntervalMetrics(intv)
+}
+
+func NewInmemSignal(inmem *InmemSink, sig syscall.Signal, w io.Writer) *InmemSignal {
+	return metrics.NewInmemSignal(inmem, sig, w)
+}
+
+func DefaultInmemSignal(inmem *InmemSink) *InmemSignal {
+	return metrics.DefaultInmemSignal(inmem)
+}
+
+func NewMetricSinkFromURL(urlStr string) (MetricSink, error) {
+	return metrics.NewMetricSinkFromURL(urlStr)
+}
+
+func NewStatsdSink(addr string) (*StatsdSink, error) {
+	return metrics.NewStatsdSink(addr)
+}
+
+func NewStatsdSinkFromURL(u *url.URL) (MetricSink, error) {
+	return metrics.NewStatsdSinkFromURL(u)
+}
+
+func NewStatsiteSink(addr string) (*StatsiteSink, error) {
+	return metrics.NewStatsiteSink(addr)
+}
+
+func NewStatsiteSinkFromURL(u *url.URL) (MetricSink, error) {
+	return metrics.NewStatsiteSinkFromURL(u)
+}
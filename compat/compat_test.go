@@ -0,0 +1,32 @@
+//go:build !armonmetrics
+// +build !armonmetrics
+
+package compat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+func TestCompat(t *testing.T) {
+	inm := NewInmemSink(time.Minute, time.Minute)
+	cfg := DefaultConfig("")
+	cfg.EnableHostname = false
+	cfg.EnableRuntimeMetrics = false
+	m, err := NewGlobal(cfg, inm)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer Shutdown()
+
+	// The types are those of github.com/hashicorp/go-metrics
+	var _ *metrics.Metrics = m
+	var _ metrics.MetricSink = inm
+	IncrCounterWithLabels([]string{"requests"}, 1, []Label{{Name: "method", Value: "GET"}})
+
+	if _, ok := inm.Data()[0].Counters["requests;method=GET"]; !ok {
+		t.Fatalf("bad counters: %v", inm.Data()[0].Counters)
+	}
+}
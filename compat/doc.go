@@ -0,0 +1,15 @@
+// Package compat provides the API shared by github.com/armon/go-metrics and
+// github.com/hashicorp/go-metrics, so that code, and in particular libraries,
+// can switch between them without code changes. Import it as
+//
+//	metrics "github.com/hashicorp/go-metrics/compat"
+//
+// It forwards to github.com/hashicorp/go-metrics by default, or to
+// github.com/armon/go-metrics when built with the armonmetrics tag:
+//
+//	go build -tags armonmetrics
+//
+// Applications embedding libraries which emit through compat should build
+// with the same tag as the library they configure the sinks with, since the
+// global metrics of the two modules are separate.
+package compat
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+)
+
+// ObserveCtx starts timing an operation run with ctx. Calling the returned
+// function with the error the operation returned emits its duration to key,
+// labeled with its outcome:
+//
+//	success - err is nil
+//	canceled - err is, or ctx ended with, context.Canceled
+//	deadline_exceeded - err is, or ctx ended with, context.DeadlineExceeded
+//	error - any other err
+//
+// For example:
+//
+//	done := m.ObserveCtx(ctx, []string{"fetch"})
+//	err := fetch(ctx)
+//	done(err)
+func (m *Metrics) ObserveCtx(ctx context.Context, key []string) func(err error) {
+	start := m.Now()
+	return func(err error) {
+		m.MeasureSinceWithLabels(key, start, []Label{{Name: "outcome", Value: ctxOutcome(ctx, err)}})
+	}
+}
+
+// ctxOutcome returns the outcome of an operation run with ctx which returned
+// err. Operations often wrap the error of the context, or return their own
+// error once it ended.
+func ctxOutcome(ctx context.Context, err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "deadline_exceeded"
+	}
+	switch ctx.Err() {
+	case context.Canceled:
+		return "canceled"
+	case context.DeadlineExceeded:
+		return "deadline_exceeded"
+	default:
+		return "error"
+	}
+}
@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMetrics_ObserveCtx(t *testing.T) {
+	inm := NewInmemSink(time.Minute, time.Minute)
+	met := &Metrics{Config: Config{FilterDefault: true, TimerGranularity: time.Millisecond}, sink: inm}
+
+	ctx := context.Background()
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	expired, cancel := context.WithDeadline(ctx, time.Now())
+	defer cancel()
+
+	met.ObserveCtx(ctx, []string{"op"})(nil)
+	met.ObserveCtx(ctx, []string{"op"})(errors.New("failed"))
+	met.ObserveCtx(ctx, []string{"op"})(fmt.Errorf("fetch: %w", context.Canceled))
+	met.ObserveCtx(canceled, []string{"op"})(errors.New("connection closed"))
+	met.ObserveCtx(expired, []string{"op"})(errors.New("i/o timeout"))
+
+	samples := inm.Data()[0].Samples
+	for outcome, count := range map[string]int{"success": 1, "error": 1, "canceled": 2, "deadline_exceeded": 1} {
+		if s, ok := samples["op;outcome="+outcome]; !ok || s.Count != count {
+			t.Fatalf("bad %s: %v", outcome, samples)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package datadog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/hashicorp/go-metrics"
+)
+
+// clientTelemetryPrefix starts the names of the metrics the DogStatsD
+// client reports about itself, which are only meant for the agent
+const clientTelemetryPrefix = "datadog.dogstatsd.client."
+
+// APIFallbackOpts configures the submission of metrics to the Datadog API
+// while the agent is unreachable, see EnableAPIFallback.
+type APIFallbackOpts struct {
+	// APIKey is the key metrics are submitted with. Required.
+	APIKey string
+
+	// Endpoint is the URL of the Datadog API of the site of the account.
+	// Defaults to https://api.datadoghq.com.
+	Endpoint string
+
+	// FlushInterval is how often metrics are submitted. Defaults to 10s.
+	FlushInterval time.Duration
+
+	// RetryInterval is how long metrics are sent to the API before the agent
+	// is tried again. Defaults to 30s.
+	RetryInterval time.Duration
+
+	// MaxPoints limits the number of series and distribution values held
+	// between submissions, further values are dropped. Defaults to 100000.
+	MaxPoints int
+
+	// HTTPClient defaults to a client with a 10s timeout
+	HTTPClient *http.Client
+}
+
+// EnableAPIFallback makes the sink submit metrics over HTTPS to the Datadog
+// API while the local agent is unreachable, instead of dropping them. The
+// agent counts as unreachable once sending to it fails, which is reported for
+// UDP once the host rejects a packet, so the first packets sent after the
+// agent went away are still lost. Counters are submitted as counts, gauges as
+// gauges, and samples and distributions as distributions, aggregated over
+// FlushInterval. Events and service checks are dropped. It must be called
+// before any metrics are emitted, and is only supported over UDP.
+func (s *DogStatsdSink) EnableAPIFallback(opts APIFallbackOpts) error {
+	if opts.APIKey == "" {
+		return errors.New("an API key is required")
+	}
+	if strings.HasPrefix(s.addr, statsd.UnixAddressPrefix) {
+		return errors.New("API fallback is not supported over Unix domain sockets")
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = "https://api.datadoghq.com"
+	}
+	if opts.FlushInterval == 0 {
+		opts.FlushInterval = 10 * time.Second
+	}
+	if opts.RetryInterval == 0 {
+		opts.RetryInterval = 30 * time.Second
+	}
+	if opts.MaxPoints == 0 {
+		opts.MaxPoints = 100000
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	api := newAPISubmitter(opts)
+	s.api = api
+	if err := s.replaceClient(); err != nil {
+		s.api = nil
+		api.close()
+		return err
+	}
+
+	if s.packer != nil {
+		s.packer.wrapWriter(func(w io.WriteCloser) io.WriteCloser {
+			return &fallbackWriter{agent: w, api: api, retry: opts.RetryInterval}
+		})
+	}
+	return nil
+}
+
+// fallbackWriter sends payloads to the agent, or to the API while sending to
+// the agent fails
+type fallbackWriter struct {
+	agent io.WriteCloser
+	api   *apiSubmitter
+	retry time.Duration
+
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+func (w *fallbackWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	down := time.Now().Before(w.downUntil)
+	w.mu.Unlock()
+
+	if !down {
+		_, err := w.agent.Write(data)
+		if err == nil {
+			return len(data), nil
+		}
+		metrics.LogWarn("DogStatsD agent unreachable, submitting metrics to the API", "sink", "datadog", "retry", w.retry, "class", "write", "error", err)
+		w.mu.Lock()
+		w.downUntil = time.Now().Add(w.retry)
+		w.mu.Unlock()
+	}
+	w.api.add(data)
+	return len(data), nil
+}
+
+func (w *fallbackWriter) Close() error {
+	return w.agent.Close()
+}
+
+// apiSubmitter aggregates DogStatsD messages and submits them to the API
+type apiSubmitter struct {
+	opts APIFallbackOpts
+
+	mu     sync.Mutex
+	series map[string]*apiSeries
+	points int
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// apiSeries aggregates the values of a metric and set of tags
+type apiSeries struct {
+	metric string
+	typ    string
+	tags   []string
+	value  float64   // Sum of counts or last gauge
+	values []float64 // Distribution values
+}
+
+func newAPISubmitter(opts APIFallbackOpts) *apiSubmitter {
+	a := &apiSubmitter{
+		opts:   opts,
+		series: make(map[string]*apiSeries),
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+func (a *apiSubmitter) run() {
+	defer close(a.doneCh)
+	ticker := time.NewTicker(a.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.flush(time.Now())
+		case <-a.stopCh:
+			a.flush(time.Now())
+			return
+		}
+	}
+}
+
+// add aggregates the newline separated DogStatsD messages of payload
+func (a *apiSubmitter) add(payload []byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, line := range strings.Split(string(payload), "\n") {
+		if a.points >= a.opts.MaxPoints {
+			return
+		}
+		a.addLine(line)
+	}
+}
+
+// addLine aggregates a message of the form name:value1:value2|type|@rate|#tags.
+// Events, service checks, sets, the telemetry of the client, which would be
+// billed as custom metrics, and malformed messages are skipped.
+func (a *apiSubmitter) addLine(line string) {
+	fields := strings.Split(line, "|")
+	i := strings.IndexByte(fields[0], ':')
+	if len(fields) < 2 || i <= 0 || strings.HasPrefix(line, "_") || strings.HasPrefix(line, clientTelemetryPrefix) {
+		return
+	}
+	name, typ := fields[0][:i], fields[1]
+
+	rate := 1.0
+	var tags []string
+	for _, field := range fields[2:] {
+		switch {
+		case strings.HasPrefix(field, "@"):
+			if r, err := strconv.ParseFloat(field[1:], 64); err == nil && r > 0 {
+				rate = r
+			}
+		case strings.HasPrefix(field, "#"):
+			tags = strings.Split(field[1:], ",")
+		}
+	}
+
+	var apiType string
+	switch typ {
+	case "c":
+		apiType = "count"
+	case "g":
+		apiType = "gauge"
+	case "ms", "h", "d":
+		apiType = "distribution"
+	default:
+		return
+	}
+
+	key := name + "|" + apiType + "|" + strings.Join(tags, ",")
+	series, ok := a.series[key]
+	if !ok {
+		series = &apiSeries{metric: name, typ: apiType, tags: tags}
+		a.series[key] = series
+		a.points++
+	}
+	for _, raw := range strings.Split(fields[0][i+1:], ":") {
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			continue
+		}
+		switch apiType {
+		case "count":
+			series.value += v / rate
+		case "gauge":
+			series.value = v
+		default:
+			series.values = append(series.values, v)
+			a.points++
+		}
+	}
+}
+
+// flush submits the aggregated metrics, stamped with now
+func (a *apiSubmitter) flush(now time.Time) {
+	a.mu.Lock()
+	series := a.series
+	a.series = make(map[string]*apiSeries)
+	a.points = 0
+	a.mu.Unlock()
+	if len(series) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	ts := now.Unix()
+	interval := int64(a.opts.FlushInterval / time.Second)
+	var metrics, distributions []map[string]interface{}
+	for _, key := range keys {
+		s := series[key]
+		m := map[string]interface{}{"metric": s.metric}
+		if len(s.tags) > 0 {
+			m["tags"] = s.tags
+		}
+		switch s.typ {
+		case "distribution":
+			m["points"] = [][]interface{}{{ts, s.values}}
+			distributions = append(distributions, m)
+			continue
+		case "count":
+			m["interval"] = interval
+		}
+		m["type"] = s.typ
+		m["points"] = [][]interface{}{{ts, s.value}}
+		metrics = append(metrics, m)
+	}
+
+	if len(metrics) > 0 {
+		a.post("/api/v1/series", metrics)
+	}
+	if len(distributions) > 0 {
+		a.post("/api/v1/distribution_points", distributions)
+	}
+}
+
+func (a *apiSubmitter) post(path string, series []map[string]interface{}) {
+	body, err := json.Marshal(map[string]interface{}{"series": series})
+	if err != nil {
+		metrics.LogError("Error encoding metrics for the Datadog API", "sink", "datadog", "class", "encode", "error", err)
+		return
+	}
+	req, err := http.NewRequest("POST", strings.TrimSuffix(a.opts.Endpoint, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		metrics.LogError("Error submitting metrics to the Datadog API", "sink", "datadog", "addr", a.opts.Endpoint, "class", "submit", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("DD-API-KEY", a.opts.APIKey)
+
+	resp, err := a.opts.HTTPClient.Do(req)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			err = fmt.Errorf("unexpected status %s", resp.Status)
+		}
+	}
+	if err != nil {
+		metrics.LogError("Error submitting metrics to the Datadog API", "sink", "datadog", "addr", a.opts.Endpoint, "class", "submit", "error", err)
+	}
+}
+
+// close submits the pending metrics
+func (a *apiSubmitter) close() {
+	close(a.stopCh)
+	<-a.doneCh
+}
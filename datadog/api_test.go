@@ -0,0 +1,123 @@
+package datadog
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection refused")
+}
+
+func (failingWriter) Close() error {
+	return nil
+}
+
+func TestAPIFallback(t *testing.T) {
+	var mu sync.Mutex
+	bodies := make(map[string]interface{})
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("DD-API-KEY") != "key" {
+			t.Errorf("bad API key: %q", req.Header.Get("DD-API-KEY"))
+		}
+		raw, _ := io.ReadAll(req.Body)
+		var body interface{}
+		if err := json.Unmarshal(raw, &body); err != nil {
+			t.Errorf("bad body: %s", raw)
+		}
+		mu.Lock()
+		bodies[req.URL.Path] = body
+		mu.Unlock()
+		resp.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	api := newAPISubmitter(APIFallbackOpts{
+		APIKey:        "key",
+		Endpoint:      srv.URL,
+		FlushInterval: 10 * time.Second,
+		MaxPoints:     100,
+		HTTPClient:    srv.Client(),
+	})
+	w := &fallbackWriter{agent: failingWriter{}, api: api, retry: time.Minute}
+	w.Write([]byte("requests:2|c|#env:prod\nrequests:3|c|@0.5|#env:prod\nqueue:1|g\nqueue:4|g"))
+	w.Write([]byte("latency:1.5:2.5|ms\nsize:512|d\n_sc|db|0\nusers:bob|s"))
+	w.Write([]byte("datadog.dogstatsd.client.metrics:5|c|#client:go,transport:udp"))
+	api.close()
+
+	expect := map[string]interface{}{
+		"/api/v1/series": map[string]interface{}{"series": []interface{}{
+			map[string]interface{}{"metric": "queue", "type": "gauge", "points": []interface{}{[]interface{}{ts, 4.0}}},
+			map[string]interface{}{"metric": "requests", "type": "count", "interval": 10.0, "tags": []interface{}{"env:prod"},
+				"points": []interface{}{[]interface{}{ts, 8.0}}},
+		}},
+		"/api/v1/distribution_points": map[string]interface{}{"series": []interface{}{
+			map[string]interface{}{"metric": "latency", "points": []interface{}{[]interface{}{ts, []interface{}{1.5, 2.5}}}},
+			map[string]interface{}{"metric": "size", "points": []interface{}{[]interface{}{ts, []interface{}{512.0}}}},
+		}},
+	}
+	// The timestamps are those of the flush
+	for _, body := range bodies {
+		for _, s := range body.(map[string]interface{})["series"].([]interface{}) {
+			s.(map[string]interface{})["points"].([]interface{})[0].([]interface{})[0] = ts
+		}
+	}
+	if !reflect.DeepEqual(bodies, expect) {
+		t.Fatalf("bad submissions: %v", bodies)
+	}
+}
+
+// ts replaces the timestamps of submitted points in TestAPIFallback
+const ts = "ts"
+
+func TestEnableAPIFallback(t *testing.T) {
+	dog := mockNewDogStatsdSink(DogStatsdAddr, EmptyTags, HostnameDisabled)
+	if err := dog.EnableAPIFallback(APIFallbackOpts{}); err == nil {
+		t.Fatalf("expected error without API key")
+	}
+
+	server, buf := setupTestServerAndBuffer(t)
+	defer server.Close()
+	if err := dog.EnableAPIFallback(APIFallbackOpts{
+		APIKey:        "key",
+		Endpoint:      "http://127.0.0.1:1",
+		FlushInterval: 10 * time.Millisecond,
+		RetryInterval: 10 * time.Millisecond,
+	}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	// While the agent is reachable, metrics are sent to it
+	dog.IncrCounter([]string{"count", "me"}, 3)
+	assertServerMatchesExpected(t, server, buf, "count.me:3|c")
+	dog.Shutdown()
+}
+
+func TestEnableAPIFallback_ValuePacking(t *testing.T) {
+	server, buf := setupTestServerAndBuffer(t)
+	defer server.Close()
+
+	// The packer keeps flushing while its writer is replaced
+	dog := mockNewDogStatsdSink(DogStatsdAddr, EmptyTags, HostnameDisabled)
+	if err := dog.EnableValuePacking(time.Millisecond); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := dog.EnableAPIFallback(APIFallbackOpts{
+		APIKey:        "key",
+		Endpoint:      "http://127.0.0.1:1",
+		FlushInterval: 10 * time.Millisecond,
+	}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	dog.IncrCounter([]string{"count", "me"}, 3)
+	assertServerMatchesExpected(t, server, buf, "count.me:3|c")
+	dog.Shutdown()
+}
@@ -0,0 +1,81 @@
+package datadog
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// OverflowTag replaces the tags of a metric once it exceeds the limit of
+// SetMaxTagSets
+const OverflowTag = "tag_set_overflow:true"
+
+// overflowCountKey is the counter sent for every value whose tags were
+// replaced with OverflowTag, tagged with the name of the metric
+const overflowCountKey = "datadog.tag_sets_overflowed"
+
+// SetMaxTagSets caps the number of unique tag sets sent per metric name,
+// since every one of them is a custom metric Datadog bills for. Once a metric
+// has been sent with max tag sets, values with a new tag set are sent with
+// only OverflowTag, and the host tag if hostname propagation is enabled, and
+// the datadog.tag_sets_overflowed counter, tagged with the metric, is
+// incremented. Tags set with SetTags don't count against the limit. The tag
+// sets seen are kept for the lifetime of the sink. A max of 0, the default,
+// disables the limit. It must be called before any metrics are emitted.
+func (s *DogStatsdSink) SetMaxTagSets(max int) {
+	if max <= 0 {
+		s.tagSets = nil
+		return
+	}
+	s.tagSets = &tagSetLimiter{max: max, seen: make(map[string]map[string]struct{})}
+}
+
+// limitTags returns the tags to send a value of the metric with, replacing
+// them with OverflowTag if they are a new tag set beyond the limit
+func (s *DogStatsdSink) limitTags(flatKey string, tags []string) []string {
+	if s.tagSets == nil || s.tagSets.allow(flatKey, tags) {
+		return tags
+	}
+
+	var limited []string
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, "host:") {
+			limited = append(limited, tag)
+		}
+	}
+	limited = append(limited, OverflowTag)
+	s.client.Count(overflowCountKey, 1, []string{"metric:" + flatKey}, 1.0)
+	return limited
+}
+
+// tagSetLimiter tracks the unique tag sets of each metric
+type tagSetLimiter struct {
+	max int
+
+	mu   sync.Mutex
+	seen map[string]map[string]struct{}
+}
+
+// allow records the tag set of the metric, returning false if it is new and
+// the metric already has the maximum number of tag sets
+func (l *tagSetLimiter) allow(flatKey string, tags []string) bool {
+	sorted := append([]string(nil), tags...)
+	sort.Strings(sorted)
+	set := strings.Join(sorted, ",")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sets, ok := l.seen[flatKey]
+	if !ok {
+		sets = make(map[string]struct{})
+		l.seen[flatKey] = sets
+	}
+	if _, ok := sets[set]; ok {
+		return true
+	}
+	if len(sets) >= l.max {
+		return false
+	}
+	sets[set] = struct{}{}
+	return true
+}
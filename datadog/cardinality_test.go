@@ -0,0 +1,47 @@
+package datadog
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+func TestSetMaxTagSets(t *testing.T) {
+	server, buf := setupTestServerAndBuffer(t)
+	defer server.Close()
+
+	dog := mockNewDogStatsdSink(DogStatsdAddr, []metrics.Label{{Name: "global"}}, HostnameEnabled)
+	dog.SetMaxTagSets(2)
+
+	user := func(id string) []metrics.Label {
+		return []metrics.Label{{Name: "user", Value: id}}
+	}
+	dog.SetGaugeWithLabels([]string{"sessions"}, 1, user("a"))
+	assertServerMatchesExpected(t, server, buf, "sessions:1|g|#global,user:a,host:test_hostname")
+	dog.SetGaugeWithLabels([]string{"sessions"}, 2, user("b"))
+	assertServerMatchesExpected(t, server, buf, "sessions:2|g|#global,user:b,host:test_hostname")
+
+	// Tag sets already seen are still sent
+	dog.SetGaugeWithLabels([]string{"sessions"}, 3, user("a"))
+	assertServerMatchesExpected(t, server, buf, "sessions:3|g|#global,user:a,host:test_hostname")
+
+	// Other metrics have their own limit
+	dog.IncrCounterWithLabels([]string{"logins"}, 1, user("c"))
+	assertServerMatchesExpected(t, server, buf, "logins:1|c|#global,user:c,host:test_hostname")
+
+	dog.SetGaugeWithLabels([]string{"sessions"}, 4, user("c"))
+	// The counter and the value are usually sent in the same packet
+	expected := "datadog.tag_sets_overflowed:1|c|#global,metric:sessions\n" +
+		"sessions:4|g|#global,host:test_hostname,tag_set_overflow:true"
+	got := readServer(server, buf)
+	if got != expected {
+		got += "\n" + readServer(server, buf)
+	}
+	if got != expected {
+		t.Fatalf("Line %s does not match expected: %s", got, expected)
+	}
+
+	dog.SetMaxTagSets(0)
+	dog.SetGaugeWithLabels([]string{"sessions"}, 5, user("c"))
+	assertServerMatchesExpected(t, server, buf, "sessions:5|g|#global,user:c,host:test_hostname")
+}
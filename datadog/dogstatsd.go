@@ -2,7 +2,12 @@ package datadog
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/DataDog/datadog-go/statsd"
 	"github.com/hashicorp/go-metrics"
@@ -14,29 +19,188 @@ type DogStatsdSink struct {
 	client            *statsd.Client
 	hostName          string
 	propagateHostname bool
+	distributions     bool
+	sanitizer         metrics.Sanitizer
+	addr              string
+	containerID       string
+	clientOpts        ClientOpts
+	packer            *packer
+	api               *apiSubmitter
+	tagSets           *tagSetLimiter
+	telemetry         metrics.MetricSink
 }
 
-// NewDogStatsdSink is used to create a new DogStatsdSink with sane defaults
+// DefaultSanitizer is the Sanitizer used by a DogStatsdSink unless replaced
+// with SetSanitizer. It replaces ':' and spaces with underscores in metric
+// names and tags.
+var DefaultSanitizer metrics.Sanitizer = &metrics.RuneSanitizer{
+	Name:       sanitize,
+	LabelName:  sanitize,
+	LabelValue: sanitize,
+}
+
+// ClientOpts tunes the buffering of the DogStatsD client, trading the
+// latency of metrics against the packets, and so metrics, lost when they are
+// sent faster than the agent reads them. Zero values use the client defaults.
+type ClientOpts struct {
+	// BufferSize is the number of datagrams queued for sending, further
+	// datagrams are dropped until the queue drains. Defaults to 2048.
+	BufferSize int
+
+	// MaxDatagramSize is the maximum size in bytes of a datagram. Defaults to
+	// 1432, which fits the MTU of most networks. Larger datagrams mean fewer
+	// packets but are fragmented, and are only safe on networks known to
+	// carry them, such as loopback or Unix domain sockets.
+	MaxDatagramSize int
+
+	// FlushInterval is how long metrics are buffered before a datagram
+	// that isn't full is sent. Defaults to 100ms.
+	FlushInterval time.Duration
+}
+
+// options returns the client options of the non-zero fields
+func (o ClientOpts) options() []statsd.Option {
+	var opts []statsd.Option
+	if o.BufferSize > 0 {
+		opts = append(opts, statsd.WithBufferPoolSize(o.BufferSize), statsd.WithSenderQueueSize(o.BufferSize))
+	}
+	if o.MaxDatagramSize > 0 {
+		opts = append(opts, statsd.WithMaxBytesPerPayload(o.MaxDatagramSize))
+	}
+	if o.FlushInterval > 0 {
+		opts = append(opts, statsd.WithBufferFlushInterval(o.FlushInterval))
+	}
+	return opts
+}
+
+// maxDatagramSize returns the maximum size of a datagram sent over UDP
+func (o ClientOpts) maxDatagramSize() int {
+	if o.MaxDatagramSize > 0 {
+		return o.MaxDatagramSize
+	}
+	return statsd.OptimalUDPPayloadSize
+}
+
+// NewDogStatsdSink is used to create a new DogStatsdSink with sane defaults.
+// When running in a container and sending over UDP, the ID of the container is
+// added to every message, so the agent attributes the metrics to the
+// container and its pod. Set DD_ORIGIN_DETECTION_ENABLED=false to disable it.
+// Like the official clients, the sink tags all metrics with the env, service
+// and version of Unified Service Tagging from the DD_ENV, DD_SERVICE and
+// DD_VERSION environment variables, and the tags listed in DD_TAGS, separated
+// by commas or spaces.
 func NewDogStatsdSink(addr string, hostName string) (*DogStatsdSink, error) {
-	client, err := statsd.New(addr)
+	return newDogStatsdSink(addr, hostName, detectContainerID(), ClientOpts{})
+}
+
+// NewDogStatsdSinkWithOpts is NewDogStatsdSink with the buffering of the
+// client tuned by opts.
+func NewDogStatsdSinkWithOpts(addr string, hostName string, opts ClientOpts) (*DogStatsdSink, error) {
+	return newDogStatsdSink(addr, hostName, detectContainerID(), opts)
+}
+
+func newDogStatsdSink(addr string, hostName string, containerID string, opts ClientOpts) (*DogStatsdSink, error) {
+	var client *statsd.Client
+	var err error
+	if containerID != "" && !strings.HasPrefix(addr, statsd.UnixAddressPrefix) {
+		var w *originWriter
+		w, err = newOriginWriter(addr, containerID, opts.maxDatagramSize())
+		if err == nil {
+			client, err = newUDPClient(w, opts)
+		}
+	} else {
+		client, err = statsd.New(addr, opts.options()...)
+	}
 	if err != nil {
 		return nil, err
 	}
+	client.Tags = append(client.Tags, envTags()...)
 	sink := &DogStatsdSink{
 		client:            client,
 		hostName:          hostName,
 		propagateHostname: false,
+		sanitizer:         DefaultSanitizer,
+		addr:              addr,
+		containerID:       containerID,
+		clientOpts:        opts,
 	}
 	return sink, nil
 }
 
+// newUDPClient creates a client sending over UDP through w
+func newUDPClient(w io.WriteCloser, opts ClientOpts) (*statsd.Client, error) {
+	// These are the defaults statsd.New uses for UDP
+	options := []statsd.Option{
+		statsd.WithBufferPoolSize(statsd.DefaultUDPBufferPoolSize),
+		statsd.WithSenderQueueSize(statsd.DefaultUDPBufferPoolSize),
+	}
+	return statsd.NewWithWriter(udpTransport{w}, append(options, opts.options()...)...)
+}
+
+// udpTransport adapts an io.WriteCloser to the transport of the client
+type udpTransport struct {
+	io.WriteCloser
+}
+
+// SetWriteTimeout is not needed for UDP
+func (udpTransport) SetWriteTimeout(time.Duration) error {
+	return nil
+}
+
+// envTagNames maps the environment variables of Unified Service Tagging to
+// the tags they set
+var envTagNames = []struct{ env, tag string }{
+	{"DD_ENV", "env"},
+	{"DD_SERVICE", "service"},
+	{"DD_VERSION", "version"},
+}
+
+// envTags returns the default tags set by the environment
+func envTags() []string {
+	var tags []string
+	for _, name := range envTagNames {
+		if value := os.Getenv(name.env); value != "" {
+			tags = append(tags, name.tag+":"+value)
+		}
+	}
+	tags = append(tags, strings.FieldsFunc(os.Getenv("DD_TAGS"), func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})...)
+	return tags
+}
+
 // SetTags sets common tags on the Dogstatsd Client that will be sent
-// along with all dogstatsd packets.
+// along with all dogstatsd packets. The entity ID tag the client sets from
+// DD_ENTITY_ID is kept, as are the tags set by the environment unless tags
+// has one of the same name.
 // Ref: http://docs.datadoghq.com/guides/dogstatsd/#tags
 func (s *DogStatsdSink) SetTags(tags []string) {
+	names := make(map[string]struct{}, len(tags))
+	for _, tag := range tags {
+		names[tagName(tag)] = struct{}{}
+	}
+	tags = tags[:len(tags):len(tags)]
+	for _, tag := range envTags() {
+		if _, ok := names[tagName(tag)]; !ok {
+			tags = append(tags, tag)
+		}
+	}
+	for _, tag := range s.client.Tags {
+		if strings.HasPrefix(tag, entityIDTagName+":") {
+			tags = append(tags, tag)
+		}
+	}
 	s.client.Tags = tags
 }
 
+// tagName returns the part of tag before the colon
+func tagName(tag string) string {
+	if i := strings.IndexByte(tag, ':'); i >= 0 {
+		return tag[:i]
+	}
+	return tag
+}
+
 // EnableHostnamePropagation forces a Dogstatsd `host` tag with the value specified by `s.HostName`
 // Since the go-metrics package has its own mechanism for attaching a hostname to metrics,
 // setting the `propagateHostname` flag ensures that `s.HostName` overrides the host tag naively set by the DogStatsd server
@@ -44,9 +208,22 @@ func (s *DogStatsdSink) EnableHostNamePropagation() {
 	s.propagateHostname = true
 }
 
+// EnableDistributions makes samples be sent as distributions, like
+// AddDistribution does, instead of timers. It must be called before any
+// metrics are emitted.
+func (s *DogStatsdSink) EnableDistributions() {
+	s.distributions = true
+}
+
+// SetSanitizer replaces the DefaultSanitizer used to normalize metric names
+// and tags. It must be called before any metrics are emitted.
+func (s *DogStatsdSink) SetSanitizer(sanitizer metrics.Sanitizer) {
+	s.sanitizer = sanitizer
+}
+
 func (s *DogStatsdSink) flattenKey(parts []string) string {
 	joined := strings.Join(parts, ".")
-	return strings.Map(sanitize, joined)
+	return s.sanitizer.SanitizeName(joined)
 }
 
 func sanitize(r rune) rune {
@@ -104,25 +281,82 @@ func (s *DogStatsdSink) AddSample(key []string, val float32) {
 // http://docs.datadoghq.com/guides/dogstatsd/#tags
 func (s *DogStatsdSink) SetGaugeWithLabels(key []string, val float32, labels []metrics.Label) {
 	flatKey, tags := s.getFlatkeyAndCombinedLabels(key, labels)
+	tags = s.limitTags(flatKey, tags)
 	rate := 1.0
 	s.client.Gauge(flatKey, float64(val), tags, rate)
 }
 
 func (s *DogStatsdSink) IncrCounterWithLabels(key []string, val float32, labels []metrics.Label) {
 	flatKey, tags := s.getFlatkeyAndCombinedLabels(key, labels)
+	tags = s.limitTags(flatKey, tags)
+	if s.packer != nil {
+		s.packer.add(flatKey, "c", strconv.FormatInt(int64(val), 10), s.packedTags(tags))
+		return
+	}
 	rate := 1.0
 	s.client.Count(flatKey, int64(val), tags, rate)
 }
 
 func (s *DogStatsdSink) AddSampleWithLabels(key []string, val float32, labels []metrics.Label) {
+	s.AddPrecisionSampleWithLabels(key, float64(val), labels)
+}
+
+func (s *DogStatsdSink) AddPrecisionSample(key []string, val float64) {
+	s.AddPrecisionSampleWithLabels(key, val, nil)
+}
+
+func (s *DogStatsdSink) AddPrecisionSampleWithLabels(key []string, val float64, labels []metrics.Label) {
+	if s.distributions {
+		s.AddDistributionWithLabels(key, val, labels)
+		return
+	}
+	flatKey, tags := s.getFlatkeyAndCombinedLabels(key, labels)
+	tags = s.limitTags(flatKey, tags)
+	if s.packer != nil {
+		s.packer.add(flatKey, "ms", strconv.FormatFloat(val, 'f', 6, 64), s.packedTags(tags))
+		return
+	}
+	rate := 1.0
+	s.client.TimeInMilliseconds(flatKey, val, tags, rate)
+}
+
+// AddDistribution sends a value of a distribution, a DogStatsD extension
+// whose percentiles are computed by Datadog across all hosts, rather than by
+// the agent of each host as they are for timers.
+// Ref: https://docs.datadoghq.com/metrics/types/?tab=distribution
+func (s *DogStatsdSink) AddDistribution(key []string, val float64) {
+	s.AddDistributionWithLabels(key, val, nil)
+}
+
+func (s *DogStatsdSink) AddDistributionWithLabels(key []string, val float64, labels []metrics.Label) {
 	flatKey, tags := s.getFlatkeyAndCombinedLabels(key, labels)
+	tags = s.limitTags(flatKey, tags)
+	if s.packer != nil {
+		s.packer.add(flatKey, "d", strconv.FormatFloat(val, 'f', -1, 64), s.packedTags(tags))
+		return
+	}
 	rate := 1.0
-	s.client.TimeInMilliseconds(flatKey, float64(val), tags, rate)
+	s.client.Distribution(flatKey, val, tags, rate)
+}
+
+// packedTags returns the tags of a packed message, which are sent without the
+// client adding its tags
+func (s *DogStatsdSink) packedTags(tags []string) []string {
+	if len(s.client.Tags) == 0 {
+		return tags
+	}
+	return append(s.client.Tags[:len(s.client.Tags):len(s.client.Tags)], tags...)
 }
 
 // Shutdown disables further metric collection, blocks to flush data, and tears down the sink.
 func (s *DogStatsdSink) Shutdown() {
+	if s.packer != nil {
+		s.packer.close()
+	}
 	s.client.Close()
+	if s.api != nil {
+		s.api.close()
+	}
 }
 
 func (s *DogStatsdSink) getFlatkeyAndCombinedLabels(key []string, labels []metrics.Label) (string, []string) {
@@ -132,8 +366,7 @@ func (s *DogStatsdSink) getFlatkeyAndCombinedLabels(key []string, labels []metri
 
 	var tags []string
 	for _, label := range labels {
-		label.Name = strings.Map(sanitize, label.Name)
-		label.Value = strings.Map(sanitize, label.Value)
+		label = s.sanitizer.SanitizeLabel(label)
 		if label.Value != "" {
 			tags = append(tags, fmt.Sprintf("%s:%s", label.Name, label.Value))
 		} else {
@@ -3,7 +3,9 @@ package datadog
 import (
 	"net"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/go-metrics"
 )
@@ -53,6 +55,7 @@ var MetricSinkTests = []struct {
 	{"SetGauge", []string{"foo", "bar", "baz"}, float32(42), EmptyTags, HostnameDisabled, "foo.bar.baz:42|g"},
 	{"AddSample", []string{"sample", "thing"}, float32(4), EmptyTags, HostnameDisabled, "sample.thing:4.000000|ms"},
 	{"IncrCounter", []string{"count", "me"}, float32(3), EmptyTags, HostnameDisabled, "count.me:3|c"},
+	{"AddDistribution", []string{"request", "size"}, float64(512), EmptyTags, HostnameDisabled, "request.size:512|d"},
 
 	{"SetGauge", []string{"foo", "baz"}, float32(42), []metrics.Label{{"my_tag", ""}}, HostnameDisabled, "foo.baz:42|g|#my_tag"},
 	{"SetGauge", []string{"foo", "baz"}, float32(42), []metrics.Label{{"my tag", "my_value"}}, HostnameDisabled, "foo.baz:42|g|#my_tag:my_value"},
@@ -61,7 +64,7 @@ var MetricSinkTests = []struct {
 }
 
 func mockNewDogStatsdSink(addr string, labels []metrics.Label, tagWithHostname bool) *DogStatsdSink {
-	dog, _ := NewDogStatsdSink(addr, MockGetHostname())
+	dog, _ := newDogStatsdSink(addr, MockGetHostname(), "", ClientOpts{})
 	_, tags := dog.getFlatkeyAndCombinedLabels(nil, labels)
 	dog.SetTags(tags)
 	if tagWithHostname {
@@ -138,6 +141,13 @@ func TestTaggableMetrics(t *testing.T) {
 	dog.IncrCounterWithLabels([]string{"sample", "thing"}, float32(4), []metrics.Label{{"tagkey", "tagvalue"}})
 	assertServerMatchesExpected(t, server, buf, "sample.thing:4|c|#tagkey:tagvalue")
 
+	dog.AddDistributionWithLabels([]string{"sample", "thing"}, 4, []metrics.Label{{Name: "tagkey", Value: "tagvalue"}})
+	assertServerMatchesExpected(t, server, buf, "sample.thing:4|d|#tagkey:tagvalue")
+
+	dog.EnableDistributions()
+	dog.AddSampleWithLabels([]string{"sample", "thing"}, float32(4), []metrics.Label{{Name: "tagkey", Value: "tagvalue"}})
+	assertServerMatchesExpected(t, server, buf, "sample.thing:4|d|#tagkey:tagvalue")
+
 	dog = mockNewDogStatsdSink(DogStatsdAddr, []metrics.Label{{Name: "global"}}, HostnameEnabled) // with hostname, global tags
 	dog.IncrCounterWithLabels([]string{"sample", "thing"}, float32(4), []metrics.Label{{"tagkey", "tagvalue"}})
 	assertServerMatchesExpected(t, server, buf, "sample.thing:4|c|#global,tagkey:tagvalue,host:test_hostname")
@@ -145,10 +155,29 @@ func TestTaggableMetrics(t *testing.T) {
 
 func assertServerMatchesExpected(t *testing.T, server *net.UDPConn, buf []byte, expected string) {
 	t.Helper()
-	n, _ := server.Read(buf)
-	msg := buf[:n]
-	if string(msg) != expected {
-		t.Fatalf("Line %s does not match expected: %s", string(msg), expected)
+	msg := readServer(server, buf)
+	if msg != expected {
+		t.Fatalf("Line %s does not match expected: %s", msg, expected)
+	}
+}
+
+// readServer returns the next packet received by server, without the
+// telemetry the client sends about itself every 10s
+func readServer(server *net.UDPConn, buf []byte) string {
+	for {
+		n, err := server.Read(buf)
+		if err != nil {
+			return ""
+		}
+		var lines []string
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			if !strings.HasPrefix(line, "datadog.dogstatsd.client.") {
+				lines = append(lines, line)
+			}
+		}
+		if len(lines) > 0 {
+			return strings.Join(lines, "\n")
+		}
 	}
 }
 
@@ -156,3 +185,81 @@ func TestMetricSinkInterface(t *testing.T) {
 	var dd *DogStatsdSink
 	_ = metrics.MetricSink(dd)
 }
+
+func TestSetSanitizer(t *testing.T) {
+	server, buf := setupTestServerAndBuffer(t)
+	defer server.Close()
+
+	dog := mockNewDogStatsdSink(DogStatsdAddr, EmptyTags, HostnameDisabled)
+	dog.SetSanitizer(&metrics.RuneSanitizer{
+		Name: func(r rune) rune {
+			if r == '-' {
+				return '_'
+			}
+			return r
+		},
+		LabelValue: func(r rune) rune {
+			if r == ',' {
+				return -1
+			}
+			return r
+		},
+	})
+	dog.SetGaugeWithLabels([]string{"my-gauge"}, float32(4), []metrics.Label{{Name: "tag", Value: "a,b"}})
+	assertServerMatchesExpected(t, server, buf, "my_gauge:4|g|#tag:ab")
+}
+
+func TestClientOpts(t *testing.T) {
+	server, buf := setupTestServerAndBuffer(t)
+	defer server.Close()
+
+	// Each message fills a datagram
+	dog, err := newDogStatsdSink(DogStatsdAddr, MockGetHostname(), "", ClientOpts{
+		BufferSize:      16,
+		MaxDatagramSize: 8,
+		FlushInterval:   time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer dog.Shutdown()
+	dog.SetGauge([]string{"a"}, 1)
+	dog.SetGauge([]string{"b"}, 2)
+	assertServerMatchesExpected(t, server, buf, "a:1|g")
+	assertServerMatchesExpected(t, server, buf, "b:2|g")
+
+	// Messages are buffered until the interval elapses
+	dog, err = newDogStatsdSink(DogStatsdAddr, MockGetHostname(), "", ClientOpts{FlushInterval: 300 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer dog.Shutdown()
+	dog.SetGauge([]string{"a"}, 1)
+	dog.SetGauge([]string{"b"}, 2)
+	server.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if msg := readServer(server, buf); msg != "" {
+		t.Fatalf("unexpected datagram: %s", msg)
+	}
+	server.SetReadDeadline(time.Time{})
+	assertServerMatchesExpected(t, server, buf, "a:1|g\nb:2|g")
+}
+
+func TestEnvTags(t *testing.T) {
+	t.Setenv("DD_ENV", "prod")
+	t.Setenv("DD_SERVICE", "api")
+	t.Setenv("DD_VERSION", "1.2")
+	t.Setenv("DD_TAGS", "region:eu, zone:a")
+	dog, err := newDogStatsdSink(DogStatsdAddr, MockGetHostname(), "", ClientOpts{})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if got := strings.Join(dog.client.Tags, ","); got != "env:prod,service:api,version:1.2,region:eu,zone:a" {
+		t.Fatalf("bad tags: %s", got)
+	}
+
+	// Tags set explicitly take precedence over those of the environment
+	dog.SetTags([]string{"env:staging", "team:web"})
+	if got := strings.Join(dog.client.Tags, ","); got != "env:staging,team:web,service:api,version:1.2,region:eu,zone:a" {
+		t.Fatalf("bad tags: %s", got)
+	}
+}
@@ -0,0 +1,74 @@
+package datadog
+
+import (
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/hashicorp/go-metrics"
+)
+
+// EventAlertType is the severity of an event
+type EventAlertType string
+
+const (
+	EventInfo    EventAlertType = "info"
+	EventSuccess EventAlertType = "success"
+	EventWarning EventAlertType = "warning"
+	EventError   EventAlertType = "error"
+)
+
+// EventPriority is the priority of an event
+type EventPriority string
+
+const (
+	EventPriorityNormal EventPriority = "normal"
+	EventPriorityLow    EventPriority = "low"
+)
+
+// EventOptions are the optional attributes of an event sent with EmitEvent
+type EventOptions struct {
+	// AlertType defaults to EventInfo
+	AlertType EventAlertType
+
+	// Priority defaults to EventPriorityNormal
+	Priority EventPriority
+
+	// AggregationKey groups the event with others of the same key
+	AggregationKey string
+
+	// SourceTypeName is the source of the event, e.g. "deploy"
+	SourceTypeName string
+
+	// Timestamp defaults to the time the agent receives the event
+	Timestamp time.Time
+
+	// Labels are sent as the tags of the event, along with the tags of the
+	// sink
+	Labels []metrics.Label
+}
+
+// EmitEvent sends an event to the Datadog event stream, such as a deploy or
+// an error, through the same DogStatsD connection as the metrics of the sink.
+// Title and text are required. The host of the event is set like the host
+// tag of metrics, see EnableHostNamePropagation.
+// Ref: https://docs.datadoghq.com/events/guides/dogstatsd/
+func (s *DogStatsdSink) EmitEvent(title, text string, opts EventOptions) error {
+	_, tags := s.getFlatkeyAndCombinedLabels(nil, opts.Labels)
+	event := &statsd.Event{
+		Title:          title,
+		Text:           text,
+		Timestamp:      opts.Timestamp,
+		AggregationKey: opts.AggregationKey,
+		Priority:       statsd.EventPriority(opts.Priority),
+		SourceTypeName: opts.SourceTypeName,
+		AlertType:      statsd.EventAlertType(opts.AlertType),
+		Tags:           tags,
+	}
+	if s.propagateHostname {
+		event.Hostname = s.hostName
+	}
+	if err := event.Check(); err != nil {
+		return err
+	}
+	return s.client.Event(event)
+}
@@ -0,0 +1,39 @@
+package datadog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+func TestEmitEvent(t *testing.T) {
+	server, buf := setupTestServerAndBuffer(t)
+	defer server.Close()
+
+	dog := mockNewDogStatsdSink(DogStatsdAddr, EmptyTags, HostnameDisabled)
+	err := dog.EmitEvent("deploy", "v1.2.3 rolled out", EventOptions{})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assertServerMatchesExpected(t, server, buf, "_e{6,17}:deploy|v1.2.3 rolled out")
+
+	dog = mockNewDogStatsdSink(DogStatsdAddr, []metrics.Label{{Name: "global"}}, HostnameEnabled)
+	err = dog.EmitEvent("failed", "disk full", EventOptions{
+		AlertType:      EventError,
+		Priority:       EventPriorityLow,
+		AggregationKey: "disk",
+		SourceTypeName: "app",
+		Timestamp:      time.Unix(1700000000, 0),
+		Labels:         []metrics.Label{{Name: "volume", Value: "data"}},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assertServerMatchesExpected(t, server, buf,
+		"_e{6,9}:failed|disk full|d:1700000000|h:test_hostname|k:disk|p:low|s:app|t:error|#global,volume:data,host:test_hostname")
+
+	if err := dog.EmitEvent("", "no title", EventOptions{}); err == nil {
+		t.Fatalf("expected error")
+	}
+}
@@ -0,0 +1,146 @@
+package datadog
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	// originDetectionEnvName disables origin detection if set to "false"
+	originDetectionEnvName = "DD_ORIGIN_DETECTION_ENABLED"
+
+	// entityIDTagName is the tag the DogStatsD client sets from the
+	// DD_ENTITY_ID environment variable, usually the pod UID injected
+	// through the Kubernetes downward API
+	entityIDTagName = "dd.internal.entity_id"
+)
+
+// containerIDPattern matches the path elements of cgroups which hold the ID
+// of the container, as created by Docker, containerd, CRI-O and ECS
+var containerIDPattern = regexp.MustCompile(
+	`^(?:[\w.-]+-)?([0-9a-f]{64}|[0-9a-f]{32}-\d+|[0-9a-f]{8}(?:-[0-9a-f]{4}){3}-[0-9a-f]{12})(?:\.scope)?$`)
+
+// detectContainerID returns the ID of the container the process runs in, or
+// the empty string if it doesn't run in one, or origin detection is disabled.
+func detectContainerID() string {
+	if strings.EqualFold(os.Getenv(originDetectionEnvName), "false") {
+		return ""
+	}
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	return parseContainerID(f)
+}
+
+// parseContainerID returns the container ID found in the cgroups listed by r,
+// in the format of /proc/self/cgroup
+func parseContainerID(r io.Reader) string {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// Lines are hierarchy-ID:controllers:path
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		for _, elem := range strings.Split(fields[2], "/") {
+			if m := containerIDPattern.FindStringSubmatch(elem); m != nil {
+				return m[1]
+			}
+		}
+	}
+	return ""
+}
+
+// originWriter is the transport of DogStatsD clients sending to the agent
+// over UDP from a container. It adds the ID of the container to every
+// message, so the agent can tag it with the container's and pod's tags, which
+// it can't tell from the source address of UDP packets. For Unix domain
+// sockets the agent detects the origin from the credentials of the socket.
+// Without a container ID messages are sent unchanged. Payloads the field
+// would grow beyond maxPayload are split between messages into several
+// datagrams.
+type originWriter struct {
+	conn       net.Conn
+	field      []byte
+	maxPayload int
+}
+
+func newOriginWriter(addr, containerID string, maxPayload int) (*originWriter, error) {
+	if addr == "" {
+		addr = agentAddressFromEnvironment()
+	}
+	if addr == "" {
+		return nil, errors.New("No address passed and autodetection from environment failed")
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	w := &originWriter{conn: conn, maxPayload: maxPayload}
+	if containerID != "" {
+		w.field = []byte("|c:" + containerID)
+	}
+	return w, nil
+}
+
+// agentAddressFromEnvironment returns the agent address the DogStatsD client
+// falls back to
+func agentAddressFromEnvironment() string {
+	host := os.Getenv("DD_AGENT_HOST")
+	if host == "" {
+		return ""
+	}
+	port := os.Getenv("DD_DOGSTATSD_PORT")
+	if port == "" {
+		port = "8125"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// Write sends the newline separated messages of data with the container ID
+// field appended to each
+func (w *originWriter) Write(data []byte) (int, error) {
+	if len(w.field) == 0 {
+		_, err := w.conn.Write(data)
+		return len(data), err
+	}
+	lines := bytes.Split(data, []byte{'\n'})
+	buf := make([]byte, 0, len(data)+len(lines)*len(w.field))
+	for _, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		if len(buf) > 0 && len(buf)+1+len(line)+len(w.field) > w.maxPayload {
+			if _, err := w.conn.Write(buf); err != nil {
+				return len(data), err
+			}
+			buf = buf[:0]
+		}
+		if len(buf) > 0 {
+			buf = append(buf, '\n')
+		}
+		buf = append(buf, line...)
+		buf = append(buf, w.field...)
+	}
+	var err error
+	if len(buf) > 0 {
+		_, err = w.conn.Write(buf)
+	}
+	return len(data), err
+}
+
+func (w *originWriter) Close() error {
+	return w.conn.Close()
+}
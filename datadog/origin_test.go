@@ -0,0 +1,90 @@
+package datadog
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+func TestParseContainerID(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		cgroup string
+		expect string
+	}{
+		{"none", "0::/\n1:cpu:/\n", ""},
+		{"docker", "12:memory:/docker/3726184226f5d3147c25fdeab5b60097e378e8a720503a5e19ecfdf29f869860\n",
+			"3726184226f5d3147c25fdeab5b60097e378e8a720503a5e19ecfdf29f869860"},
+		{"systemd", "1:name=systemd:/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod2d3da189_6407_48e3_9ab6_78188d75e609.slice/cri-containerd-3e74d3fd9db4c9dd921ae05c2502fb984d0cde1b36e581b13f79c639da4518a1.scope\n",
+			"3e74d3fd9db4c9dd921ae05c2502fb984d0cde1b36e581b13f79c639da4518a1"},
+		{"ecs", "9:perf_event:/ecs/34dc0b5e626f2c5c4c5170e34b10e765-1234567890\n", "34dc0b5e626f2c5c4c5170e34b10e765-1234567890"},
+		{"uuid", "1:cpu:/kubepods/pod1234/8d5c2e7a-9b3e-4f1a-a7a3-6d5e8d4c2b1f\n", "8d5c2e7a-9b3e-4f1a-a7a3-6d5e8d4c2b1f"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := parseContainerID(strings.NewReader(tc.cgroup)); got != tc.expect {
+				t.Fatalf("got %q, want %q", got, tc.expect)
+			}
+		})
+	}
+}
+
+func TestOriginDetection(t *testing.T) {
+	server, buf := setupTestServerAndBuffer(t)
+	defer server.Close()
+
+	dog, err := newDogStatsdSink(DogStatsdAddr, MockGetHostname(), "abc123", ClientOpts{})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	dog.SetGaugeWithLabels([]string{"foo", "bar"}, 42, []metrics.Label{{Name: "tagkey", Value: "tagvalue"}})
+	assertServerMatchesExpected(t, server, buf, "foo.bar:42|g|#tagkey:tagvalue|c:abc123")
+
+	if err := dog.ServiceCheck("db", StatusOK, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assertServerMatchesExpected(t, server, buf, "_sc|db|0|c:abc123")
+}
+
+func TestSetTags_KeepsEntityID(t *testing.T) {
+	t.Setenv("DD_ENTITY_ID", "pod-uid")
+	dog, err := newDogStatsdSink(DogStatsdAddr, MockGetHostname(), "", ClientOpts{})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	dog.SetTags([]string{"env:prod"})
+	if got := strings.Join(dog.client.Tags, ","); got != "env:prod,dd.internal.entity_id:pod-uid" {
+		t.Fatalf("bad tags: %s", got)
+	}
+}
+
+func TestOriginWriter_SplitsPayloads(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer conn.Close()
+
+	w, err := newOriginWriter(conn.LocalAddr().String(), "abc", 20)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer w.Close()
+	if _, err := w.Write([]byte("aaaa:1|c\nbbbb:1|c\n")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	buf := make([]byte, 64)
+	for _, expect := range []string{"aaaa:1|c|c:abc", "bbbb:1|c|c:abc"} {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if got := string(buf[:n]); got != expect {
+			t.Fatalf("got %q, want %q", got, expect)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package datadog
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/hashicorp/go-metrics"
+)
+
+// EnableValuePacking makes the sink send the values of counters, samples and
+// distributions emitted during each interval in one message per metric and
+// set of tags, using the name:value1:value2|type syntax of DogStatsD
+// protocol v1.1, instead of one message per value. Hot metrics then take a
+// fraction of the packets. Values are only sent at the end of the interval,
+// or once the message of a metric fills a packet. It requires Datadog agent
+// 6.25 or 7.25 or later, and is only supported over UDP. It must be called
+// before any metrics are emitted.
+func (s *DogStatsdSink) EnableValuePacking(interval time.Duration) error {
+	if strings.HasPrefix(s.addr, statsd.UnixAddressPrefix) {
+		return errors.New("value packing is not supported over Unix domain sockets")
+	}
+	maxPayload := s.clientOpts.maxDatagramSize()
+	w, err := newOriginWriter(s.addr, s.containerID, maxPayload)
+	if err != nil {
+		return err
+	}
+	s.packer = newPacker(w, interval, maxPayload, len(w.field))
+	return nil
+}
+
+// packer collects values of the same metric and tags and sends them packed
+// into one message
+type packer struct {
+	maxPayload int
+	overhead   int // Bytes the writer adds to every message
+
+	mu     sync.Mutex
+	w      io.WriteCloser
+	series map[string]*packedSeries
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// packedSeries is the message of a metric and tags being packed
+type packedSeries struct {
+	header string // name:
+	values []byte // value1:value2
+	footer string // |type|#tags
+}
+
+func (p *packedSeries) line() string {
+	return p.header + string(p.values) + p.footer
+}
+
+func newPacker(w io.WriteCloser, interval time.Duration, maxPayload, overhead int) *packer {
+	p := &packer{
+		w:          w,
+		maxPayload: maxPayload,
+		overhead:   overhead,
+		series:     make(map[string]*packedSeries),
+		stopCh:     make(chan struct{}),
+		doneCh:     make(chan struct{}),
+	}
+	go p.run(interval)
+	return p
+}
+
+func (p *packer) run(interval time.Duration) {
+	defer close(p.doneCh)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.flush()
+		case <-p.stopCh:
+			p.flush()
+			return
+		}
+	}
+}
+
+// add packs value into the message of the metric name of type typ and tags
+func (p *packer) add(name, typ, value string, tags []string) {
+	footer := "|" + typ
+	if len(tags) > 0 {
+		footer += "|#" + strings.Join(tags, ",")
+	}
+	key := name + footer
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	series, ok := p.series[key]
+	if !ok {
+		series = &packedSeries{header: name + ":", footer: footer}
+		p.series[key] = series
+	}
+	if len(series.values) > 0 {
+		// Send the message once adding the value would overflow a packet
+		if len(series.header)+len(series.values)+1+len(value)+len(series.footer)+p.overhead > p.maxPayload {
+			p.write(p.w, series.line())
+			series.values = series.values[:0]
+		} else {
+			series.values = append(series.values, ':')
+		}
+	}
+	series.values = append(series.values, value...)
+}
+
+// flush sends the messages of all metrics, combining as many of them into a
+// packet as fit
+func (p *packer) flush() {
+	p.mu.Lock()
+	lines := make([]string, 0, len(p.series))
+	for _, series := range p.series {
+		lines = append(lines, series.line())
+	}
+	p.series = make(map[string]*packedSeries)
+	w := p.w
+	p.mu.Unlock()
+
+	sort.Strings(lines)
+	// Each message grows by the overhead of the writer once sent
+	var payload []byte
+	var n int
+	for _, line := range lines {
+		if n > 0 && len(payload)+1+len(line)+(n+1)*p.overhead > p.maxPayload {
+			p.write(w, string(payload))
+			payload = payload[:0]
+			n = 0
+		}
+		if n > 0 {
+			payload = append(payload, '\n')
+		}
+		payload = append(payload, line...)
+		n++
+	}
+	if len(payload) > 0 {
+		p.write(w, string(payload))
+	}
+}
+
+// wrapWriter replaces the writer of the packer with the result of wrap
+func (p *packer) wrapWriter(wrap func(io.WriteCloser) io.WriteCloser) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.w = wrap(p.w)
+}
+
+func (p *packer) write(w io.Writer, payload string) {
+	if _, err := io.WriteString(w, payload); err != nil {
+		metrics.LogError("Error sending packed DogStatsD metrics", "sink", "datadog", "class", "write", "error", err)
+	}
+}
+
+// close sends the pending values and closes the connection
+func (p *packer) close() {
+	close(p.stopCh)
+	<-p.doneCh
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.w.Close()
+}
@@ -0,0 +1,88 @@
+package datadog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+type recordingWriter struct {
+	payloads []string
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.payloads = append(w.payloads, string(p))
+	return len(p), nil
+}
+
+func (w *recordingWriter) Close() error {
+	return nil
+}
+
+func TestValuePacking(t *testing.T) {
+	server, buf := setupTestServerAndBuffer(t)
+	defer server.Close()
+
+	dog := mockNewDogStatsdSink(DogStatsdAddr, []metrics.Label{{Name: "global"}}, HostnameDisabled)
+	if err := dog.EnableValuePacking(time.Hour); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	tags := []metrics.Label{{Name: "tagkey", Value: "tagvalue"}}
+	for _, v := range []float32{1, 2, 3} {
+		dog.IncrCounterWithLabels([]string{"count", "me"}, v, tags)
+	}
+	dog.IncrCounter([]string{"count", "me"}, 1)
+	dog.AddSample([]string{"latency"}, 4)
+	dog.AddSample([]string{"latency"}, 5.5)
+	dog.AddDistribution([]string{"size"}, 512)
+	dog.Shutdown()
+
+	assertServerMatchesExpected(t, server, buf,
+		"count.me:1:2:3|c|#global,tagkey:tagvalue\ncount.me:1|c|#global\nlatency:4.000000:5.500000|ms|#global\nsize:512|d|#global")
+
+	dog, _ = newDogStatsdSink("unix:///tmp/dsd.socket", MockGetHostname(), "", ClientOpts{})
+	if err := dog.EnableValuePacking(time.Hour); err == nil {
+		t.Fatalf("expected error for Unix domain socket")
+	}
+}
+
+func TestPacker_Overflow(t *testing.T) {
+	w := &recordingWriter{}
+	p := newPacker(w, time.Hour, 20, 0)
+	for _, v := range []string{"111", "222", "333", "444"} {
+		p.add("a.b", "c", v, nil)
+	}
+	p.add("c.d", "c", "1", nil)
+	p.close()
+
+	expect := []string{"a.b:111:222:333|c", "a.b:444|c\nc.d:1|c"}
+	if len(w.payloads) != len(expect) {
+		t.Fatalf("bad payloads: %q", w.payloads)
+	}
+	for i := range expect {
+		if w.payloads[i] != expect[i] {
+			t.Fatalf("bad payloads: %q", w.payloads)
+		}
+	}
+}
+
+func TestPacker_Overhead(t *testing.T) {
+	w := &recordingWriter{}
+	p := newPacker(w, time.Hour, 20, 4)
+	for _, v := range []string{"111", "222", "333", "444"} {
+		p.add("a.b", "c", v, nil)
+	}
+	p.add("c.d", "c", "1", nil)
+	p.close()
+
+	expect := []string{"a.b:111:222|c", "a.b:333:444|c", "c.d:1|c"}
+	if len(w.payloads) != len(expect) {
+		t.Fatalf("bad payloads: %q", w.payloads)
+	}
+	for i := range expect {
+		if w.payloads[i] != expect[i] {
+			t.Fatalf("bad payloads: %q", w.payloads)
+		}
+	}
+}
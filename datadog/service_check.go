@@ -0,0 +1,37 @@
+package datadog
+
+import (
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/hashicorp/go-metrics"
+)
+
+// Status is the state reported by a service check
+type Status byte
+
+const (
+	StatusOK       = Status(statsd.Ok)
+	StatusWarning  = Status(statsd.Warn)
+	StatusCritical = Status(statsd.Critical)
+	StatusUnknown  = Status(statsd.Unknown)
+)
+
+// ServiceCheck reports the status of the named check, which Datadog monitors
+// can alert on, e.g. whether a dependency is reachable. The name is
+// sanitized like metric names and the labels are sent as tags, along with
+// the tags of the sink.
+// Ref: https://docs.datadoghq.com/developers/service_checks/dogstatsd_service_checks_submission/
+func (s *DogStatsdSink) ServiceCheck(name string, status Status, labels []metrics.Label) error {
+	_, tags := s.getFlatkeyAndCombinedLabels(nil, labels)
+	check := &statsd.ServiceCheck{
+		Name:   s.sanitizer.SanitizeName(name),
+		Status: statsd.ServiceCheckStatus(status),
+		Tags:   tags,
+	}
+	if s.propagateHostname {
+		check.Hostname = s.hostName
+	}
+	if err := check.Check(); err != nil {
+		return err
+	}
+	return s.client.ServiceCheck(check)
+}
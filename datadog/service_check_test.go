@@ -0,0 +1,29 @@
+package datadog
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+func TestServiceCheck(t *testing.T) {
+	server, buf := setupTestServerAndBuffer(t)
+	defer server.Close()
+
+	dog := mockNewDogStatsdSink(DogStatsdAddr, EmptyTags, HostnameDisabled)
+	if err := dog.ServiceCheck("db.reachable", StatusOK, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assertServerMatchesExpected(t, server, buf, "_sc|db.reachable|0")
+
+	dog = mockNewDogStatsdSink(DogStatsdAddr, []metrics.Label{{Name: "global"}}, HostnameEnabled)
+	err := dog.ServiceCheck("db reachable", StatusCritical, []metrics.Label{{Name: "db", Value: "primary"}})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assertServerMatchesExpected(t, server, buf, "_sc|db_reachable|2|h:test_hostname|#global,db:primary,host:test_hostname")
+
+	if err := dog.ServiceCheck("", StatusOK, nil); err == nil {
+		t.Fatalf("expected error")
+	}
+}
@@ -0,0 +1,126 @@
+package datadog
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/hashicorp/go-metrics"
+)
+
+// EnableClientTelemetry reports the telemetry of the DogStatsD client, the
+// packets and bytes it sent, and those it dropped because its queue was full
+// or sending failed, as counters to sink, such as an InmemSink or the
+// Metrics of the application, so the loss of metrics can be alerted on. The
+// counters are named after the metrics of the client without the datadog.
+// prefix, e.g. dogstatsd.client.packets_dropped_queue, and are incremented
+// every 10s, when the client sends its telemetry to the agent. As the
+// telemetry shares the queue of the client with the metrics, it is dropped
+// too while the queue is full, and the values of those intervals are lost.
+// The intervals whose telemetry never arrived are counted separately, as
+// dogstatsd.client.telemetry_dropped. It must be called before any metrics
+// are emitted, and is only supported over UDP.
+func (s *DogStatsdSink) EnableClientTelemetry(sink metrics.MetricSink) error {
+	if strings.HasPrefix(s.addr, statsd.UnixAddressPrefix) {
+		return errors.New("client telemetry is not supported over Unix domain sockets")
+	}
+	prev := s.telemetry
+	s.telemetry = sink
+	if err := s.replaceClient(); err != nil {
+		s.telemetry = prev
+		return err
+	}
+	return nil
+}
+
+// replaceClient replaces the client of the sink with one sending over UDP,
+// through the API fallback and reporting its telemetry if they are enabled
+func (s *DogStatsdSink) replaceClient() error {
+	agent, err := newOriginWriter(s.addr, s.containerID, s.clientOpts.maxDatagramSize())
+	if err != nil {
+		return err
+	}
+	var w io.WriteCloser = agent
+	if s.api != nil {
+		w = &fallbackWriter{agent: w, api: s.api, retry: s.api.opts.RetryInterval}
+	}
+	if s.telemetry != nil {
+		w = newTelemetryWriter(w, s.telemetry, metrics.SystemClock)
+	}
+
+	client, err := newUDPClient(w, s.clientOpts)
+	if err != nil {
+		w.Close()
+		return err
+	}
+	client.Tags = s.client.Tags
+	s.client.Close()
+	s.client = client
+	return nil
+}
+
+// telemetryWriter passes payloads on to the agent, incrementing a counter of
+// sink for every telemetry message of the client in them
+type telemetryWriter struct {
+	io.WriteCloser
+	sink  metrics.MetricSink
+	clock metrics.Clock
+
+	// last is when the telemetry of the client last arrived, or the writer
+	// was created
+	lock sync.Mutex
+	last time.Time
+}
+
+func newTelemetryWriter(w io.WriteCloser, sink metrics.MetricSink, clock metrics.Clock) *telemetryWriter {
+	return &telemetryWriter{WriteCloser: w, sink: sink, clock: clock, last: clock.Now()}
+}
+
+func (w *telemetryWriter) Write(data []byte) (int, error) {
+	if bytes.Contains(data, []byte(clientTelemetryPrefix)) {
+		for _, line := range strings.Split(string(data), "\n") {
+			w.report(line)
+		}
+	}
+	return w.WriteCloser.Write(data)
+}
+
+// report increments the counter of a telemetry message of the form
+// datadog.dogstatsd.client.name:value|c|#tags
+func (w *telemetryWriter) report(line string) {
+	if !strings.HasPrefix(line, clientTelemetryPrefix) {
+		return
+	}
+	name := strings.TrimPrefix(line, "datadog.")
+	if strings.HasPrefix(name, "dogstatsd.client.packets_sent:") {
+		w.arrived()
+	}
+	i := strings.IndexByte(name, ':')
+	j := strings.IndexByte(name, '|')
+	if i <= 0 || j < i {
+		return
+	}
+	v, err := strconv.ParseFloat(name[i+1:j], 32)
+	if err != nil || v == 0 {
+		return
+	}
+	w.sink.IncrCounter(strings.Split(name[:i], "."), float32(v))
+}
+
+// arrived counts the intervals whose telemetry was dropped since it last
+// arrived. The client sends packets_sent first, every TelemetryInterval.
+func (w *telemetryWriter) arrived() {
+	now := w.clock.Now()
+	w.lock.Lock()
+	elapsed := now.Sub(w.last)
+	w.last = now
+	w.lock.Unlock()
+	if dropped := (elapsed+statsd.TelemetryInterval/2)/statsd.TelemetryInterval - 1; dropped > 0 {
+		w.sink.IncrCounter([]string{"dogstatsd", "client", "telemetry_dropped"}, float32(dropped))
+	}
+}
@@ -0,0 +1,78 @@
+package datadog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/hashicorp/go-metrics"
+	"github.com/hashicorp/go-metrics/metricstest"
+)
+
+func TestTelemetryWriter(t *testing.T) {
+	inm := metrics.NewInmemSink(time.Minute, time.Minute)
+	agent := &recordingWriter{}
+	clock := metricstest.NewClock(time.Unix(0, 0))
+	w := newTelemetryWriter(agent, inm, clock)
+	clock.Advance(statsd.TelemetryInterval)
+
+	w.Write([]byte("count.me:3|c"))
+	w.Write([]byte("count.me:1|c\n" +
+		"datadog.dogstatsd.client.packets_sent:12|c|#client:go,transport:custom\n" +
+		"datadog.dogstatsd.client.packets_dropped_queue:2|c|#client:go,transport:custom\n" +
+		"datadog.dogstatsd.client.bytes_dropped_writer:0|c|#client:go,transport:custom"))
+
+	if len(agent.payloads) != 2 {
+		t.Fatalf("bad payloads: %q", agent.payloads)
+	}
+	counters := inm.Data()[0].Counters
+	if len(counters) != 2 {
+		t.Fatalf("bad counters: %v", counters)
+	}
+	if c := counters["dogstatsd.client.packets_sent"]; c.Sum != 12 {
+		t.Fatalf("bad packets_sent: %v", c)
+	}
+	if c := counters["dogstatsd.client.packets_dropped_queue"]; c.Sum != 2 {
+		t.Fatalf("bad packets_dropped_queue: %v", c)
+	}
+}
+
+func TestTelemetryWriter_QueueFull(t *testing.T) {
+	inm := metrics.NewInmemSink(time.Minute, time.Minute)
+	clock := metricstest.NewClock(time.Unix(0, 0))
+	w := newTelemetryWriter(&recordingWriter{}, inm, clock)
+	batch := []byte("datadog.dogstatsd.client.packets_sent:1|c|#client:go,transport:custom\n" +
+		"datadog.dogstatsd.client.packets_dropped_queue:0|c|#client:go,transport:custom")
+
+	clock.Advance(statsd.TelemetryInterval)
+	w.Write(batch)
+	if _, ok := inm.Data()[0].Counters["dogstatsd.client.telemetry_dropped"]; ok {
+		t.Fatalf("unexpected telemetry_dropped: %v", inm.Data()[0].Counters)
+	}
+
+	// The two batches sent while the queue was full never arrive, only the
+	// one after it drained
+	clock.Advance(3 * statsd.TelemetryInterval)
+	w.Write(batch)
+	if c := inm.Data()[0].Counters["dogstatsd.client.telemetry_dropped"]; c.Sum != 2 {
+		t.Fatalf("bad telemetry_dropped: %v", c)
+	}
+}
+
+func TestEnableClientTelemetry(t *testing.T) {
+	server, buf := setupTestServerAndBuffer(t)
+	defer server.Close()
+
+	dog := mockNewDogStatsdSink(DogStatsdAddr, EmptyTags, HostnameDisabled)
+	if err := dog.EnableClientTelemetry(metrics.NewInmemSink(time.Minute, time.Minute)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	dog.IncrCounter([]string{"count", "me"}, 3)
+	assertServerMatchesExpected(t, server, buf, "count.me:3|c")
+	dog.Shutdown()
+
+	dog, _ = newDogStatsdSink("unix:///tmp/dsd.socket", MockGetHostname(), "", ClientOpts{})
+	if err := dog.EnableClientTelemetry(metrics.NewInmemSink(time.Minute, time.Minute)); err == nil {
+		t.Fatalf("expected error for Unix domain socket")
+	}
+}
@@ -0,0 +1,258 @@
+package metrics
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// DeriveFunc computes a derived value from the metrics seen during the last
+// interval. If ok is false nothing is emitted for the interval, for example
+// because a ratio's denominator was zero.
+type DeriveFunc func(v *IntervalValues) (val float32, ok bool)
+
+// IntervalValues exposes the metrics that passed through a DerivedSink during
+// the interval that just ended. Keys are matched as the sink sees them, so any
+// service or type prefixes added by Metrics must be included. Values with the
+// same key but different labels are combined.
+type IntervalValues struct {
+	counters   map[string]float64
+	gauges     map[string]float32
+	prevGauges map[string]float32
+}
+
+// Counter returns the sum of all counter increments for key during the
+// interval.
+func (v *IntervalValues) Counter(key []string) float64 {
+	return v.counters[strings.Join(key, ".")]
+}
+
+// Gauge returns the last value a gauge was set to. Gauges keep their value
+// across intervals until they are set again, or expire, see
+// DerivedSink.SetExpirationPolicy.
+func (v *IntervalValues) Gauge(key []string) (float32, bool) {
+	val, ok := v.gauges[strings.Join(key, ".")]
+	return val, ok
+}
+
+// PreviousGauge returns the value a gauge had at the end of the previous
+// interval.
+func (v *IntervalValues) PreviousGauge(key []string) (float32, bool) {
+	val, ok := v.prevGauges[strings.Join(key, ".")]
+	return val, ok
+}
+
+// Ratio derives numerator/denominator from the counter sums of the interval,
+// e.g. an error ratio from error and request counters. Nothing is emitted for
+// intervals where the denominator is zero.
+func Ratio(numerator, denominator []string) DeriveFunc {
+	return func(v *IntervalValues) (float32, bool) {
+		denom := v.Counter(denominator)
+		if denom == 0 {
+			return 0, false
+		}
+		return float32(v.Counter(numerator) / denom), true
+	}
+}
+
+// Delta derives the change of a cumulative gauge since the previous interval.
+// Nothing is emitted until the gauge has been seen in two intervals.
+func Delta(gauge []string) DeriveFunc {
+	return func(v *IntervalValues) (float32, bool) {
+		cur, ok := v.Gauge(gauge)
+		if !ok {
+			return 0, false
+		}
+		prev, ok := v.PreviousGauge(gauge)
+		if !ok {
+			return 0, false
+		}
+		return cur - prev, true
+	}
+}
+
+type derivation struct {
+	key []string
+	fn  DeriveFunc
+}
+
+// DerivedSink wraps a MetricSink and computes derived series from the metrics
+// passing through it. All metrics are forwarded unchanged; once per interval
+// every registered derivation is evaluated and its result is emitted to the
+// wrapped sink as a gauge.
+type DerivedSink struct {
+	sink     MetricSink
+	interval time.Duration
+
+	lock        sync.Mutex
+	derivations []derivation
+	counters    map[string]float64
+	gauges      map[string]float32
+	prevGauges  map[string]float32
+	expiration  *ExpirationPolicy
+	gaugeExpiry map[string]time.Time // Only has the gauges which expire
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewDerivedSink creates a DerivedSink that evaluates its derivations every
+// interval and emits them to sink.
+func NewDerivedSink(sink MetricSink, interval time.Duration) *DerivedSink {
+	d := &DerivedSink{
+		sink:        sink,
+		interval:    interval,
+		counters:    make(map[string]float64),
+		gauges:      make(map[string]float32),
+		prevGauges:  make(map[string]float32),
+		gaugeExpiry: make(map[string]time.Time),
+		stopCh:      make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+// Derive registers a derived series. The value returned by fn is emitted as
+// a gauge under key at the end of every interval.
+func (d *DerivedSink) Derive(key []string, fn DeriveFunc) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.derivations = append(d.derivations, derivation{key: key, fn: fn})
+}
+
+// SetExpirationPolicy makes gauges which have not been set for longer than
+// the TTL the policy gives their key disappear at the end of the interval,
+// so the gauges of departed peers or sessions don't accumulate. By default,
+// or when policy is nil, gauges never expire.
+func (d *DerivedSink) SetExpirationPolicy(policy *ExpirationPolicy) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.expiration = policy
+	d.gaugeExpiry = make(map[string]time.Time)
+}
+
+func (d *DerivedSink) SetGauge(key []string, val float32) {
+	d.SetGaugeWithLabels(key, val, nil)
+}
+
+func (d *DerivedSink) SetGaugeWithLabels(key []string, val float32, labels []Label) {
+	k := strings.Join(key, ".")
+	d.lock.Lock()
+	d.gauges[k] = val
+	if d.expiration != nil {
+		if ttl := d.expiration.TTL(key); ttl != 0 {
+			d.gaugeExpiry[k] = time.Now().Add(ttl)
+		} else {
+			delete(d.gaugeExpiry, k)
+		}
+	}
+	d.lock.Unlock()
+	d.sink.SetGaugeWithLabels(key, val, labels)
+}
+
+func (d *DerivedSink) EmitKey(key []string, val float32) {
+	d.sink.EmitKey(key, val)
+}
+
+func (d *DerivedSink) IncrCounter(key []string, val float32) {
+	d.IncrCounterWithLabels(key, val, nil)
+}
+
+func (d *DerivedSink) IncrCounterWithLabels(key []string, val float32, labels []Label) {
+	d.lock.Lock()
+	d.counters[strings.Join(key, ".")] += float64(val)
+	d.lock.Unlock()
+	d.sink.IncrCounterWithLabels(key, val, labels)
+}
+
+func (d *DerivedSink) IncrCounterWithExemplar(key []string, val float32, labels []Label, exemplar []Label) {
+	d.lock.Lock()
+	d.counters[strings.Join(key, ".")] += float64(val)
+	d.lock.Unlock()
+	incrCounterWithExemplar(d.sink, key, val, labels, exemplar)
+}
+
+func (d *DerivedSink) AddSample(key []string, val float32) {
+	d.AddSampleWithLabels(key, val, nil)
+}
+
+func (d *DerivedSink) AddSampleWithLabels(key []string, val float32, labels []Label) {
+	d.sink.AddSampleWithLabels(key, val, labels)
+}
+
+func (d *DerivedSink) AddSampleWithExemplar(key []string, val float32, labels []Label, exemplar []Label) {
+	addSampleWithExemplar(d.sink, key, val, labels, exemplar)
+}
+
+func (d *DerivedSink) AddPrecisionSample(key []string, val float64) {
+	d.AddPrecisionSampleWithLabels(key, val, nil)
+}
+
+func (d *DerivedSink) AddPrecisionSampleWithLabels(key []string, val float64, labels []Label) {
+	addPrecisionSample(d.sink, key, val, labels)
+}
+
+// SetHistogramBuckets passes the buckets on to the wrapped sink if it
+// supports histograms.
+func (d *DerivedSink) SetHistogramBuckets(buckets *HistogramBuckets) {
+	configureHistogramBuckets(d.sink, buckets)
+}
+
+// Shutdown stops evaluating derivations and shuts down the wrapped sink.
+func (d *DerivedSink) Shutdown() {
+	d.stopOnce.Do(func() {
+		close(d.stopCh)
+	})
+	if ss, ok := d.sink.(ShutdownSink); ok {
+		ss.Shutdown()
+	}
+}
+
+// run evaluates the derivations every interval until the sink is shut down
+func (d *DerivedSink) run() {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.flush()
+		case <-d.stopCh:
+			return
+		}
+	}
+}
+
+// flush ends the current interval: it evaluates all derivations against the
+// values seen so far, resets the counters, drops the expired gauges and emits
+// the results.
+func (d *DerivedSink) flush() {
+	d.lock.Lock()
+	now := time.Now()
+	for k, expiry := range d.gaugeExpiry {
+		if expiry.Before(now) {
+			delete(d.gauges, k)
+			delete(d.gaugeExpiry, k)
+		}
+	}
+	values := &IntervalValues{
+		counters:   d.counters,
+		gauges:     make(map[string]float32, len(d.gauges)),
+		prevGauges: d.prevGauges,
+	}
+	for k, v := range d.gauges {
+		values.gauges[k] = v
+	}
+	derivations := make([]derivation, len(d.derivations))
+	copy(derivations, d.derivations)
+
+	d.counters = make(map[string]float64)
+	d.prevGauges = values.gauges
+	d.lock.Unlock()
+
+	for _, der := range derivations {
+		if val, ok := der.fn(values); ok {
+			d.sink.SetGauge(der.key, val)
+		}
+	}
+}
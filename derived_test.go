@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDerivedSink_Ratio(t *testing.T) {
+	m := &MockSink{}
+	d := NewDerivedSink(m, time.Hour)
+	defer d.Shutdown()
+
+	d.Derive([]string{"http", "error_ratio"}, Ratio([]string{"http", "errors"}, []string{"http", "requests"}))
+
+	d.IncrCounter([]string{"http", "requests"}, 3)
+	d.IncrCounterWithLabels([]string{"http", "requests"}, 1, []Label{{"code", "500"}})
+	d.IncrCounterWithLabels([]string{"http", "errors"}, 1, []Label{{"code", "500"}})
+
+	// All metrics are passed through
+	if len(m.getKeys()) != 3 {
+		t.Fatalf("expected metrics to be forwarded, got %v", m.getKeys())
+	}
+
+	d.flush()
+	if got, want := m.getKeys()[3], []string{"http", "error_ratio"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got key %v want %v", got, want)
+	}
+	if m.vals[3] != 0.25 {
+		t.Fatalf("bad val: %v", m.vals[3])
+	}
+
+	// Counters are reset each interval, so there is nothing to divide by
+	d.flush()
+	if len(m.getKeys()) != 4 {
+		t.Fatalf("expected no derived value for an empty interval, got %v", m.getKeys())
+	}
+}
+
+func TestDerivedSink_Delta(t *testing.T) {
+	m := &MockSink{}
+	d := NewDerivedSink(m, time.Hour)
+	defer d.Shutdown()
+
+	d.Derive([]string{"bytes", "delta"}, Delta([]string{"bytes", "total"}))
+
+	d.SetGauge([]string{"bytes", "total"}, 100)
+	d.flush()
+	if len(m.getKeys()) != 1 {
+		t.Fatalf("expected no delta after the first interval, got %v", m.getKeys())
+	}
+
+	d.SetGauge([]string{"bytes", "total"}, 130)
+	d.flush()
+	if got, want := m.getKeys()[2], []string{"bytes", "delta"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got key %v want %v", got, want)
+	}
+	if m.vals[2] != 30 {
+		t.Fatalf("bad val: %v", m.vals[2])
+	}
+
+	// The gauge keeps its value when not updated
+	d.flush()
+	if m.vals[3] != 0 {
+		t.Fatalf("bad val: %v", m.vals[3])
+	}
+}
+
+func TestDerivedSink_ExpirationPolicy(t *testing.T) {
+	m := &MockSink{}
+	d := NewDerivedSink(m, time.Hour)
+	defer d.Shutdown()
+	d.SetExpirationPolicy(NewExpirationPolicy(0, map[string]time.Duration{"session": time.Millisecond}))
+
+	d.SetGauge([]string{"session", "a"}, 1)
+	d.SetGauge([]string{"bytes", "total"}, 100)
+	time.Sleep(5 * time.Millisecond)
+	d.flush()
+
+	// The idle session gauge expired, the one without a TTL is kept
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if len(d.gauges) != 1 || d.gauges["bytes.total"] != 100 || len(d.gaugeExpiry) != 0 {
+		t.Fatalf("bad gauges: %v %v", d.gauges, d.gaugeExpiry)
+	}
+}
+
+func TestDerivedSink_Custom(t *testing.T) {
+	m := &MockSink{}
+	d := NewDerivedSink(m, 10*time.Millisecond)
+
+	d.Derive([]string{"custom"}, func(v *IntervalValues) (float32, bool) {
+		g, ok := v.Gauge([]string{"queue", "depth"})
+		return g * 2, ok
+	})
+	d.SetGauge([]string{"queue", "depth"}, 21)
+
+	deadline := time.Now().Add(time.Second)
+	for len(m.getKeys()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("derived value was not emitted")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	d.Shutdown()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !reflect.DeepEqual(m.keys[1], []string{"custom"}) || m.vals[1] != 42 {
+		t.Fatalf("bad derived value %v %v", m.keys[1], m.vals[1])
+	}
+	if !m.shutdown {
+		t.Fatalf("wrapped sink was not shut down")
+	}
+}
+
+func TestDerivedSinkInterface(t *testing.T) {
+	var d *DerivedSink
+	_ = MetricSink(d)
+	_ = ShutdownSink(d)
+}
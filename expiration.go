@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"strings"
+	"time"
+
+	iradix "github.com/hashicorp/go-immutable-radix"
+)
+
+// ExpirationPolicy decides how long a metric may go without being updated
+// before an aggregating sink stops reporting it. Rules are keyed by metric
+// prefix, with '.' as the separator, and the longest matching prefix wins,
+// the same way Config.AllowedPrefixes and Config.BlockedPrefixes are matched.
+// A TTL of zero means the metric never expires.
+type ExpirationPolicy struct {
+	defaultTTL time.Duration
+	rules      *iradix.Tree
+}
+
+// NewExpirationPolicy creates an ExpirationPolicy which applies defaultTTL to
+// every key that doesn't match one of the prefixes in rules.
+func NewExpirationPolicy(defaultTTL time.Duration, rules map[string]time.Duration) *ExpirationPolicy {
+	tree := iradix.New()
+	for prefix, ttl := range rules {
+		tree, _, _ = tree.Insert([]byte(prefix), ttl)
+	}
+	return &ExpirationPolicy{
+		defaultTTL: defaultTTL,
+		rules:      tree,
+	}
+}
+
+// TTL returns how long the given key may go without updates before it
+// expires. Zero means it never expires.
+func (p *ExpirationPolicy) TTL(key []string) time.Duration {
+	if p.rules.Len() == 0 {
+		return p.defaultTTL
+	}
+	_, ttl, ok := p.rules.Root().LongestPrefix([]byte(strings.Join(key, ".")))
+	if !ok {
+		return p.defaultTTL
+	}
+	return ttl.(time.Duration)
+}
+
+// Expired returns whether a key last updated at lastUpdate has expired at
+// time now.
+func (p *ExpirationPolicy) Expired(key []string, lastUpdate, now time.Time) bool {
+	ttl := p.TTL(key)
+	return ttl != 0 && lastUpdate.Add(ttl).Before(now)
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpirationPolicy_TTL(t *testing.T) {
+	p := NewExpirationPolicy(time.Minute, map[string]time.Duration{
+		"peer":         10 * time.Second,
+		"peer.session": 0,
+	})
+
+	for _, tc := range []struct {
+		key    []string
+		expect time.Duration
+	}{
+		{[]string{"other", "key"}, time.Minute},
+		{[]string{"peer", "rtt"}, 10 * time.Second},
+		{[]string{"peer", "session", "count"}, 0},
+	} {
+		if got := p.TTL(tc.key); got != tc.expect {
+			t.Fatalf("TTL(%v) = %v, want %v", tc.key, got, tc.expect)
+		}
+	}
+
+	now := time.Now()
+	if !p.Expired([]string{"peer", "rtt"}, now.Add(-11*time.Second), now) {
+		t.Fatalf("expected key to be expired")
+	}
+	if p.Expired([]string{"peer", "rtt"}, now.Add(-9*time.Second), now) {
+		t.Fatalf("expected key not to be expired")
+	}
+	if p.Expired([]string{"peer", "session", "count"}, now.Add(-time.Hour), now) {
+		t.Fatalf("a zero TTL should never expire")
+	}
+
+	empty := NewExpirationPolicy(0, nil)
+	if empty.TTL([]string{"any"}) != 0 {
+		t.Fatalf("expected default TTL")
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+// +build !windows
+
+package metrics
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileDescriptors returns the number of open file descriptors of the process
+// and their soft limit, read from /proc on Linux and /dev/fd on BSDs and
+// macOS
+func fileDescriptors() (open, max uint64, ok bool) {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 0, 0, false
+	}
+	for _, dir := range []string{"/proc/self/fd", "/dev/fd"} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		// Reading the directory opens a descriptor of its own
+		return uint64(len(entries)) - 1, uint64(limit.Cur), true
+	}
+	return 0, 0, false
+}
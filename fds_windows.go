@@ -0,0 +1,9 @@
+//go:build windows
+// +build windows
+
+package metrics
+
+// fileDescriptors is not supported on Windows, which has handles instead
+func fileDescriptors() (open, max uint64, ok bool) {
+	return 0, 0, false
+}
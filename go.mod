@@ -1,20 +1,35 @@
 module github.com/hashicorp/go-metrics
 
-go 1.12
+go 1.21
 
 require (
 	github.com/DataDog/datadog-go v3.2.0+incompatible
 	github.com/circonus-labs/circonus-gometrics v2.3.1+incompatible
+	github.com/go-logr/logr v1.4.2
+	github.com/golang/protobuf v1.5.4
+	github.com/hashicorp/go-immutable-radix v1.3.1
+	github.com/klauspost/compress v1.17.11
+	github.com/pascaldekloe/goe v0.1.0
+	github.com/prometheus/client_golang v1.21.0
+	github.com/prometheus/client_model v0.6.1
+	github.com/prometheus/common v0.62.0
+	google.golang.org/protobuf v1.36.1
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/circonus-labs/circonusllhist v0.1.3 // indirect
-	github.com/golang/protobuf v1.3.2
-	github.com/hashicorp/go-immutable-radix v1.0.0
+	github.com/hashicorp/go-cleanhttp v0.5.2 // indirect
 	github.com/hashicorp/go-retryablehttp v0.5.3 // indirect
-	github.com/pascaldekloe/goe v0.1.0
-	github.com/prometheus/client_golang v1.4.0
-	github.com/prometheus/client_model v0.2.0
-	github.com/prometheus/common v0.9.1
+	github.com/hashicorp/golang-lru v0.5.4 // indirect
+	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/prometheus/procfs v0.15.1 // indirect
+	github.com/stretchr/objx v0.5.2 // indirect
 	github.com/tv42/httpunix v0.0.0-20150427012821-b75d8614f926 // indirect
+	golang.org/x/sys v0.28.0 // indirect
 )
 
 // Introduced undocumented breaking change to metrics sink interface
-retract v0.3.11
\ No newline at end of file
+retract v0.3.11
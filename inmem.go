@@ -4,9 +4,13 @@ import (
 	"bytes"
 	"fmt"
 	"math"
+	"math/rand"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -31,6 +35,40 @@ type InmemSink struct {
 	intervalLock sync.RWMutex
 
 	rateDenom float64
+
+	// expiration holds the *ExpirationPolicy used to carry gauges
+	// over into new intervals, see SetExpirationPolicy.
+	expiration atomic.Value
+
+	// reservoirSize is the number of values kept per sample key
+	// and interval, see SetReservoirSize. Accessed atomically.
+	reservoirSize int64
+
+	// subscribers receive finished intervals, see Subscribe
+	subscribers intervalSubscribers
+
+	// aggregations is the Aggregation tracked for counters and
+	// samples, see SetAggregations. Accessed atomically.
+	aggregations int32
+
+	// histogramBuckets holds the *HistogramBuckets samples are
+	// counted into, see SetHistogramBuckets.
+	histogramBuckets atomic.Value
+
+	// maxKeys limits the number of keys per interval, see SetMaxKeys.
+	// Accessed atomically.
+	maxKeys int64
+
+	// shards is the number of shards of new intervals, see SetShards.
+	// Accessed atomically.
+	shards int64
+
+	// tiers receive the intervals pruned from intervals, see
+	// SetDownsampling. Guarded by intervalLock.
+	tiers []*downsampleTier
+
+	// clock holds the Clock of intervals, see SetClock
+	clock atomic.Value
 }
 
 // IntervalMetrics stores the aggregated metrics
@@ -59,6 +97,22 @@ type IntervalMetrics struct {
 	// done is closed when this interval has ended, and a new IntervalMetrics
 	// has been created to receive any future metrics.
 	done chan struct{}
+
+	// gaugeExpiry maps the gauge key to the time it expires at. A zero
+	// time means the gauge never expires. It is only maintained while an
+	// ExpirationPolicy is set on the sink.
+	gaugeExpiry map[string]time.Time
+
+	// gaugeSets counts how often each gauge key was set during the interval
+	gaugeSets map[string]int
+
+	// keyLRU orders the keys by their last update while the sink has a key
+	// limit, see SetMaxKeys
+	keyLRU keyLRU
+
+	// shards receive the metrics while the interval is current, if the
+	// sink is sharded, see SetShards
+	shards []*intervalShard
 }
 
 // NewIntervalMetrics creates a new IntervalMetrics for a given interval
@@ -70,6 +124,9 @@ func NewIntervalMetrics(intv time.Time) *IntervalMetrics {
 		Counters: make(map[string]SampledValue),
 		Samples:  make(map[string]SampledValue),
 		done:     make(chan struct{}),
+
+		gaugeExpiry: make(map[string]time.Time),
+		gaugeSets:   make(map[string]int),
 	}
 }
 
@@ -82,11 +139,88 @@ type AggregateSample struct {
 	SumSq       float64   `json:"-"` // The sum of squared values
 	Min         float64   // Minimum value
 	Max         float64   // Maximum value
-	LastUpdated time.Time `json:"-"` // When value was last updated
+	Last        float64   `json:",omitempty"` // The value ingested last, if tracked
+	LastUpdated time.Time `json:"-"`          // When value was last updated
+
+	// Histogram counts the values per bucket, if histogram buckets are
+	// configured for the key
+	Histogram *Histogram `json:",omitempty"`
+
+	// disabled holds the optional aggregations which are not tracked
+	disabled Aggregation
+
+	// values is a uniform random sample of at most reservoirSize of the
+	// ingested values, used to estimate percentiles.
+	values        []float64
+	reservoirSize int
+}
+
+// Histogram counts ingested values into buckets. Counts has one more entry
+// than Bounds: Counts[i] is the number of values v with Bounds[i-1] < v <=
+// Bounds[i], and the last entry counts the values above the highest bound.
+type Histogram struct {
+	Bounds []float64
+	Counts []uint64
+}
+
+func newHistogram(bounds []float64) *Histogram {
+	return &Histogram{Bounds: bounds, Counts: make([]uint64, len(bounds)+1)}
 }
 
-// Computes a Stddev of the values
+func (h *Histogram) observe(v float64) {
+	h.Counts[sort.SearchFloat64s(h.Bounds, v)]++
+}
+
+// Cumulative returns the number of values less than or equal to each bound,
+// followed by the total count.
+func (h *Histogram) Cumulative() []uint64 {
+	cumulative := make([]uint64, len(h.Counts))
+	var total uint64
+	for i, c := range h.Counts {
+		total += c
+		cumulative[i] = total
+	}
+	return cumulative
+}
+
+func (h *Histogram) deepCopy() *Histogram {
+	counts := make([]uint64, len(h.Counts))
+	copy(counts, h.Counts)
+	return &Histogram{Bounds: h.Bounds, Counts: counts}
+}
+
+// Aggregation selects optional aggregations an AggregateSample tracks in
+// addition to the count, sum, min and max of the ingested values.
+type Aggregation uint8
+
+const (
+	// AggregateLast tracks the value ingested last
+	AggregateLast Aggregation = 1 << iota
+
+	// AggregateRate tracks the sum of the values per second of the interval
+	AggregateRate
+
+	// AggregateStddev tracks the sum of squares to compute the standard
+	// deviation from
+	AggregateStddev
+
+	allAggregations = AggregateLast | AggregateRate | AggregateStddev
+)
+
+// DefaultAggregations are the aggregations an InmemSink tracks by default
+const DefaultAggregations = AggregateRate | AggregateStddev
+
+// newSampleAggregate creates an AggregateSample which keeps a reservoir of
+// values, so its percentiles can be computed.
+func newSampleAggregate(reservoirSize int) *AggregateSample {
+	return &AggregateSample{reservoirSize: reservoirSize}
+}
+
+// Computes a Stddev of the values, or 0 if it isn't tracked
 func (a *AggregateSample) Stddev() float64 {
+	if a.disabled&AggregateStddev != 0 {
+		return 0
+	}
 	num := (float64(a.Count) * a.SumSq) - math.Pow(a.Sum, 2)
 	div := float64(a.Count * (a.Count - 1))
 	if div == 0 {
@@ -105,17 +239,87 @@ func (a *AggregateSample) Mean() float64 {
 
 // Ingest is used to update a sample
 func (a *AggregateSample) Ingest(v float64, rateDenom float64) {
+	a.ingest(v, rateDenom, time.Now())
+}
+
+// ingest updates the sample with v, last updated at now
+func (a *AggregateSample) ingest(v float64, rateDenom float64, now time.Time) {
 	a.Count++
 	a.Sum += v
-	a.SumSq += (v * v)
+	if a.disabled&AggregateStddev == 0 {
+		a.SumSq += (v * v)
+	}
 	if v < a.Min || a.Count == 1 {
 		a.Min = v
 	}
 	if v > a.Max || a.Count == 1 {
 		a.Max = v
 	}
-	a.Rate = float64(a.Sum) / rateDenom
-	a.LastUpdated = time.Now()
+	if a.disabled&AggregateRate == 0 {
+		a.Rate = float64(a.Sum) / rateDenom
+	}
+	if a.disabled&AggregateLast == 0 {
+		a.Last = v
+	}
+	if a.Histogram != nil {
+		a.Histogram.observe(v)
+	}
+	a.LastUpdated = now
+
+	// Keep a uniform sample of the values using reservoir sampling, so once
+	// the reservoir is full every value has the same chance to be in it.
+	switch {
+	case a.reservoirSize <= 0:
+	case len(a.values) < a.reservoirSize:
+		a.values = append(a.values, v)
+	default:
+		if j := rand.Intn(a.Count); j < a.reservoirSize {
+			a.values[j] = v
+		}
+	}
+}
+
+// Values returns a copy of the ingested values retained by reservoir sampling,
+// in no particular order. Only samples of an InmemSink retain values.
+func (a *AggregateSample) Values() []float64 {
+	if a.values == nil {
+		return nil
+	}
+	values := make([]float64, len(a.values))
+	copy(values, a.values)
+	return values
+}
+
+// Percentile estimates the value below which the fraction q of the ingested
+// values fall, e.g. Percentile(0.99) for the 99th percentile, using the
+// nearest-rank method on the retained values. It returns 0 if no values have
+// been retained.
+func (a *AggregateSample) Percentile(q float64) float64 {
+	return a.Percentiles(q)[0]
+}
+
+// Percentiles is like Percentile for each of qs, sorting the retained values
+// only once.
+func (a *AggregateSample) Percentiles(qs ...float64) []float64 {
+	ps := make([]float64, len(qs))
+	if len(a.values) == 0 {
+		return ps
+	}
+	sorted := make([]float64, len(a.values))
+	copy(sorted, a.values)
+	sort.Float64s(sorted)
+
+	for n, q := range qs {
+		rank := int(math.Ceil(q * float64(len(sorted))))
+		switch {
+		case rank < 1:
+			rank = 1
+		case rank > len(sorted):
+			rank = len(sorted)
+		}
+		ps[n] = sorted[rank-1]
+	}
+	return ps
 }
 
 func (a *AggregateSample) String() string {
@@ -144,7 +348,15 @@ func NewInmemSinkFromURL(u *url.URL) (MetricSink, error) {
 		return nil, fmt.Errorf("Bad 'retain' param: %s", err)
 	}
 
-	return NewInmemSink(interval, retain), nil
+	sink := NewInmemSink(interval, retain)
+	if v := params.Get("reservoir"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("Bad 'reservoir' param: %q", v)
+		}
+		sink.SetReservoirSize(size)
+	}
+	return sink, nil
 }
 
 // NewInmemSink is used to construct a new in-memory sink.
@@ -156,11 +368,80 @@ func NewInmemSink(interval, retain time.Duration) *InmemSink {
 		retain:       retain,
 		maxIntervals: int(retain / interval),
 		rateDenom:    float64(interval.Nanoseconds()) / float64(rateTimeUnit.Nanoseconds()),
+
+		aggregations: int32(DefaultAggregations),
 	}
 	i.intervals = make([]*IntervalMetrics, 0, i.maxIntervals)
+	i.expiration.Store((*ExpirationPolicy)(nil))
+	i.histogramBuckets.Store((*HistogramBuckets)(nil))
+	i.clock.Store(clockValue{SystemClock})
 	return i
 }
 
+// SetClock sets the Clock telling the sink which interval is current, and
+// pacing its periodic work such as SaveSnapshotFileEvery, e.g. to roll over
+// intervals deterministically in tests. Set it before the sink receives
+// metrics; it's SystemClock unless set, or when c is nil.
+func (i *InmemSink) SetClock(c Clock) {
+	i.clock.Store(clockValue{clockOrSystem(c)})
+}
+
+func (i *InmemSink) getClock() Clock {
+	return i.clock.Load().(clockValue).Clock
+}
+
+// now returns the time of the sink's Clock
+func (i *InmemSink) now() time.Time {
+	return i.getClock().Now()
+}
+
+// SetExpirationPolicy makes gauges carry over into new intervals until they
+// have not been set for longer than the TTL the policy gives their key, so
+// gauges of departed peers or sessions stop being reported once idle. Expired
+// gauges are also left out of the current interval returned by Data. By
+// default, or when policy is nil, every interval starts out empty.
+func (i *InmemSink) SetExpirationPolicy(policy *ExpirationPolicy) {
+	i.expiration.Store(policy)
+}
+
+func (i *InmemSink) expirationPolicy() *ExpirationPolicy {
+	p, _ := i.expiration.Load().(*ExpirationPolicy)
+	return p
+}
+
+// SetReservoirSize sets how many raw values are retained per sample key and
+// interval. Once more values are added, reservoir sampling keeps a uniform
+// random selection of them. The retained values are available from
+// AggregateSample.Values and are used to estimate percentiles, which are 0
+// otherwise. By default no values are retained, since each of them costs 8
+// bytes per key and interval; a size of about 1000 estimates the 99th
+// percentile well. The size applies to keys first seen after the call.
+func (i *InmemSink) SetReservoirSize(size int) {
+	atomic.StoreInt64(&i.reservoirSize, int64(size))
+}
+
+// SetAggregations selects the optional aggregations tracked for counters and
+// samples from now on, DefaultAggregations unless set. Untracked aggregations
+// are reported as 0.
+func (i *InmemSink) SetAggregations(aggregations Aggregation) {
+	atomic.StoreInt32(&i.aggregations, int32(aggregations))
+}
+
+// SetHistogramBuckets makes samples whose keys buckets has bounds for count
+// their values into a Histogram, in addition to the other aggregations. It
+// applies to keys first seen after the call. A nil buckets disables
+// histograms.
+func (i *InmemSink) SetHistogramBuckets(buckets *HistogramBuckets) {
+	i.histogramBuckets.Store(buckets)
+}
+
+// newAggregate creates an AggregateSample tracking the configured aggregations
+func (i *InmemSink) newAggregate(reservoirSize int) *AggregateSample {
+	agg := newSampleAggregate(reservoirSize)
+	agg.disabled = allAggregations &^ Aggregation(atomic.LoadInt32(&i.aggregations))
+	return agg
+}
+
 func (i *InmemSink) SetGauge(key []string, val float32) {
 	i.SetGaugeWithLabels(key, val, nil)
 }
@@ -169,19 +450,38 @@ func (i *InmemSink) SetGaugeWithLabels(key []string, val float32, labels []Label
 	k, name := i.flattenKeyLabels(key, labels)
 	intv := i.getInterval()
 
-	intv.Lock()
-	defer intv.Unlock()
-	intv.Gauges[k] = GaugeValue{Name: name, Value: val, Labels: labels}
+	var expiry time.Time
+	policy := i.expirationPolicy()
+	if policy != nil {
+		if ttl := policy.TTL(key); ttl != 0 {
+			expiry = i.now().Add(ttl)
+		}
+	}
+
+	i.update(intv, keyKindGauge, k, func(m intervalMaps) {
+		g := GaugeValue{Name: name, Value: val, Labels: labels}
+		if prev, ok := m.gauges[k]; ok {
+			// The labels of a key never change
+			g.DisplayLabels = prev.DisplayLabels
+		} else {
+			g.DisplayLabels = labelMap(labels)
+		}
+		m.gauges[k] = g
+		m.gaugeSets[k]++
+		if policy != nil {
+			m.gaugeExpiry[k] = expiry
+		}
+	})
 }
 
 func (i *InmemSink) EmitKey(key []string, val float32) {
 	k := i.flattenKey(key)
 	intv := i.getInterval()
 
-	intv.Lock()
-	defer intv.Unlock()
-	vals := intv.Points[k]
-	intv.Points[k] = append(vals, val)
+	i.update(intv, keyKindPoint, k, func(m intervalMaps) {
+		vals := m.points[k]
+		m.points[k] = append(vals, val)
+	})
 }
 
 func (i *InmemSink) IncrCounter(key []string, val float32) {
@@ -192,19 +492,19 @@ func (i *InmemSink) IncrCounterWithLabels(key []string, val float32, labels []La
 	k, name := i.flattenKeyLabels(key, labels)
 	intv := i.getInterval()
 
-	intv.Lock()
-	defer intv.Unlock()
-
-	agg, ok := intv.Counters[k]
-	if !ok {
-		agg = SampledValue{
-			Name:            name,
-			AggregateSample: &AggregateSample{},
-			Labels:          labels,
+	i.update(intv, keyKindCounter, k, func(m intervalMaps) {
+		agg, ok := m.counters[k]
+		if !ok {
+			agg = SampledValue{
+				Name:            name,
+				AggregateSample: i.newAggregate(0),
+				Labels:          labels,
+				DisplayLabels:   labelMap(labels),
+			}
+			m.counters[k] = agg
 		}
-		intv.Counters[k] = agg
-	}
-	agg.Ingest(float64(val), i.rateDenom)
+		agg.ingest(float64(val), i.rateDenom, i.now())
+	})
 }
 
 func (i *InmemSink) AddSample(key []string, val float32) {
@@ -212,22 +512,35 @@ func (i *InmemSink) AddSample(key []string, val float32) {
 }
 
 func (i *InmemSink) AddSampleWithLabels(key []string, val float32, labels []Label) {
+	i.AddPrecisionSampleWithLabels(key, float64(val), labels)
+}
+
+func (i *InmemSink) AddPrecisionSample(key []string, val float64) {
+	i.AddPrecisionSampleWithLabels(key, val, nil)
+}
+
+func (i *InmemSink) AddPrecisionSampleWithLabels(key []string, val float64, labels []Label) {
 	k, name := i.flattenKeyLabels(key, labels)
 	intv := i.getInterval()
 
-	intv.Lock()
-	defer intv.Unlock()
-
-	agg, ok := intv.Samples[k]
-	if !ok {
-		agg = SampledValue{
-			Name:            name,
-			AggregateSample: &AggregateSample{},
-			Labels:          labels,
+	i.update(intv, keyKindSample, k, func(m intervalMaps) {
+		agg, ok := m.samples[k]
+		if !ok {
+			agg = SampledValue{
+				Name:            name,
+				AggregateSample: i.newAggregate(int(atomic.LoadInt64(&i.reservoirSize))),
+				Labels:          labels,
+				DisplayLabels:   labelMap(labels),
+			}
+			if buckets, _ := i.histogramBuckets.Load().(*HistogramBuckets); buckets != nil {
+				if bounds := buckets.For(key); bounds != nil {
+					agg.Histogram = newHistogram(bounds)
+				}
+			}
+			m.samples[k] = agg
 		}
-		intv.Samples[k] = agg
-	}
-	agg.Ingest(float64(val), i.rateDenom)
+		agg.ingest(val, i.rateDenom, i.now())
+	})
 }
 
 // Data is used to retrieve all the aggregated metrics
@@ -270,8 +583,33 @@ func (i *InmemSink) Data() []*IntervalMetrics {
 	for k, v := range current.Samples {
 		copyCurrent.Samples[k] = v.deepCopy()
 	}
+	copyCurrent.gaugeExpiry = make(map[string]time.Time, len(current.gaugeExpiry))
+	for k, v := range current.gaugeExpiry {
+		copyCurrent.gaugeExpiry[k] = v
+	}
+	copyCurrent.gaugeSets = make(map[string]int, len(current.gaugeSets))
+	for k, v := range current.gaugeSets {
+		copyCurrent.gaugeSets[k] = v
+	}
+	copyCurrent.keyLRU = keyLRU{}
+	copyCurrent.shards = nil
+	for _, shard := range current.shards {
+		shard.Lock()
+		mergeMaps(copyCurrent.maps(), shard.intervalMaps, true)
+		shard.Unlock()
+	}
 	current.RUnlock()
 
+	// Leave out gauges which expired during the interval, so they disappear
+	// without waiting for the next one
+	now := i.now()
+	for k, expiry := range copyCurrent.gaugeExpiry {
+		if !expiry.IsZero() && expiry.Before(now) {
+			delete(copyCurrent.Gauges, k)
+			delete(copyCurrent.gaugeExpiry, k)
+		}
+	}
+
 	return intervals
 }
 
@@ -279,7 +617,7 @@ func (i *InmemSink) Data() []*IntervalMetrics {
 // previous interval exists, or if the current time is beyond the window for the
 // current interval.
 func (i *InmemSink) getInterval() *IntervalMetrics {
-	intv := time.Now().Truncate(i.interval)
+	intv := i.now().Truncate(i.interval)
 
 	// Attempt to return the existing interval first, because it only requires
 	// a read lock.
@@ -301,20 +639,57 @@ func (i *InmemSink) getInterval() *IntervalMetrics {
 	}
 
 	current := NewIntervalMetrics(intv)
+	current.shards = i.newShards()
 	i.intervals = append(i.intervals, current)
 	if n > 0 {
+		i.intervals[n-1].mergeShards()
 		close(i.intervals[n-1].done)
+		i.subscribers.publish(i.intervals[n-1])
+		if policy := i.expirationPolicy(); policy != nil {
+			i.carryGauges(i.intervals[n-1], current, i.now())
+		}
 	}
 
 	n++
 	// Prune old intervals if the count exceeds the max.
 	if n >= i.maxIntervals {
+		i.rollup(i.intervals[:n-i.maxIntervals])
 		copy(i.intervals[0:], i.intervals[n-i.maxIntervals:])
 		i.intervals = i.intervals[:i.maxIntervals]
 	}
 	return current
 }
 
+// carryGauges copies the gauges of the previous interval that have not
+// expired yet into the next one. The carried gauges count against the key
+// limit of the next interval, in the order they were last updated.
+func (i *InmemSink) carryGauges(prev, next *IntervalMetrics, now time.Time) {
+	prev.RLock()
+	defer prev.RUnlock()
+	next.Lock()
+	defer next.Unlock()
+
+	for k, expiry := range prev.gaugeExpiry {
+		if !expiry.IsZero() && expiry.Before(now) {
+			continue
+		}
+		next.Gauges[k] = prev.Gauges[k]
+		next.gaugeExpiry[k] = expiry
+	}
+
+	if lru := prev.keyLRU; lru.order != nil {
+		for elem := lru.order.Back(); elem != nil; elem = elem.Prev() {
+			k := elem.Value.(trackedKey)
+			if _, ok := next.Gauges[k.key]; ok && k.kind == keyKindGauge {
+				i.touchKey(next, keyKindGauge, k.key)
+			}
+		}
+	}
+	// Gauges which the previous interval didn't track, e.g. as the limit was
+	// set since, are tracked too
+	i.trackKeys(next)
+}
+
 // Flattens the key for formatting, removes spaces
 func (i *InmemSink) flattenKey(parts []string) string {
 	buf := &bytes.Buffer{}
@@ -0,0 +1,102 @@
+package metrics
+
+import "sort"
+
+// IntervalDiff holds the per-key changes between two intervals, as computed by
+// InmemSink.Diff.
+type IntervalDiff struct {
+	Gauges   []GaugeDiff
+	Counters []CounterDiff
+}
+
+// GaugeDiff is the change of a gauge between two intervals. HadBefore or
+// HasAfter is false if the gauge wasn't set in the first or second interval.
+type GaugeDiff struct {
+	Name   string
+	Hash   string
+	Labels []Label
+
+	Before, After       float32
+	HadBefore, HasAfter bool
+	Delta               float32
+}
+
+// CounterDiff is the change of a counter between two intervals. Counters which
+// were not incremented during an interval are treated as zero for it.
+type CounterDiff struct {
+	Name   string
+	Hash   string
+	Labels []Label
+
+	Before, After float64 // The sums of increments during each interval
+	Delta         float64 // After - Before
+	CountDelta    int     // The change in the number of increments
+}
+
+// Diff compares interval a against a later interval b, typically two entries
+// of Data, and returns the gauges and counters that changed between them.
+// Since Data copies the current interval, calling it before and after some
+// operation and diffing the last entries gives the counter increases caused
+// by the operation, as long as the interval didn't end in between. Keys whose
+// values are identical in both intervals are left out. Results are sorted by
+// key.
+func (i *InmemSink) Diff(a, b *IntervalMetrics) IntervalDiff {
+	a.RLock()
+	defer a.RUnlock()
+	if a != b {
+		b.RLock()
+		defer b.RUnlock()
+	}
+
+	var diff IntervalDiff
+	for hash, before := range a.Gauges {
+		g := GaugeDiff{Name: before.Name, Hash: hash, Labels: before.Labels, Before: before.Value, HadBefore: true}
+		if after, ok := b.Gauges[hash]; ok {
+			g.After, g.HasAfter = after.Value, true
+		}
+		if !g.HasAfter || g.After != g.Before {
+			g.Delta = g.After - g.Before
+			diff.Gauges = append(diff.Gauges, g)
+		}
+	}
+	for hash, after := range b.Gauges {
+		if _, ok := a.Gauges[hash]; ok {
+			continue
+		}
+		diff.Gauges = append(diff.Gauges, GaugeDiff{
+			Name: after.Name, Hash: hash, Labels: after.Labels,
+			After: after.Value, HasAfter: true, Delta: after.Value,
+		})
+	}
+
+	counters := make(map[string]*CounterDiff)
+	for hash, before := range a.Counters {
+		counters[hash] = &CounterDiff{
+			Name: before.Name, Hash: hash, Labels: before.Labels,
+			Before: before.Sum, CountDelta: -before.Count,
+		}
+	}
+	for hash, after := range b.Counters {
+		c, ok := counters[hash]
+		if !ok {
+			c = &CounterDiff{Name: after.Name, Hash: hash, Labels: after.Labels}
+			counters[hash] = c
+		}
+		c.After = after.Sum
+		c.CountDelta += after.Count
+	}
+	for _, c := range counters {
+		c.Delta = c.After - c.Before
+		if c.Delta != 0 || c.CountDelta != 0 {
+			diff.Counters = append(diff.Counters, *c)
+		}
+	}
+
+	sort.Slice(diff.Gauges, func(i, j int) bool {
+		return diff.Gauges[i].Hash < diff.Gauges[j].Hash
+	})
+	sort.Slice(diff.Counters, func(i, j int) bool {
+		return diff.Counters[i].Hash < diff.Counters[j].Hash
+	})
+	return diff
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInmemSink_Diff(t *testing.T) {
+	inm := NewInmemSink(time.Minute, time.Hour)
+	inm.SetGauge([]string{"same"}, 1)
+	inm.SetGauge([]string{"changed"}, 1)
+	inm.SetGauge([]string{"removed"}, 1)
+	inm.IncrCounter([]string{"requests"}, 1)
+	inm.IncrCounter([]string{"idle"}, 1)
+	before := inm.Data()
+
+	inm.SetGauge([]string{"changed"}, 4)
+	inm.SetGaugeWithLabels([]string{"added"}, 2, []Label{{"a", "b"}})
+	inm.IncrCounter([]string{"requests"}, 2)
+	inm.IncrCounter([]string{"requests"}, 3)
+	inm.IncrCounter([]string{"errors"}, 1)
+	after := inm.Data()
+
+	a := before[len(before)-1]
+	b := after[len(after)-1]
+	delete(b.Gauges, "removed")
+
+	diff := inm.Diff(a, b)
+
+	expectGauges := []GaugeDiff{
+		{Name: "added", Hash: "added;a=b", Labels: []Label{{"a", "b"}}, After: 2, HasAfter: true, Delta: 2},
+		{Name: "changed", Hash: "changed", Before: 1, After: 4, HadBefore: true, HasAfter: true, Delta: 3},
+		{Name: "removed", Hash: "removed", Before: 1, HadBefore: true, Delta: -1},
+	}
+	if !reflect.DeepEqual(diff.Gauges, expectGauges) {
+		t.Fatalf("bad gauges: %#v", diff.Gauges)
+	}
+
+	expectCounters := []CounterDiff{
+		{Name: "errors", Hash: "errors", After: 1, Delta: 1, CountDelta: 1},
+		{Name: "requests", Hash: "requests", Before: 1, After: 6, Delta: 5, CountDelta: 2},
+	}
+	if !reflect.DeepEqual(diff.Counters, expectCounters) {
+		t.Fatalf("bad counters: %#v", diff.Counters)
+	}
+
+	if diff := inm.Diff(b, b); len(diff.Gauges) != 0 || len(diff.Counters) != 0 {
+		t.Fatalf("expected no changes: %v", diff)
+	}
+}
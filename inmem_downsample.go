@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"fmt"
+	"time"
+)
+
+// DownsampleTier configures intervals an InmemSink rolls older metrics up
+// into, see SetDownsampling.
+type DownsampleTier struct {
+	// Interval is the length of the rolled up intervals
+	Interval time.Duration
+
+	// Retain is how long rolled up intervals are kept
+	Retain time.Duration
+}
+
+// TierMetrics holds the intervals of one level of the history of an
+// InmemSink, as returned by History.
+type TierMetrics struct {
+	// Interval is the length of the intervals
+	Interval time.Duration
+
+	// Intervals are the intervals of the tier, oldest first
+	Intervals []*IntervalMetrics
+}
+
+type downsampleTier struct {
+	DownsampleTier
+	maxIntervals int
+	intervals    []*IntervalMetrics
+}
+
+// SetDownsampling makes the sink roll intervals up into coarser ones once they
+// are older than its retention period, instead of discarding them. Intervals
+// leaving the sink's retention are merged into the intervals of the first
+// tier, which are in turn merged into those of the next tier once they are
+// older than its Retain, and so on. For example a sink with a 10s interval
+// retained for 10m and tiers of 1m for 1h and 5m for 1d keeps a day of history
+// in a few hundred intervals. Each tier's Interval must be a multiple of the
+// previous one. Rolled up intervals are available from History. It should be
+// set before any intervals are pruned; calling it again discards the rolled
+// up intervals.
+func (i *InmemSink) SetDownsampling(tiers ...DownsampleTier) error {
+	prev := i.interval
+	downsampled := make([]*downsampleTier, 0, len(tiers))
+	for _, t := range tiers {
+		if t.Interval <= 0 || t.Interval%prev != 0 {
+			return fmt.Errorf("downsampling interval %s is not a multiple of %s", t.Interval, prev)
+		}
+		if t.Retain < t.Interval {
+			return fmt.Errorf("downsampling retention %s is shorter than its interval %s", t.Retain, t.Interval)
+		}
+		downsampled = append(downsampled, &downsampleTier{
+			DownsampleTier: t,
+			maxIntervals:   int(t.Retain / t.Interval),
+		})
+		prev = t.Interval
+	}
+
+	i.intervalLock.Lock()
+	i.tiers = downsampled
+	i.intervalLock.Unlock()
+	return nil
+}
+
+// History returns the intervals of the sink followed by the rolled up
+// intervals of each downsampling tier, so from the most recent and finest
+// grained to the oldest and coarsest. The first entry holds the intervals
+// returned by Data.
+func (i *InmemSink) History() []TierMetrics {
+	history := []TierMetrics{{Interval: i.interval, Intervals: i.Data()}}
+
+	i.intervalLock.RLock()
+	defer i.intervalLock.RUnlock()
+	for _, t := range i.tiers {
+		intervals := make([]*IntervalMetrics, len(t.intervals))
+		copy(intervals, t.intervals)
+		history = append(history, TierMetrics{Interval: t.Interval, Intervals: intervals})
+	}
+	return history
+}
+
+// rollup merges intervals pruned from the sink into the downsampling tiers.
+// It must be called with the interval lock held.
+func (i *InmemSink) rollup(pruned []*IntervalMetrics) {
+	for _, t := range i.tiers {
+		if len(pruned) == 0 {
+			return
+		}
+		for _, intv := range pruned {
+			t.add(intv)
+		}
+
+		pruned = nil
+		if n := len(t.intervals); n > t.maxIntervals {
+			pruned = make([]*IntervalMetrics, n-t.maxIntervals)
+			copy(pruned, t.intervals)
+			copy(t.intervals, t.intervals[n-t.maxIntervals:])
+			t.intervals = t.intervals[:t.maxIntervals]
+		}
+	}
+}
+
+// add merges intv into the interval of the tier covering its start time
+func (t *downsampleTier) add(intv *IntervalMetrics) {
+	start := intv.Interval.Truncate(t.Interval)
+
+	var rolled *IntervalMetrics
+	if n := len(t.intervals); n > 0 && t.intervals[n-1].Interval.Equal(start) {
+		rolled = t.intervals[n-1]
+	} else {
+		rolled = NewIntervalMetrics(start)
+		close(rolled.done)
+		t.intervals = append(t.intervals, rolled)
+	}
+
+	intv.RLock()
+	rolled.Lock()
+	// The interval may still be referenced from Data, so copy its values
+	mergeMaps(rolled.maps(), intv.maps(), true)
+
+	// Rates are relative to the length of the interval
+	rateDenom := t.Interval.Seconds()
+	for _, m := range []map[string]SampledValue{rolled.Counters, rolled.Samples} {
+		for _, v := range m {
+			if v.disabled&AggregateRate == 0 {
+				v.Rate = v.Sum / rateDenom
+			}
+		}
+	}
+	rolled.Unlock()
+	intv.RUnlock()
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInmemSink_Downsampling(t *testing.T) {
+	inm := NewInmemSink(time.Minute, 2*time.Minute)
+	if err := inm.SetDownsampling(DownsampleTier{Interval: 90 * time.Second, Retain: time.Hour}); err == nil {
+		t.Fatalf("expected error for misaligned interval")
+	}
+	if err := inm.SetDownsampling(DownsampleTier{Interval: 2 * time.Minute, Retain: time.Minute}); err == nil {
+		t.Fatalf("expected error for short retention")
+	}
+	err := inm.SetDownsampling(
+		DownsampleTier{Interval: 2 * time.Minute, Retain: 4 * time.Minute},
+		DownsampleTier{Interval: 4 * time.Minute, Retain: 8 * time.Minute},
+	)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	base := time.Unix(0, 0)
+	var pruned []*IntervalMetrics
+	for n := 0; n < 5; n++ {
+		intv := NewIntervalMetrics(base.Add(time.Duration(n) * time.Minute))
+		intv.Gauges["g"] = GaugeValue{Name: "g", Value: float32(n)}
+		agg := inm.newAggregate(0)
+		agg.Ingest(1, inm.rateDenom)
+		intv.Counters["c"] = SampledValue{Name: "c", AggregateSample: agg}
+		pruned = append(pruned, intv)
+	}
+	inm.rollup(pruned)
+
+	history := inm.History()
+	if len(history) != 3 {
+		t.Fatalf("bad: %v", history)
+	}
+	if history[0].Interval != time.Minute || history[1].Interval != 2*time.Minute {
+		t.Fatalf("bad: %v", history)
+	}
+
+	// The first 2m interval was rolled up further, leaving two
+	first := history[1].Intervals
+	if len(first) != 2 || !first[0].Interval.Equal(base.Add(2*time.Minute)) {
+		t.Fatalf("bad: %v", first)
+	}
+	c := first[0].Counters["c"]
+	if c.Count != 2 || c.Sum != 2 || c.Rate != 2.0/120 {
+		t.Fatalf("bad: %v", c)
+	}
+	if g := first[0].Gauges["g"]; g.Value != 3 {
+		t.Fatalf("bad: %v", g)
+	}
+	if c := first[1].Counters["c"]; c.Count != 1 {
+		t.Fatalf("bad: %v", c)
+	}
+
+	second := history[2].Intervals
+	if len(second) != 1 || !second[0].Interval.Equal(base) {
+		t.Fatalf("bad: %v", second)
+	}
+	if c := second[0].Counters["c"]; c.Count != 2 || c.Rate != 2.0/240 {
+		t.Fatalf("bad: %v", c)
+	}
+
+	// The pruned intervals are unchanged
+	if c := pruned[0].Counters["c"]; c.Count != 1 || c.Rate != 1.0/60 {
+		t.Fatalf("bad: %v", c)
+	}
+}
@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"sort"
@@ -22,6 +23,8 @@ type GaugeValue struct {
 	Hash  string `json:"-"`
 	Value float32
 
+	// Labels are the labels the gauge was set with. DisplayLabels maps
+	// their names to their values, and is how they are encoded as JSON.
 	Labels        []Label           `json:"-"`
 	DisplayLabels map[string]string `json:"Labels"`
 }
@@ -38,16 +41,39 @@ type SampledValue struct {
 	Mean   float64
 	Stddev float64
 
+	// Percentiles estimated from a sample of the values, only set if the
+	// sink retained values, see InmemSink.SetReservoirSize
+	P50 *float64 `json:",omitempty"`
+	P90 *float64 `json:",omitempty"`
+	P99 *float64 `json:",omitempty"`
+
+	// Labels are the labels the metric was emitted with, see GaugeValue
 	Labels        []Label           `json:"-"`
 	DisplayLabels map[string]string `json:"Labels"`
 }
 
+// labelMap maps the names of labels to their values
+func labelMap(labels []Label) map[string]string {
+	m := make(map[string]string, len(labels))
+	for _, label := range labels {
+		m[label.Name] = label.Value
+	}
+	return m
+}
+
 // deepCopy allocates a new instance of AggregateSample
 func (source *SampledValue) deepCopy() SampledValue {
 	dest := *source
 	if source.AggregateSample != nil {
 		dest.AggregateSample = &AggregateSample{}
 		*dest.AggregateSample = *source.AggregateSample
+		if source.Histogram != nil {
+			dest.Histogram = source.Histogram.deepCopy()
+		}
+		if source.values != nil {
+			dest.values = make([]float64, len(source.values))
+			copy(dest.values, source.values)
+		}
 	}
 	return dest
 }
@@ -72,6 +98,46 @@ func (i *InmemSink) DisplayMetrics(resp http.ResponseWriter, req *http.Request)
 	return newMetricSummaryFromInterval(interval), nil
 }
 
+// DisplaySnapshot is the payload served by the DisplayHandler. Previous is
+// omitted until the sink has finished its first interval.
+type DisplaySnapshot struct {
+	Current  MetricsSummary
+	Previous *MetricsSummary `json:",omitempty"`
+}
+
+// DisplayHandler returns an http.Handler serving the aggregates of the current
+// and the most recent finished interval as JSON, similar to DisplayMetrics but
+// usable without an HTTP framework decoding its return value.
+func (i *InmemSink) DisplayHandler() http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			resp.Header().Set("Allow", "GET, HEAD")
+			http.Error(resp, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		data := i.Data()
+		n := len(data)
+		snapshot := DisplaySnapshot{
+			Current: newMetricSummaryFromInterval(data[n-1]),
+		}
+		if n > 1 {
+			previous := newMetricSummaryFromInterval(data[n-2])
+			snapshot.Previous = &previous
+		}
+
+		resp.Header().Set("Content-Type", "application/json")
+		if req.Method == http.MethodHead {
+			return
+		}
+		enc := json.NewEncoder(resp)
+		if _, ok := req.URL.Query()["pretty"]; ok {
+			enc.SetIndent("", "  ")
+		}
+		enc.Encode(snapshot)
+	})
+}
+
 func newMetricSummaryFromInterval(interval *IntervalMetrics) MetricsSummary {
 	interval.RLock()
 	defer interval.RUnlock()
@@ -92,11 +158,8 @@ func newMetricSummaryFromInterval(interval *IntervalMetrics) MetricsSummary {
 	})
 
 	for hash, value := range interval.Gauges {
+		// DisplayLabels is set when the gauge is first set
 		value.Hash = hash
-		value.DisplayLabels = make(map[string]string)
-		for _, label := range value.Labels {
-			value.DisplayLabels[label.Name] = label.Value
-		}
 		value.Labels = nil
 
 		summary.Gauges = append(summary.Gauges, value)
@@ -114,19 +177,19 @@ func newMetricSummaryFromInterval(interval *IntervalMetrics) MetricsSummary {
 func formatSamples(source map[string]SampledValue) []SampledValue {
 	output := make([]SampledValue, 0, len(source))
 	for hash, sample := range source {
-		displayLabels := make(map[string]string)
-		for _, label := range sample.Labels {
-			displayLabels[label.Name] = label.Value
-		}
-
-		output = append(output, SampledValue{
+		value := SampledValue{
 			Name:            sample.Name,
 			Hash:            hash,
 			AggregateSample: sample.AggregateSample,
 			Mean:            sample.AggregateSample.Mean(),
 			Stddev:          sample.AggregateSample.Stddev(),
-			DisplayLabels:   displayLabels,
-		})
+			DisplayLabels:   sample.DisplayLabels,
+		}
+		if len(sample.AggregateSample.values) > 0 {
+			ps := sample.AggregateSample.Percentiles(0.5, 0.9, 0.99)
+			value.P50, value.P90, value.P99 = &ps[0], &ps[1], &ps[2]
+		}
+		output = append(output, value)
 	}
 	sort.Slice(output, func(i, j int) bool {
 		return output[i].Hash < output[j].Hash
@@ -15,6 +15,7 @@ import (
 func TestDisplayMetrics(t *testing.T) {
 	interval := 10 * time.Millisecond
 	inm := NewInmemSink(interval, 50*time.Millisecond)
+	inm.SetReservoirSize(1028)
 
 	// Add data points
 	inm.SetGauge([]string{"foo", "bar"}, 42)
@@ -67,6 +68,8 @@ func TestDisplayMetrics(t *testing.T) {
 					Sum:   42,
 					SumSq: 884,
 					Rate:  4200,
+
+					disabled: AggregateLast,
 				},
 				Mean:   21,
 				Stddev: 1.4142135623730951,
@@ -81,6 +84,8 @@ func TestDisplayMetrics(t *testing.T) {
 					Sum:   60,
 					SumSq: 2000,
 					Rate:  6000,
+
+					disabled: AggregateLast,
 				},
 				Mean:          30,
 				Stddev:        14.142135623730951,
@@ -98,9 +103,16 @@ func TestDisplayMetrics(t *testing.T) {
 					Sum:   44,
 					SumSq: 976,
 					Rate:  4400,
+
+					disabled:      AggregateLast,
+					values:        []float64{20, 24},
+					reservoirSize: 1028,
 				},
 				Mean:   22,
 				Stddev: 2.8284271247461903,
+				P50:    float64Ptr(20),
+				P90:    float64Ptr(24),
+				P99:    float64Ptr(24),
 			},
 			{
 				Name: "foo.bar",
@@ -112,9 +124,16 @@ func TestDisplayMetrics(t *testing.T) {
 					Sum:   56,
 					SumSq: 1618,
 					Rate:  5600,
+
+					disabled:      AggregateLast,
+					values:        []float64{23, 33},
+					reservoirSize: 1028,
 				},
 				Mean:          28,
 				Stddev:        7.0710678118654755,
+				P50:           float64Ptr(23),
+				P90:           float64Ptr(33),
+				P99:           float64Ptr(33),
 				DisplayLabels: map[string]string{"a": "b"},
 			},
 		},
@@ -137,6 +156,10 @@ func TestDisplayMetrics(t *testing.T) {
 	verify.Values(t, "all", result, expected)
 }
 
+func float64Ptr(v float64) *float64 {
+	return &v
+}
+
 func TestDisplayMetrics_RaceSetGauge(t *testing.T) {
 	interval := 200 * time.Millisecond
 	inm := NewInmemSink(interval, 10*interval)
@@ -278,6 +301,77 @@ func TestDisplayMetrics_RaceMetricsSetGauge(t *testing.T) {
 	verify.Values(t, "all", got, float32(42))
 }
 
+func TestInmemSink_DisplayHandler(t *testing.T) {
+	inm := NewInmemSink(time.Minute, time.Hour)
+	handler := inm.DisplayHandler()
+
+	inm.SetGauge([]string{"foo", "bar"}, 2)
+
+	get := func() DisplaySnapshot {
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest("GET", "/v1/metrics", nil))
+		if resp.Code != http.StatusOK {
+			t.Fatalf("bad status: %d", resp.Code)
+		}
+		if ct := resp.Header().Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("bad content type: %s", ct)
+		}
+		var snapshot DisplaySnapshot
+		if err := json.NewDecoder(resp.Body).Decode(&snapshot); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return snapshot
+	}
+
+	snapshot := get()
+	if snapshot.Previous != nil {
+		t.Fatalf("expected no previous interval: %v", snapshot.Previous)
+	}
+	if len(snapshot.Current.Gauges) != 1 || snapshot.Current.Gauges[0].Value != 2 {
+		t.Fatalf("bad current interval: %v", snapshot.Current)
+	}
+
+	// Add a finished interval before the current one
+	prev := NewIntervalMetrics(inm.intervals[0].Interval.Add(-time.Minute))
+	prev.Gauges["foo.bar"] = GaugeValue{Name: "foo.bar", Value: 1}
+	inm.intervals = append([]*IntervalMetrics{prev}, inm.intervals...)
+
+	snapshot = get()
+	if snapshot.Previous == nil || len(snapshot.Previous.Gauges) != 1 || snapshot.Previous.Gauges[0].Value != 1 {
+		t.Fatalf("bad previous interval: %v", snapshot.Previous)
+	}
+	if len(snapshot.Current.Gauges) != 1 || snapshot.Current.Gauges[0].Value != 2 {
+		t.Fatalf("bad current interval: %v", snapshot.Current)
+	}
+
+	// Without a reservoir there are no percentiles to report
+	inm.AddSample([]string{"foo", "latency"}, 3)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/v1/metrics", nil))
+	var raw struct {
+		Current struct {
+			Samples []map[string]interface{}
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(raw.Current.Samples) != 1 {
+		t.Fatalf("bad samples: %v", raw.Current.Samples)
+	}
+	for _, field := range []string{"P50", "P90", "P99"} {
+		if v, ok := raw.Current.Samples[0][field]; ok {
+			t.Fatalf("unexpected %s: %v", field, v)
+		}
+	}
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("POST", "/v1/metrics", nil))
+	if resp.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("bad status: %d", resp.Code)
+	}
+}
+
 func TestInmemSink_Stream(t *testing.T) {
 	interval := 10 * time.Millisecond
 	total := 50 * time.Millisecond
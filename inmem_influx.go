@@ -0,0 +1,149 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// InfluxHandler returns an http.Handler rendering the current interval of the
+// sink in the InfluxDB line protocol, e.g. to be scraped by Telegraf's http
+// input plugin.
+func (i *InmemSink) InfluxHandler() http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		i.WriteInflux(resp)
+	})
+}
+
+// WriteInflux writes the current interval of the sink to w in the InfluxDB
+// line protocol. Every metric becomes a measurement named after its flattened
+// key, with its labels as tags and the start of the interval as timestamp.
+// Gauges have a value field, and the points of EmitKey count, sum, min, max
+// and mean fields, as InfluxDB would only keep one of several points with the
+// same timestamp. Counters and samples have count, sum, min, max, mean and
+// rate fields, and samples additionally have p50, p90 and p99 fields.
+// Newlines in names and labels, which the line protocol can't escape, are
+// replaced with spaces.
+func (i *InmemSink) WriteInflux(w io.Writer) error {
+	data := i.Data()
+	intv := data[len(data)-1]
+	ts := intv.Interval.UnixNano()
+
+	var lines []string
+	for _, g := range intv.Gauges {
+		lines = append(lines, influxLine(g.Name, g.Labels, "value="+influxFloat(float64(g.Value)), ts))
+	}
+	for name, points := range intv.Points {
+		if len(points) > 0 {
+			lines = append(lines, influxLine(name, nil, influxPointFields(points), ts))
+		}
+	}
+	for _, c := range intv.Counters {
+		lines = append(lines, influxLine(c.Name, c.Labels, influxAggregateFields(c.AggregateSample, false), ts))
+	}
+	for _, s := range intv.Samples {
+		lines = append(lines, influxLine(s.Name, s.Labels, influxAggregateFields(s.AggregateSample, true), ts))
+	}
+	sort.Strings(lines)
+
+	buf := bufio.NewWriter(w)
+	for _, line := range lines {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Flush()
+}
+
+// ServeExecd makes the program a Telegraf execd input using the STDIN signal:
+// it writes the current interval of the sink to out, see WriteInflux, every
+// time a line is read from in, until in is closed. For example:
+//
+//	go inm.ServeExecd(os.Stdin, os.Stdout)
+//
+// with the Telegraf configuration
+//
+//	[[inputs.execd]]
+//	  command = ["/usr/bin/service"]
+//	  signal = "STDIN"
+//	  data_format = "influx"
+func (i *InmemSink) ServeExecd(in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		if err := i.WriteInflux(out); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func influxAggregateFields(a *AggregateSample, percentiles bool) string {
+	fields := fmt.Sprintf("count=%di,sum=%s,min=%s,max=%s,mean=%s,rate=%s",
+		a.Count, influxFloat(a.Sum), influxFloat(a.Min), influxFloat(a.Max),
+		influxFloat(a.Mean()), influxFloat(a.Rate))
+	if percentiles {
+		ps := a.Percentiles(0.5, 0.9, 0.99)
+		fields += fmt.Sprintf(",p50=%s,p90=%s,p99=%s", influxFloat(ps[0]), influxFloat(ps[1]), influxFloat(ps[2]))
+	}
+	return fields
+}
+
+// influxPointFields aggregates the points of a key
+func influxPointFields(points []float32) string {
+	low, high := float64(points[0]), float64(points[0])
+	var sum float64
+	for _, p := range points {
+		v := float64(p)
+		sum += v
+		if v < low {
+			low = v
+		}
+		if v > high {
+			high = v
+		}
+	}
+	return fmt.Sprintf("count=%di,sum=%s,min=%s,max=%s,mean=%s",
+		len(points), influxFloat(sum), influxFloat(low), influxFloat(high),
+		influxFloat(sum/float64(len(points))))
+}
+
+var (
+	influxMeasurementReplacer = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\ `)
+	influxTagReplacer         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\ `)
+)
+
+func influxLine(name string, labels []Label, fields string, ts int64) string {
+	var b strings.Builder
+	b.WriteString(influxMeasurementReplacer.Replace(name))
+
+	tags := make([]Label, 0, len(labels))
+	for _, l := range labels {
+		// Tags with empty values are not allowed
+		if l.Name != "" && l.Value != "" {
+			tags = append(tags, l)
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].Name < tags[j].Name
+	})
+	for _, l := range tags {
+		b.WriteByte(',')
+		b.WriteString(influxTagReplacer.Replace(l.Name))
+		b.WriteByte('=')
+		b.WriteString(influxTagReplacer.Replace(l.Value))
+	}
+
+	b.WriteByte(' ')
+	b.WriteString(fields)
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatInt(ts, 10))
+	return b.String()
+}
+
+func influxFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInmemSink_WriteInflux(t *testing.T) {
+	inm := NewInmemSink(time.Minute, time.Hour)
+	inm.SetReservoirSize(1028)
+	inm.SetGaugeWithLabels([]string{"foo", "bar"}, 42, []Label{{"z", "1"}, {"a", "b c"}, {"empty", ""}, {"nl", "x\ny"}})
+	inm.EmitKey([]string{"key"}, 1)
+	inm.EmitKey([]string{"key"}, 2)
+	inm.EmitKey([]string{"key"}, 6)
+	inm.IncrCounter([]string{"counter"}, 2)
+	inm.IncrCounter([]string{"counter"}, 4)
+	inm.AddSample([]string{"sample"}, 10)
+
+	resp := httptest.NewRecorder()
+	inm.InfluxHandler().ServeHTTP(resp, httptest.NewRequest("GET", "/metrics/influx", nil))
+
+	data := inm.Data()
+	ts := data[len(data)-1].Interval.UnixNano()
+	expect := fmt.Sprintf(`counter count=2i,sum=6,min=2,max=4,mean=3,rate=0.1 %[1]d
+foo.bar,a=b\ c,nl=x\ y,z=1 value=42 %[1]d
+key count=3i,sum=9,min=1,max=6,mean=3 %[1]d
+sample count=1i,sum=10,min=10,max=10,mean=10,rate=0.16666666666666666,p50=10,p90=10,p99=10 %[1]d
+`, ts)
+	if got := resp.Body.String(); got != expect {
+		t.Fatalf("bad output:\n%s", got)
+	}
+}
+
+func TestInmemSink_ServeExecd(t *testing.T) {
+	inm := NewInmemSink(time.Minute, time.Hour)
+	var out strings.Builder
+	inm.SetGauge([]string{"foo"}, 1)
+	if err := inm.ServeExecd(strings.NewReader("\n\n"), &out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	ts := inm.Data()[0].Interval.UnixNano()
+	if expect := fmt.Sprintf("foo value=1 %[1]d\nfoo value=1 %[1]d\n", ts); out.String() != expect {
+		t.Fatalf("bad output:\n%s", out.String())
+	}
+}
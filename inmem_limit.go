@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"container/list"
+	"sync/atomic"
+)
+
+// droppedKeysKey is the counter an InmemSink increments for every key it
+// evicts to stay within its key limit
+const droppedKeysKey = "inmem.dropped_keys"
+
+// Kinds of keys tracked for eviction
+const (
+	keyKindGauge = iota
+	keyKindPoint
+	keyKindCounter
+	keyKindSample
+)
+
+type trackedKey struct {
+	kind int
+	key  string
+}
+
+// keyLRU orders the keys of an interval by their last update
+type keyLRU struct {
+	order *list.List
+	elems map[trackedKey]*list.Element
+}
+
+// SetMaxKeys caps the number of keys, across all metric types, tracked per
+// interval. Once an interval holds max keys, recording a new key evicts the
+// least recently updated one and increments the inmem.dropped_keys counter,
+// which doesn't count against the limit. This bounds the memory a label
+// explosion can use. A max of 0, the default, disables the limit. It should
+// be set before any metrics are recorded, keys recorded earlier are never
+// evicted.
+func (i *InmemSink) SetMaxKeys(max int) {
+	atomic.StoreInt64(&i.maxKeys, int64(max))
+}
+
+// touchKey marks the key as most recently updated, evicting the least recently
+// updated key of the interval if a new key exceeds the limit. The interval
+// must be locked.
+func (i *InmemSink) touchKey(intv *IntervalMetrics, kind int, key string) {
+	max := int(atomic.LoadInt64(&i.maxKeys))
+	if max <= 0 || (kind == keyKindCounter && key == droppedKeysKey) {
+		return
+	}
+
+	lru := &intv.keyLRU
+	if lru.order == nil {
+		lru.order = list.New()
+		lru.elems = make(map[trackedKey]*list.Element)
+	}
+	k := trackedKey{kind: kind, key: key}
+	if elem, ok := lru.elems[k]; ok {
+		lru.order.MoveToFront(elem)
+		return
+	}
+
+	for lru.order.Len() >= max {
+		oldest := lru.order.Remove(lru.order.Back()).(trackedKey)
+		delete(lru.elems, oldest)
+		intv.evict(oldest)
+		i.countDroppedKey(intv)
+	}
+	lru.elems[k] = lru.order.PushFront(k)
+}
+
+// trackKeys registers the keys of the locked interval which it didn't receive
+// through touchKey, such as those restored from a snapshot, in its key LRU.
+// Keys beyond the limit are evicted in no particular order.
+func (i *InmemSink) trackKeys(intv *IntervalMetrics) {
+	if atomic.LoadInt64(&i.maxKeys) <= 0 {
+		return
+	}
+	track := func(kind int, key string) {
+		if _, ok := intv.keyLRU.elems[trackedKey{kind: kind, key: key}]; !ok {
+			i.touchKey(intv, kind, key)
+		}
+	}
+	for k := range intv.Gauges {
+		track(keyKindGauge, k)
+	}
+	for k := range intv.Points {
+		track(keyKindPoint, k)
+	}
+	for k := range intv.Counters {
+		track(keyKindCounter, k)
+	}
+	for k := range intv.Samples {
+		track(keyKindSample, k)
+	}
+}
+
+func (intv *IntervalMetrics) evict(k trackedKey) {
+	switch k.kind {
+	case keyKindGauge:
+		delete(intv.Gauges, k.key)
+		delete(intv.gaugeExpiry, k.key)
+		delete(intv.gaugeSets, k.key)
+	case keyKindPoint:
+		delete(intv.Points, k.key)
+	case keyKindCounter:
+		delete(intv.Counters, k.key)
+	case keyKindSample:
+		delete(intv.Samples, k.key)
+	}
+}
+
+// countDroppedKey increments the dropped keys counter of the locked interval
+func (i *InmemSink) countDroppedKey(intv *IntervalMetrics) {
+	agg, ok := intv.Counters[droppedKeysKey]
+	if !ok {
+		agg = SampledValue{
+			Name:            droppedKeysKey,
+			AggregateSample: i.newAggregate(0),
+			DisplayLabels:   map[string]string{},
+		}
+		intv.Counters[droppedKeysKey] = agg
+	}
+	agg.ingest(1, i.rateDenom, i.now())
+}
@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInmemSink_SetMaxKeys(t *testing.T) {
+	inm := NewInmemSink(time.Minute, time.Hour)
+	inm.SetMaxKeys(3)
+
+	inm.SetGauge([]string{"gauge"}, 1)
+	inm.EmitKey([]string{"point"}, 1)
+	inm.IncrCounter([]string{"counter"}, 1)
+
+	// Updating the gauge makes the point the least recently updated key
+	inm.SetGauge([]string{"gauge"}, 2)
+	inm.AddSample([]string{"sample"}, 1)
+	inm.AddSampleWithLabels([]string{"sample"}, 1, []Label{{"a", "b"}})
+
+	data := inm.Data()
+	intv := data[len(data)-1]
+	if _, ok := intv.Points["point"]; ok {
+		t.Fatalf("expected point to be evicted: %v", intv.Points)
+	}
+	if _, ok := intv.Counters["counter"]; ok {
+		t.Fatalf("expected counter to be evicted: %v", intv.Counters)
+	}
+	if intv.Gauges["gauge"].Value != 2 {
+		t.Fatalf("bad: %v", intv.Gauges)
+	}
+	if len(intv.Samples) != 2 {
+		t.Fatalf("bad: %v", intv.Samples)
+	}
+	if dropped := intv.Counters[droppedKeysKey]; dropped.AggregateSample == nil || dropped.Sum != 2 {
+		t.Fatalf("bad dropped keys counter: %v", intv.Counters)
+	}
+	if len(intv.Counters) != 1 {
+		t.Fatalf("bad: %v", intv.Counters)
+	}
+}
+
+func TestInmemSink_SetMaxKeysCarriedGauges(t *testing.T) {
+	inm := NewInmemSink(time.Minute, time.Hour)
+	inm.SetMaxKeys(2)
+	inm.SetExpirationPolicy(NewExpirationPolicy(0, nil))
+
+	prev := inm.getInterval()
+	inm.SetGauge([]string{"a"}, 1)
+	inm.SetGauge([]string{"b"}, 2)
+	inm.SetGauge([]string{"a"}, 3)
+
+	next := NewIntervalMetrics(prev.Interval.Add(time.Minute))
+	inm.carryGauges(prev, next, time.Now())
+	if len(next.keyLRU.elems) != 2 {
+		t.Fatalf("expected the carried gauges to be tracked: %v", next.keyLRU.elems)
+	}
+
+	// The carried gauge updated least recently is evicted first
+	next.Lock()
+	inm.touchKey(next, keyKindGauge, "c")
+	next.Gauges["c"] = GaugeValue{Name: "c", Value: 4}
+	next.Unlock()
+	if _, ok := next.Gauges["b"]; ok {
+		t.Fatalf("expected b to be evicted: %v", next.Gauges)
+	}
+	if next.Gauges["a"].Value != 3 || len(next.Gauges) != 2 {
+		t.Fatalf("bad: %v", next.Gauges)
+	}
+}
@@ -0,0 +1,219 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// prometheusQuantiles are the quantiles rendered for every sample
+var prometheusQuantiles = []float64{0.5, 0.9, 0.99}
+
+// PrometheusHandler returns an http.Handler rendering the current interval of
+// the sink in the Prometheus text exposition format, so it can be scraped as a
+// /metrics endpoint without depending on the Prometheus client library.
+func (i *InmemSink) PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		i.WritePrometheus(resp)
+	})
+}
+
+// WritePrometheus writes the current interval of the sink to w in the
+// Prometheus text exposition format. Gauges are written as gauges, the sums of
+// counters during the interval as untyped metrics, and samples as histograms
+// if histogram buckets are configured for them, or else as summaries with
+// their estimated quantiles. Summaries of sinks without a reservoir, see
+// SetReservoirSize, have no values to estimate quantiles from, and only
+// have their sum and count. Points from EmitKey have no equivalent and
+// are left out. Names and labels are sanitized to the characters Prometheus
+// accepts. As metrics of different types can't share a name, which includes
+// the _sum, _count and _bucket series of summaries and histograms, metrics
+// whose sanitized names collide with those of another type are left out too,
+// keeping gauges over counters over samples.
+func (i *InmemSink) WritePrometheus(w io.Writer) error {
+	data := i.Data()
+	intv := data[len(data)-1]
+
+	var families []promFamily
+	for hash, g := range intv.Gauges {
+		families = append(families, promFamily{
+			name: promName(g.Name), typ: "gauge", hash: hash,
+			labels: g.Labels, value: float64(g.Value),
+		})
+	}
+	for hash, c := range intv.Counters {
+		families = append(families, promFamily{
+			name: promName(c.Name), typ: "untyped", hash: hash,
+			labels: c.Labels, value: c.Sum,
+		})
+	}
+	for hash, s := range intv.Samples {
+		typ := "summary"
+		if s.Histogram != nil {
+			typ = "histogram"
+		}
+		families = append(families, promFamily{
+			name: promName(s.Name), typ: typ, hash: hash,
+			labels: s.Labels, sample: s.AggregateSample,
+		})
+	}
+	sort.Slice(families, func(i, j int) bool {
+		if families[i].rank() != families[j].rank() {
+			return families[i].rank() < families[j].rank()
+		}
+		if families[i].name != families[j].name {
+			return families[i].name < families[j].name
+		}
+		return families[i].hash < families[j].hash
+	})
+	families = claimPromNames(families)
+	sort.Slice(families, func(i, j int) bool {
+		if families[i].name != families[j].name {
+			return families[i].name < families[j].name
+		}
+		return families[i].hash < families[j].hash
+	})
+
+	buf := bufio.NewWriter(w)
+	var lastName string
+	for _, f := range families {
+		if f.name != lastName {
+			fmt.Fprintf(buf, "# TYPE %s %s\n", f.name, f.typ)
+			lastName = f.name
+		}
+		labels := promLabels(f.labels)
+		if f.sample == nil {
+			fmt.Fprintf(buf, "%s%s %s\n", f.name, promLabelString(labels), promValue(f.value))
+			continue
+		}
+		if h := f.sample.Histogram; h != nil {
+			for i, count := range h.Cumulative() {
+				le := "+Inf"
+				if i < len(h.Bounds) {
+					le = promValue(h.Bounds[i])
+				}
+				bl := append(labels, Label{"le", le})
+				fmt.Fprintf(buf, "%s_bucket%s %d\n", f.name, promLabelString(bl), count)
+			}
+		} else if len(f.sample.values) > 0 {
+			ps := f.sample.Percentiles(prometheusQuantiles...)
+			for n, q := range prometheusQuantiles {
+				ql := append(labels, Label{"quantile", strconv.FormatFloat(q, 'g', -1, 64)})
+				fmt.Fprintf(buf, "%s%s %s\n", f.name, promLabelString(ql), promValue(ps[n]))
+			}
+		}
+		fmt.Fprintf(buf, "%s_sum%s %s\n", f.name, promLabelString(labels), promValue(f.sample.Sum))
+		fmt.Fprintf(buf, "%s_count%s %d\n", f.name, promLabelString(labels), f.sample.Count)
+	}
+	return buf.Flush()
+}
+
+type promFamily struct {
+	name   string
+	typ    string
+	hash   string
+	labels []Label
+	value  float64
+	sample *AggregateSample
+}
+
+// rank orders the types of families by precedence when their names collide
+func (f promFamily) rank() int {
+	switch f.typ {
+	case "gauge":
+		return 0
+	case "untyped":
+		return 1
+	default:
+		return 2
+	}
+}
+
+// seriesNames returns the names of the series written for the family
+func (f promFamily) seriesNames() []string {
+	switch f.typ {
+	case "summary":
+		return []string{f.name, f.name + "_sum", f.name + "_count"}
+	case "histogram":
+		return []string{f.name, f.name + "_bucket", f.name + "_sum", f.name + "_count"}
+	default:
+		return []string{f.name}
+	}
+}
+
+// claimPromNames returns the families whose series names aren't already
+// claimed by a family with another name or type, and whose labels aren't those
+// of an earlier family of the same name, in order
+func claimPromNames(families []promFamily) []promFamily {
+	owners := make(map[string]string)
+	series := make(map[string]bool)
+	kept := families[:0]
+	for _, f := range families {
+		owner := f.typ + " " + f.name
+		names := f.seriesNames()
+		collides := false
+		for _, name := range names {
+			if o, ok := owners[name]; ok && o != owner {
+				collides = true
+				break
+			}
+		}
+		sig := f.name + promLabelString(promLabels(f.labels))
+		if collides || series[sig] {
+			continue
+		}
+		series[sig] = true
+		for _, name := range names {
+			owners[name] = owner
+		}
+		kept = append(kept, f)
+	}
+	return kept
+}
+
+// promName replaces the runes Prometheus doesn't allow in metric names
+func promName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == ':' || r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+	if name == "" || ('0' <= name[0] && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+// promLabels returns a copy of labels with their names made valid Prometheus
+// label names
+func promLabels(labels []Label) []Label {
+	out := make([]Label, 0, len(labels)+1)
+	for _, l := range labels {
+		l.Name = strings.Replace(promName(l.Name), ":", "_", -1)
+		out = append(out, l)
+	}
+	return out
+}
+
+var promLabelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promLabelString(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, len(labels))
+	for i, l := range labels {
+		parts[i] = fmt.Sprintf(`%s="%s"`, l.Name, promLabelValueReplacer.Replace(l.Value))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func promValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
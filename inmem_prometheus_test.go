@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInmemSink_WritePrometheus(t *testing.T) {
+	inm := NewInmemSink(time.Minute, time.Hour)
+	inm.SetReservoirSize(1028)
+	inm.SetGauge([]string{"foo", "bar"}, 42)
+	inm.SetGaugeWithLabels([]string{"foo", "bar"}, 23, []Label{{"a-b", "quote\"d"}})
+	inm.EmitKey([]string{"ignored"}, 1)
+	inm.IncrCounter([]string{"1counter"}, 2)
+	inm.IncrCounter([]string{"1counter"}, 3)
+	inm.AddSample([]string{"sample", "latency"}, 10)
+	inm.AddSample([]string{"sample", "latency"}, 20)
+
+	resp := httptest.NewRecorder()
+	inm.PrometheusHandler().ServeHTTP(resp, httptest.NewRequest("GET", "/metrics", nil))
+
+	expect := `# TYPE _1counter untyped
+_1counter 5
+# TYPE foo_bar gauge
+foo_bar 42
+foo_bar{a_b="quote\"d"} 23
+# TYPE sample_latency summary
+sample_latency{quantile="0.5"} 10
+sample_latency{quantile="0.9"} 20
+sample_latency{quantile="0.99"} 20
+sample_latency_sum 30
+sample_latency_count 2
+`
+	if got := resp.Body.String(); got != expect {
+		t.Fatalf("bad output:\n%s", got)
+	}
+	if ct := resp.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Fatalf("bad content type: %s", ct)
+	}
+}
+
+func TestInmemSink_WritePrometheusNoReservoir(t *testing.T) {
+	inm := NewInmemSink(time.Minute, time.Hour)
+	inm.AddSample([]string{"sample", "latency"}, 10)
+	inm.AddSample([]string{"sample", "latency"}, 20)
+
+	var buf bytes.Buffer
+	if err := inm.WritePrometheus(&buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expect := `# TYPE sample_latency summary
+sample_latency_sum 30
+sample_latency_count 2
+`
+	if got := buf.String(); got != expect {
+		t.Fatalf("bad output:\n%s", got)
+	}
+}
+
+func TestInmemSink_WritePrometheusHistogram(t *testing.T) {
+	inm := NewInmemSink(time.Minute, time.Hour)
+	inm.SetHistogramBuckets(NewHistogramBuckets([]float64{1, 2.5}, nil))
+	inm.AddSampleWithLabels([]string{"latency"}, 0.5, []Label{{"a", "b"}})
+	inm.AddSampleWithLabels([]string{"latency"}, 2, []Label{{"a", "b"}})
+	inm.AddSampleWithLabels([]string{"latency"}, 3, []Label{{"a", "b"}})
+
+	var buf bytes.Buffer
+	if err := inm.WritePrometheus(&buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expect := `# TYPE latency histogram
+latency_bucket{a="b",le="1"} 1
+latency_bucket{a="b",le="2.5"} 2
+latency_bucket{a="b",le="+Inf"} 3
+latency_sum{a="b"} 5.5
+latency_count{a="b"} 3
+`
+	if got := buf.String(); got != expect {
+		t.Fatalf("bad output:\n%s", got)
+	}
+}
+
+func TestInmemSink_WritePrometheusCollisions(t *testing.T) {
+	inm := NewInmemSink(time.Minute, time.Hour)
+	inm.SetHistogramBuckets(NewHistogramBuckets(nil, map[string][]float64{"hist": {1}}))
+	inm.SetGauge([]string{"foo", "bar"}, 1)
+	inm.IncrCounter([]string{"foo_bar"}, 2)
+	inm.SetGauge([]string{"dup", "a"}, 3)
+	inm.SetGauge([]string{"dup_a"}, 4)
+	inm.SetGauge([]string{"latency_count"}, 5)
+	inm.AddSample([]string{"latency"}, 6)
+	inm.AddSample([]string{"hist"}, 7)
+	inm.AddSample([]string{"hist", "sum"}, 8)
+
+	var buf bytes.Buffer
+	if err := inm.WritePrometheus(&buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expect := `# TYPE dup_a gauge
+dup_a 3
+# TYPE foo_bar gauge
+foo_bar 1
+# TYPE hist histogram
+hist_bucket{le="1"} 0
+hist_bucket{le="+Inf"} 1
+hist_sum 7
+hist_count 1
+# TYPE latency_count gauge
+latency_count 5
+`
+	if got := buf.String(); got != expect {
+		t.Fatalf("bad output:\n%s", got)
+	}
+}
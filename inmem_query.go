@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"path"
+	"sort"
+	"time"
+)
+
+// QueryResult holds the values one metric had in each of the intervals a
+// query covered. Only intervals in which the metric was emitted are included.
+type QueryResult struct {
+	// Type is one of "gauge", "point", "counter" or "sample"
+	Type   string
+	Name   string
+	Hash   string
+	Labels []Label
+	Values []QueryValue
+}
+
+// QueryValue is the value of a metric during a single interval. Depending on
+// the type of the metric either Value, Points or AggregateSample is set.
+type QueryValue struct {
+	Interval time.Time
+	Value    float32
+	Points   []float32
+	*AggregateSample
+}
+
+// Query returns the history of all metrics whose name matches pattern across
+// the retained intervals overlapping the time range from to to. The pattern
+// uses the syntax of path.Match and is matched against the flattened name
+// without labels, so "http.*" matches every metric starting with "http.". A
+// zero from or to leaves the range open on that side. Results are sorted by
+// type and key, and their values by interval.
+func (i *InmemSink) Query(pattern string, from, to time.Time) ([]QueryResult, error) {
+	// Report a malformed pattern even if there are no metrics to match
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]*QueryResult)
+	add := func(typ, name, hash string, labels []Label, value QueryValue) {
+		if ok, _ := path.Match(pattern, name); !ok {
+			return
+		}
+		id := typ + ":" + hash
+		r, ok := results[id]
+		if !ok {
+			r = &QueryResult{Type: typ, Name: name, Hash: hash, Labels: labels}
+			results[id] = r
+		}
+		r.Values = append(r.Values, value)
+	}
+
+	for _, intv := range i.Data() {
+		if !from.IsZero() && !intv.Interval.Add(i.interval).After(from) {
+			continue
+		}
+		if !to.IsZero() && intv.Interval.After(to) {
+			continue
+		}
+
+		intv.RLock()
+		for hash, g := range intv.Gauges {
+			add("gauge", g.Name, hash, g.Labels, QueryValue{Interval: intv.Interval, Value: g.Value})
+		}
+		for name, points := range intv.Points {
+			add("point", name, name, nil, QueryValue{Interval: intv.Interval, Points: points})
+		}
+		for hash, c := range intv.Counters {
+			c = c.deepCopy()
+			add("counter", c.Name, hash, c.Labels, QueryValue{Interval: intv.Interval, AggregateSample: c.AggregateSample})
+		}
+		for hash, s := range intv.Samples {
+			s = s.deepCopy()
+			add("sample", s.Name, hash, s.Labels, QueryValue{Interval: intv.Interval, AggregateSample: s.AggregateSample})
+		}
+		intv.RUnlock()
+	}
+
+	output := make([]QueryResult, 0, len(results))
+	for _, r := range results {
+		output = append(output, *r)
+	}
+	sort.Slice(output, func(i, j int) bool {
+		if output[i].Type != output[j].Type {
+			return output[i].Type < output[j].Type
+		}
+		return output[i].Hash < output[j].Hash
+	})
+	return output, nil
+}
@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInmemSink_Query(t *testing.T) {
+	inm := NewInmemSink(time.Minute, time.Hour)
+
+	// Fill in two finished intervals
+	start := time.Now().Truncate(time.Minute).Add(-2 * time.Minute)
+	for n := 0; n < 2; n++ {
+		intv := NewIntervalMetrics(start.Add(time.Duration(n) * time.Minute))
+		intv.Gauges["http.conns;a=b"] = GaugeValue{Name: "http.conns", Value: float32(n), Labels: []Label{{"a", "b"}}}
+		intv.Points["http.key"] = []float32{float32(n)}
+		agg := &AggregateSample{}
+		agg.Ingest(float64(n), 1)
+		intv.Samples["http.latency"] = SampledValue{Name: "http.latency", AggregateSample: agg}
+		intv.Gauges["db.conns"] = GaugeValue{Name: "db.conns", Value: 5}
+		inm.intervals = append(inm.intervals, intv)
+	}
+	inm.IncrCounter([]string{"http", "requests"}, 1)
+
+	results, err := inm.Query("http.*", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var types []string
+	for _, r := range results {
+		types = append(types, r.Type+" "+r.Hash)
+	}
+	expect := []string{"counter http.requests", "gauge http.conns;a=b", "point http.key", "sample http.latency"}
+	if !reflect.DeepEqual(types, expect) {
+		t.Fatalf("bad: %v", types)
+	}
+
+	gauge := results[1]
+	if len(gauge.Values) != 2 || gauge.Values[0].Value != 0 || gauge.Values[1].Value != 1 {
+		t.Fatalf("bad: %v", gauge.Values)
+	}
+	if !gauge.Values[0].Interval.Equal(start) {
+		t.Fatalf("bad interval: %v", gauge.Values[0].Interval)
+	}
+	if !reflect.DeepEqual(gauge.Labels, []Label{{"a", "b"}}) {
+		t.Fatalf("bad labels: %v", gauge.Labels)
+	}
+	if results[0].Values[0].Count != 1 {
+		t.Fatalf("bad: %v", results[0].Values[0])
+	}
+
+	// Only the second finished interval
+	results, err = inm.Query("*.conns", start.Add(time.Minute), start.Add(90*time.Second))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("bad: %v", results)
+	}
+	for _, r := range results {
+		if len(r.Values) != 1 || !r.Values[0].Interval.Equal(start.Add(time.Minute)) {
+			t.Fatalf("bad: %v", r)
+		}
+	}
+
+	if _, err := inm.Query("[", time.Time{}, time.Time{}); err == nil {
+		t.Fatalf("expected error for bad pattern")
+	}
+}
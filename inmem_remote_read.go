@@ -0,0 +1,328 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/klauspost/compress/s2"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The types of label matchers of remote-read queries
+const (
+	remoteMatchEqual = iota
+	remoteMatchNotEqual
+	remoteMatchRegexp
+	remoteMatchNotRegexp
+)
+
+// remoteReadQuery is a query of a Prometheus remote-read request
+type remoteReadQuery struct {
+	start, end int64
+	matchers   []remoteMatcher
+}
+
+type remoteMatcher struct {
+	typ         uint64
+	name, value string
+	re          *regexp.Regexp
+}
+
+func (m remoteMatcher) matches(value string) bool {
+	switch m.typ {
+	case remoteMatchNotEqual:
+		return value != m.value
+	case remoteMatchRegexp:
+		return m.re.MatchString(value)
+	case remoteMatchNotRegexp:
+		return !m.re.MatchString(value)
+	default:
+		return value == m.value
+	}
+}
+
+// remoteSeries is a time series of a remote-read response
+type remoteSeries struct {
+	labels  []Label
+	samples []remoteSample
+}
+
+type remoteSample struct {
+	value float64
+	ts    int64
+}
+
+// RemoteReadHandler returns an http.Handler serving the retained intervals of
+// the sink over the Prometheus remote-read API, so e.g. Grafana can query the
+// recent history of an agent while the central TSDB is unreachable:
+//
+//	http.Handle("/api/v1/read", inm.RemoteReadHandler())
+//
+// Every interval is a sample at its start. The series are named as by
+// WritePrometheus: gauges have their value, counters their sum during the
+// interval, and samples have <name>_sum and <name>_count series, plus
+// <name>_bucket series labeled with le if histogram buckets are configured
+// for them, or else the estimated quantiles labeled with quantile if the
+// sink has a reservoir. Points
+// from EmitKey are left out. Only the samples response type is supported,
+// not streamed chunks.
+func (i *InmemSink) RemoteReadHandler() http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			resp.Header().Set("Allow", http.MethodPost)
+			http.Error(resp, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		compressed, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(resp, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body, err := s2.Decode(nil, compressed)
+		if err != nil {
+			http.Error(resp, err.Error(), http.StatusBadRequest)
+			return
+		}
+		queries, err := parseRemoteReadRequest(body)
+		if err != nil {
+			http.Error(resp, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		all := i.remoteSeries()
+		var out []byte
+		for _, q := range queries {
+			var result []byte
+			for _, s := range all {
+				if ts := q.filter(s); ts != nil {
+					result = protowire.AppendTag(result, 1, protowire.BytesType)
+					result = protowire.AppendBytes(result, appendRemoteSeries(nil, ts))
+				}
+			}
+			out = protowire.AppendTag(out, 1, protowire.BytesType)
+			out = protowire.AppendBytes(out, result)
+		}
+
+		resp.Header().Set("Content-Type", "application/x-protobuf")
+		resp.Header().Set("Content-Encoding", "snappy")
+		resp.Write(s2.EncodeSnappy(nil, out))
+	})
+}
+
+// remoteSeries returns the series of the retained intervals, sorted by
+// their labels
+func (i *InmemSink) remoteSeries() []*remoteSeries {
+	bySig := make(map[string]*remoteSeries)
+	add := func(name string, labels []Label, value float64, ts int64) {
+		all := make([]Label, 0, len(labels)+1)
+		all = append(all, Label{"__name__", name})
+		all = append(all, labels...)
+		sort.SliceStable(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+		sig := promLabelString(all)
+		s, ok := bySig[sig]
+		if !ok {
+			s = &remoteSeries{labels: all}
+			bySig[sig] = s
+		}
+		// Series of which sanitized names or labels collide keep the
+		// sample of the gauge, then of the counter, then of the sample
+		if n := len(s.samples); n > 0 && s.samples[n-1].ts == ts {
+			return
+		}
+		s.samples = append(s.samples, remoteSample{value, ts})
+	}
+
+	for _, intv := range i.Data() {
+		ts := intv.Interval.UnixNano() / int64(1e6)
+		for _, hash := range sortedKeys(intv.Gauges) {
+			g := intv.Gauges[hash]
+			add(promName(g.Name), promLabels(g.Labels), float64(g.Value), ts)
+		}
+		for _, hash := range sortedKeys(intv.Counters) {
+			c := intv.Counters[hash]
+			add(promName(c.Name), promLabels(c.Labels), c.Sum, ts)
+		}
+		for _, hash := range sortedKeys(intv.Samples) {
+			s := intv.Samples[hash]
+			name, labels := promName(s.Name), promLabels(s.Labels)
+			if h := s.Histogram; h != nil {
+				for n, count := range h.Cumulative() {
+					le := "+Inf"
+					if n < len(h.Bounds) {
+						le = promValue(h.Bounds[n])
+					}
+					add(name+"_bucket", append(labels[:len(labels):len(labels)], Label{"le", le}), float64(count), ts)
+				}
+			} else if len(s.values) > 0 {
+				ps := s.Percentiles(prometheusQuantiles...)
+				for n, q := range prometheusQuantiles {
+					quantile := strconv.FormatFloat(q, 'g', -1, 64)
+					add(name, append(labels[:len(labels):len(labels)], Label{"quantile", quantile}), ps[n], ts)
+				}
+			}
+			add(name+"_sum", labels, s.Sum, ts)
+			add(name+"_count", labels, float64(s.Count), ts)
+		}
+	}
+
+	series := make([]*remoteSeries, 0, len(bySig))
+	for _, sig := range sortedKeys(bySig) {
+		series = append(series, bySig[sig])
+	}
+	return series
+}
+
+// filter returns the samples of s within the time range of q if its labels
+// match those of q, or else nil
+func (q remoteReadQuery) filter(s *remoteSeries) *remoteSeries {
+	for _, m := range q.matchers {
+		var value string
+		for _, l := range s.labels {
+			if l.Name == m.name {
+				value = l.Value
+				break
+			}
+		}
+		if !m.matches(value) {
+			return nil
+		}
+	}
+	var samples []remoteSample
+	for _, sample := range s.samples {
+		if sample.ts >= q.start && sample.ts <= q.end {
+			samples = append(samples, sample)
+		}
+	}
+	if len(samples) == 0 {
+		return nil
+	}
+	return &remoteSeries{labels: s.labels, samples: samples}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var errRemoteReadMalformed = errors.New("malformed remote-read request")
+
+// parseRemoteReadRequest returns the queries of the protobuf encoded
+// prometheus.ReadRequest b. Hints are ignored.
+func parseRemoteReadRequest(b []byte) ([]remoteReadQuery, error) {
+	var queries []remoteReadQuery
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		q := remoteReadQuery{start: math.MinInt64, end: math.MaxInt64}
+		err := consumeFields(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+			switch {
+			case num == 1 && typ == protowire.VarintType:
+				x, _ := protowire.ConsumeVarint(v)
+				q.start = int64(x)
+			case num == 2 && typ == protowire.VarintType:
+				x, _ := protowire.ConsumeVarint(v)
+				q.end = int64(x)
+			case num == 3 && typ == protowire.BytesType:
+				m, err := parseRemoteMatcher(v)
+				if err != nil {
+					return err
+				}
+				q.matchers = append(q.matchers, m)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		queries = append(queries, q)
+		return nil
+	})
+	return queries, err
+}
+
+// parseRemoteMatcher returns the protobuf encoded prometheus.LabelMatcher b
+func parseRemoteMatcher(b []byte) (remoteMatcher, error) {
+	var m remoteMatcher
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			m.typ, _ = protowire.ConsumeVarint(v)
+		case num == 2 && typ == protowire.BytesType:
+			m.name = string(v)
+		case num == 3 && typ == protowire.BytesType:
+			m.value = string(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return m, err
+	}
+	if m.typ == remoteMatchRegexp || m.typ == remoteMatchNotRegexp {
+		// Prometheus anchors regular expressions of matchers
+		if m.re, err = regexp.Compile("^(?:" + m.value + ")$"); err != nil {
+			return m, err
+		}
+	} else if m.typ > remoteMatchNotRegexp {
+		return m, errRemoteReadMalformed
+	}
+	return m, nil
+}
+
+// consumeFields calls fn with the number, type and encoded value of every
+// field of the protobuf message b. The values of length-delimited fields are
+// passed without their length.
+func consumeFields(b []byte, fn func(protowire.Number, protowire.Type, []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errRemoteReadMalformed
+		}
+		b = b[n:]
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return errRemoteReadMalformed
+		}
+		v := b[:n]
+		if typ == protowire.BytesType {
+			v, _ = protowire.ConsumeBytes(v)
+		}
+		if err := fn(num, typ, v); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// appendRemoteSeries appends s to b as a protobuf encoded prometheus.TimeSeries
+func appendRemoteSeries(b []byte, s *remoteSeries) []byte {
+	for _, l := range s.labels {
+		var label []byte
+		label = protowire.AppendTag(label, 1, protowire.BytesType)
+		label = protowire.AppendString(label, l.Name)
+		label = protowire.AppendTag(label, 2, protowire.BytesType)
+		label = protowire.AppendString(label, l.Value)
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, label)
+	}
+	for _, sample := range s.samples {
+		var enc []byte
+		enc = protowire.AppendTag(enc, 1, protowire.Fixed64Type)
+		enc = protowire.AppendFixed64(enc, math.Float64bits(sample.value))
+		enc = protowire.AppendTag(enc, 2, protowire.VarintType)
+		enc = protowire.AppendVarint(enc, uint64(sample.ts))
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, enc)
+	}
+	return b
+}
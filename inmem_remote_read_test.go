@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func appendRemoteMatcher(b []byte, typ uint64, name, value string) []byte {
+	var m []byte
+	m = protowire.AppendTag(m, 1, protowire.VarintType)
+	m = protowire.AppendVarint(m, typ)
+	m = protowire.AppendTag(m, 2, protowire.BytesType)
+	m = protowire.AppendString(m, name)
+	m = protowire.AppendTag(m, 3, protowire.BytesType)
+	m = protowire.AppendString(m, value)
+	b = protowire.AppendTag(b, 3, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+// decodeRemoteReadResponse returns the series of every result of the
+// protobuf encoded ReadResponse b, as label strings followed by their
+// samples
+func decodeRemoteReadResponse(t *testing.T, b []byte) [][]string {
+	var results [][]string
+	err := consumeFields(b, func(_ protowire.Number, _ protowire.Type, result []byte) error {
+		var series []string
+		err := consumeFields(result, func(_ protowire.Number, _ protowire.Type, ts []byte) error {
+			var labels []Label
+			var samples []string
+			err := consumeFields(ts, func(num protowire.Number, _ protowire.Type, v []byte) error {
+				fields := make(map[protowire.Number][]byte)
+				consumeFields(v, func(num protowire.Number, _ protowire.Type, v []byte) error {
+					fields[num] = v
+					return nil
+				})
+				if num == 1 {
+					labels = append(labels, Label{string(fields[1]), string(fields[2])})
+				} else {
+					bits, _ := protowire.ConsumeFixed64(fields[1])
+					ms, _ := protowire.ConsumeVarint(fields[2])
+					samples = append(samples, fmt.Sprintf("%g@%d", math.Float64frombits(bits), ms))
+				}
+				return nil
+			})
+			series = append(series, promLabelString(labels)+" "+strings.Join(samples, " "))
+			return err
+		})
+		results = append(results, series)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return results
+}
+
+func TestInmemSink_RemoteReadHandler(t *testing.T) {
+	inm := NewInmemSink(10*time.Millisecond, time.Hour)
+	inm.SetHistogramBuckets(NewHistogramBuckets([]float64{1}, nil))
+	inm.SetGaugeWithLabels([]string{"foo", "bar"}, 42, []Label{{"a", "b"}})
+	inm.IncrCounter([]string{"requests"}, 2)
+	inm.AddSample([]string{"latency"}, 0.5)
+	first := inm.Data()[0].Interval.UnixNano() / int64(1e6)
+	time.Sleep(15 * time.Millisecond)
+	inm.IncrCounter([]string{"requests"}, 3)
+	data := inm.Data()
+	if len(data) != 2 {
+		t.Fatalf("bad intervals: %d", len(data))
+	}
+	second := data[1].Interval.UnixNano() / int64(1e6)
+
+	var query []byte
+	query = protowire.AppendTag(query, 1, protowire.VarintType)
+	query = protowire.AppendVarint(query, uint64(first))
+	query = protowire.AppendTag(query, 2, protowire.VarintType)
+	query = protowire.AppendVarint(query, uint64(second))
+	query = appendRemoteMatcher(query, remoteMatchRegexp, "__name__", "requests|latency_.*")
+	query = appendRemoteMatcher(query, remoteMatchNotEqual, "le", "+Inf")
+	var req []byte
+	req = protowire.AppendTag(req, 1, protowire.BytesType)
+	req = protowire.AppendBytes(req, query)
+	query = appendRemoteMatcher(nil, remoteMatchEqual, "a", "b")
+	req = protowire.AppendTag(req, 1, protowire.BytesType)
+	req = protowire.AppendBytes(req, query)
+
+	resp := httptest.NewRecorder()
+	inm.RemoteReadHandler().ServeHTTP(resp, httptest.NewRequest("POST", "/api/v1/read", bytes.NewReader(s2.EncodeSnappy(nil, req))))
+	if resp.Code != 200 {
+		t.Fatalf("bad status: %d %s", resp.Code, resp.Body)
+	}
+	if ce := resp.Header().Get("Content-Encoding"); ce != "snappy" {
+		t.Fatalf("bad content encoding: %s", ce)
+	}
+	body, err := s2.Decode(nil, resp.Body.Bytes())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The second query has no time range
+	expect := fmt.Sprint([][]string{
+		{
+			fmt.Sprintf(`{__name__="latency_bucket",le="1"} 1@%d`, first),
+			fmt.Sprintf(`{__name__="latency_count"} 1@%d`, first),
+			fmt.Sprintf(`{__name__="latency_sum"} 0.5@%d`, first),
+			fmt.Sprintf(`{__name__="requests"} 2@%d 3@%d`, first, second),
+		},
+		{
+			fmt.Sprintf(`{__name__="foo_bar",a="b"} 42@%d`, first),
+		},
+	})
+	if got := fmt.Sprint(decodeRemoteReadResponse(t, body)); got != expect {
+		t.Fatalf("got %s\nwant %s", got, expect)
+	}
+}
+
+func TestInmemSink_RemoteReadHandlerNoReservoir(t *testing.T) {
+	inm := NewInmemSink(time.Minute, time.Hour)
+	inm.AddSample([]string{"latency"}, 0.5)
+	ts := inm.Data()[0].Interval.UnixNano() / int64(1e6)
+
+	query := appendRemoteMatcher(nil, remoteMatchRegexp, "__name__", "latency.*")
+	var req []byte
+	req = protowire.AppendTag(req, 1, protowire.BytesType)
+	req = protowire.AppendBytes(req, query)
+
+	resp := httptest.NewRecorder()
+	inm.RemoteReadHandler().ServeHTTP(resp, httptest.NewRequest("POST", "/api/v1/read", bytes.NewReader(s2.EncodeSnappy(nil, req))))
+	if resp.Code != 200 {
+		t.Fatalf("bad status: %d %s", resp.Code, resp.Body)
+	}
+	body, err := s2.Decode(nil, resp.Body.Bytes())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	expect := fmt.Sprint([][]string{{
+		fmt.Sprintf(`{__name__="latency_count"} 1@%d`, ts),
+		fmt.Sprintf(`{__name__="latency_sum"} 0.5@%d`, ts),
+	}})
+	if got := fmt.Sprint(decodeRemoteReadResponse(t, body)); got != expect {
+		t.Fatalf("got %s\nwant %s", got, expect)
+	}
+}
+
+func TestInmemSink_RemoteReadHandlerMalformed(t *testing.T) {
+	inm := NewInmemSink(time.Minute, time.Hour)
+	for _, body := range [][]byte{
+		[]byte("not snappy"),
+		s2.EncodeSnappy(nil, []byte{0x0a, 0x05}),
+		s2.EncodeSnappy(nil, protowire.AppendBytes(protowire.AppendTag(nil, 1, protowire.BytesType),
+			appendRemoteMatcher(nil, remoteMatchRegexp, "__name__", "("))),
+	} {
+		resp := httptest.NewRecorder()
+		inm.RemoteReadHandler().ServeHTTP(resp, httptest.NewRequest("POST", "/api/v1/read", bytes.NewReader(body)))
+		if resp.Code != 400 {
+			t.Fatalf("bad status for %q: %d", body, resp.Code)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package metrics
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// intervalMaps references the maps holding the metrics of an interval, or of
+// one shard of it
+type intervalMaps struct {
+	gauges      map[string]GaugeValue
+	points      map[string][]float32
+	counters    map[string]SampledValue
+	samples     map[string]SampledValue
+	gaugeExpiry map[string]time.Time
+	gaugeSets   map[string]int
+}
+
+func newIntervalMaps() intervalMaps {
+	return intervalMaps{
+		gauges:      make(map[string]GaugeValue),
+		points:      make(map[string][]float32),
+		counters:    make(map[string]SampledValue),
+		samples:     make(map[string]SampledValue),
+		gaugeExpiry: make(map[string]time.Time),
+		gaugeSets:   make(map[string]int),
+	}
+}
+
+// maps returns the maps of the interval itself
+func (intv *IntervalMetrics) maps() intervalMaps {
+	return intervalMaps{
+		gauges:      intv.Gauges,
+		points:      intv.Points,
+		counters:    intv.Counters,
+		samples:     intv.Samples,
+		gaugeExpiry: intv.gaugeExpiry,
+		gaugeSets:   intv.gaugeSets,
+	}
+}
+
+// intervalShard receives the metrics of some of the keys of the current
+// interval, so emissions for different keys don't contend on one lock. Once
+// the interval has ended, its shards are merged into it.
+type intervalShard struct {
+	sync.Mutex
+	merged bool
+	intervalMaps
+}
+
+// SetShards splits the metrics of each interval into n shards by key hash,
+// each of them locked separately, which removes lock contention between
+// emissions for different keys at very high emission rates. Shards are merged
+// when an interval ends and when Data copies the current interval, so the
+// IntervalMetrics returned by Data, Stream and Subscribe are unchanged. A
+// value of 0 or 1, the default, disables sharding. Key limits set with
+// SetMaxKeys also disable sharding. It applies to intervals started after the
+// call.
+func (i *InmemSink) SetShards(n int) {
+	atomic.StoreInt64(&i.shards, int64(n))
+}
+
+// newShards returns the shards for a new interval, or nil if the interval
+// shouldn't be sharded
+func (i *InmemSink) newShards() []*intervalShard {
+	n := int(atomic.LoadInt64(&i.shards))
+	if n <= 1 || atomic.LoadInt64(&i.maxKeys) > 0 {
+		return nil
+	}
+	shards := make([]*intervalShard, n)
+	for j := range shards {
+		shards[j] = &intervalShard{intervalMaps: newIntervalMaps()}
+	}
+	return shards
+}
+
+// shardFor returns the shard of the interval receiving key, or nil if the
+// interval isn't sharded
+func (intv *IntervalMetrics) shardFor(key string) *intervalShard {
+	if len(intv.shards) == 0 {
+		return nil
+	}
+	// FNV-1a
+	h := uint32(2166136261)
+	for j := 0; j < len(key); j++ {
+		h ^= uint32(key[j])
+		h *= 16777619
+	}
+	return intv.shards[h%uint32(len(intv.shards))]
+}
+
+// update applies fn to the maps receiving key: those of its shard while the
+// interval is sharded, or else those of the interval itself.
+func (i *InmemSink) update(intv *IntervalMetrics, kind int, key string, fn func(m intervalMaps)) {
+	if shard := intv.shardFor(key); shard != nil {
+		shard.Lock()
+		if !shard.merged {
+			fn(shard.intervalMaps)
+			shard.Unlock()
+			return
+		}
+		// Metrics recorded after the interval ended go to the interval
+		shard.Unlock()
+	}
+
+	intv.Lock()
+	defer intv.Unlock()
+	i.touchKey(intv, kind, key)
+	fn(intv.maps())
+}
+
+// mergeShards moves the metrics of all shards into the interval itself
+func (intv *IntervalMetrics) mergeShards() {
+	if len(intv.shards) == 0 {
+		return
+	}
+	intv.Lock()
+	defer intv.Unlock()
+	for _, shard := range intv.shards {
+		shard.Lock()
+		mergeMaps(intv.maps(), shard.intervalMaps, false)
+		shard.merged = true
+		shard.intervalMaps = intervalMaps{}
+		shard.Unlock()
+	}
+}
+
+// mergeMaps merges the metrics of src into dst. If copyValues is set, src
+// remains in use and aggregates are copied rather than moved.
+func mergeMaps(dst, src intervalMaps, copyValues bool) {
+	for k, v := range src.gauges {
+		dst.gauges[k] = v
+	}
+	for k, v := range src.gaugeExpiry {
+		dst.gaugeExpiry[k] = v
+	}
+	for k, v := range src.gaugeSets {
+		dst.gaugeSets[k] += v
+	}
+	for k, v := range src.points {
+		// Limit the capacity so appending never writes to an array the
+		// interval still uses
+		existing := dst.points[k]
+		dst.points[k] = append(existing[:len(existing):len(existing)], v...)
+	}
+	mergeSamples(dst.counters, src.counters, copyValues)
+	mergeSamples(dst.samples, src.samples, copyValues)
+}
+
+func mergeSamples(dst, src map[string]SampledValue, copyValues bool) {
+	for k, v := range src {
+		existing, ok := dst[k]
+		switch {
+		case ok:
+			existing.merge(v.AggregateSample)
+		case copyValues:
+			dst[k] = v.deepCopy()
+		default:
+			dst[k] = v
+		}
+	}
+}
+
+// merge adds the values aggregated in b to a
+func (a *AggregateSample) merge(b *AggregateSample) {
+	if b.Count == 0 {
+		return
+	}
+	if a.Count == 0 || b.Min < a.Min {
+		a.Min = b.Min
+	}
+	if a.Count == 0 || b.Max > a.Max {
+		a.Max = b.Max
+	}
+	a.Count += b.Count
+	a.Sum += b.Sum
+	a.SumSq += b.SumSq
+	a.Rate += b.Rate
+	if b.LastUpdated.After(a.LastUpdated) {
+		a.Last = b.Last
+		a.LastUpdated = b.LastUpdated
+	}
+
+	switch {
+	case b.Histogram == nil:
+	case a.Histogram == nil:
+		a.Histogram = b.Histogram.deepCopy()
+	case len(a.Histogram.Counts) == len(b.Histogram.Counts):
+		for j, c := range b.Histogram.Counts {
+			a.Histogram.Counts[j] += c
+		}
+	}
+
+	if len(b.values) == 0 {
+		return
+	}
+	size := a.reservoirSize
+	if size == 0 {
+		size = b.reservoirSize
+	}
+	values := append(a.values[:len(a.values):len(a.values)], b.values...)
+	if len(values) > size {
+		// Keep a random selection of both reservoirs. This is not exactly
+		// uniform if they sampled different numbers of values.
+		rand.Shuffle(len(values), func(x, y int) {
+			values[x], values[y] = values[y], values[x]
+		})
+		values = values[:size]
+	}
+	a.values = values
+	a.reservoirSize = size
+}
@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInmemSink_SetShards(t *testing.T) {
+	record := func(inm *InmemSink) {
+		for n := 0; n < 100; n++ {
+			key := []string{"key", fmt.Sprint(n % 10)}
+			inm.SetGauge(key, float32(n))
+			inm.EmitKey(key, float32(n))
+			inm.IncrCounterWithLabels(key, float32(n), []Label{{"a", "b"}})
+			inm.AddSample(key, float32(n))
+		}
+	}
+
+	plain := NewInmemSink(time.Minute, time.Hour)
+	plain.SetAggregations(DefaultAggregations | AggregateLast)
+	plain.SetReservoirSize(1028)
+	record(plain)
+
+	sharded := NewInmemSink(time.Minute, time.Hour)
+	sharded.SetAggregations(DefaultAggregations | AggregateLast)
+	sharded.SetReservoirSize(1028)
+	sharded.SetShards(4)
+	record(sharded)
+
+	expect := plain.Data()
+	got := sharded.Data()
+	if n := len(got[len(got)-1].shards); n != 0 {
+		t.Fatalf("expected copy without shards, got %d", n)
+	}
+	if n := len(sharded.intervals[len(sharded.intervals)-1].shards); n != 4 {
+		t.Fatalf("expected 4 shards, got %d", n)
+	}
+	compareIntervals(t, got[len(got)-1], expect[len(expect)-1])
+
+	// Roll over to a new interval, which merges the shards of the old one
+	intv := sharded.intervals[0]
+	intv.Interval = intv.Interval.Add(-time.Minute)
+	sharded.getInterval()
+	record(sharded)
+
+	got = sharded.Data()
+	if len(got) != 2 {
+		t.Fatalf("bad: %v", got)
+	}
+	if len(got[0].Counters) != 10 || !got[0].shards[0].merged {
+		t.Fatalf("expected shards to be merged: %v", got[0].Counters)
+	}
+	compareIntervals(t, got[0], expect[len(expect)-1])
+	compareIntervals(t, got[1], expect[len(expect)-1])
+
+	// Metrics recorded into the old interval after it ended are kept
+	sharded.update(got[0], keyKindCounter, "late", func(m intervalMaps) {
+		m.counters["late"] = SampledValue{Name: "late", AggregateSample: &AggregateSample{Count: 1}}
+	})
+	if _, ok := got[0].Counters["late"]; !ok {
+		t.Fatalf("expected late counter")
+	}
+}
+
+// compareIntervals compares the metrics of two intervals, ignoring the order
+// of points and sampled values
+func compareIntervals(t *testing.T, got, expect *IntervalMetrics) {
+	t.Helper()
+	if !reflect.DeepEqual(got.Gauges, expect.Gauges) {
+		t.Fatalf("bad gauges: %v != %v", got.Gauges, expect.Gauges)
+	}
+	if !reflect.DeepEqual(got.gaugeSets, expect.gaugeSets) {
+		t.Fatalf("bad gauge sets: %v != %v", got.gaugeSets, expect.gaugeSets)
+	}
+	if !reflect.DeepEqual(got.Points, expect.Points) {
+		t.Fatalf("bad points: %v != %v", got.Points, expect.Points)
+	}
+	for _, m := range []struct{ got, expect map[string]SampledValue }{
+		{got.Counters, expect.Counters},
+		{got.Samples, expect.Samples},
+	} {
+		if len(m.got) != len(m.expect) {
+			t.Fatalf("bad: %v != %v", m.got, m.expect)
+		}
+		for k, e := range m.expect {
+			g := m.got[k]
+			if g.Count != e.Count || g.Sum != e.Sum || g.SumSq != e.SumSq || g.Min != e.Min || g.Max != e.Max || g.Last != e.Last {
+				t.Fatalf("bad %s: %v != %v", k, g.AggregateSample, e.AggregateSample)
+			}
+			if g.Percentile(0.5) != e.Percentile(0.5) {
+				t.Fatalf("bad %s median: %v != %v", k, g.Percentile(0.5), e.Percentile(0.5))
+			}
+		}
+	}
+}
+
+func TestInmemSink_ShardsRace(t *testing.T) {
+	inm := NewInmemSink(time.Millisecond, 10*time.Millisecond)
+	inm.SetShards(8)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for n := 0; n < 1000; n++ {
+				key := []string{"key", fmt.Sprint(n % 20)}
+				inm.SetGauge(key, 1)
+				inm.EmitKey(key, 1)
+				inm.IncrCounter(key, 1)
+				inm.AddSample(key, 1)
+			}
+		}(w)
+	}
+	for n := 0; n < 100; n++ {
+		for _, intv := range inm.Data() {
+			intv.RLock()
+			for _, c := range intv.Counters {
+				_ = c.Sum
+			}
+			intv.RUnlock()
+		}
+	}
+	wg.Wait()
+}
+
+func BenchmarkInmemSink_IncrCounter(b *testing.B) {
+	for _, shards := range []int{0, 16} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			inm := NewInmemSink(time.Minute, time.Hour)
+			inm.SetShards(shards)
+			keys := make([][]string, 64)
+			for n := range keys {
+				keys[n] = []string{"key", fmt.Sprint(n)}
+			}
+			b.RunParallel(func(pb *testing.PB) {
+				n := 0
+				for pb.Next() {
+					inm.IncrCounter(keys[n%len(keys)], 1)
+					n++
+				}
+			})
+		})
+	}
+}
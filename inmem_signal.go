@@ -2,13 +2,30 @@ package metrics
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
+)
+
+// DumpFormat selects the output format of an InmemSignal
+type DumpFormat int32
+
+const (
+	// DumpFormatText writes one human readable line per metric
+	DumpFormatText DumpFormat = iota
+
+	// DumpFormatJSON writes one JSON encoded MetricsSummary per interval,
+	// separated by newlines, so the output can be processed with tools like
+	// jq.
+	DumpFormatJSON
 )
 
 // InmemSignal is used to listen for a given signal, and when received,
@@ -22,10 +39,18 @@ type InmemSignal struct {
 	stop     bool
 	stopCh   chan struct{}
 	stopLock sync.Mutex
+
+	// dumpLock serializes the dumps to w of signals and the trigger file
+	dumpLock sync.Mutex
+
+	// format is the DumpFormat of the output. Accessed atomically.
+	format int32
 }
 
 // NewInmemSignal creates a new InmemSignal which listens for a given signal,
-// and dumps the current metrics out to a writer
+// and dumps the current metrics out to a writer. If sig is 0 no signal is
+// listened for, and dumps are only triggered by DumpNow, DumpHandler or
+// WatchTriggerFile.
 func NewInmemSignal(inmem *InmemSink, sig syscall.Signal, w io.Writer) *InmemSignal {
 	i := &InmemSignal{
 		signal: sig,
@@ -34,7 +59,9 @@ func NewInmemSignal(inmem *InmemSink, sig syscall.Signal, w io.Writer) *InmemSig
 		sigCh:  make(chan os.Signal, 1),
 		stopCh: make(chan struct{}),
 	}
-	signal.Notify(i.sigCh, sig)
+	if sig != 0 {
+		signal.Notify(i.sigCh, sig)
+	}
 	go i.run()
 	return i
 }
@@ -45,6 +72,11 @@ func DefaultInmemSignal(inmem *InmemSink) *InmemSignal {
 	return NewInmemSignal(inmem, DefaultSignal, os.Stderr)
 }
 
+// SetFormat sets the format metrics are dumped in, DumpFormatText by default.
+func (i *InmemSignal) SetFormat(format DumpFormat) {
+	atomic.StoreInt32(&i.format, int32(format))
+}
+
 // Stop is used to stop the InmemSignal from listening
 func (i *InmemSignal) Stop() {
 	i.stopLock.Lock()
@@ -70,11 +102,73 @@ func (i *InmemSignal) run() {
 	}
 }
 
+// DumpNow writes the metrics of all finished intervals to w, exactly like the
+// dump triggered by the signal. It allows triggering a dump on platforms or in
+// containers where sending signals isn't possible.
+func (i *InmemSignal) DumpNow(w io.Writer) {
+	i.writeStats(w)
+}
+
+// DumpHandler returns an http.Handler responding with a dump of the metrics,
+// in the format set with SetFormat.
+func (i *InmemSignal) DumpHandler() http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if DumpFormat(atomic.LoadInt32(&i.format)) == DumpFormatJSON {
+			resp.Header().Set("Content-Type", "application/json")
+		} else {
+			resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		}
+		i.writeStats(resp)
+	})
+}
+
+// WatchTriggerFile checks every interval whether a file exists at path. If it
+// does, the file is removed and the metrics are dumped to the writer of the
+// InmemSignal, so a dump can be triggered by creating the file, e.g. with
+// touch. Watching ends when the InmemSignal is stopped.
+func (i *InmemSignal) WatchTriggerFile(path string, interval time.Duration) {
+	go func() {
+		ticker := i.inm.getClock().NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				if _, err := os.Stat(path); err != nil {
+					continue
+				}
+				if err := os.Remove(path); err != nil {
+					continue
+				}
+				i.dumpStats()
+			case <-i.stopCh:
+				return
+			}
+		}
+	}()
+}
+
 // dumpStats is used to dump the data to output writer
 func (i *InmemSignal) dumpStats() {
+	i.dumpLock.Lock()
+	defer i.dumpLock.Unlock()
+	i.writeStats(i.w)
+}
+
+// writeStats writes the data of all finished intervals to w
+func (i *InmemSignal) writeStats(w io.Writer) {
 	buf := bytes.NewBuffer(nil)
 
 	data := i.inm.Data()
+	if DumpFormat(atomic.LoadInt32(&i.format)) == DumpFormatJSON {
+		enc := json.NewEncoder(buf)
+		// Skip the last period which is still being aggregated
+		for j := 0; j < len(data)-1; j++ {
+			enc.Encode(newMetricSummaryFromInterval(data[j]))
+		}
+		w.Write(buf.Bytes())
+		return
+	}
+
 	// Skip the last period which is still being aggregated
 	for j := 0; j < len(data)-1; j++ {
 		intv := data[j]
@@ -100,7 +194,7 @@ func (i *InmemSignal) dumpStats() {
 	}
 
 	// Write out the bytes
-	i.w.Write(buf.Bytes())
+	w.Write(buf.Bytes())
 }
 
 // Flattens the key for formatting along with its labels, removes spaces
@@ -2,9 +2,14 @@ package metrics
 
 import (
 	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
@@ -58,6 +63,115 @@ func TestInmemSignal(t *testing.T) {
 	}
 }
 
+func TestInmemSignal_JSON(t *testing.T) {
+	buf := newBuffer()
+	inm := NewInmemSink(10*time.Millisecond, 50*time.Millisecond)
+	sig := NewInmemSignal(inm, syscall.SIGUSR1, buf)
+	defer sig.Stop()
+	sig.SetFormat(DumpFormatJSON)
+
+	inm.SetGauge([]string{"foo"}, 42)
+	inm.AddSampleWithLabels([]string{"zxcv"}, 42, []Label{{"a", "b"}})
+
+	// Wait for period to end
+	time.Sleep(15 * time.Millisecond)
+	sig.dumpStats()
+
+	dec := json.NewDecoder(strings.NewReader(buf.String()))
+	var summary MetricsSummary
+	if err := dec.Decode(&summary); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(summary.Gauges) != 1 || summary.Gauges[0].Name != "foo" || summary.Gauges[0].Value != 42 {
+		t.Fatalf("bad: %v", summary.Gauges)
+	}
+	if len(summary.Samples) != 1 || summary.Samples[0].DisplayLabels["a"] != "b" {
+		t.Fatalf("bad: %v", summary.Samples)
+	}
+}
+
+func TestInmemSignal_Triggers(t *testing.T) {
+	buf := newBuffer()
+	inm := NewInmemSink(10*time.Millisecond, 50*time.Millisecond)
+	sig := NewInmemSignal(inm, 0, buf)
+	defer sig.Stop()
+
+	inm.SetGauge([]string{"foo"}, 42)
+
+	// Wait for period to end
+	time.Sleep(15 * time.Millisecond)
+
+	out := newBuffer()
+	sig.DumpNow(out)
+	if !strings.Contains(out.String(), "[G] 'foo': 42") {
+		t.Fatalf("bad: %v", out)
+	}
+
+	resp := httptest.NewRecorder()
+	sig.DumpHandler().ServeHTTP(resp, httptest.NewRequest("GET", "/debug/metrics", nil))
+	if !strings.Contains(resp.Body.String(), "[G] 'foo': 42") {
+		t.Fatalf("bad: %v", resp.Body)
+	}
+
+	dir, err := ioutil.TempDir("", "inmem-signal")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	trigger := filepath.Join(dir, "dump")
+
+	sig.WatchTriggerFile(trigger, time.Millisecond)
+	if err := ioutil.WriteFile(trigger, nil, 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for start := time.Now(); buf.String() == ""; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("trigger file did not cause a dump")
+		}
+	}
+	if !strings.Contains(buf.String(), "[G] 'foo': 42") {
+		t.Fatalf("bad: %v", buf)
+	}
+	if _, err := os.Stat(trigger); !os.IsNotExist(err) {
+		t.Fatalf("expected trigger file to be removed: %v", err)
+	}
+}
+
+func TestInmemSignal_SerializesDumps(t *testing.T) {
+	w := &overlapWriter{}
+	inm := NewInmemSink(time.Minute, time.Hour)
+	sig := NewInmemSignal(inm, 0, w)
+	defer sig.Stop()
+
+	var wg sync.WaitGroup
+	for j := 0; j < 10; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sig.dumpStats()
+		}()
+	}
+	wg.Wait()
+	if atomic.LoadInt32(&w.overlapped) != 0 {
+		t.Fatalf("dumps overlapped")
+	}
+}
+
+// overlapWriter records whether writes overlapped
+type overlapWriter struct {
+	active     int32
+	overlapped int32
+}
+
+func (w *overlapWriter) Write(p []byte) (int, error) {
+	if atomic.AddInt32(&w.active, 1) > 1 {
+		atomic.StoreInt32(&w.overlapped, 1)
+	}
+	time.Sleep(time.Millisecond)
+	atomic.AddInt32(&w.active, -1)
+	return len(p), nil
+}
+
 func newBuffer() *syncBuffer {
 	return &syncBuffer{buf: bytes.NewBuffer(nil)}
 }
@@ -0,0 +1,293 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// inmemSnapshotVersion is the version of the snapshot format written by
+// WriteSnapshot
+const inmemSnapshotVersion = 1
+
+type inmemSnapshot struct {
+	Version   int
+	Intervals []intervalSnapshot
+}
+
+type intervalSnapshot struct {
+	Interval time.Time
+	Gauges   map[string]gaugeSnapshot
+	Points   map[string][]float32
+	Counters map[string]sampleSnapshot
+	Samples  map[string]sampleSnapshot
+}
+
+type gaugeSnapshot struct {
+	Name   string
+	Value  float32
+	Labels []Label `json:",omitempty"`
+
+	// Sets is how often the gauge was set during the interval
+	Sets int `json:",omitempty"`
+
+	// Expiry is only set for gauges carried over by an expiration policy,
+	// and is zero if they don't expire
+	Expiry *time.Time `json:",omitempty"`
+}
+
+type sampleSnapshot struct {
+	Name          string
+	Labels        []Label `json:",omitempty"`
+	Count         int
+	Rate          float64
+	Sum           float64
+	SumSq         float64
+	Min           float64
+	Max           float64
+	Last          float64 `json:",omitempty"`
+	LastUpdated   time.Time
+	Disabled      Aggregation `json:",omitempty"`
+	Histogram     *Histogram  `json:",omitempty"`
+	Values        []float64   `json:",omitempty"`
+	ReservoirSize int         `json:",omitempty"`
+}
+
+// WriteSnapshot writes all retained intervals, including the current one, to
+// w. The snapshot can be loaded back with ReadSnapshot, e.g. after a restart.
+func (i *InmemSink) WriteSnapshot(w io.Writer) error {
+	data := i.Data()
+	snap := inmemSnapshot{
+		Version:   inmemSnapshotVersion,
+		Intervals: make([]intervalSnapshot, 0, len(data)),
+	}
+	for _, intv := range data {
+		intv.RLock()
+		s := intervalSnapshot{
+			Interval: intv.Interval,
+			Gauges:   make(map[string]gaugeSnapshot, len(intv.Gauges)),
+			Points:   make(map[string][]float32, len(intv.Points)),
+			Counters: snapshotSamples(intv.Counters),
+			Samples:  snapshotSamples(intv.Samples),
+		}
+		for k, g := range intv.Gauges {
+			gs := gaugeSnapshot{Name: g.Name, Value: g.Value, Labels: g.Labels, Sets: intv.gaugeSets[k]}
+			if expiry, ok := intv.gaugeExpiry[k]; ok {
+				gs.Expiry = &expiry
+			}
+			s.Gauges[k] = gs
+		}
+		for k, p := range intv.Points {
+			s.Points[k] = p
+		}
+		intv.RUnlock()
+		snap.Intervals = append(snap.Intervals, s)
+	}
+	return json.NewEncoder(w).Encode(snap)
+}
+
+func snapshotSamples(source map[string]SampledValue) map[string]sampleSnapshot {
+	out := make(map[string]sampleSnapshot, len(source))
+	for k, v := range source {
+		var histogram *Histogram
+		if v.Histogram != nil {
+			histogram = v.Histogram.deepCopy()
+		}
+		out[k] = sampleSnapshot{
+			Name:          v.Name,
+			Labels:        v.Labels,
+			Count:         v.Count,
+			Rate:          v.Rate,
+			Sum:           v.Sum,
+			SumSq:         v.SumSq,
+			Min:           v.Min,
+			Max:           v.Max,
+			Last:          v.Last,
+			LastUpdated:   v.LastUpdated,
+			Disabled:      v.disabled,
+			Histogram:     histogram,
+			Values:        v.Values(),
+			ReservoirSize: v.reservoirSize,
+		}
+	}
+	return out
+}
+
+// ReadSnapshot loads intervals written by WriteSnapshot into the sink.
+// Intervals older than the retention period of the sink, or not older than
+// any interval the sink already holds, are discarded. Metrics recorded into
+// a loaded interval that is still current are added to its values.
+func (i *InmemSink) ReadSnapshot(r io.Reader) error {
+	var snap inmemSnapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return err
+	}
+	if snap.Version != inmemSnapshotVersion {
+		return fmt.Errorf("unsupported inmem snapshot version %d", snap.Version)
+	}
+
+	cutoff := i.now().Add(-i.retain)
+	var loaded []*IntervalMetrics
+	for _, s := range snap.Intervals {
+		if s.Interval.Before(cutoff) {
+			continue
+		}
+		loaded = append(loaded, restoreInterval(s))
+	}
+
+	i.intervalLock.Lock()
+	defer i.intervalLock.Unlock()
+
+	if n := len(i.intervals); n > 0 {
+		first := i.intervals[0].Interval
+		for len(loaded) > 0 && !loaded[len(loaded)-1].Interval.Before(first) {
+			loaded = loaded[:len(loaded)-1]
+		}
+	}
+	if len(loaded) == 0 {
+		return nil
+	}
+
+	intervals := append(loaded, i.intervals...)
+	for _, intv := range intervals[:len(intervals)-1] {
+		select {
+		case <-intv.done:
+		default:
+			close(intv.done)
+		}
+	}
+	if n := len(intervals); n > i.maxIntervals {
+		intervals = intervals[n-i.maxIntervals:]
+	}
+	i.intervals = intervals
+
+	// A loaded interval that is still current receives metrics like one the
+	// sink started, so it's sharded and its keys count against the key limit
+	if current := intervals[len(intervals)-1]; current == loaded[len(loaded)-1] &&
+		current.Interval == i.now().Truncate(i.interval) {
+		current.shards = i.newShards()
+		current.Lock()
+		i.trackKeys(current)
+		current.Unlock()
+	}
+	return nil
+}
+
+func restoreInterval(s intervalSnapshot) *IntervalMetrics {
+	// getInterval compares intervals with ==, so restore the location
+	// time.Now uses
+	intv := NewIntervalMetrics(s.Interval.Local())
+	for k, g := range s.Gauges {
+		intv.Gauges[k] = GaugeValue{Name: g.Name, Value: g.Value, Labels: g.Labels, DisplayLabels: labelMap(g.Labels)}
+		if g.Sets > 0 {
+			intv.gaugeSets[k] = g.Sets
+		}
+		if g.Expiry != nil {
+			intv.gaugeExpiry[k] = *g.Expiry
+		}
+	}
+	for k, p := range s.Points {
+		intv.Points[k] = p
+	}
+	restoreSamples(intv.Counters, s.Counters)
+	restoreSamples(intv.Samples, s.Samples)
+	return intv
+}
+
+func restoreSamples(dest map[string]SampledValue, source map[string]sampleSnapshot) {
+	for k, v := range source {
+		dest[k] = SampledValue{
+			Name:          v.Name,
+			Labels:        v.Labels,
+			DisplayLabels: labelMap(v.Labels),
+			AggregateSample: &AggregateSample{
+				Count:         v.Count,
+				Rate:          v.Rate,
+				Sum:           v.Sum,
+				SumSq:         v.SumSq,
+				Min:           v.Min,
+				Max:           v.Max,
+				Last:          v.Last,
+				LastUpdated:   v.LastUpdated,
+				Histogram:     v.Histogram,
+				disabled:      v.Disabled,
+				values:        v.Values,
+				reservoirSize: v.ReservoirSize,
+			},
+		}
+	}
+}
+
+// SaveSnapshotFile writes a snapshot of the retained intervals to the file at
+// path. The file is replaced atomically, so a crash while saving leaves the
+// previous snapshot intact.
+func (i *InmemSink) SaveSnapshotFile(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := i.WriteSnapshot(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// SaveSnapshotFileEvery saves a snapshot of the retained intervals to the file
+// at path every interval, see SaveSnapshotFile, and once more when the
+// returned function is called to stop it, which may be called more than
+// once. Errors are logged. Together with
+// LoadSnapshotFile on start, this keeps the recent history across restarts.
+func (i *InmemSink) SaveSnapshotFileEvery(path string, interval time.Duration) (stop func()) {
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		ticker := i.getClock().NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+			case <-stopCh:
+				i.saveSnapshotFileLogged(path)
+				return
+			}
+			i.saveSnapshotFileLogged(path)
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(stopCh) })
+		<-doneCh
+	}
+}
+
+func (i *InmemSink) saveSnapshotFileLogged(path string) {
+	if err := i.SaveSnapshotFile(path); err != nil {
+		LogError("Error saving metrics snapshot", "sink", "inmem", "path", path, "class", "write", "error", err)
+	}
+}
+
+// LoadSnapshotFile loads a snapshot saved by SaveSnapshotFile into the sink,
+// see ReadSnapshot. A missing file is not an error, so it can be called on
+// every start.
+func (i *InmemSink) LoadSnapshotFile(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return i.ReadSnapshot(f)
+}
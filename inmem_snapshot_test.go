@@ -0,0 +1,187 @@
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInmemSink_Snapshot(t *testing.T) {
+	inm := NewInmemSink(time.Minute, time.Hour)
+	inm.SetReservoirSize(1028)
+	inm.SetExpirationPolicy(NewExpirationPolicy(0, map[string]time.Duration{"expiring": time.Hour}))
+
+	old := NewIntervalMetrics(time.Now().Truncate(time.Minute).Add(-time.Minute))
+	old.Gauges["old"] = GaugeValue{Name: "old", Value: 1}
+	inm.intervals = append(inm.intervals, old)
+
+	inm.SetGaugeWithLabels([]string{"foo"}, 41, []Label{{"a", "b"}})
+	inm.SetGaugeWithLabels([]string{"foo"}, 42, []Label{{"a", "b"}})
+	inm.SetGauge([]string{"expiring"}, 7)
+	inm.EmitKey([]string{"key"}, 2)
+	inm.IncrCounter([]string{"counter"}, 3)
+	inm.AddSample([]string{"sample"}, 4)
+	inm.AddSample([]string{"sample"}, 5)
+
+	var buf bytes.Buffer
+	if err := inm.WriteSnapshot(&buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	restored := NewInmemSink(time.Minute, time.Hour)
+	if err := restored.ReadSnapshot(&buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	expect, got := inm.Data(), restored.Data()
+	if len(got) != 2 {
+		t.Fatalf("bad: %v", got)
+	}
+	if got[0].Gauges["old"].Value != 1 {
+		t.Fatalf("bad: %v", got[0].Gauges)
+	}
+	for _, fn := range []func(*IntervalMetrics) interface{}{
+		func(intv *IntervalMetrics) interface{} { return intv.Interval },
+		func(intv *IntervalMetrics) interface{} { return intv.Gauges },
+		func(intv *IntervalMetrics) interface{} { return intv.gaugeSets },
+		func(intv *IntervalMetrics) interface{} { return len(intv.gaugeExpiry) },
+		func(intv *IntervalMetrics) interface{} { return intv.Points },
+		func(intv *IntervalMetrics) interface{} {
+			// LastUpdated loses its monotonic clock reading
+			c := *intv.Counters["counter"].AggregateSample
+			c.LastUpdated = time.Time{}
+			return c
+		},
+	} {
+		if !reflect.DeepEqual(fn(got[1]), fn(expect[1])) {
+			t.Fatalf("bad: %v != %v", fn(got[1]), fn(expect[1]))
+		}
+	}
+	if e := got[1].gaugeExpiry["expiring"]; !e.Equal(expect[1].gaugeExpiry["expiring"]) {
+		t.Fatalf("bad expiry: %v != %v", e, expect[1].gaugeExpiry["expiring"])
+	}
+	sample := got[1].Samples["sample"]
+	if sample.Count != 2 || sample.Percentile(0.5) != 4 {
+		t.Fatalf("bad: %v", sample)
+	}
+
+	// The restored current interval keeps aggregating
+	restored.AddSample([]string{"sample"}, 6)
+	got = restored.Data()
+	if got[1].Samples["sample"].Count != 3 {
+		t.Fatalf("bad: %v", got[1].Samples)
+	}
+
+	if err := restored.ReadSnapshot(strings.NewReader(`{"Version":99}`)); err == nil {
+		t.Fatalf("expected version error")
+	}
+}
+
+func TestInmemSink_SnapshotFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inmem-snapshot")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "metrics.json")
+
+	inm := NewInmemSink(time.Minute, time.Hour)
+	if err := inm.LoadSnapshotFile(path); err != nil {
+		t.Fatalf("missing file should be ignored: %v", err)
+	}
+
+	inm.SetGauge([]string{"foo"}, 42)
+	if err := inm.SaveSnapshotFile(path); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatalf("expected temporary file to be removed: %v", files)
+	}
+
+	restored := NewInmemSink(time.Minute, time.Hour)
+	if err := restored.LoadSnapshotFile(path); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	data := restored.Data()
+	if data[len(data)-1].Gauges["foo"].Value != 42 {
+		t.Fatalf("bad: %v", data)
+	}
+}
+
+func TestInmemSink_SnapshotRestoresCurrentInterval(t *testing.T) {
+	inm := NewInmemSink(time.Minute, time.Hour)
+	inm.SetGauge([]string{"a"}, 1)
+	inm.SetGauge([]string{"b"}, 2)
+	inm.IncrCounter([]string{"c"}, 3)
+	var buf bytes.Buffer
+	if err := inm.WriteSnapshot(&buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	snapshot := buf.String()
+
+	limited := NewInmemSink(time.Minute, time.Hour)
+	limited.SetMaxKeys(2)
+	if err := limited.ReadSnapshot(strings.NewReader(snapshot)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	limited.SetGauge([]string{"d"}, 4)
+	intv := limited.Data()[0]
+	if n := len(intv.Gauges) + len(intv.Counters); n != 3 {
+		t.Fatalf("expected 2 keys and the dropped keys counter: %v %v", intv.Gauges, intv.Counters)
+	}
+	if _, ok := intv.Gauges["d"]; !ok {
+		t.Fatalf("missing new key: %v", intv.Gauges)
+	}
+	if intv.Counters[droppedKeysKey].Count != 2 {
+		t.Fatalf("bad: %v", intv.Counters)
+	}
+
+	sharded := NewInmemSink(time.Minute, time.Hour)
+	sharded.SetShards(4)
+	if err := sharded.ReadSnapshot(strings.NewReader(snapshot)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n := len(sharded.getInterval().shards); n != 4 {
+		t.Fatalf("bad: %d shards", n)
+	}
+	sharded.SetGauge([]string{"d"}, 4)
+	if g := sharded.Data()[0].Gauges; len(g) != 3 || g["d"].Value != 4 {
+		t.Fatalf("bad: %v", g)
+	}
+}
+
+func TestInmemSink_SaveSnapshotFileEvery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	inm := NewInmemSink(time.Minute, time.Hour)
+	inm.SetGauge([]string{"foo"}, 1)
+
+	stop := inm.SaveSnapshotFileEvery(path, 10*time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("snapshot not saved")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Stopping saves a final snapshot
+	inm.SetGauge([]string{"foo"}, 2)
+	stop()
+	stop()
+	restored := NewInmemSink(time.Minute, time.Hour)
+	if err := restored.LoadSnapshotFile(path); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if v := restored.Data()[0].Gauges["foo"].Value; v != 2 {
+		t.Fatalf("bad: %v", v)
+	}
+}
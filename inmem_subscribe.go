@@ -0,0 +1,85 @@
+package metrics
+
+import "sync"
+
+// BackpressurePolicy decides what happens to a finished interval when the
+// channel of a subscriber is full.
+type BackpressurePolicy int
+
+const (
+	// DropNewest discards the interval that just finished
+	DropNewest BackpressurePolicy = iota
+
+	// DropOldest discards the oldest undelivered interval to make room for
+	// the one that just finished
+	DropOldest
+)
+
+type intervalSubscriber struct {
+	ch     chan *IntervalMetrics
+	policy BackpressurePolicy
+}
+
+type intervalSubscribers struct {
+	lock sync.Mutex
+	subs map[*intervalSubscriber]struct{}
+}
+
+// Subscribe returns a channel receiving every interval of the sink once it has
+// finished, so interval aggregates can be consumed without polling Data. As
+// with Stream, an interval finishes when the first metric of the next one is
+// recorded. Delivery never blocks the sink: once buffer intervals are waiting
+// to be received, policy decides which one is dropped. Metrics recorded
+// concurrently with the rollover may still be added to a delivered interval,
+// so it must be read locked while it is read.
+//
+// The returned function ends the subscription and closes the channel.
+func (i *InmemSink) Subscribe(buffer int, policy BackpressurePolicy) (<-chan *IntervalMetrics, func()) {
+	sub := &intervalSubscriber{
+		ch:     make(chan *IntervalMetrics, buffer),
+		policy: policy,
+	}
+
+	i.subscribers.lock.Lock()
+	if i.subscribers.subs == nil {
+		i.subscribers.subs = make(map[*intervalSubscriber]struct{})
+	}
+	i.subscribers.subs[sub] = struct{}{}
+	i.subscribers.lock.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			i.subscribers.lock.Lock()
+			delete(i.subscribers.subs, sub)
+			close(sub.ch)
+			i.subscribers.lock.Unlock()
+		})
+	}
+	return sub.ch, cancel
+}
+
+// publish hands a finished interval to all subscribers without blocking
+func (s *intervalSubscribers) publish(intv *IntervalMetrics) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for sub := range s.subs {
+		select {
+		case sub.ch <- intv:
+			continue
+		default:
+		}
+		if sub.policy != DropOldest {
+			continue
+		}
+		select {
+		case <-sub.ch:
+		default:
+		}
+		select {
+		case sub.ch <- intv:
+		default:
+		}
+	}
+}
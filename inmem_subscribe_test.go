@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInmemSink_Subscribe(t *testing.T) {
+	inm := NewInmemSink(time.Minute, time.Hour)
+	ch, cancel := inm.Subscribe(1, DropNewest)
+	oldest, cancelOldest := inm.Subscribe(1, DropOldest)
+	defer cancelOldest()
+
+	// Finish three past intervals by making each the last one before
+	// rolling over to the current interval
+	start := time.Now().Truncate(time.Minute).Add(-3 * time.Minute)
+	var finished []*IntervalMetrics
+	for n := 0; n < 3; n++ {
+		intv := NewIntervalMetrics(start.Add(time.Duration(n) * time.Minute))
+		finished = append(finished, intv)
+		inm.intervals = []*IntervalMetrics{intv}
+		inm.getInterval()
+	}
+
+	select {
+	case intv := <-ch:
+		if intv != finished[0] {
+			t.Fatalf("expected first interval, got %v", intv.Interval)
+		}
+	default:
+		t.Fatalf("expected an interval")
+	}
+	select {
+	case intv := <-oldest:
+		if intv != finished[2] {
+			t.Fatalf("expected last interval, got %v", intv.Interval)
+		}
+	default:
+		t.Fatalf("expected an interval")
+	}
+
+	cancel()
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Fatalf("expected channel to be closed")
+	}
+}
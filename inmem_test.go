@@ -1,8 +1,10 @@
 package metrics
 
 import (
+	"encoding/json"
 	"math"
 	"net/url"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -113,19 +115,278 @@ func TestInmemSink(t *testing.T) {
 	}
 }
 
+func TestAggregateSample_Percentile(t *testing.T) {
+	agg := newSampleAggregate(1028)
+	if p := agg.Percentile(0.5); p != 0 {
+		t.Fatalf("bad: %v", p)
+	}
+	for v := 100; v > 0; v-- {
+		agg.Ingest(float64(v), 1)
+	}
+	for q, expect := range map[float64]float64{0: 1, 0.5: 50, 0.9: 90, 0.99: 99, 1: 100} {
+		if p := agg.Percentile(q); p != expect {
+			t.Fatalf("bad percentile %v: %v", q, p)
+		}
+	}
+
+	// Once the reservoir is full it keeps a uniform sample
+	agg = newSampleAggregate(100)
+	for v := 0; v < 10000; v++ {
+		agg.Ingest(float64(v), 1)
+	}
+	if len(agg.values) != 100 {
+		t.Fatalf("bad: %d values", len(agg.values))
+	}
+	if p := agg.Percentile(0.5); p < 2000 || p > 8000 {
+		t.Fatalf("bad median: %v", p)
+	}
+
+	// Counters don't keep values
+	agg = &AggregateSample{}
+	agg.Ingest(1, 1)
+	if agg.values != nil {
+		t.Fatalf("bad: %v", agg.values)
+	}
+}
+
+func TestInmemSink_SetReservoirSize(t *testing.T) {
+	inm := NewInmemSink(time.Second, time.Minute)
+	inm.SetReservoirSize(3)
+	for v := 1; v <= 10; v++ {
+		inm.AddSample([]string{"foo"}, float32(v))
+	}
+	inm.SetReservoirSize(0)
+	inm.AddSample([]string{"bar"}, 1)
+
+	data := inm.Data()
+	values := data[len(data)-1].Samples["foo"].Values()
+	if len(values) != 3 {
+		t.Fatalf("bad: %v", values)
+	}
+	for _, v := range values {
+		if v < 1 || v > 10 {
+			t.Fatalf("bad: %v", values)
+		}
+	}
+
+	bar := data[len(data)-1].Samples["bar"]
+	if bar.Values() != nil || bar.Percentile(0.5) != 0 {
+		t.Fatalf("expected no values: %v", bar.Values())
+	}
+}
+
+func TestInmemSink_SetAggregations(t *testing.T) {
+	inm := NewInmemSink(time.Second, time.Minute)
+	inm.AddSample([]string{"default"}, 1)
+	inm.AddSample([]string{"default"}, 3)
+
+	inm.SetAggregations(AggregateLast)
+	inm.AddSample([]string{"last"}, 1)
+	inm.AddSample([]string{"last"}, 3)
+	inm.IncrCounter([]string{"counter"}, 2)
+
+	data := inm.Data()
+	samples := data[len(data)-1].Samples
+
+	def := samples["default"]
+	if def.Last != 0 || def.Rate != 4 || def.AggregateSample.Stddev() == 0 {
+		t.Fatalf("bad: %v", def)
+	}
+
+	last := samples["last"]
+	if last.Last != 3 || last.Rate != 0 || last.SumSq != 0 || last.AggregateSample.Stddev() != 0 {
+		t.Fatalf("bad: %v", last)
+	}
+	if last.Sum != 4 || last.Count != 2 || last.Min != 1 || last.Max != 3 {
+		t.Fatalf("bad: %v", last)
+	}
+
+	counter := data[len(data)-1].Counters["counter"]
+	if counter.Last != 2 || counter.Rate != 0 {
+		t.Fatalf("bad: %v", counter)
+	}
+}
+
+func TestInmemSink_SetHistogramBuckets(t *testing.T) {
+	inm := NewInmemSink(time.Second, time.Minute)
+	var _ HistogramSink = inm
+	inm.SetHistogramBuckets(NewHistogramBuckets(nil, map[string][]float64{
+		"http": {10, 1, 5},
+	}))
+
+	for _, v := range []float32{0.5, 1, 3, 7, 20, 30} {
+		inm.AddSample([]string{"http", "latency"}, v)
+		inm.AddSample([]string{"db", "latency"}, v)
+	}
+
+	data := inm.Data()
+	samples := data[len(data)-1].Samples
+	h := samples["http.latency"].Histogram
+	if h == nil {
+		t.Fatalf("expected histogram")
+	}
+	if !reflect.DeepEqual(h.Bounds, []float64{1, 5, 10}) {
+		t.Fatalf("bad bounds: %v", h.Bounds)
+	}
+	if !reflect.DeepEqual(h.Counts, []uint64{2, 1, 1, 2}) {
+		t.Fatalf("bad counts: %v", h.Counts)
+	}
+	if !reflect.DeepEqual(h.Cumulative(), []uint64{2, 3, 4, 6}) {
+		t.Fatalf("bad cumulative counts: %v", h.Cumulative())
+	}
+	if samples["db.latency"].Histogram != nil {
+		t.Fatalf("expected no histogram: %v", samples["db.latency"].Histogram)
+	}
+}
+
+func TestInmemSink_ExpirationPolicy(t *testing.T) {
+	inm := NewInmemSink(10*time.Millisecond, 100*time.Millisecond)
+	inm.SetExpirationPolicy(NewExpirationPolicy(0, map[string]time.Duration{
+		"short": 30 * time.Millisecond,
+	}))
+
+	inm.SetGauge([]string{"short", "gauge"}, 1)
+	inm.SetGauge([]string{"long", "gauge"}, 2)
+	inm.SetGaugeWithLabels([]string{"long", "gauge"}, 3, []Label{{"a", "b"}})
+
+	// The gauges are carried over into the next interval
+	time.Sleep(12 * time.Millisecond)
+	data := inm.Data()
+	current := data[len(data)-1]
+	if current.Gauges["short.gauge"].Value != 1 {
+		t.Fatalf("expected short gauge to be carried over: %v", current.Gauges)
+	}
+	if current.Gauges["long.gauge;a=b"].Value != 3 {
+		t.Fatalf("expected long gauge to be carried over: %v", current.Gauges)
+	}
+
+	// Only the gauge without a TTL is left once the short one expired
+	time.Sleep(50 * time.Millisecond)
+	data = inm.Data()
+	current = data[len(data)-1]
+	if _, ok := current.Gauges["short.gauge"]; ok {
+		t.Fatalf("expected short gauge to expire: %v", current.Gauges)
+	}
+	if current.Gauges["long.gauge"].Value != 2 {
+		t.Fatalf("expected long gauge to be carried over: %v", current.Gauges)
+	}
+
+	// Without a policy intervals start out empty
+	inm.SetExpirationPolicy(nil)
+	time.Sleep(12 * time.Millisecond)
+	data = inm.Data()
+	current = data[len(data)-1]
+	if len(current.Gauges) != 0 {
+		t.Fatalf("expected no gauges: %v", current.Gauges)
+	}
+}
+
+func TestInmemSink_ExpirationPolicyCurrentInterval(t *testing.T) {
+	inm := NewInmemSink(time.Hour, 2*time.Hour)
+	inm.SetExpirationPolicy(NewExpirationPolicy(0, map[string]time.Duration{
+		"session": 20 * time.Millisecond,
+	}))
+	inm.SetGauge([]string{"session", "gauge"}, 1)
+	inm.SetGauge([]string{"other", "gauge"}, 2)
+
+	// Idle gauges disappear from the current interval before it ends
+	time.Sleep(30 * time.Millisecond)
+	data := inm.Data()
+	current := data[len(data)-1]
+	if _, ok := current.Gauges["session.gauge"]; ok {
+		t.Fatalf("expected session gauge to expire: %v", current.Gauges)
+	}
+	if current.Gauges["other.gauge"].Value != 2 {
+		t.Fatalf("bad: %v", current.Gauges)
+	}
+
+	// Setting it again brings it back
+	inm.SetGauge([]string{"session", "gauge"}, 3)
+	data = inm.Data()
+	current = data[len(data)-1]
+	if current.Gauges["session.gauge"].Value != 3 {
+		t.Fatalf("bad: %v", current.Gauges)
+	}
+}
+
+func TestInmemSink_AddPrecisionSample(t *testing.T) {
+	inm := NewInmemSink(time.Second, time.Minute)
+
+	// 16777217 can't be represented as a float32
+	inm.AddPrecisionSample([]string{"foo", "bar"}, 16777217)
+	inm.AddPrecisionSampleWithLabels([]string{"foo", "bar"}, 0.000001, []Label{{"a", "b"}})
+
+	data := inm.Data()
+	sample := data[0].Samples["foo.bar"]
+	if sample.Max != 16777217 {
+		t.Fatalf("bad: %v", sample)
+	}
+	sample = data[0].Samples["foo.bar;a=b"]
+	if sample.Sum != 0.000001 {
+		t.Fatalf("bad: %v", sample)
+	}
+}
+
+func TestInmemSink_Labels(t *testing.T) {
+	inm := NewInmemSink(time.Second, time.Minute)
+	labels := []Label{{"a", "b"}, {"c", "d"}}
+	inm.SetGaugeWithLabels([]string{"gauge"}, 1, labels)
+	inm.SetGaugeWithLabels([]string{"gauge"}, 2, labels)
+	inm.IncrCounterWithLabels([]string{"counter"}, 1, labels)
+	inm.AddSampleWithLabels([]string{"sample"}, 1, labels)
+
+	data := inm.Data()
+	intv := data[len(data)-1]
+	expect := map[string]string{"a": "b", "c": "d"}
+	for _, got := range []map[string]string{
+		intv.Gauges["gauge;a=b;c=d"].DisplayLabels,
+		intv.Counters["counter;a=b;c=d"].DisplayLabels,
+		intv.Samples["sample;a=b;c=d"].DisplayLabels,
+	} {
+		if !reflect.DeepEqual(got, expect) {
+			t.Fatalf("bad: %v", got)
+		}
+	}
+	if g := intv.Gauges["gauge;a=b;c=d"]; g.Name != "gauge" || !reflect.DeepEqual(g.Labels, labels) {
+		t.Fatalf("bad: %v", g)
+	}
+
+	encoded, err := json.Marshal(intv)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !strings.Contains(string(encoded), `"Name":"counter"`) || !strings.Contains(string(encoded), `"Labels":{"a":"b","c":"d"}`) {
+		t.Fatalf("bad: %s", encoded)
+	}
+}
+
 func TestNewInmemSinkFromURL(t *testing.T) {
 	for _, tc := range []struct {
-		desc           string
-		input          string
-		expectErr      string
-		expectInterval time.Duration
-		expectRetain   time.Duration
+		desc            string
+		input           string
+		expectErr       string
+		expectInterval  time.Duration
+		expectRetain    time.Duration
+		expectReservoir int64
 	}{
 		{
-			desc:           "interval and duration are set via query params",
-			input:          "inmem://?interval=11s&retain=22s",
-			expectInterval: duration(t, "11s"),
-			expectRetain:   duration(t, "22s"),
+			desc:            "interval and duration are set via query params",
+			input:           "inmem://?interval=11s&retain=22s",
+			expectInterval:  duration(t, "11s"),
+			expectRetain:    duration(t, "22s"),
+			expectReservoir: 0,
+		},
+		{
+			desc:            "reservoir size is set via query params",
+			input:           "inmem://?interval=11s&retain=22s&reservoir=10",
+			expectInterval:  duration(t, "11s"),
+			expectRetain:    duration(t, "22s"),
+			expectReservoir: 10,
+		},
+		{
+			desc:      "reservoir must be a number",
+			input:     "inmem://?interval=11s&retain=22s&reservoir=many",
+			expectErr: "Bad 'reservoir' param",
 		},
 		{
 			desc:      "interval is required",
@@ -169,6 +430,9 @@ func TestNewInmemSinkFromURL(t *testing.T) {
 				if is.retain != tc.expectRetain {
 					t.Fatalf("expected retain %s, got: %s", tc.expectRetain, is.retain)
 				}
+				if is.reservoirSize != tc.expectReservoir {
+					t.Fatalf("expected reservoir %d, got: %d", tc.expectReservoir, is.reservoirSize)
+				}
 			}
 		})
 	}
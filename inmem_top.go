@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"sort"
+	"time"
+)
+
+// KeyCount is the number of emissions or distinct label sets of a metric,
+// as reported by InmemSink.TopKeys.
+type KeyCount struct {
+	Name  string
+	Count int
+}
+
+// TopKeysReport lists the metrics emitted most often and those with the most
+// distinct label sets during a window of an InmemSink.
+type TopKeysReport struct {
+	Hottest            []KeyCount
+	HighestCardinality []KeyCount
+}
+
+// TopKeys returns the n metric names with the most emissions and the n with
+// the most distinct label sets in the intervals overlapping the last window,
+// to help find instrumentation hot spots and cardinality leaks. A window of 0
+// covers only the current interval. Metrics are identified by their flattened
+// name without labels. Ties are broken by name.
+func (i *InmemSink) TopKeys(n int, window time.Duration) TopKeysReport {
+	emissions := make(map[string]int)
+	labelSets := make(map[string]map[string]struct{})
+	addLabelSet := func(name, hash string) {
+		sets, ok := labelSets[name]
+		if !ok {
+			sets = make(map[string]struct{})
+			labelSets[name] = sets
+		}
+		sets[hash] = struct{}{}
+	}
+
+	start := i.now().Add(-window)
+	for _, intv := range i.Data() {
+		if !intv.Interval.Add(i.interval).After(start) {
+			continue
+		}
+		intv.RLock()
+		for hash, g := range intv.Gauges {
+			// Gauges carried over from a previous interval weren't set
+			emissions[g.Name] += intv.gaugeSets[hash]
+			addLabelSet(g.Name, hash)
+		}
+		for name, points := range intv.Points {
+			emissions[name] += len(points)
+			addLabelSet(name, name)
+		}
+		for _, samples := range []map[string]SampledValue{intv.Counters, intv.Samples} {
+			for hash, s := range samples {
+				emissions[s.Name] += s.Count
+				addLabelSet(s.Name, hash)
+			}
+		}
+		intv.RUnlock()
+	}
+
+	cardinality := make(map[string]int, len(labelSets))
+	for name, sets := range labelSets {
+		cardinality[name] = len(sets)
+	}
+	return TopKeysReport{
+		Hottest:            topCounts(emissions, n),
+		HighestCardinality: topCounts(cardinality, n),
+	}
+}
+
+func topCounts(counts map[string]int, n int) []KeyCount {
+	out := make([]KeyCount, 0, len(counts))
+	for name, count := range counts {
+		out = append(out, KeyCount{Name: name, Count: count})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Name < out[j].Name
+	})
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
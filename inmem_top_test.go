@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInmemSink_TopKeys(t *testing.T) {
+	inm := NewInmemSink(time.Minute, time.Hour)
+	for n := 0; n < 5; n++ {
+		inm.IncrCounter([]string{"hot"}, 1)
+		inm.SetGaugeWithLabels([]string{"wide"}, 1, []Label{{"id", fmt.Sprint(n)}})
+	}
+	for n := 0; n < 3; n++ {
+		inm.AddSampleWithLabels([]string{"sample"}, 1, []Label{{"id", fmt.Sprint(n % 2)}})
+	}
+	inm.EmitKey([]string{"point"}, 1)
+
+	report := inm.TopKeys(2, 0)
+	expect := TopKeysReport{
+		Hottest:            []KeyCount{{"hot", 5}, {"wide", 5}},
+		HighestCardinality: []KeyCount{{"wide", 5}, {"sample", 2}},
+	}
+	if !reflect.DeepEqual(report, expect) {
+		t.Fatalf("bad: %#v", report)
+	}
+
+	// Setting a gauge again counts as an emission but not a new label set
+	inm.SetGaugeWithLabels([]string{"wide"}, 2, []Label{{"id", "0"}})
+	report = inm.TopKeys(10, 0)
+	if report.Hottest[0] != (KeyCount{"wide", 6}) || report.HighestCardinality[0] != (KeyCount{"wide", 5}) {
+		t.Fatalf("bad: %#v", report)
+	}
+	if len(report.Hottest) != 4 {
+		t.Fatalf("bad: %#v", report.Hottest)
+	}
+}
+
+func TestInmemSink_TopKeysWindow(t *testing.T) {
+	inm := NewInmemSink(time.Minute, time.Hour)
+	old := NewIntervalMetrics(time.Now().Truncate(time.Minute).Add(-10 * time.Minute))
+	old.Counters["old"] = SampledValue{Name: "old", AggregateSample: &AggregateSample{Count: 100}}
+	inm.intervals = append(inm.intervals, old)
+	inm.IncrCounter([]string{"new"}, 1)
+
+	if report := inm.TopKeys(10, 0); !reflect.DeepEqual(report.Hottest, []KeyCount{{"new", 1}}) {
+		t.Fatalf("bad: %#v", report)
+	}
+	if report := inm.TopKeys(10, time.Hour); !reflect.DeepEqual(report.Hottest, []KeyCount{{"old", 100}, {"new", 1}}) {
+		t.Fatalf("bad: %#v", report)
+	}
+}
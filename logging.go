@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-logr/logr"
+)
+
+// Logger receives the errors of sinks and collectors, with a message and
+// alternating keys and values of structured fields such as:
+//
+//	sink - the sink, e.g. statsd
+//	addr, path - where the sink writes to
+//	class - the class of the error, e.g. connect, write or flush
+//	error - the error
+//
+// *slog.Logger and hclog.Logger implement it, and NewLogrLogger adapts a
+// logr.Logger. By default errors are written to the standard logger, e.g.
+//
+//	[ERR] Error writing to statsd: sink=statsd addr=localhost:8125 class=write error=...
+type Logger interface {
+	Error(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+}
+
+// logger holds a loggerHolder: atomic.Value requires the same concrete type
+var logger atomic.Value
+
+type loggerHolder struct {
+	Logger
+}
+
+func init() {
+	SetLogger(stdLogger{})
+}
+
+// SetLogger routes the errors of sinks and collectors to l.
+func SetLogger(l Logger) {
+	logger.Store(loggerHolder{l})
+}
+
+// LogError logs an error with the logger set by SetLogger, for sinks and
+// collectors outside this package.
+func LogError(msg string, keysAndValues ...interface{}) {
+	logger.Load().(loggerHolder).Error(msg, keysAndValues...)
+}
+
+// LogWarn logs a warning with the logger set by SetLogger.
+func LogWarn(msg string, keysAndValues ...interface{}) {
+	logger.Load().(loggerHolder).Warn(msg, keysAndValues...)
+}
+
+// stdLogger writes to the standard logger
+type stdLogger struct{}
+
+func (stdLogger) Error(msg string, keysAndValues ...interface{}) {
+	log.Printf("[ERR] %s%s", msg, formatFields(keysAndValues))
+}
+
+func (stdLogger) Warn(msg string, keysAndValues ...interface{}) {
+	log.Printf("[WARN] %s%s", msg, formatFields(keysAndValues))
+}
+
+// formatFields formats keysAndValues as ": key=value key=value"
+func formatFields(keysAndValues []interface{}) string {
+	if len(keysAndValues) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(":")
+	for i := 0; i < len(keysAndValues); i += 2 {
+		var value interface{} = "<missing>"
+		if i+1 < len(keysAndValues) {
+			value = keysAndValues[i+1]
+		}
+		fmt.Fprintf(&b, " %v=%v", keysAndValues[i], value)
+	}
+	return b.String()
+}
+
+// NewLogrLogger returns a Logger logging errors with l.Error, passing the
+// value of the error field as the error, and warnings with l.Info.
+func NewLogrLogger(l logr.Logger) Logger {
+	return logrLogger{l}
+}
+
+type logrLogger struct {
+	l logr.Logger
+}
+
+func (l logrLogger) Error(msg string, keysAndValues ...interface{}) {
+	var err error
+	fields := make([]interface{}, 0, len(keysAndValues))
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		if e, ok := keysAndValues[i+1].(error); ok && keysAndValues[i] == "error" && err == nil {
+			err = e
+			continue
+		}
+		fields = append(fields, keysAndValues[i], keysAndValues[i+1])
+	}
+	l.l.Error(err, msg, fields...)
+}
+
+func (l logrLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.l.Info(msg, keysAndValues...)
+}
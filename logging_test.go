@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+)
+
+var _ Logger = slog.Default()
+
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprint("error ", msg, keysAndValues))
+}
+
+func (l *recordingLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprint("warn ", msg, keysAndValues))
+}
+
+func TestSetLogger(t *testing.T) {
+	l := &recordingLogger{}
+	SetLogger(l)
+	defer SetLogger(stdLogger{})
+
+	LogError("Error writing to statsd", "sink", "statsd", "error", errors.New("refused"))
+	LogWarn("Dropping statsd line", "line", "x")
+	want := []string{
+		"error Error writing to statsd[sink statsd error refused]",
+		"warn Dropping statsd line[line x]",
+	}
+	if fmt.Sprint(l.lines) != fmt.Sprint(want) {
+		t.Fatalf("got %q, want %q", l.lines, want)
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	flags := log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	}()
+
+	LogError("Error writing to statsd", "sink", "statsd", "error", errors.New("refused"), "dangling")
+	if got, want := buf.String(), "[ERR] Error writing to statsd: sink=statsd error=refused dangling=<missing>\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestLogrLogger(t *testing.T) {
+	var lines []string
+	l := NewLogrLogger(funcr.New(func(prefix, args string) {
+		lines = append(lines, args)
+	}, funcr.Options{}))
+
+	l.Error("Error writing to statsd", "sink", "statsd", "error", errors.New("refused"))
+	l.Warn("Dropping statsd line", "line", "x")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"error"="refused"`) || !strings.Contains(lines[0], `"sink"="statsd"`) ||
+		!strings.Contains(lines[1], `"line"="x"`) {
+		t.Fatalf("bad lines: %q", lines)
+	}
+}
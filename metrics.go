@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"runtime"
+	"runtime/pprof"
 	"strings"
 	"time"
 
@@ -18,28 +19,11 @@ func (m *Metrics) SetGauge(key []string, val float32) {
 }
 
 func (m *Metrics) SetGaugeWithLabels(key []string, val float32, labels []Label) {
-	if m.HostName != "" {
-		if m.EnableHostnameLabel {
-			labels = append(labels, Label{"host", m.HostName})
-		} else if m.EnableHostname {
-			key = insert(0, m.HostName, key)
-		}
-	}
-	if m.EnableTypePrefix {
-		key = insert(0, "gauge", key)
-	}
-	if m.ServiceName != "" {
-		if m.EnableServiceLabel {
-			labels = append(labels, Label{"service", m.ServiceName})
-		} else {
-			key = insert(0, m.ServiceName, key)
-		}
-	}
-	allowed, labelsFiltered := m.allowMetric(key, labels)
+	key, labels, allowed := m.prepare("gauge", key, labels, nil)
 	if !allowed {
 		return
 	}
-	m.sink.SetGaugeWithLabels(key, val, labelsFiltered)
+	m.sink.SetGaugeWithLabels(key, val, labels)
 }
 
 func (m *Metrics) EmitKey(key []string, val float32) {
@@ -61,24 +45,18 @@ func (m *Metrics) IncrCounter(key []string, val float32) {
 }
 
 func (m *Metrics) IncrCounterWithLabels(key []string, val float32, labels []Label) {
-	if m.HostName != "" && m.EnableHostnameLabel {
-		labels = append(labels, Label{"host", m.HostName})
-	}
-	if m.EnableTypePrefix {
-		key = insert(0, "counter", key)
-	}
-	if m.ServiceName != "" {
-		if m.EnableServiceLabel {
-			labels = append(labels, Label{"service", m.ServiceName})
-		} else {
-			key = insert(0, m.ServiceName, key)
-		}
-	}
-	allowed, labelsFiltered := m.allowMetric(key, labels)
+	m.IncrCounterWithExemplar(key, val, labels, nil)
+}
+
+// IncrCounterWithExemplar is like IncrCounterWithLabels, but also hands the
+// exemplar, e.g. []Label{{"trace_id", id}}, to sinks supporting exemplars, see
+// ExemplarSink. Other sinks only get the increment.
+func (m *Metrics) IncrCounterWithExemplar(key []string, val float32, labels []Label, exemplar []Label) {
+	key, labels, allowed := m.prepare("counter", key, labels, nil)
 	if !allowed {
 		return
 	}
-	m.sink.IncrCounterWithLabels(key, val, labelsFiltered)
+	incrCounterWithExemplar(m.sink, key, val, labels, exemplar)
 }
 
 func (m *Metrics) AddSample(key []string, val float32) {
@@ -86,52 +64,134 @@ func (m *Metrics) AddSample(key []string, val float32) {
 }
 
 func (m *Metrics) AddSampleWithLabels(key []string, val float32, labels []Label) {
-	if m.HostName != "" && m.EnableHostnameLabel {
-		labels = append(labels, Label{"host", m.HostName})
-	}
-	if m.EnableTypePrefix {
-		key = insert(0, "sample", key)
-	}
-	if m.ServiceName != "" {
-		if m.EnableServiceLabel {
-			labels = append(labels, Label{"service", m.ServiceName})
-		} else {
-			key = insert(0, m.ServiceName, key)
-		}
-	}
-	allowed, labelsFiltered := m.allowMetric(key, labels)
+	m.AddSampleWithExemplar(key, val, labels, nil)
+}
+
+// AddSampleWithExemplar is like AddSampleWithLabels, but also hands the
+// exemplar to sinks supporting exemplars, see IncrCounterWithExemplar.
+func (m *Metrics) AddSampleWithExemplar(key []string, val float32, labels []Label, exemplar []Label) {
+	key, labels, allowed := m.prepare("sample", key, labels, nil)
 	if !allowed {
 		return
 	}
-	m.sink.AddSampleWithLabels(key, val, labelsFiltered)
+	addSampleWithExemplar(m.sink, key, val, labels, exemplar)
 }
 
+// MeasureSince records the time elapsed since start as a timer sample. Pass a
+// start of Now to measure it with Config.Clock, or of time.Now to measure it
+// with the system clock, see since.
 func (m *Metrics) MeasureSince(key []string, start time.Time) {
 	m.MeasureSinceWithLabels(key, start, nil)
 }
 
 func (m *Metrics) MeasureSinceWithLabels(key []string, start time.Time, labels []Label) {
-	if m.HostName != "" && m.EnableHostnameLabel {
-		labels = append(labels, Label{"host", m.HostName})
+	m.AddDurationWithLabels(key, m.since(start), labels)
+}
+
+// AddDuration records a timer sample for an already measured duration. The
+// duration is converted using the configured TimerGranularity, the same way
+// MeasureSince does.
+func (m *Metrics) AddDuration(key []string, d time.Duration) {
+	m.AddDurationWithLabels(key, d, nil)
+}
+
+func (m *Metrics) AddDurationWithLabels(key []string, d time.Duration, labels []Label) {
+	key, labels, allowed := m.prepare("timer", key, labels, nil)
+	if !allowed {
+		return
 	}
-	if m.EnableTypePrefix {
-		key = insert(0, "timer", key)
+	m.addDurationSample(key, d, labels)
+}
+
+// addDurationSample converts d using the configured TimerGranularity and adds
+// it to the sink, at float64 precision if the sink supports it.
+func (m *Metrics) addDurationSample(key []string, d time.Duration, labels []Label) {
+	if ps, ok := m.sink.(PrecisionSampleSink); ok {
+		ps.AddPrecisionSampleWithLabels(key, float64(d.Nanoseconds())/float64(m.TimerGranularity), labels)
+		return
 	}
-	if m.ServiceName != "" {
-		if m.EnableServiceLabel {
-			labels = append(labels, Label{"service", m.ServiceName})
-		} else {
-			key = insert(0, m.ServiceName, key)
-		}
+	msec := float32(d.Nanoseconds()) / float32(m.TimerGranularity)
+	m.sink.AddSampleWithLabels(key, msec, labels)
+}
+
+// SetGaugeWithLabelsFunc is like SetGaugeWithLabels, except that labelsFunc is
+// only called if the key passes the configured prefix filters. Use it when the
+// labels are expensive to build.
+func (m *Metrics) SetGaugeWithLabelsFunc(key []string, val float32, labelsFunc func() []Label) {
+	key, labels, allowed := m.prepare("gauge", key, nil, labelsFunc)
+	if !allowed {
+		return
+	}
+	m.sink.SetGaugeWithLabels(key, val, labels)
+}
+
+// IncrCounterWithLabelsFunc is like IncrCounterWithLabels, except that
+// labelsFunc is only called if the key passes the configured prefix filters.
+func (m *Metrics) IncrCounterWithLabelsFunc(key []string, val float32, labelsFunc func() []Label) {
+	key, labels, allowed := m.prepare("counter", key, nil, labelsFunc)
+	if !allowed {
+		return
+	}
+	m.sink.IncrCounterWithLabels(key, val, labels)
+}
+
+// AddSampleWithLabelsFunc is like AddSampleWithLabels, except that labelsFunc
+// is only called if the key passes the configured prefix filters.
+func (m *Metrics) AddSampleWithLabelsFunc(key []string, val float32, labelsFunc func() []Label) {
+	key, labels, allowed := m.prepare("sample", key, nil, labelsFunc)
+	if !allowed {
+		return
 	}
-	allowed, labelsFiltered := m.allowMetric(key, labels)
+	m.sink.AddSampleWithLabels(key, val, labels)
+}
+
+// MeasureSinceWithLabelsFunc is like MeasureSinceWithLabels, except that
+// labelsFunc is only called if the key passes the configured prefix filters.
+func (m *Metrics) MeasureSinceWithLabelsFunc(key []string, start time.Time, labelsFunc func() []Label) {
+	m.AddDurationWithLabelsFunc(key, m.since(start), labelsFunc)
+}
+
+// AddDurationWithLabelsFunc is like AddDurationWithLabels, except that
+// labelsFunc is only called if the key passes the configured prefix filters.
+func (m *Metrics) AddDurationWithLabelsFunc(key []string, d time.Duration, labelsFunc func() []Label) {
+	key, labels, allowed := m.prepare("timer", key, nil, labelsFunc)
 	if !allowed {
 		return
 	}
-	now := time.Now()
-	elapsed := now.Sub(start)
-	msec := float32(elapsed.Nanoseconds()) / float32(m.TimerGranularity)
-	m.sink.AddSampleWithLabels(key, msec, labelsFiltered)
+	m.addDurationSample(key, d, labels)
+}
+
+// prepare applies the host, type and service prefixes and labels configured
+// for metrics of typ, and returns whether the key passes the prefix filters.
+// The hostname only prefixes the keys of gauges. If labelsFunc is set, it
+// builds the labels instead, and is only called if the key is allowed. The
+// filters are read once, without locking.
+func (m *Metrics) prepare(typ string, key []string, labels []Label, labelsFunc func() []Label) ([]string, []Label, bool) {
+	if typ == "gauge" && m.HostName != "" && !m.EnableHostnameLabel && m.EnableHostname {
+		key = insert(0, m.HostName, key)
+	}
+	if m.EnableTypePrefix {
+		key = insert(0, typ, key)
+	}
+	if m.ServiceName != "" && !m.EnableServiceLabel {
+		key = insert(0, m.ServiceName, key)
+	}
+
+	f := m.loadFilters()
+	if !m.keyIsAllowed(f, key) {
+		return nil, nil, false
+	}
+
+	if labelsFunc != nil {
+		labels = labelsFunc()
+	}
+	if m.HostName != "" && m.EnableHostnameLabel {
+		labels = append(labels, Label{"host", m.HostName})
+	}
+	if m.ServiceName != "" && m.EnableServiceLabel {
+		labels = append(labels, Label{"service", m.ServiceName})
+	}
+	return key, filterLabels(labels, f.allowedLabels, f.blockedLabels), true
 }
 
 // UpdateFilter overwrites the existing filter with the given rules.
@@ -140,6 +200,8 @@ func (m *Metrics) UpdateFilter(allow, block []string) {
 }
 
 // UpdateFilterAndLabels overwrites the existing filter with the given rules.
+// The new filters are compiled before they replace the existing ones, so
+// emitting metrics doesn't wait for the update.
 func (m *Metrics) UpdateFilterAndLabels(allow, block, allowedLabels, blockedLabels []string) {
 	m.filterLock.Lock()
 	defer m.filterLock.Unlock()
@@ -147,50 +209,72 @@ func (m *Metrics) UpdateFilterAndLabels(allow, block, allowedLabels, blockedLabe
 	m.AllowedPrefixes = allow
 	m.BlockedPrefixes = block
 
-	if allowedLabels == nil {
+	f := &metricFilters{}
+	if allowedLabels != nil {
 		// Having a white list means we take only elements from it
-		m.allowedLabels = nil
-	} else {
-		m.allowedLabels = make(map[string]bool)
+		f.allowedLabels = make(map[string]bool)
 		for _, v := range allowedLabels {
-			m.allowedLabels[v] = true
+			f.allowedLabels[v] = true
 		}
 	}
-	m.blockedLabels = make(map[string]bool)
+	f.blockedLabels = make(map[string]bool)
 	for _, v := range blockedLabels {
-		m.blockedLabels[v] = true
+		f.blockedLabels[v] = true
 	}
 	m.AllowedLabels = allowedLabels
 	m.BlockedLabels = blockedLabels
 
-	m.filter = iradix.New()
+	f.tree = iradix.New()
 	for _, prefix := range m.AllowedPrefixes {
-		m.filter, _, _ = m.filter.Insert([]byte(prefix), true)
+		f.tree, _, _ = f.tree.Insert([]byte(prefix), true)
 	}
 	for _, prefix := range m.BlockedPrefixes {
-		m.filter, _, _ = m.filter.Insert([]byte(prefix), false)
+		f.tree, _, _ = f.tree.Insert([]byte(prefix), false)
+	}
+	m.filters.Store(f)
+}
+
+// metricFilters are the compiled prefix and label filters of Metrics. They
+// are never modified once stored, but replaced by UpdateFilterAndLabels.
+type metricFilters struct {
+	tree          *iradix.Tree
+	allowedLabels map[string]bool
+	blockedLabels map[string]bool
+}
+
+// noFilters are the filters of Metrics never configured by
+// UpdateFilterAndLabels
+var noFilters = &metricFilters{}
+
+// loadFilters returns the current filters
+func (m *Metrics) loadFilters() *metricFilters {
+	if f, ok := m.filters.Load().(*metricFilters); ok {
+		return f
 	}
+	return noFilters
 }
 
+// Shutdown stops the pollers started with Poll, then shuts the sink down if
+// it supports it.
 func (m *Metrics) Shutdown() {
+	m.stopPollers()
 	if ss, ok := m.sink.(ShutdownSink); ok {
 		ss.Shutdown()
 	}
 }
 
 // labelIsAllowed return true if a should be included in metric
-// the caller should lock m.filterLock while calling this method
-func (m *Metrics) labelIsAllowed(label *Label) bool {
+func labelIsAllowed(label *Label, allowedLabels, blockedLabels map[string]bool) bool {
 	labelName := (*label).Name
-	if m.blockedLabels != nil {
-		_, ok := m.blockedLabels[labelName]
+	if blockedLabels != nil {
+		_, ok := blockedLabels[labelName]
 		if ok {
 			// If present, let's remove this label
 			return false
 		}
 	}
-	if m.allowedLabels != nil {
-		_, ok := m.allowedLabels[labelName]
+	if allowedLabels != nil {
+		_, ok := allowedLabels[labelName]
 		return ok
 	}
 	// Allow by default
@@ -198,14 +282,13 @@ func (m *Metrics) labelIsAllowed(label *Label) bool {
 }
 
 // filterLabels return only allowed labels
-// the caller should lock m.filterLock while calling this method
-func (m *Metrics) filterLabels(labels []Label) []Label {
+func filterLabels(labels []Label, allowedLabels, blockedLabels map[string]bool) []Label {
 	if labels == nil {
 		return nil
 	}
 	toReturn := []Label{}
 	for _, label := range labels {
-		if m.labelIsAllowed(&label) {
+		if labelIsAllowed(&label, allowedLabels, blockedLabels) {
 			toReturn = append(toReturn, label)
 		}
 	}
@@ -215,30 +298,92 @@ func (m *Metrics) filterLabels(labels []Label) []Label {
 // Returns whether the metric should be allowed based on configured prefix filters
 // Also return the applicable labels
 func (m *Metrics) allowMetric(key []string, labels []Label) (bool, []Label) {
-	m.filterLock.RLock()
-	defer m.filterLock.RUnlock()
+	f := m.loadFilters()
+	return m.keyIsAllowed(f, key), filterLabels(labels, f.allowedLabels, f.blockedLabels)
+}
 
-	if m.filter == nil || m.filter.Len() == 0 {
-		return m.Config.FilterDefault, m.filterLabels(labels)
+// keyIsAllowed checks the key against the prefix filters of f
+func (m *Metrics) keyIsAllowed(f *metricFilters, key []string) bool {
+	if f.tree == nil || f.tree.Len() == 0 {
+		return m.Config.FilterDefault
 	}
 
-	_, allowed, ok := m.filter.Root().LongestPrefix([]byte(strings.Join(key, ".")))
+	_, allowed, ok := f.tree.Root().LongestPrefix([]byte(strings.Join(key, ".")))
 	if !ok {
-		return m.Config.FilterDefault, m.filterLabels(labels)
+		return m.Config.FilterDefault
 	}
 
-	return allowed.(bool), m.filterLabels(labels)
+	return allowed.(bool)
+}
+
+// clock returns the configured Clock
+func (m *Metrics) clock() Clock {
+	return clockOrSystem(m.Clock)
+}
+
+// Now returns the time of Config.Clock, to pass as the start of MeasureSince.
+func (m *Metrics) Now() time.Time {
+	return m.clock().Now()
+}
+
+// since returns the time elapsed since start. Times of time.Now carry a
+// monotonic clock reading, and are measured with time.Since even if a Clock
+// is configured, as subtracting them from the time of another clock is
+// meaningless. Times without one, such as those of a fake Clock, are measured
+// with the configured Clock.
+func (m *Metrics) since(start time.Time) time.Duration {
+	if start != start.Round(0) {
+		return time.Since(start)
+	}
+	return m.clock().Now().Sub(start)
 }
 
 // Periodically collects runtime stats to publish
 func (m *Metrics) collectStats() {
 	for {
-		time.Sleep(m.ProfileInterval)
-		m.EmitRuntimeStats()
+		<-m.clock().After(m.ProfileInterval)
+		if m.EnableRuntimeMetrics {
+			m.EmitRuntimeStats()
+		}
+		if m.EnableProcessMetrics {
+			m.EmitProcessStats()
+		}
+		if m.EnableBuildInfo {
+			m.EmitBuildInfo()
+		}
 	}
 }
 
-// Emits various runtime statsitics
+// Emits various runtime statsitics, and those of the runtime/metrics package
+// and the process enabled in the Config:
+//
+//	EnableRuntimeSchedulerMetrics:
+//	    runtime.sched_latency_p50_ns, _p90_ns, _p99_ns - gauges of the
+//	    quantiles of the time goroutines waited to run
+//	EnableRuntimeGCMetrics:
+//	    runtime.gc_cpu_fraction - gauge of the fraction of CPU time spent on
+//	    GC
+//	    runtime.gc_heap_goal_bytes - gauge of the heap size the GC aims for
+//	    runtime.gc_heap_live_bytes - gauge of the heap marked live by the
+//	    last GC
+//	    runtime.gc_gogc_percent - gauge of GOGC, as set by the environment
+//	    or debug.SetGCPercent, or 0 if the GC is off
+//	    runtime.gc_memory_limit_bytes - gauge of GOMEMLIMIT, as set by the
+//	    environment or debug.SetMemoryLimit
+//	EnableRuntimeMemoryClasses:
+//	    runtime.memory.<class>_bytes - gauges of the memory mapped by the
+//	    runtime by class, e.g. runtime.memory.heap.objects_bytes
+//	EnableRuntimeThreadMetrics:
+//	    runtime.num_threads - gauge of the OS threads created, which the
+//	    runtime rarely exits
+//	EnableRuntimeFDMetrics, on platforms other than Windows:
+//	    runtime.open_fds - gauge of the open file descriptors
+//	    runtime.max_fds - gauge of the limit of open file descriptors
+//	    runtime.fd_headroom - gauge of the file descriptors left to the
+//	    limit
+//
+// The quantiles and the fraction are those of the time since the previous
+// call, or since the start of the process for the first one.
 func (m *Metrics) EmitRuntimeStats() {
 	// Export number of Goroutines
 	numRoutines := runtime.NumGoroutine()
@@ -273,6 +418,24 @@ func (m *Metrics) EmitRuntimeStats() {
 		m.AddSample([]string{"runtime", "gc_pause_ns"}, float32(pause))
 	}
 	m.lastNumGC = num
+
+	m.emitRuntimeMetrics()
+
+	if m.EnableRuntimeThreadMetrics {
+		m.SetGauge([]string{"runtime", "num_threads"}, float32(pprof.Lookup("threadcreate").Count()))
+	}
+	if m.EnableRuntimeFDMetrics {
+		if open, max, ok := fileDescriptors(); ok {
+			m.SetGauge([]string{"runtime", "open_fds"}, float32(open))
+			m.SetGauge([]string{"runtime", "max_fds"}, float32(max))
+			// The limit may have been lowered below the open descriptors
+			var headroom uint64
+			if max > open {
+				headroom = max - open
+			}
+			m.SetGauge([]string{"runtime", "fd_headroom"}, float32(headroom))
+		}
+	}
 }
 
 // Creates a new slice with the provided string value as the first element
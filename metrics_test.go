@@ -1,8 +1,12 @@
 package metrics
 
 import (
+	"math"
+	"os"
 	"reflect"
 	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -236,6 +240,205 @@ func TestMetrics_MeasureSince(t *testing.T) {
 	}
 }
 
+func TestMetrics_AddDuration(t *testing.T) {
+	m, met := mockMetric()
+	met.TimerGranularity = time.Millisecond
+	met.AddDuration([]string{"key"}, 1500*time.Microsecond)
+	if m.getKeys()[0][0] != "key" {
+		t.Fatalf("")
+	}
+	if m.vals[0] != 1.5 {
+		t.Fatalf("bad val: %v", m.vals[0])
+	}
+
+	m, met = mockMetric()
+	met.TimerGranularity = time.Second
+	labels := []Label{{"a", "b"}}
+	met.AddDurationWithLabels([]string{"key"}, 2*time.Second, labels)
+	if m.getKeys()[0][0] != "key" {
+		t.Fatalf("")
+	}
+	if m.vals[0] != 2 {
+		t.Fatalf("bad val: %v", m.vals[0])
+	}
+	if !reflect.DeepEqual(m.labels[0], labels) {
+		t.Fatalf("")
+	}
+
+	m, met = mockMetric()
+	met.TimerGranularity = time.Millisecond
+	met.EnableTypePrefix = true
+	met.AddDuration([]string{"key"}, time.Millisecond)
+	if m.getKeys()[0][0] != "timer" || m.getKeys()[0][1] != "key" {
+		t.Fatalf("")
+	}
+
+	m, met = mockMetric()
+	met.TimerGranularity = time.Millisecond
+	met.ServiceName = "service"
+	met.AddDuration([]string{"key"}, time.Millisecond)
+	if m.getKeys()[0][0] != "service" || m.getKeys()[0][1] != "key" {
+		t.Fatalf("")
+	}
+}
+
+type precisionMockSink struct {
+	MockSink
+	precisionVals []float64
+}
+
+func (m *precisionMockSink) AddPrecisionSample(key []string, val float64) {
+	m.AddPrecisionSampleWithLabels(key, val, nil)
+}
+func (m *precisionMockSink) AddPrecisionSampleWithLabels(key []string, val float64, labels []Label) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.keys = append(m.keys, key)
+	m.precisionVals = append(m.precisionVals, val)
+	m.labels = append(m.labels, labels)
+}
+
+func TestMetrics_AddDurationPrecision(t *testing.T) {
+	m := &precisionMockSink{}
+	met := &Metrics{Config: Config{FilterDefault: true}, sink: m}
+	met.TimerGranularity = time.Millisecond
+
+	met.AddDuration([]string{"key"}, 1234567*time.Nanosecond)
+	if len(m.vals) != 0 {
+		t.Fatalf("float32 sample used: %v", m.vals)
+	}
+	if m.precisionVals[0] != 1.234567 {
+		t.Fatalf("bad val: %v", m.precisionVals[0])
+	}
+
+	met.TimerGranularity = time.Nanosecond
+	met.MeasureSince([]string{"key"}, time.Now().Add(-time.Second))
+	if m.precisionVals[1] < 1e9 {
+		t.Fatalf("bad val: %v", m.precisionVals[1])
+	}
+}
+
+type exemplarMockSink struct {
+	MockSink
+	exemplars [][]Label
+}
+
+func (m *exemplarMockSink) IncrCounterWithExemplar(key []string, val float32, labels []Label, exemplar []Label) {
+	m.IncrCounterWithLabels(key, val, labels)
+	m.exemplars = append(m.exemplars, exemplar)
+}
+func (m *exemplarMockSink) AddSampleWithExemplar(key []string, val float32, labels []Label, exemplar []Label) {
+	m.AddSampleWithLabels(key, val, labels)
+	m.exemplars = append(m.exemplars, exemplar)
+}
+
+func TestMetrics_Exemplar(t *testing.T) {
+	exemplar := []Label{{"trace_id", "abc"}}
+	m := &exemplarMockSink{}
+	plain := &MockSink{}
+	met := &Metrics{Config: Config{FilterDefault: true, ServiceName: "service"}, sink: FanoutSink{m, plain}}
+
+	met.IncrCounterWithExemplar([]string{"counter"}, 1, nil, exemplar)
+	met.AddSampleWithExemplar([]string{"sample"}, 2, []Label{{"a", "b"}}, exemplar)
+	met.IncrCounter([]string{"counter"}, 1)
+
+	if len(m.exemplars) != 2 || !reflect.DeepEqual(m.exemplars[1], exemplar) {
+		t.Fatalf("bad exemplars: %v", m.exemplars)
+	}
+	if !reflect.DeepEqual(m.keys[1], []string{"service", "sample"}) || !reflect.DeepEqual(m.labels[1], []Label{{"a", "b"}}) {
+		t.Fatalf("bad: %v %v", m.keys, m.labels)
+	}
+	if len(m.vals) != 3 {
+		t.Fatalf("bad: %v", m.vals)
+	}
+	// Sinks without exemplar support get the metrics alone
+	if len(plain.vals) != 3 || plain.vals[1] != 2 {
+		t.Fatalf("bad: %v", plain.vals)
+	}
+}
+
+func TestMetrics_WithLabelsFunc(t *testing.T) {
+	labels := []Label{{"a", "b"}}
+	emitters := map[string]func(met *Metrics, key []string, fn func() []Label){
+		"SetGauge": func(met *Metrics, key []string, fn func() []Label) {
+			met.SetGaugeWithLabelsFunc(key, 1, fn)
+		},
+		"IncrCounter": func(met *Metrics, key []string, fn func() []Label) {
+			met.IncrCounterWithLabelsFunc(key, 1, fn)
+		},
+		"AddSample": func(met *Metrics, key []string, fn func() []Label) {
+			met.AddSampleWithLabelsFunc(key, 1, fn)
+		},
+		"MeasureSince": func(met *Metrics, key []string, fn func() []Label) {
+			met.MeasureSinceWithLabelsFunc(key, time.Now(), fn)
+		},
+		"AddDuration": func(met *Metrics, key []string, fn func() []Label) {
+			met.AddDurationWithLabelsFunc(key, time.Millisecond, fn)
+		},
+	}
+
+	for name, emit := range emitters {
+		t.Run(name, func(t *testing.T) {
+			m, met := mockMetric()
+			met.TimerGranularity = time.Millisecond
+			met.UpdateFilter(nil, []string{"blocked"})
+
+			calls := 0
+			fn := func() []Label {
+				calls++
+				return labels
+			}
+
+			emit(met, []string{"blocked", "key"}, fn)
+			if calls != 0 {
+				t.Fatalf("labels func should not be called for a blocked key")
+			}
+			if len(m.getKeys()) != 0 {
+				t.Fatalf("blocked key should not be emitted: %v", m.getKeys())
+			}
+
+			emit(met, []string{"key"}, fn)
+			if calls != 1 {
+				t.Fatalf("labels func should be called once, got %d", calls)
+			}
+			if m.getKeys()[0][0] != "key" {
+				t.Fatalf("bad key %v", m.getKeys())
+			}
+			if !reflect.DeepEqual(m.labels[0], labels) {
+				t.Fatalf("bad labels %v", m.labels[0])
+			}
+		})
+	}
+
+	m, met := mockMetric()
+	met.HostName = "host1"
+	met.ServiceName = "service"
+	met.EnableHostnameLabel = true
+	met.EnableServiceLabel = true
+	met.BlockedLabels = []string{"secret"}
+	met.UpdateFilterAndLabels(nil, nil, nil, []string{"secret"})
+	met.IncrCounterWithLabelsFunc([]string{"key"}, 1, func() []Label {
+		return []Label{{"a", "b"}, {"secret", "x"}}
+	})
+	expected := []Label{{"a", "b"}, {"host", "host1"}, {"service", "service"}}
+	if !reflect.DeepEqual(m.labels[0], expected) {
+		t.Fatalf("got labels %v want %v", m.labels[0], expected)
+	}
+	if !reflect.DeepEqual(m.getKeys()[0], []string{"key"}) {
+		t.Fatalf("bad key %v", m.getKeys()[0])
+	}
+
+	m, met = mockMetric()
+	met.HostName = "host1"
+	met.ServiceName = "service"
+	met.EnableHostname = true
+	met.SetGaugeWithLabelsFunc([]string{"key"}, 1, nil)
+	if !reflect.DeepEqual(m.getKeys()[0], []string{"service", "host1", "key"}) {
+		t.Fatalf("bad key %v", m.getKeys()[0])
+	}
+}
+
 func TestMetrics_EmitRuntimeStats(t *testing.T) {
 	runtime.GC()
 	m, met := mockMetric()
@@ -517,3 +720,243 @@ func TestMetrics_Filter_Labels_ModifyArgs(t *testing.T) {
 		t.Fatalf("SetGaugeWithLabels modified the input argument")
 	}
 }
+
+func TestMetrics_EmitRuntimeMetrics(t *testing.T) {
+	inm := NewInmemSink(time.Minute, time.Minute)
+	met := &Metrics{Config: Config{
+		FilterDefault:                 true,
+		EnableRuntimeSchedulerMetrics: true,
+		EnableRuntimeGCMetrics:        true,
+		EnableRuntimeMemoryClasses:    true,
+	}, sink: inm}
+
+	// Make goroutines wait to run, and the GC use some CPU
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runtime.Gosched()
+		}()
+	}
+	wg.Wait()
+	runtime.GC()
+	met.EmitRuntimeStats()
+
+	gauges := inm.Data()[0].Gauges
+	for _, key := range []string{
+		"runtime.sched_latency_p50_ns",
+		"runtime.sched_latency_p99_ns",
+		"runtime.gc_cpu_fraction",
+		"runtime.gc_heap_goal_bytes",
+		"runtime.gc_heap_live_bytes",
+		"runtime.gc_memory_limit_bytes",
+		"runtime.memory.heap.objects_bytes",
+		"runtime.memory.total_bytes",
+	} {
+		if _, ok := gauges[key]; !ok {
+			t.Fatalf("missing %s: %v", key, gauges)
+		}
+	}
+	// GOGC is 100 unless set in the environment
+	if g, ok := gauges["runtime.gc_gogc_percent"]; !ok || (os.Getenv("GOGC") == "" && g.Value != 100) {
+		t.Fatalf("bad gc_gogc_percent: %v", g)
+	}
+	if g := gauges["runtime.gc_cpu_fraction"]; g.Value < 0 || g.Value > 1 {
+		t.Fatalf("bad gc_cpu_fraction: %v", g)
+	}
+	if p50, p99 := gauges["runtime.sched_latency_p50_ns"], gauges["runtime.sched_latency_p99_ns"]; p50.Value > p99.Value {
+		t.Fatalf("bad latencies: %v %v", p50, p99)
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	buckets := []float64{math.Inf(-1), 1, 2, 4, math.Inf(1)}
+	counts := []uint64{0, 5, 4, 1}
+	for q, expect := range map[float64]float64{0.5: 2, 0.9: 4, 0.99: 4, 1: 4} {
+		if v := histogramQuantile(counts, buckets, 10, q); v != expect {
+			t.Fatalf("bad quantile %v: %v", q, v)
+		}
+	}
+	if v := histogramQuantile([]uint64{0, 0, 0, 1}, buckets, 1, 0.5); v != 4 {
+		t.Fatalf("bad unbounded quantile: %v", v)
+	}
+}
+
+func TestMetrics_EmitRuntimeThreadsAndFDs(t *testing.T) {
+	inm := NewInmemSink(time.Minute, time.Minute)
+	met := &Metrics{Config: Config{
+		FilterDefault:              true,
+		EnableRuntimeThreadMetrics: true,
+		EnableRuntimeFDMetrics:     true,
+	}, sink: inm}
+	met.EmitRuntimeStats()
+
+	gauges := inm.Data()[0].Gauges
+	if g := gauges["runtime.num_threads"]; g.Value < 1 {
+		t.Fatalf("bad num_threads: %v", gauges)
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+	open, max := gauges["runtime.open_fds"], gauges["runtime.max_fds"]
+	if open.Value < 3 || max.Value < open.Value {
+		t.Fatalf("bad fds: %v", gauges)
+	}
+	if g := gauges["runtime.fd_headroom"]; g.Value != max.Value-open.Value {
+		t.Fatalf("bad fd_headroom: %v", gauges)
+	}
+}
+
+func TestMetrics_EmitProcessStats(t *testing.T) {
+	inm := NewInmemSink(time.Minute, time.Minute)
+	met := &Metrics{Config: Config{FilterDefault: true}, sink: inm}
+	met.EmitProcessStats()
+
+	gauges := inm.Data()[0].Gauges
+	if runtime.GOOS != "linux" {
+		if _, ok := gauges["process.cpu_seconds"]; !ok {
+			t.Fatalf("missing cpu_seconds: %v", gauges)
+		}
+		return
+	}
+	for _, key := range []string{
+		"process.cpu_seconds",
+		"process.voluntary_context_switches",
+		"process.involuntary_context_switches",
+	} {
+		if _, ok := gauges[key]; !ok {
+			t.Fatalf("missing %s: %v", key, gauges)
+		}
+	}
+	if g := gauges["process.resident_memory_bytes"]; g.Value < 1<<20 {
+		t.Fatalf("bad resident_memory_bytes: %v", g)
+	}
+	if g := gauges["process.virtual_memory_bytes"]; g.Value < gauges["process.resident_memory_bytes"].Value {
+		t.Fatalf("bad virtual_memory_bytes: %v", g)
+	}
+	// Gauges are float32, which hold times to about two minutes
+	start := time.Unix(int64(gauges["process.start_time_seconds"].Value), 0)
+	if d := time.Since(start); d < -2*time.Minute || d > time.Hour {
+		t.Fatalf("bad start_time_seconds: %v", start)
+	}
+}
+
+func TestMetrics_EmitBuildInfo(t *testing.T) {
+	inm := NewInmemSink(time.Minute, time.Minute)
+	met := &Metrics{Config: Config{FilterDefault: true}, sink: inm}
+	met.EmitBuildInfo()
+
+	for key, g := range inm.Data()[0].Gauges {
+		if !strings.HasPrefix(key, "build.info;") || g.Value != 1 {
+			t.Fatalf("bad gauge %s: %v", key, g)
+		}
+		if !strings.Contains(key, ";go_version="+runtime.Version()) {
+			t.Fatalf("bad go_version: %s", key)
+		}
+		return
+	}
+	t.Fatalf("missing build.info")
+}
+
+// BenchmarkMetrics_FilteredParallel emits through prefix and label filters
+// from all CPUs, e.g.
+//
+//	go test -run none -bench FilteredParallel -cpu 1,8,64
+func BenchmarkMetrics_FilteredParallel(b *testing.B) {
+	conf := DefaultConfig("")
+	conf.EnableHostname = false
+	conf.EnableRuntimeMetrics = false
+	conf.AllowedPrefixes = []string{"api"}
+	conf.BlockedPrefixes = []string{"api.debug"}
+	conf.BlockedLabels = []string{"peer"}
+	m, err := New(conf, &BlackholeSink{})
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+	key := []string{"api", "requests"}
+	labels := []Label{{"method", "GET"}, {"peer", "10.0.0.1"}}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			m.IncrCounterWithLabels(key, 1, labels)
+		}
+	})
+}
+
+// BenchmarkMetrics_prepare compares filtering in prepare, which reads the
+// filters from an atomic.Value, with reading them under the RWMutex that
+// used to guard them, e.g.
+//
+//	go test -run none -bench Metrics_prepare -cpu 1,8,64
+func BenchmarkMetrics_prepare(b *testing.B) {
+	conf := DefaultConfig("")
+	conf.EnableHostname = false
+	conf.EnableRuntimeMetrics = false
+	conf.AllowedPrefixes = []string{"api"}
+	conf.BlockedPrefixes = []string{"api.debug"}
+	conf.BlockedLabels = []string{"peer"}
+	m, err := New(conf, &BlackholeSink{})
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+	key := []string{"api", "requests"}
+	labels := []Label{{"method", "GET"}, {"peer", "10.0.0.1"}}
+
+	b.Run("atomic", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				f := m.loadFilters()
+				if m.keyIsAllowed(f, key) {
+					filterLabels(labels, f.allowedLabels, f.blockedLabels)
+				}
+			}
+		})
+	})
+	b.Run("rwmutex", func(b *testing.B) {
+		var lock sync.RWMutex
+		f := m.loadFilters()
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				lock.RLock()
+				allowed := m.keyIsAllowed(f, key)
+				allowedLabels, blockedLabels := f.allowedLabels, f.blockedLabels
+				lock.RUnlock()
+				if allowed {
+					filterLabels(labels, allowedLabels, blockedLabels)
+				}
+			}
+		})
+	})
+}
+
+func TestMetrics_UpdateFilterConcurrent(t *testing.T) {
+	m, met := mockMetric()
+	met.UpdateFilter([]string{"allowed"}, nil)
+	met.FilterDefault = false
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			met.UpdateFilterAndLabels([]string{"allowed"}, []string{"allowed.blocked"}, nil, []string{"b"})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		met.IncrCounterWithLabels([]string{"allowed", "counter"}, 1, []Label{{"a", "1"}})
+		met.IncrCounter([]string{"other"}, 1)
+	}
+	wg.Wait()
+
+	for _, key := range m.getKeys() {
+		if key[0] != "allowed" {
+			t.Fatalf("filtered key emitted: %v", key)
+		}
+	}
+	if n := len(m.getKeys()); n != 100 {
+		t.Fatalf("bad keys: %d", n)
+	}
+}
@@ -0,0 +1,106 @@
+// Package metricsexpvar republishes the variables of the expvar package as
+// metrics, so libraries instrumented with expvar feed the same sinks.
+package metricsexpvar
+
+import (
+	"expvar"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+// Opts configures the metrics emitted by EmitWithOpts
+type Opts struct {
+	// Prefix is prepended to the keys of the metrics. Defaults to expvar.
+	Prefix []string
+
+	// Exclude are the names of the variables not emitted.
+	Exclude []string
+}
+
+// Emit emits the numeric variables published with expvar as gauges:
+//
+//	expvar.<name> - *expvar.Int, *expvar.Float, and *expvar.Func returning
+//	    a number
+//	expvar.<name>.<key> - the numeric entries of an *expvar.Map, recursively
+//
+// Other variables, such as strings and the memstats of the runtime, are
+// skipped; see metrics.Metrics.EmitRuntimeStats for the latter.
+func Emit(m *metrics.Metrics) {
+	EmitWithOpts(m, Opts{})
+}
+
+// EmitWithOpts is Emit configured by opts.
+func EmitWithOpts(m *metrics.Metrics, opts Opts) {
+	emitVars(m, opts)
+}
+
+// Poll emits the variables every interval, see EmitWithOpts and
+// metrics.Metrics.Poll.
+func Poll(m *metrics.Metrics, interval time.Duration, opts Opts) (stop func()) {
+	return m.Poll(interval, func(emit metrics.GaugeEmitter) {
+		emitVars(emit, opts)
+	})
+}
+
+func emitVars(emit metrics.GaugeEmitter, opts Opts) {
+	prefix := opts.Prefix
+	if prefix == nil {
+		prefix = []string{"expvar"}
+	}
+	excluded := make(map[string]bool, len(opts.Exclude))
+	for _, name := range opts.Exclude {
+		excluded[name] = true
+	}
+	expvar.Do(func(kv expvar.KeyValue) {
+		if !excluded[kv.Key] {
+			emitVar(emit, appendKey(prefix, kv.Key), kv.Value)
+		}
+	})
+}
+
+func emitVar(emit metrics.GaugeEmitter, key []string, v expvar.Var) {
+	switch v := v.(type) {
+	case *expvar.Int:
+		emit.SetGauge(key, float32(v.Value()))
+	case *expvar.Float:
+		emit.SetGauge(key, float32(v.Value()))
+	case *expvar.Map:
+		v.Do(func(kv expvar.KeyValue) {
+			emitVar(emit, appendKey(key, kv.Key), kv.Value)
+		})
+	case expvar.Func:
+		if value, ok := number(v.Value()); ok {
+			emit.SetGauge(key, value)
+		}
+	}
+}
+
+// number returns the value of numeric types
+func number(v interface{}) (float32, bool) {
+	switch v := v.(type) {
+	case int:
+		return float32(v), true
+	case int32:
+		return float32(v), true
+	case int64:
+		return float32(v), true
+	case uint:
+		return float32(v), true
+	case uint32:
+		return float32(v), true
+	case uint64:
+		return float32(v), true
+	case float32:
+		return v, true
+	case float64:
+		return float32(v), true
+	default:
+		return 0, false
+	}
+}
+
+// appendKey returns a copy of prefix with name appended
+func appendKey(prefix []string, name string) []string {
+	return append(prefix[:len(prefix):len(prefix)], name)
+}
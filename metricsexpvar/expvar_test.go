@@ -0,0 +1,50 @@
+package metricsexpvar
+
+import (
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+func TestPoll(t *testing.T) {
+	inm := metrics.NewInmemSink(time.Minute, time.Minute)
+	cfg := metrics.DefaultConfig("")
+	cfg.EnableHostname = false
+	cfg.EnableRuntimeMetrics = false
+	m, err := metrics.New(cfg, inm)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	expvar.NewInt("test_requests").Add(3)
+	expvar.NewFloat("test_ratio").Set(0.5)
+	expvar.NewString("test_version").Set("1.0")
+	expvar.Publish("test_uptime", expvar.Func(func() interface{} { return 42 }))
+	hits := expvar.NewMap("test_cache")
+	hits.Add("hits", 5)
+	hits.Set("nested", new(expvar.Map).Init())
+	hits.Get("nested").(*expvar.Map).AddFloat("misses", 2)
+	expvar.NewInt("test_excluded").Set(1)
+
+	Poll(m, time.Hour, Opts{Prefix: []string{"legacy"}, Exclude: []string{"test_excluded"}})()
+
+	gauges := inm.Data()[0].Gauges
+	for key, value := range map[string]float32{
+		"legacy.test_requests":            3,
+		"legacy.test_ratio":               0.5,
+		"legacy.test_uptime":              42,
+		"legacy.test_cache.hits":          5,
+		"legacy.test_cache.nested.misses": 2,
+	} {
+		if g, ok := gauges[key]; !ok || g.Value != value {
+			t.Fatalf("bad %s: %v", key, gauges)
+		}
+	}
+	for _, key := range []string{"legacy.test_version", "legacy.test_excluded", "legacy.memstats", "legacy.cmdline"} {
+		if _, ok := gauges[key]; ok {
+			t.Fatalf("unexpected %s", key)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+module github.com/hashicorp/go-metrics/metricsgokit
+
+go 1.21
+
+require (
+	github.com/go-kit/kit v0.13.0
+	github.com/hashicorp/go-metrics v0.5.4
+)
+
+require (
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/hashicorp/go-immutable-radix v1.3.1 // indirect
+	github.com/hashicorp/golang-lru v0.5.4 // indirect
+	github.com/klauspost/compress v1.17.11 // indirect
+	google.golang.org/protobuf v1.36.1 // indirect
+)
+
+replace github.com/hashicorp/go-metrics => ../
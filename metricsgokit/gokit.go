@@ -0,0 +1,134 @@
+// Package metricsgokit implements the metrics interfaces of go-kit with
+// go-metrics, so services built with go-kit emit to the configured sinks.
+package metricsgokit
+
+import (
+	"strings"
+	"sync"
+
+	kitmetrics "github.com/go-kit/kit/metrics"
+	"github.com/hashicorp/go-metrics"
+)
+
+// Counter is a go-kit Counter emitting a counter. For example:
+//
+//	requests := metricsgokit.NewCounter(metrics.Default(), []string{"api", "requests"})
+//	requests.With("method", "GET").Add(1)
+type Counter struct {
+	m      *metrics.Metrics
+	key    []string
+	labels []metrics.Label
+}
+
+// NewCounter returns a Counter emitting key to m.
+func NewCounter(m *metrics.Metrics, key []string) *Counter {
+	return &Counter{m: m, key: key}
+}
+
+// With returns a Counter adding the labels of labelValues, pairs of label
+// names and values.
+func (c *Counter) With(labelValues ...string) kitmetrics.Counter {
+	return &Counter{m: c.m, key: c.key, labels: withLabels(c.labels, labelValues)}
+}
+
+// Add increments the counter by delta.
+func (c *Counter) Add(delta float64) {
+	c.m.IncrCounterWithLabels(c.key, float32(delta), c.labels)
+}
+
+// Gauge is a go-kit Gauge emitting a gauge. The gauges returned by With share
+// the values added for each set of labels.
+type Gauge struct {
+	m      *metrics.Metrics
+	key    []string
+	labels []metrics.Label
+	values *gaugeValues
+}
+
+// gaugeValues are the values of a gauge by labels
+type gaugeValues struct {
+	lock   sync.Mutex
+	values map[string]float64
+}
+
+// NewGauge returns a Gauge emitting key to m.
+func NewGauge(m *metrics.Metrics, key []string) *Gauge {
+	return &Gauge{
+		m:      m,
+		key:    key,
+		values: &gaugeValues{values: make(map[string]float64)},
+	}
+}
+
+// With returns a Gauge adding the labels of labelValues, pairs of label names
+// and values.
+func (g *Gauge) With(labelValues ...string) kitmetrics.Gauge {
+	return &Gauge{m: g.m, key: g.key, labels: withLabels(g.labels, labelValues), values: g.values}
+}
+
+// Set sets the gauge to value.
+func (g *Gauge) Set(value float64) {
+	g.values.lock.Lock()
+	g.values.values[labelsID(g.labels)] = value
+	g.values.lock.Unlock()
+	g.m.SetGaugeWithLabels(g.key, float32(value), g.labels)
+}
+
+// Add adds delta to the value of the gauge.
+func (g *Gauge) Add(delta float64) {
+	id := labelsID(g.labels)
+	g.values.lock.Lock()
+	value := g.values.values[id] + delta
+	g.values.values[id] = value
+	g.values.lock.Unlock()
+	g.m.SetGaugeWithLabels(g.key, float32(value), g.labels)
+}
+
+// Histogram is a go-kit Histogram emitting a sample.
+type Histogram struct {
+	m      *metrics.Metrics
+	key    []string
+	labels []metrics.Label
+}
+
+// NewHistogram returns a Histogram emitting key to m.
+func NewHistogram(m *metrics.Metrics, key []string) *Histogram {
+	return &Histogram{m: m, key: key}
+}
+
+// With returns a Histogram adding the labels of labelValues, pairs of label
+// names and values.
+func (h *Histogram) With(labelValues ...string) kitmetrics.Histogram {
+	return &Histogram{m: h.m, key: h.key, labels: withLabels(h.labels, labelValues)}
+}
+
+// Observe adds value to the sample.
+func (h *Histogram) Observe(value float64) {
+	h.m.AddSampleWithLabels(h.key, float32(value), h.labels)
+}
+
+// withLabels returns a copy of labels with the pairs of labelValues appended.
+// Like the go-kit implementations, a label missing its value has the value
+// "unknown".
+func withLabels(labels []metrics.Label, labelValues []string) []metrics.Label {
+	if len(labelValues)%2 != 0 {
+		labelValues = append(labelValues, "unknown")
+	}
+	labels = labels[:len(labels):len(labels)]
+	for i := 0; i < len(labelValues); i += 2 {
+		labels = append(labels, metrics.Label{Name: labelValues[i], Value: labelValues[i+1]})
+	}
+	return labels
+}
+
+// labelsID returns a string identifying labels
+func labelsID(labels []metrics.Label) string {
+	var b strings.Builder
+	for _, l := range labels {
+		b.WriteString(l.Name)
+		b.WriteByte(0)
+		b.WriteString(l.Value)
+		b.WriteByte(0)
+	}
+	return b.String()
+}
@@ -0,0 +1,57 @@
+package metricsgokit
+
+import (
+	"testing"
+	"time"
+
+	kitmetrics "github.com/go-kit/kit/metrics"
+	"github.com/hashicorp/go-metrics"
+)
+
+var (
+	_ kitmetrics.Counter   = &Counter{}
+	_ kitmetrics.Gauge     = &Gauge{}
+	_ kitmetrics.Histogram = &Histogram{}
+)
+
+func TestMetrics(t *testing.T) {
+	inm := metrics.NewInmemSink(time.Minute, time.Minute)
+	cfg := metrics.DefaultConfig("")
+	cfg.EnableHostname = false
+	cfg.EnableRuntimeMetrics = false
+	m, err := metrics.New(cfg, inm)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	requests := NewCounter(m, []string{"api", "requests"})
+	requests.With("method", "GET").Add(1)
+	requests.With("method", "GET").Add(2)
+	requests.With("method").Add(1)
+	inFlight := NewGauge(m, []string{"api", "in_flight"})
+	inFlight.With("method", "GET").Add(3)
+	inFlight.With("method", "GET").Add(-1)
+	inFlight.Set(5)
+	duration := NewHistogram(m, []string{"api", "duration"})
+	duration.With("method", "GET").Observe(10)
+	duration.With("method", "GET").Observe(20)
+
+	data := inm.Data()[0]
+	if c, ok := data.Counters["api.requests;method=GET"]; !ok || c.Count != 2 || c.Sum != 3 {
+		t.Fatalf("bad counters: %v", data.Counters)
+	}
+	if _, ok := data.Counters["api.requests;method=unknown"]; !ok {
+		t.Fatalf("bad counters: %v", data.Counters)
+	}
+	for key, value := range map[string]float32{
+		"api.in_flight;method=GET": 2,
+		"api.in_flight":            5,
+	} {
+		if g, ok := data.Gauges[key]; !ok || g.Value != value {
+			t.Fatalf("bad %s: %v", key, data.Gauges)
+		}
+	}
+	if s, ok := data.Samples["api.duration;method=GET"]; !ok || s.Count != 2 || s.Sum != 30 {
+		t.Fatalf("bad samples: %v", data.Samples)
+	}
+}
@@ -0,0 +1,92 @@
+package metricsgrpc
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+)
+
+var (
+	clientRequestsKey = []string{"grpc", "client", "requests"}
+	clientDurationKey = []string{"grpc", "client", "request_duration"}
+	clientInFlightKey = []string{"grpc", "client", "in_flight"}
+)
+
+// clientInFlight maps the *metrics.Metrics of client interceptors to the
+// *int64 of the unary and streaming RPCs in flight, as both emit the same
+// gauge
+var clientInFlight sync.Map
+
+// clientInFlightCounter returns the counter of the RPCs in flight of m
+func clientInFlightCounter(m *metrics.Metrics) *int64 {
+	v, _ := clientInFlight.LoadOrStore(m, new(int64))
+	return v.(*int64)
+}
+
+// UnaryClientInterceptor returns an interceptor emitting the metrics of the
+// unary RPCs sent to m:
+//
+//	grpc.client.requests - counter of RPCs
+//	grpc.client.request_duration - sample of the time taken by RPCs
+//	grpc.client.in_flight - gauge of the RPCs waiting for a response, both
+//	    unary and streaming ones, see StreamClientInterceptor
+//
+// The counter and sample are labeled with the service, the method, the
+// status code of the RPC and the address of the peer it was sent to, or
+// "unknown" if it wasn't sent.
+func UnaryClientInterceptor(m *metrics.Metrics) grpc.UnaryClientInterceptor {
+	inFlight := clientInFlightCounter(m)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		m.SetGauge(clientInFlightKey, float32(atomic.AddInt64(inFlight, 1)))
+		var p peer.Peer
+		err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Peer(&p))...)
+		m.SetGauge(clientInFlightKey, float32(atomic.AddInt64(inFlight, -1)))
+		observe(m, clientRequestsKey, clientDurationKey, method, start, err, peerLabel(&p))
+		return err
+	}
+}
+
+// StreamClientInterceptor is UnaryClientInterceptor for streaming RPCs. A
+// streaming RPC is in flight, and its duration lasts, until the stream ends
+// or its context is canceled.
+func StreamClientInterceptor(m *metrics.Metrics) grpc.StreamClientInterceptor {
+	inFlight := clientInFlightCounter(m)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		start := time.Now()
+		m.SetGauge(clientInFlightKey, float32(atomic.AddInt64(inFlight, 1)))
+		// The peer is known once the stream is created. grpc.Peer can't be
+		// used, as it is only filled in after the OnFinish callbacks ran.
+		var p atomic.Pointer[peer.Peer]
+		var once sync.Once
+		done := func(err error) {
+			once.Do(func() {
+				m.SetGauge(clientInFlightKey, float32(atomic.AddInt64(inFlight, -1)))
+				observe(m, clientRequestsKey, clientDurationKey, method, start, err, peerLabel(p.Load()))
+			})
+		}
+
+		cs, err := streamer(ctx, desc, cc, method, append(opts, grpc.OnFinish(done))...)
+		if err != nil {
+			done(err)
+			return cs, err
+		}
+		if pr, ok := peer.FromContext(cs.Context()); ok {
+			p.Store(pr)
+		}
+		return cs, nil
+	}
+}
+
+// peerLabel returns the label of the address of the peer an RPC was sent to
+func peerLabel(p *peer.Peer) metrics.Label {
+	if p == nil || p.Addr == nil {
+		return metrics.Label{Name: "peer", Value: "unknown"}
+	}
+	return metrics.Label{Name: "peer", Value: p.Addr.String()}
+}
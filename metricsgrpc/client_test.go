@@ -0,0 +1,109 @@
+package metricsgrpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestClientInterceptors(t *testing.T) {
+	m, inm := newTestMetrics(t)
+	conn := serveHealth(t, nil,
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor(m)),
+		grpc.WithStreamInterceptor(StreamClientInterceptor(m)))
+	client := healthpb.NewHealthClient(conn)
+
+	ctx := context.Background()
+	client.Check(ctx, &healthpb.HealthCheckRequest{Service: "up"})
+	client.Check(ctx, &healthpb.HealthCheckRequest{Service: "missing"})
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	stream, err := client.Watch(watchCtx, &healthpb.HealthCheckRequest{Service: "up"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	stream.Recv()
+	if g := inm.Data()[0].Gauges["grpc.client.in_flight"]; g.Value != 1 {
+		t.Fatalf("bad in_flight: %v", g)
+	}
+	cancel()
+
+	key := "grpc.client.requests;service=grpc.health.v1.Health;method="
+	expect := map[string]int{
+		key + "Check;code=OK;peer=bufconn":       1,
+		key + "Check;code=NotFound;peer=bufconn": 1,
+		key + "Watch;code=Canceled;peer=bufconn": 1,
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		counters := inm.Data()[0].Counters
+		ok := len(counters) == len(expect)
+		for k, count := range expect {
+			c, found := counters[k]
+			ok = ok && found && c.Count == count
+		}
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("bad counters: %v", counters)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if g := inm.Data()[0].Gauges["grpc.client.in_flight"]; g.Value != 0 {
+		t.Fatalf("bad in_flight: %v", g)
+	}
+}
+
+// fakeClientStream is a client stream of which only the context is known
+type fakeClientStream struct {
+	grpc.ClientStream
+}
+
+func (fakeClientStream) Context() context.Context {
+	return context.Background()
+}
+
+func TestClientInterceptors_InFlight(t *testing.T) {
+	m, inm := newTestMetrics(t)
+	unary := UnaryClientInterceptor(m)
+	stream := StreamClientInterceptor(m)
+	inFlight := func() float32 {
+		return inm.Data()[0].Gauges["grpc.client.in_flight"].Value
+	}
+
+	var finish func(err error)
+	_, err := stream(context.Background(), &grpc.StreamDesc{}, nil, "/svc/Watch",
+		func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			for _, opt := range opts {
+				if o, ok := opt.(grpc.OnFinishCallOption); ok {
+					finish = o.OnFinish
+				}
+			}
+			return fakeClientStream{}, nil
+		})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	err = unary(context.Background(), "/svc/Check", nil, nil, nil,
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			if got := inFlight(); got != 2 {
+				t.Fatalf("bad in_flight during unary call: %v", got)
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if got := inFlight(); got != 1 {
+		t.Fatalf("bad in_flight after unary call: %v", got)
+	}
+	finish(nil)
+	if got := inFlight(); got != 0 {
+		t.Fatalf("bad in_flight after stream: %v", got)
+	}
+}
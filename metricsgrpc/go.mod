@@ -0,0 +1,22 @@
+module github.com/hashicorp/go-metrics/metricsgrpc
+
+go 1.21
+
+require (
+	github.com/hashicorp/go-metrics v0.5.4
+	google.golang.org/grpc v1.65.0
+)
+
+require (
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/hashicorp/go-immutable-radix v1.3.1 // indirect
+	github.com/hashicorp/golang-lru v0.5.4 // indirect
+	github.com/klauspost/compress v1.17.11 // indirect
+	golang.org/x/net v0.33.0 // indirect
+	golang.org/x/sys v0.28.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240528184218-531527333157 // indirect
+	google.golang.org/protobuf v1.36.1 // indirect
+)
+
+replace github.com/hashicorp/go-metrics => ../
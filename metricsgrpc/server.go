@@ -0,0 +1,68 @@
+package metricsgrpc
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	serverRequestsKey = []string{"grpc", "server", "requests"}
+	serverDurationKey = []string{"grpc", "server", "request_duration"}
+)
+
+// UnaryServerInterceptor returns an interceptor emitting the metrics of the
+// unary RPCs served to m:
+//
+//	grpc.server.requests - counter of RPCs
+//	grpc.server.request_duration - sample of the time taken to handle RPCs
+//
+// Both are labeled with the service, the method and the status code of the
+// RPC, e.g. OK or Unavailable.
+func UnaryServerInterceptor(m *metrics.Metrics) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		observe(m, serverRequestsKey, serverDurationKey, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streaming RPCs. The
+// duration of a streaming RPC is the time until the handler returned.
+func StreamServerInterceptor(m *metrics.Metrics) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		observe(m, serverRequestsKey, serverDurationKey, info.FullMethod, start, err)
+		return err
+	}
+}
+
+// observe emits the count and duration of an RPC of the method named
+// /package.Service/Method which returned err, labeled with extra as well
+func observe(m *metrics.Metrics, requestsKey, durationKey []string, fullMethod string, start time.Time, err error, extra ...metrics.Label) {
+	service, method := splitMethod(fullMethod)
+	labels := []metrics.Label{
+		{Name: "service", Value: service},
+		{Name: "method", Value: method},
+		{Name: "code", Value: status.Code(err).String()},
+	}
+	labels = append(labels, extra...)
+	m.IncrCounterWithLabels(requestsKey, 1, labels)
+	m.MeasureSinceWithLabels(durationKey, start, labels)
+}
+
+// splitMethod returns the service and method of a method named
+// /package.Service/Method
+func splitMethod(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndexByte(fullMethod, '/'); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+	return "unknown", fullMethod
+}
@@ -0,0 +1,108 @@
+package metricsgrpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestMetrics returns Metrics emitting to the returned InmemSink without
+// any prefixes
+func newTestMetrics(t *testing.T) (*metrics.Metrics, *metrics.InmemSink) {
+	inm := metrics.NewInmemSink(time.Minute, time.Minute)
+	cfg := metrics.DefaultConfig("")
+	cfg.EnableHostname = false
+	cfg.EnableRuntimeMetrics = false
+	m, err := metrics.New(cfg, inm)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return m, inm
+}
+
+// serveHealth serves the health service over an in-memory listener with
+// the given server options, returning a connection to it
+func serveHealth(t *testing.T, serverOpts []grpc.ServerOption, dialOpts ...grpc.DialOption) *grpc.ClientConn {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(serverOpts...)
+	hs := health.NewServer()
+	hs.SetServingStatus("up", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(srv, hs)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	dialOpts = append(dialOpts,
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.Dial("passthrough:///bufnet", dialOpts...)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestServerInterceptors(t *testing.T) {
+	m, inm := newTestMetrics(t)
+	conn := serveHealth(t, []grpc.ServerOption{
+		grpc.UnaryInterceptor(UnaryServerInterceptor(m)),
+		grpc.StreamInterceptor(StreamServerInterceptor(m)),
+	})
+	client := healthpb.NewHealthClient(conn)
+
+	ctx := context.Background()
+	client.Check(ctx, &healthpb.HealthCheckRequest{Service: "up"})
+	client.Check(ctx, &healthpb.HealthCheckRequest{Service: "up"})
+	client.Check(ctx, &healthpb.HealthCheckRequest{Service: "missing"})
+
+	// The stream ends once the context is canceled
+	watchCtx, cancel := context.WithCancel(ctx)
+	stream, err := client.Watch(watchCtx, &healthpb.HealthCheckRequest{Service: "up"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	stream.Recv()
+	cancel()
+
+	key := "grpc.server.requests;service=grpc.health.v1.Health;method="
+	expect := map[string]int{
+		key + "Check;code=OK":       2,
+		key + "Check;code=NotFound": 1,
+		key + "Watch;code=Canceled": 1,
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		counters := inm.Data()[0].Counters
+		ok := len(counters) == len(expect)
+		for k, count := range expect {
+			ok = ok && counters[k].Count == count
+		}
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("bad counters: %v", counters)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s := inm.Data()[0].Samples["grpc.server.request_duration;service=grpc.health.v1.Health;method=Check;code=OK"]; s.Count != 2 {
+		t.Fatalf("bad durations: %v", inm.Data()[0].Samples)
+	}
+}
+
+func TestSplitMethod(t *testing.T) {
+	if s, m := splitMethod("/pkg.Service/Method"); s != "pkg.Service" || m != "Method" {
+		t.Fatalf("bad split: %s %s", s, m)
+	}
+	if s, m := splitMethod("odd"); s != "unknown" || m != "odd" {
+		t.Fatalf("bad split: %s %s", s, m)
+	}
+}
@@ -0,0 +1,153 @@
+// Package metricshealth runs health checks, emitting their results as
+// metrics and serving them for probes such as /healthz.
+package metricshealth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+// Check returns an error if what it checks is unhealthy. It should return
+// once ctx is done.
+type Check func(ctx context.Context) error
+
+// Result is the result of a check
+type Result struct {
+	Healthy  bool          `json:"healthy"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// Registry runs the registered checks, emitting their results to m:
+//
+//	health.check.healthy - gauge of 1 if a check passed and 0 otherwise,
+//	    labeled with the name of the check
+//	health.check.latency_ms - gauge of the time the check took
+//	health.healthy - gauge of 1 if all checks passed and 0 otherwise
+//
+// A Registry is safe for concurrent use.
+type Registry struct {
+	m       *metrics.Metrics
+	timeout time.Duration
+
+	lock   sync.RWMutex
+	checks map[string]Check
+}
+
+// NewRegistry returns a Registry emitting to m, which cancels checks taking
+// longer than timeout.
+func NewRegistry(m *metrics.Metrics, timeout time.Duration) *Registry {
+	return &Registry{
+		m:       m,
+		timeout: timeout,
+		checks:  make(map[string]Check),
+	}
+}
+
+// Register registers check with name, replacing any check of that name.
+func (r *Registry) Register(name string, check Check) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.checks[name] = check
+}
+
+// Unregister removes the check of name, if any.
+func (r *Registry) Unregister(name string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.checks, name)
+}
+
+// Run runs the checks concurrently, emits their results and returns them by
+// name.
+func (r *Registry) Run(ctx context.Context) map[string]Result {
+	r.lock.RLock()
+	checks := make(map[string]Check, len(r.checks))
+	for name, check := range r.checks {
+		checks[name] = check
+	}
+	r.lock.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]Result, len(checks))
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			start := time.Now()
+			err := check(ctx)
+			result := Result{Healthy: err == nil, Duration: time.Since(start)}
+			if err != nil {
+				result.Error = err.Error()
+			}
+			lock.Lock()
+			results[name] = result
+			lock.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	healthy := true
+	for name, result := range results {
+		labels := []metrics.Label{{Name: "check", Value: name}}
+		r.m.SetGaugeWithLabels([]string{"health", "check", "healthy"}, boolGauge(result.Healthy), labels)
+		r.m.SetGaugeWithLabels([]string{"health", "check", "latency_ms"}, float32(result.Duration.Seconds()*1000), labels)
+		healthy = healthy && result.Healthy
+	}
+	r.m.SetGauge([]string{"health", "healthy"}, boolGauge(healthy))
+	return results
+}
+
+// Poll runs the checks every interval, see Run and metrics.Metrics.Poll, so
+// their metrics are emitted without probes.
+func (r *Registry) Poll(interval time.Duration) (stop func()) {
+	return r.m.Poll(interval, func(metrics.GaugeEmitter) {
+		r.Run(context.Background())
+	})
+}
+
+// Handler returns an http.Handler running the checks. It responds with 200
+// if all passed, and 503 otherwise, with the results as JSON, e.g.
+//
+//	{"healthy":false,"checks":{"db":{"healthy":false,"error":"connection refused","duration_ns":1200}}}
+//
+// Requests with the verbose=false query parameter only get the status.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		results := r.Run(req.Context())
+		healthy := true
+		for _, result := range results {
+			healthy = healthy && result.Healthy
+		}
+
+		status := http.StatusOK
+		if !healthy {
+			status = http.StatusServiceUnavailable
+		}
+		if req.URL.Query().Get("verbose") == "false" {
+			w.WriteHeader(status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(struct {
+			Healthy bool              `json:"healthy"`
+			Checks  map[string]Result `json:"checks"`
+		}{healthy, results})
+	})
+}
+
+func boolGauge(b bool) float32 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,75 @@
+package metricshealth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+func TestRegistry(t *testing.T) {
+	inm := metrics.NewInmemSink(time.Minute, time.Minute)
+	cfg := metrics.DefaultConfig("")
+	cfg.EnableHostname = false
+	cfg.EnableRuntimeMetrics = false
+	m, err := metrics.New(cfg, inm)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	r := NewRegistry(m, 50*time.Millisecond)
+	r.Register("cache", func(context.Context) error { return nil })
+	r.Register("db", func(context.Context) error { return errors.New("connection refused") })
+	r.Register("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != 503 {
+		t.Fatalf("bad status: %d", rec.Code)
+	}
+	var body struct {
+		Healthy bool
+		Checks  map[string]Result
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if body.Healthy || !body.Checks["cache"].Healthy || body.Checks["db"].Error != "connection refused" ||
+		body.Checks["slow"].Error != context.DeadlineExceeded.Error() {
+		t.Fatalf("bad body: %+v", body)
+	}
+
+	gauges := inm.Data()[0].Gauges
+	for key, value := range map[string]float32{
+		"health.check.healthy;check=cache": 1,
+		"health.check.healthy;check=db":    0,
+		"health.check.healthy;check=slow":  0,
+		"health.healthy":                   0,
+	} {
+		if g, ok := gauges[key]; !ok || g.Value != value {
+			t.Fatalf("bad %s: %v", key, gauges)
+		}
+	}
+	if g := gauges["health.check.latency_ms;check=slow"]; g.Value < 50 {
+		t.Fatalf("bad latency: %v", g)
+	}
+
+	r.Unregister("db")
+	r.Unregister("slow")
+	rec = httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/healthz?verbose=false", nil))
+	if rec.Code != 200 || rec.Body.Len() != 0 {
+		t.Fatalf("bad response: %d %q", rec.Code, rec.Body)
+	}
+	r.Poll(time.Hour)()
+	if g := inm.Data()[0].Gauges["health.healthy"]; g.Value != 1 {
+		t.Fatalf("bad healthy: %v", g)
+	}
+}
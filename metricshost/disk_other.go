@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package metricshost
+
+func readDiskUsage(string) (diskUsage, error) {
+	return diskUsage{}, errNotSupported
+}
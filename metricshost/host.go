@@ -0,0 +1,146 @@
+// Package metricshost collects basic metrics of the host, for single binary
+// appliances which can't run an agent such as node_exporter.
+package metricshost
+
+import (
+	"errors"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+// errNotSupported is returned by the readers of metrics not available on the
+// platform
+var errNotSupported = errors.New("not supported on this platform")
+
+// Opts configures the metrics emitted by Emit
+type Opts struct {
+	// Mounts are the paths of the file systems to emit the disk usage of,
+	// e.g. / and /var. None are emitted by default.
+	Mounts []string
+
+	// Interfaces are the network interfaces to emit the counters of. All are
+	// emitted by default.
+	Interfaces []string
+}
+
+// Emit emits the metrics of the host to m:
+//
+//	host.load1, host.load5, host.load15 - gauges of the load average, on
+//	    Linux
+//	host.disk.total_bytes - gauge of the size of a file system, labeled with
+//	    its mount
+//	host.disk.free_bytes - gauge of the bytes available to unprivileged
+//	    users
+//	host.disk.used_bytes - gauge of the bytes used
+//	host.network.receive_bytes, transmit_bytes - gauges of the total bytes
+//	    received and sent by an interface, on Linux, labeled with the
+//	    interface
+//	host.network.receive_packets, transmit_packets - gauges of the total
+//	    packets
+//	host.network.receive_errors, transmit_errors - gauges of the total
+//	    errors
+//
+// The disk usage is not available on Windows. Metrics which are not
+// available are skipped, other errors are logged.
+func Emit(m *metrics.Metrics, opts Opts) {
+	switch load, err := readLoadAverage(); {
+	case err == errNotSupported:
+	case err != nil:
+		metrics.LogError("Error reading the load average", "class", "read", "error", err)
+	default:
+		m.SetGauge([]string{"host", "load1"}, float32(load[0]))
+		m.SetGauge([]string{"host", "load5"}, float32(load[1]))
+		m.SetGauge([]string{"host", "load15"}, float32(load[2]))
+	}
+
+	for _, mount := range opts.Mounts {
+		usage, err := readDiskUsage(mount)
+		if err == errNotSupported {
+			break
+		}
+		if err != nil {
+			metrics.LogError("Error reading the disk usage", "path", mount, "class", "read", "error", err)
+			continue
+		}
+		labels := []metrics.Label{{Name: "mount", Value: mount}}
+		m.SetGaugeWithLabels([]string{"host", "disk", "total_bytes"}, float32(usage.total), labels)
+		m.SetGaugeWithLabels([]string{"host", "disk", "free_bytes"}, float32(usage.available), labels)
+		m.SetGaugeWithLabels([]string{"host", "disk", "used_bytes"}, float32(usage.total-usage.free), labels)
+	}
+
+	counters, err := readNetworkCounters()
+	if err == errNotSupported {
+		return
+	}
+	if err != nil {
+		metrics.LogError("Error reading the network counters", "class", "read", "error", err)
+		return
+	}
+	for _, c := range counters {
+		if !selected(c.name, opts.Interfaces) {
+			continue
+		}
+		labels := []metrics.Label{{Name: "interface", Value: c.name}}
+		m.SetGaugeWithLabels([]string{"host", "network", "receive_bytes"}, float32(c.receiveBytes), labels)
+		m.SetGaugeWithLabels([]string{"host", "network", "transmit_bytes"}, float32(c.transmitBytes), labels)
+		m.SetGaugeWithLabels([]string{"host", "network", "receive_packets"}, float32(c.receivePackets), labels)
+		m.SetGaugeWithLabels([]string{"host", "network", "transmit_packets"}, float32(c.transmitPackets), labels)
+		m.SetGaugeWithLabels([]string{"host", "network", "receive_errors"}, float32(c.receiveErrors), labels)
+		m.SetGaugeWithLabels([]string{"host", "network", "transmit_errors"}, float32(c.transmitErrors), labels)
+	}
+}
+
+// EmitEvery emits the metrics of the host every interval, see Emit, until the
+// returned function is called to stop it.
+func EmitEvery(m *metrics.Metrics, opts Opts, interval time.Duration) (stop func()) {
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			Emit(m, opts)
+			select {
+			case <-ticker.C:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stopCh)
+		<-doneCh
+	}
+}
+
+// diskUsage is the usage of a file system in bytes
+type diskUsage struct {
+	total     uint64
+	free      uint64
+	available uint64
+}
+
+// networkCounters are the totals of a network interface
+type networkCounters struct {
+	name            string
+	receiveBytes    uint64
+	transmitBytes   uint64
+	receivePackets  uint64
+	transmitPackets uint64
+	receiveErrors   uint64
+	transmitErrors  uint64
+}
+
+func selected(name string, names []string) bool {
+	if names == nil {
+		return true
+	}
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package metricshost
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func readLoadAverage() ([3]float64, error) {
+	var load [3]float64
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return load, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return load, fmt.Errorf("malformed /proc/loadavg")
+	}
+	for i := range load {
+		if load[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return load, err
+		}
+	}
+	return load, nil
+}
+
+// readNetworkCounters reads /proc/net/dev, which after two header lines has
+// a line per interface, e.g.
+//
+//	eth0: 1024 8 0 0 0 0 0 0 2048 16 0 0 0 0 0 0
+//
+// with the bytes, packets and errors received in the first three columns,
+// and those transmitted in the 9th to 11th.
+func readNetworkCounters() ([]networkCounters, error) {
+	f, err := os.Open("/proc/net/dev")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var counters []networkCounters
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, values, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(values)
+		if len(fields) < 11 {
+			continue
+		}
+		field := func(i int) uint64 {
+			v, _ := strconv.ParseUint(fields[i], 10, 64)
+			return v
+		}
+		counters = append(counters, networkCounters{
+			name:            strings.TrimSpace(name),
+			receiveBytes:    field(0),
+			receivePackets:  field(1),
+			receiveErrors:   field(2),
+			transmitBytes:   field(8),
+			transmitPackets: field(9),
+			transmitErrors:  field(10),
+		})
+	}
+	return counters, scanner.Err()
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package metricshost
+
+func readLoadAverage() ([3]float64, error) {
+	return [3]float64{}, errNotSupported
+}
+
+func readNetworkCounters() ([]networkCounters, error) {
+	return nil, errNotSupported
+}
@@ -0,0 +1,49 @@
+package metricshost
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+func TestEmit(t *testing.T) {
+	inm := metrics.NewInmemSink(time.Minute, time.Minute)
+	cfg := metrics.DefaultConfig("")
+	cfg.EnableHostname = false
+	cfg.EnableRuntimeMetrics = false
+	m, err := metrics.New(cfg, inm)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	stop := EmitEvery(m, Opts{Mounts: []string{"/"}, Interfaces: []string{"lo"}}, time.Hour)
+	stop()
+
+	gauges := inm.Data()[0].Gauges
+	if runtime.GOOS != "linux" {
+		return
+	}
+	for _, key := range []string{
+		"host.load1",
+		"host.load15",
+		"host.disk.free_bytes;mount=/",
+		"host.network.receive_bytes;interface=lo",
+		"host.network.transmit_errors;interface=lo",
+	} {
+		if _, ok := gauges[key]; !ok {
+			t.Fatalf("missing %s: %v", key, gauges)
+		}
+	}
+	total, used := gauges["host.disk.total_bytes;mount=/"], gauges["host.disk.used_bytes;mount=/"]
+	if total.Value <= 0 || used.Value > total.Value {
+		t.Fatalf("bad disk usage: %v %v", total, used)
+	}
+	for key := range gauges {
+		if strings.HasPrefix(key, "host.network.") && !strings.HasSuffix(key, ";interface=lo") {
+			t.Fatalf("interface not selected: %s", key)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package metricshttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+// ClientOpts configures the metrics emitted by RoundTripperWithOpts
+type ClientOpts struct {
+	// Prefix is prepended to the keys of the metrics. Defaults to
+	// http.client.
+	Prefix []string
+
+	// Endpoint returns the endpoint label of a request, such as the name of
+	// the API call. It must not return raw paths, as every distinct value is
+	// a series. By default the endpoint set with WithEndpoint is used, if
+	// any.
+	Endpoint func(*http.Request) string
+}
+
+// RoundTripper wraps next, or http.DefaultTransport if it is nil, to emit
+// the metrics of outbound requests to m:
+//
+//	http.client.requests - counter of requests
+//	http.client.request_duration - sample of the time until the response
+//	    headers were received
+//	http.client.connections - counter of the connections requests were sent
+//	    over, labeled with whether the connection was reused
+//
+// All are labeled with the host of the request. Requests and durations are
+// also labeled with the method, the endpoint, see WithEndpoint, and the
+// class of the status code, e.g. 2xx, or "error" if no response was
+// received.
+func RoundTripper(m *metrics.Metrics, next http.RoundTripper) http.RoundTripper {
+	return RoundTripperWithOpts(m, next, ClientOpts{})
+}
+
+// RoundTripperWithOpts is RoundTripper configured by opts.
+func RoundTripperWithOpts(m *metrics.Metrics, next http.RoundTripper, opts ClientOpts) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	prefix := opts.Prefix
+	if prefix == nil {
+		prefix = []string{"http", "client"}
+	}
+	return &roundTripper{
+		m:             m,
+		next:          next,
+		endpoint:      opts.Endpoint,
+		requestsKey:   appendKey(prefix, "requests"),
+		durationKey:   appendKey(prefix, "request_duration"),
+		connectionKey: appendKey(prefix, "connections"),
+	}
+}
+
+type roundTripper struct {
+	m        *metrics.Metrics
+	next     http.RoundTripper
+	endpoint func(*http.Request) string
+
+	requestsKey   []string
+	durationKey   []string
+	connectionKey []string
+}
+
+func (t *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := metrics.Label{Name: "host", Value: req.URL.Host}
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			reused := metrics.Label{Name: "reused", Value: strconv.FormatBool(info.Reused)}
+			t.m.IncrCounterWithLabels(t.connectionKey, 1, []metrics.Label{host, reused})
+		},
+	}
+	traced := req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(traced)
+
+	labels := []metrics.Label{host, {Name: "method", Value: methodLabel(req.Method)}}
+	endpoint, _ := req.Context().Value(endpointKey{}).(string)
+	if t.endpoint != nil {
+		endpoint = t.endpoint(req)
+	}
+	if endpoint != "" {
+		labels = append(labels, metrics.Label{Name: "endpoint", Value: endpoint})
+	}
+	status := "error"
+	if err == nil {
+		status = statusClass(resp.StatusCode)
+	}
+	labels = append(labels, metrics.Label{Name: "status", Value: status})
+	t.m.IncrCounterWithLabels(t.requestsKey, 1, labels)
+	t.m.MeasureSinceWithLabels(t.durationKey, start, labels)
+	return resp, err
+}
+
+// endpointKey holds the endpoint of a request in its context
+type endpointKey struct{}
+
+// WithEndpoint returns a copy of ctx making RoundTripper label the requests
+// sent with it with endpoint, e.g.
+//
+//	req, err := http.NewRequestWithContext(metricshttp.WithEndpoint(ctx, "get_user"), "GET", url, nil)
+func WithEndpoint(ctx context.Context, endpoint string) context.Context {
+	return context.WithValue(ctx, endpointKey{}, endpoint)
+}
@@ -0,0 +1,75 @@
+package metricshttp
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestRoundTripper(t *testing.T) {
+	m, inm := newTestMetrics(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/missing" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	host := srv.Listener.Addr().String()
+
+	client := &http.Client{Transport: RoundTripper(m, srv.Client().Transport)}
+	for _, path := range []string{"/users/1", "/users/2", "/missing"} {
+		ctx := WithEndpoint(context.Background(), "users")
+		req, err := http.NewRequestWithContext(ctx, "GET", srv.URL+path, nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		// Read the body so the connection is reused
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	resp, err := client.Get("http://" + host + "/users/3")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+
+	// A request which gets no response
+	refused := RoundTripperWithOpts(m, nil, ClientOpts{
+		Prefix:   []string{"out"},
+		Endpoint: func(*http.Request) string { return "refused" },
+	})
+	req := &http.Request{Method: "GET", URL: &url.URL{Scheme: "http", Host: "127.0.0.1:1", Path: "/"}, Header: http.Header{}}
+	if _, err := refused.RoundTrip(req); err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	data := inm.Data()[0]
+	for key, count := range map[string]int{
+		"http.client.requests;host=" + host + ";method=GET;endpoint=users;status=2xx": 2,
+		"http.client.requests;host=" + host + ";method=GET;endpoint=users;status=4xx": 1,
+		"http.client.requests;host=" + host + ";method=GET;status=2xx":                1,
+		"out.requests;host=127.0.0.1:1;method=GET;endpoint=refused;status=error":      1,
+	} {
+		if c := data.Counters[key]; c.Count != count {
+			t.Fatalf("bad %s: %v", key, data.Counters)
+		}
+	}
+	if s := data.Samples["http.client.request_duration;host="+host+";method=GET;endpoint=users;status=2xx"]; s.Count != 2 {
+		t.Fatalf("bad durations: %v", data.Samples)
+	}
+	if c := data.Counters["http.client.connections;host="+host+";reused=false"]; c.Count != 1 {
+		t.Fatalf("bad new connections: %v", data.Counters)
+	}
+	if c := data.Counters["http.client.connections;host="+host+";reused=true"]; c.Count != 3 {
+		t.Fatalf("bad reused connections: %v", data.Counters)
+	}
+}
@@ -0,0 +1,181 @@
+package metricshttp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+// ServerOpts configures the metrics emitted by MiddlewareWithOpts
+type ServerOpts struct {
+	// Prefix is prepended to the keys of the metrics. Defaults to
+	// http.server.
+	Prefix []string
+
+	// Route returns the route label of a request, such as the pattern it
+	// is served by. It must not return raw paths, as every distinct value is
+	// a series. By default the route set by Route is used, if any.
+	Route func(*http.Request) string
+}
+
+// Middleware wraps handlers to emit the request rate, errors and duration
+// (RED) metrics of a server to m:
+//
+//	http.server.requests - counter of requests
+//	http.server.request_duration - sample of the time taken to respond
+//	http.server.in_flight - gauge of the requests being served
+//
+// The counter and sample are labeled with the method, the route, see Route,
+// and the class of the status code, e.g. 2xx. Handlers which panic are
+// counted as 5xx.
+func Middleware(m *metrics.Metrics) func(http.Handler) http.Handler {
+	return MiddlewareWithOpts(m, ServerOpts{})
+}
+
+// MiddlewareWithOpts is Middleware configured by opts.
+func MiddlewareWithOpts(m *metrics.Metrics, opts ServerOpts) func(http.Handler) http.Handler {
+	prefix := opts.Prefix
+	if prefix == nil {
+		prefix = []string{"http", "server"}
+	}
+	requestsKey := appendKey(prefix, "requests")
+	durationKey := appendKey(prefix, "request_duration")
+	inFlightKey := appendKey(prefix, "in_flight")
+
+	var inFlight int64
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+			m.SetGauge(inFlightKey, float32(atomic.AddInt64(&inFlight, 1)))
+
+			route := new(string)
+			req = req.WithContext(context.WithValue(req.Context(), routeKey{}, route))
+			rec := &statusRecorder{ResponseWriter: w}
+			returned := false
+			defer func() {
+				m.SetGauge(inFlightKey, float32(atomic.AddInt64(&inFlight, -1)))
+				// A panicking handler responds with 500 unless it wrote a
+				// status before. The panic isn't recovered, so it goes on
+				// with its original stack.
+				if !returned && rec.status == 0 {
+					rec.status = http.StatusInternalServerError
+				}
+				if opts.Route != nil {
+					*route = opts.Route(req)
+				}
+				labels := []metrics.Label{{Name: "method", Value: methodLabel(req.Method)}}
+				if *route != "" {
+					labels = append(labels, metrics.Label{Name: "route", Value: *route})
+				}
+				labels = append(labels, metrics.Label{Name: "status", Value: statusClass(rec.status)})
+				m.IncrCounterWithLabels(requestsKey, 1, labels)
+				m.MeasureSinceWithLabels(durationKey, start, labels)
+			}()
+			next.ServeHTTP(rec, req)
+			returned = true
+		})
+	}
+}
+
+// routeKey holds the route of a request served by Middleware in its context
+type routeKey struct{}
+
+// Route wraps a handler registered for pattern so Middleware labels its
+// requests with pattern, e.g.
+//
+//	mux.Handle("/users/", metricshttp.Route("/users/", usersHandler))
+//	http.ListenAndServe(addr, metricshttp.Middleware(m)(mux))
+//
+// Requests of handlers not wrapped have no route label.
+func Route(pattern string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if route, ok := req.Context().Value(routeKey{}).(*string); ok {
+			*route = pattern
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// statusRecorder records the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+// Flush supports streaming handlers
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		if r.status == 0 {
+			r.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// Hijack supports handlers taking over the connection, such as websockets.
+// Hijacked requests are counted with a 101 status.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("metricshttp: ResponseWriter does not support hijacking")
+	}
+	if r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the ResponseWriter of the server
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// statusClass returns the class of a status code, e.g. 2xx. Handlers which
+// write nothing respond with 200.
+func statusClass(status int) string {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status < 100 || status > 599 {
+		return "other"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// methodLabel returns the method as a label value, folding unknown methods
+// into one value so they can't add series
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return "other"
+	}
+}
+
+// appendKey returns a copy of prefix with name appended
+func appendKey(prefix []string, name string) []string {
+	return append(prefix[:len(prefix):len(prefix)], name)
+}
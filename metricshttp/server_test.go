@@ -0,0 +1,96 @@
+package metricshttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+// newTestMetrics returns Metrics emitting to the returned InmemSink without
+// any prefixes
+func newTestMetrics(t *testing.T) (*metrics.Metrics, *metrics.InmemSink) {
+	inm := metrics.NewInmemSink(time.Minute, time.Minute)
+	cfg := metrics.DefaultConfig("")
+	cfg.EnableHostname = false
+	cfg.EnableRuntimeMetrics = false
+	m, err := metrics.New(cfg, inm)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return m, inm
+}
+
+func TestMiddleware(t *testing.T) {
+	m, inm := newTestMetrics(t)
+
+	mux := http.NewServeMux()
+	mux.Handle("/users/", Route("/users/", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok"))
+	})))
+	mux.Handle("/fail", Route("/fail", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "failed", http.StatusServiceUnavailable)
+	})))
+	handler := Middleware(m)(mux)
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", "/users/1", nil),
+		httptest.NewRequest("GET", "/users/2", nil),
+		httptest.NewRequest("POST", "/fail", nil),
+		httptest.NewRequest("PURGE", "/missing", nil),
+	} {
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	data := inm.Data()[0]
+	for key, count := range map[string]int{
+		"http.server.requests;method=GET;route=/users/;status=2xx": 2,
+		"http.server.requests;method=POST;route=/fail;status=5xx":  1,
+		"http.server.requests;method=other;status=4xx":             1,
+	} {
+		if c := data.Counters[key]; c.Count != count {
+			t.Fatalf("bad %s: %v", key, c)
+		}
+		sample := "http.server.request_duration" + key[len("http.server.requests"):]
+		if s := data.Samples[sample]; s.Count != count {
+			t.Fatalf("bad %s: %v", sample, s)
+		}
+	}
+	if len(data.Counters) != 3 {
+		t.Fatalf("bad counters: %v", data.Counters)
+	}
+	if g := data.Gauges["http.server.in_flight"]; g.Value != 0 {
+		t.Fatalf("bad in_flight: %v", g)
+	}
+}
+
+func TestMiddleware_Panic(t *testing.T) {
+	m, inm := newTestMetrics(t)
+	handler := MiddlewareWithOpts(m, ServerOpts{
+		Prefix: []string{"api"},
+		Route:  func(*http.Request) string { return "panic" },
+	})(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	}))
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("the panic was not passed on")
+			}
+			// The panic goes on unrecovered, rather than panicking again
+			if n := strings.Count(string(debug.Stack()), "\npanic("); n != 1 {
+				t.Fatalf("the panic was raised %d times:\n%s", n, debug.Stack())
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}()
+
+	if c := inm.Data()[0].Counters["api.requests;method=GET;route=panic;status=5xx"]; c.Count != 1 {
+		t.Fatalf("bad requests: %v", inm.Data()[0].Counters)
+	}
+}
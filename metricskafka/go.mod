@@ -0,0 +1,20 @@
+module github.com/hashicorp/go-metrics/metricskafka
+
+go 1.21
+
+require (
+	github.com/hashicorp/go-metrics v0.5.4
+	github.com/twmb/franz-go v1.17.1
+	github.com/twmb/franz-go/pkg/kmsg v1.8.0
+)
+
+require (
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/hashicorp/go-immutable-radix v1.3.1 // indirect
+	github.com/hashicorp/golang-lru v0.5.4 // indirect
+	github.com/klauspost/compress v1.17.11 // indirect
+	github.com/pierrec/lz4/v4 v4.1.21 // indirect
+	google.golang.org/protobuf v1.36.1 // indirect
+)
+
+replace github.com/hashicorp/go-metrics => ../
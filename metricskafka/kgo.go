@@ -0,0 +1,161 @@
+// Package metricskafka translates the statistics of Kafka clients into
+// metrics.
+package metricskafka
+
+import (
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// KgoHooks are hooks of a franz-go client emitting its metrics to m. Broker
+// metrics are labeled with the node ID of the broker, produce and fetch
+// metrics with the topic and partition:
+//
+//	kafka.broker.connects - counter of the connections opened
+//	kafka.broker.connect_errors - counter of the connections which failed
+//	kafka.broker.disconnects - counter of the connections closed
+//	kafka.broker.write_bytes - counter of the bytes written
+//	kafka.broker.read_bytes - counter of the bytes read
+//	kafka.broker.request_duration - sample of the time from writing
+//	    requests until their responses were read, also labeled with the
+//	    request, e.g. Produce
+//	kafka.broker.errors - counter of the requests which failed, also labeled
+//	    with the request
+//	kafka.broker.throttle_duration - sample of the time brokers throttled
+//	    the client
+//	kafka.producer.records - counter of the records produced
+//	kafka.producer.bytes - counter of the uncompressed bytes produced
+//	kafka.producer.compressed_bytes - counter of the bytes produced
+//	kafka.producer.buffered_records - gauge of the records waiting to be
+//	    produced
+//	kafka.producer.errors - counter of the records which failed
+//	kafka.consumer.records - counter of the records fetched
+//	kafka.consumer.bytes - counter of the uncompressed bytes fetched
+//	kafka.consumer.compressed_bytes - counter of the bytes fetched
+//	kafka.consumer.buffered_records - gauge of the records fetched but not
+//	    polled yet
+//	kafka.consumer.group_errors - counter of the errors managing the consumer
+//	    group
+//
+// For example:
+//
+//	client, err := kgo.NewClient(kgo.SeedBrokers(brokers...), kgo.WithHooks(metricskafka.NewKgoHooks(metrics.Default())))
+type KgoHooks struct {
+	m *metrics.Metrics
+
+	producerBuffered int64
+	consumerBuffered int64
+}
+
+// NewKgoHooks returns KgoHooks emitting to m.
+func NewKgoHooks(m *metrics.Metrics) *KgoHooks {
+	return &KgoHooks{m: m}
+}
+
+var (
+	_ kgo.HookBrokerConnect           = (*KgoHooks)(nil)
+	_ kgo.HookBrokerDisconnect        = (*KgoHooks)(nil)
+	_ kgo.HookBrokerE2E               = (*KgoHooks)(nil)
+	_ kgo.HookBrokerThrottle          = (*KgoHooks)(nil)
+	_ kgo.HookGroupManageError        = (*KgoHooks)(nil)
+	_ kgo.HookProduceBatchWritten     = (*KgoHooks)(nil)
+	_ kgo.HookProduceRecordBuffered   = (*KgoHooks)(nil)
+	_ kgo.HookProduceRecordUnbuffered = (*KgoHooks)(nil)
+	_ kgo.HookFetchBatchRead          = (*KgoHooks)(nil)
+	_ kgo.HookFetchRecordBuffered     = (*KgoHooks)(nil)
+	_ kgo.HookFetchRecordUnbuffered   = (*KgoHooks)(nil)
+)
+
+func (h *KgoHooks) OnBrokerConnect(meta kgo.BrokerMetadata, _ time.Duration, _ net.Conn, err error) {
+	if err != nil {
+		h.m.IncrCounterWithLabels([]string{"kafka", "broker", "connect_errors"}, 1, nodeLabels(meta))
+		return
+	}
+	h.m.IncrCounterWithLabels([]string{"kafka", "broker", "connects"}, 1, nodeLabels(meta))
+}
+
+func (h *KgoHooks) OnBrokerDisconnect(meta kgo.BrokerMetadata, _ net.Conn) {
+	h.m.IncrCounterWithLabels([]string{"kafka", "broker", "disconnects"}, 1, nodeLabels(meta))
+}
+
+// OnBrokerE2E emits the bytes and duration of requests, including the ones
+// which failed to be written or read.
+func (h *KgoHooks) OnBrokerE2E(meta kgo.BrokerMetadata, key int16, e2e kgo.BrokerE2E) {
+	labels := append(nodeLabels(meta), metrics.Label{Name: "request", Value: kmsg.NameForKey(key)})
+	if e2e.Err() != nil {
+		h.m.IncrCounterWithLabels([]string{"kafka", "broker", "errors"}, 1, labels)
+	}
+	if e2e.BytesWritten > 0 {
+		h.m.IncrCounterWithLabels([]string{"kafka", "broker", "write_bytes"}, float32(e2e.BytesWritten), nodeLabels(meta))
+	}
+	if e2e.BytesRead > 0 {
+		h.m.IncrCounterWithLabels([]string{"kafka", "broker", "read_bytes"}, float32(e2e.BytesRead), nodeLabels(meta))
+	}
+	h.m.AddSampleWithLabels([]string{"kafka", "broker", "request_duration"}, durationMs(e2e.DurationE2E()), labels)
+}
+
+func (h *KgoHooks) OnBrokerThrottle(meta kgo.BrokerMetadata, throttleInterval time.Duration, _ bool) {
+	h.m.AddSampleWithLabels([]string{"kafka", "broker", "throttle_duration"}, durationMs(throttleInterval), nodeLabels(meta))
+}
+
+func (h *KgoHooks) OnGroupManageError(error) {
+	h.m.IncrCounter([]string{"kafka", "consumer", "group_errors"}, 1)
+}
+
+func (h *KgoHooks) OnProduceBatchWritten(_ kgo.BrokerMetadata, topic string, partition int32, bm kgo.ProduceBatchMetrics) {
+	labels := partitionLabels(topic, partition)
+	h.m.IncrCounterWithLabels([]string{"kafka", "producer", "records"}, float32(bm.NumRecords), labels)
+	h.m.IncrCounterWithLabels([]string{"kafka", "producer", "bytes"}, float32(bm.UncompressedBytes), labels)
+	h.m.IncrCounterWithLabels([]string{"kafka", "producer", "compressed_bytes"}, float32(bm.CompressedBytes), labels)
+}
+
+func (h *KgoHooks) OnProduceRecordBuffered(*kgo.Record) {
+	h.m.SetGauge([]string{"kafka", "producer", "buffered_records"}, float32(atomic.AddInt64(&h.producerBuffered, 1)))
+}
+
+func (h *KgoHooks) OnProduceRecordUnbuffered(r *kgo.Record, err error) {
+	h.m.SetGauge([]string{"kafka", "producer", "buffered_records"}, float32(atomic.AddInt64(&h.producerBuffered, -1)))
+	if err != nil {
+		h.m.IncrCounterWithLabels([]string{"kafka", "producer", "errors"}, 1, []metrics.Label{{Name: "topic", Value: r.Topic}})
+	}
+}
+
+func (h *KgoHooks) OnFetchBatchRead(_ kgo.BrokerMetadata, topic string, partition int32, bm kgo.FetchBatchMetrics) {
+	labels := partitionLabels(topic, partition)
+	h.m.IncrCounterWithLabels([]string{"kafka", "consumer", "records"}, float32(bm.NumRecords), labels)
+	h.m.IncrCounterWithLabels([]string{"kafka", "consumer", "bytes"}, float32(bm.UncompressedBytes), labels)
+	h.m.IncrCounterWithLabels([]string{"kafka", "consumer", "compressed_bytes"}, float32(bm.CompressedBytes), labels)
+}
+
+func (h *KgoHooks) OnFetchRecordBuffered(*kgo.Record) {
+	h.m.SetGauge([]string{"kafka", "consumer", "buffered_records"}, float32(atomic.AddInt64(&h.consumerBuffered, 1)))
+}
+
+func (h *KgoHooks) OnFetchRecordUnbuffered(*kgo.Record, bool) {
+	h.m.SetGauge([]string{"kafka", "consumer", "buffered_records"}, float32(atomic.AddInt64(&h.consumerBuffered, -1)))
+}
+
+// nodeLabels returns the labels of a broker. Seed brokers have negative node
+// IDs until their actual ID is known.
+func nodeLabels(meta kgo.BrokerMetadata) []metrics.Label {
+	return []metrics.Label{{Name: "node", Value: strconv.Itoa(int(meta.NodeID))}}
+}
+
+func partitionLabels(topic string, partition int32) []metrics.Label {
+	return []metrics.Label{
+		{Name: "topic", Value: topic},
+		{Name: "partition", Value: strconv.Itoa(int(partition))},
+	}
+}
+
+// durationMs returns d in milliseconds, the unit of the samples of
+// MeasureSince
+func durationMs(d time.Duration) float32 {
+	return float32(d.Nanoseconds()) / float32(time.Millisecond)
+}
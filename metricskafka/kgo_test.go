@@ -0,0 +1,66 @@
+package metricskafka
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestKgoHooks(t *testing.T) {
+	inm := metrics.NewInmemSink(time.Minute, time.Minute)
+	cfg := metrics.DefaultConfig("")
+	cfg.EnableHostname = false
+	cfg.EnableRuntimeMetrics = false
+	m, err := metrics.New(cfg, inm)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	h := NewKgoHooks(m)
+	// The hooks are accepted by the client
+	client, err := kgo.NewClient(kgo.SeedBrokers("127.0.0.1:1"), kgo.WithHooks(h))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	client.Close()
+
+	broker := kgo.BrokerMetadata{NodeID: 1}
+	h.OnBrokerConnect(broker, time.Millisecond, nil, nil)
+	h.OnBrokerConnect(broker, time.Millisecond, nil, errors.New("refused"))
+	h.OnBrokerE2E(broker, 0, kgo.BrokerE2E{BytesWritten: 100, BytesRead: 20, TimeToRead: 2 * time.Millisecond})
+	h.OnBrokerE2E(broker, 0, kgo.BrokerE2E{BytesWritten: 50, ReadErr: errors.New("reset")})
+	h.OnProduceRecordBuffered(&kgo.Record{Topic: "events"})
+	h.OnProduceRecordBuffered(&kgo.Record{Topic: "events"})
+	h.OnProduceRecordUnbuffered(&kgo.Record{Topic: "events"}, errors.New("too large"))
+	h.OnProduceBatchWritten(broker, "events", 3, kgo.ProduceBatchMetrics{NumRecords: 1, UncompressedBytes: 40, CompressedBytes: 10})
+	h.OnFetchBatchRead(broker, "events", 3, kgo.FetchBatchMetrics{NumRecords: 5, UncompressedBytes: 200, CompressedBytes: 50})
+
+	data := inm.Data()[0]
+	for key, sum := range map[string]float64{
+		"kafka.broker.connects;node=1":                             1,
+		"kafka.broker.connect_errors;node=1":                       1,
+		"kafka.broker.write_bytes;node=1":                          150,
+		"kafka.broker.read_bytes;node=1":                           20,
+		"kafka.broker.errors;node=1;request=Produce":               1,
+		"kafka.producer.errors;topic=events":                       1,
+		"kafka.producer.records;topic=events;partition=3":          1,
+		"kafka.producer.bytes;topic=events;partition=3":            40,
+		"kafka.producer.compressed_bytes;topic=events;partition=3": 10,
+		"kafka.consumer.records;topic=events;partition=3":          5,
+		"kafka.consumer.bytes;topic=events;partition=3":            200,
+		"kafka.consumer.compressed_bytes;topic=events;partition=3": 50,
+	} {
+		if c, ok := data.Counters[key]; !ok || c.Sum != sum {
+			t.Fatalf("bad %s: %v", key, data.Counters)
+		}
+	}
+	if s, ok := data.Samples["kafka.broker.request_duration;node=1;request=Produce"]; !ok || s.Count != 2 || s.Max != 2 {
+		t.Fatalf("bad request_duration: %v", data.Samples)
+	}
+	if g := data.Gauges["kafka.producer.buffered_records"]; g.Value != 1 {
+		t.Fatalf("bad buffered_records: %v", data.Gauges)
+	}
+}
@@ -0,0 +1,110 @@
+// Package metricsopencensus exports the views of OpenCensus to go-metrics,
+// for services still instrumented with OpenCensus.
+package metricsopencensus
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-metrics"
+	"go.opencensus.io/stats/view"
+)
+
+// Exporter is a view.Exporter emitting the rows of views to go-metrics. The
+// name of a view, split on "/", is the key of its metrics, and the tags of a
+// row are their labels:
+//
+//	Count and Sum - counters of the increase since the previous export
+//	LastValue - gauges
+//	Distribution - counters of the increase of the count and sum of values,
+//	    <name>_count and <name>_sum, and of the cumulative counts of the
+//	    buckets, <name>_bucket labeled with le
+//
+// For example:
+//
+//	view.RegisterExporter(metricsopencensus.NewExporter(metrics.Default()))
+type Exporter struct {
+	m *metrics.Metrics
+
+	lock sync.Mutex
+	last map[string]float64
+}
+
+// NewExporter returns an Exporter emitting to m.
+func NewExporter(m *metrics.Metrics) *Exporter {
+	return &Exporter{m: m, last: make(map[string]float64)}
+}
+
+// ExportView emits the rows of vd.
+func (e *Exporter) ExportView(vd *view.Data) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	name := vd.View.Name
+	key := strings.Split(name, "/")
+	for _, row := range vd.Rows {
+		labels := make([]metrics.Label, 0, len(row.Tags)+1)
+		for _, t := range row.Tags {
+			labels = append(labels, metrics.Label{Name: t.Key.Name(), Value: t.Value})
+		}
+		switch data := row.Data.(type) {
+		case *view.CountData:
+			e.counter(key, float64(data.Value), labels)
+		case *view.SumData:
+			e.counter(key, data.Value, labels)
+		case *view.LastValueData:
+			e.m.SetGaugeWithLabels(key, float32(data.Value), labels)
+		case *view.DistributionData:
+			bounds := vd.View.Aggregation.Buckets
+			var cumulative int64
+			for i, count := range data.CountPerBucket {
+				if i >= len(bounds) {
+					// The last bucket is unbounded, its cumulative count
+					// is the count
+					break
+				}
+				cumulative += count
+				le := strconv.FormatFloat(bounds[i], 'g', -1, 64)
+				e.counter(withSuffix(key, "_bucket"), float64(cumulative),
+					append(labels[:len(labels):len(labels)], metrics.Label{Name: "le", Value: le}))
+			}
+			e.counter(withSuffix(key, "_count"), float64(data.Count), labels)
+			e.counter(withSuffix(key, "_sum"), data.Sum(), labels)
+		}
+	}
+}
+
+// counter emits the increase of the total of a series since its previous
+// export. Totals which dropped were reset, e.g. by re-registering the view.
+func (e *Exporter) counter(key []string, total float64, labels []metrics.Label) {
+	id := seriesID(key, labels)
+	delta := total
+	if last, ok := e.last[id]; ok && total >= last {
+		delta = total - last
+	}
+	e.last[id] = total
+	if delta > 0 {
+		e.m.IncrCounterWithLabels(key, float32(delta), labels)
+	}
+}
+
+// withSuffix returns a copy of key with suffix appended to its last part
+func withSuffix(key []string, suffix string) []string {
+	key = append([]string(nil), key...)
+	key[len(key)-1] += suffix
+	return key
+}
+
+// seriesID returns a string identifying the series of key with labels
+func seriesID(key []string, labels []metrics.Label) string {
+	var b strings.Builder
+	b.WriteString(strings.Join(key, "/"))
+	for _, l := range labels {
+		b.WriteByte(0)
+		b.WriteString(l.Name)
+		b.WriteByte(0)
+		b.WriteString(l.Value)
+	}
+	return b.String()
+}
@@ -0,0 +1,55 @@
+package metricsopencensus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+func TestExporter(t *testing.T) {
+	inm := metrics.NewInmemSink(time.Minute, time.Minute)
+	cfg := metrics.DefaultConfig("")
+	cfg.EnableHostname = false
+	cfg.EnableRuntimeMetrics = false
+	m, err := metrics.New(cfg, inm)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	e := NewExporter(m)
+
+	method := tag.MustNewKey("method")
+	latency := stats.Float64("latency", "", stats.UnitMilliseconds)
+	requests := &view.View{Name: "app/requests", Measure: latency, Aggregation: view.Count()}
+	inflight := &view.View{Name: "app/inflight", Measure: latency, Aggregation: view.LastValue()}
+	durations := &view.View{Name: "app/latency", Measure: latency, Aggregation: view.Distribution(10)}
+	get := []tag.Tag{{Key: method, Value: "GET"}}
+
+	e.ExportView(&view.Data{View: requests, Rows: []*view.Row{{Tags: get, Data: &view.CountData{Value: 3}}}})
+	e.ExportView(&view.Data{View: requests, Rows: []*view.Row{{Tags: get, Data: &view.CountData{Value: 5}}}})
+	e.ExportView(&view.Data{View: inflight, Rows: []*view.Row{{Data: &view.LastValueData{Value: 4}}}})
+	e.ExportView(&view.Data{View: durations, Rows: []*view.Row{{Data: &view.DistributionData{
+		Count: 2, Mean: 10, CountPerBucket: []int64{1, 1},
+	}}}})
+
+	data := inm.Data()[0]
+	for key, want := range map[string]struct {
+		count int
+		sum   float64
+	}{
+		"app.requests;method=GET":  {2, 5},
+		"app.latency_count":        {1, 2},
+		"app.latency_sum":          {1, 20},
+		"app.latency_bucket;le=10": {1, 1},
+	} {
+		if c, ok := data.Counters[key]; !ok || c.Count != want.count || c.Sum != want.sum {
+			t.Fatalf("bad %s: %v", key, data.Counters)
+		}
+	}
+	if g, ok := data.Gauges["app.inflight"]; !ok || g.Value != 4 {
+		t.Fatalf("bad gauges: %v", data.Gauges)
+	}
+}
@@ -0,0 +1,389 @@
+// Package metricsotel implements the metrics API of OpenTelemetry with
+// go-metrics, so libraries instrumented with OpenTelemetry emit to the
+// configured sinks without the OpenTelemetry SDK and an exporter.
+package metricsotel
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/embedded"
+)
+
+// MeterProvider is a metric.MeterProvider emitting to go-metrics. The name of
+// an instrument, split on ".", is the key of its metrics, and its attributes
+// are their labels. The instruments are emitted as:
+//
+//	Counter - counter
+//	UpDownCounter - gauge of the sum of the values added
+//	Histogram - sample
+//	Gauge - gauge
+//	ObservableCounter - counter of the increase since the previous
+//	    observation
+//	ObservableUpDownCounter, ObservableGauge - gauge
+//
+// Observable instruments are observed every collection interval. The names
+// and versions of meters, and the descriptions and units of instruments, are
+// not emitted. For example:
+//
+//	otel.SetMeterProvider(metricsotel.NewMeterProvider(metrics.Default(), 10*time.Second))
+type MeterProvider struct {
+	embedded.MeterProvider
+
+	m    *metrics.Metrics
+	stop func()
+
+	lock      sync.Mutex
+	callbacks map[*registration]struct{}
+}
+
+// NewMeterProvider returns a MeterProvider emitting to m, observing the
+// observable instruments every collectInterval until it is shut down.
+func NewMeterProvider(m *metrics.Metrics, collectInterval time.Duration) *MeterProvider {
+	p := &MeterProvider{
+		m:         m,
+		callbacks: make(map[*registration]struct{}),
+	}
+	p.stop = m.Poll(collectInterval, func(metrics.GaugeEmitter) {
+		p.collect()
+	})
+	return p
+}
+
+// Meter returns a Meter of the MeterProvider. All meters share the keys of
+// their instruments.
+func (p *MeterProvider) Meter(string, ...metric.MeterOption) metric.Meter {
+	return &meter{p: p}
+}
+
+// Shutdown stops observing the observable instruments.
+func (p *MeterProvider) Shutdown() {
+	p.stop()
+}
+
+// collect calls the registered callbacks
+func (p *MeterProvider) collect() {
+	p.lock.Lock()
+	callbacks := make([]func(context.Context) error, 0, len(p.callbacks))
+	for r := range p.callbacks {
+		callbacks = append(callbacks, r.callback)
+	}
+	p.lock.Unlock()
+
+	for _, callback := range callbacks {
+		if err := callback(context.Background()); err != nil {
+			metrics.LogError("Error observing OpenTelemetry instruments", "class", "observe", "error", err)
+		}
+	}
+}
+
+// register registers a callback to call on collection
+func (p *MeterProvider) register(callback func(context.Context) error) *registration {
+	r := &registration{p: p, callback: callback}
+	p.lock.Lock()
+	p.callbacks[r] = struct{}{}
+	p.lock.Unlock()
+	return r
+}
+
+type registration struct {
+	embedded.Registration
+
+	p        *MeterProvider
+	callback func(context.Context) error
+}
+
+func (r *registration) Unregister() error {
+	r.p.lock.Lock()
+	delete(r.p.callbacks, r)
+	r.p.lock.Unlock()
+	return nil
+}
+
+type meter struct {
+	embedded.Meter
+
+	p *MeterProvider
+}
+
+func (m *meter) Int64Counter(name string, _ ...metric.Int64CounterOption) (metric.Int64Counter, error) {
+	return &int64Adder{instrument: m.instrument(name, counterKind)}, nil
+}
+
+func (m *meter) Int64UpDownCounter(name string, _ ...metric.Int64UpDownCounterOption) (metric.Int64UpDownCounter, error) {
+	return &int64Adder{instrument: m.instrument(name, upDownCounterKind)}, nil
+}
+
+func (m *meter) Int64Histogram(name string, _ ...metric.Int64HistogramOption) (metric.Int64Histogram, error) {
+	return &int64Recorder{instrument: m.instrument(name, histogramKind)}, nil
+}
+
+func (m *meter) Int64Gauge(name string, _ ...metric.Int64GaugeOption) (metric.Int64Gauge, error) {
+	return &int64Recorder{instrument: m.instrument(name, gaugeKind)}, nil
+}
+
+func (m *meter) Float64Counter(name string, _ ...metric.Float64CounterOption) (metric.Float64Counter, error) {
+	return &float64Adder{instrument: m.instrument(name, counterKind)}, nil
+}
+
+func (m *meter) Float64UpDownCounter(name string, _ ...metric.Float64UpDownCounterOption) (metric.Float64UpDownCounter, error) {
+	return &float64Adder{instrument: m.instrument(name, upDownCounterKind)}, nil
+}
+
+func (m *meter) Float64Histogram(name string, _ ...metric.Float64HistogramOption) (metric.Float64Histogram, error) {
+	return &float64Recorder{instrument: m.instrument(name, histogramKind)}, nil
+}
+
+func (m *meter) Float64Gauge(name string, _ ...metric.Float64GaugeOption) (metric.Float64Gauge, error) {
+	return &float64Recorder{instrument: m.instrument(name, gaugeKind)}, nil
+}
+
+func (m *meter) Int64ObservableCounter(name string, options ...metric.Int64ObservableCounterOption) (metric.Int64ObservableCounter, error) {
+	o := &int64Observable{instrument: m.instrument(name, counterKind)}
+	m.registerInt64(o, metric.NewInt64ObservableCounterConfig(options...).Callbacks())
+	return o, nil
+}
+
+func (m *meter) Int64ObservableUpDownCounter(name string, options ...metric.Int64ObservableUpDownCounterOption) (metric.Int64ObservableUpDownCounter, error) {
+	o := &int64Observable{instrument: m.instrument(name, gaugeKind)}
+	m.registerInt64(o, metric.NewInt64ObservableUpDownCounterConfig(options...).Callbacks())
+	return o, nil
+}
+
+func (m *meter) Int64ObservableGauge(name string, options ...metric.Int64ObservableGaugeOption) (metric.Int64ObservableGauge, error) {
+	o := &int64Observable{instrument: m.instrument(name, gaugeKind)}
+	m.registerInt64(o, metric.NewInt64ObservableGaugeConfig(options...).Callbacks())
+	return o, nil
+}
+
+func (m *meter) Float64ObservableCounter(name string, options ...metric.Float64ObservableCounterOption) (metric.Float64ObservableCounter, error) {
+	o := &float64Observable{instrument: m.instrument(name, counterKind)}
+	m.registerFloat64(o, metric.NewFloat64ObservableCounterConfig(options...).Callbacks())
+	return o, nil
+}
+
+func (m *meter) Float64ObservableUpDownCounter(name string, options ...metric.Float64ObservableUpDownCounterOption) (metric.Float64ObservableUpDownCounter, error) {
+	o := &float64Observable{instrument: m.instrument(name, gaugeKind)}
+	m.registerFloat64(o, metric.NewFloat64ObservableUpDownCounterConfig(options...).Callbacks())
+	return o, nil
+}
+
+func (m *meter) Float64ObservableGauge(name string, options ...metric.Float64ObservableGaugeOption) (metric.Float64ObservableGauge, error) {
+	o := &float64Observable{instrument: m.instrument(name, gaugeKind)}
+	m.registerFloat64(o, metric.NewFloat64ObservableGaugeConfig(options...).Callbacks())
+	return o, nil
+}
+
+// RegisterCallback registers f to observe instruments on collection. Only
+// instruments of the MeterProvider can be observed, others are ignored.
+func (m *meter) RegisterCallback(f metric.Callback, _ ...metric.Observable) (metric.Registration, error) {
+	return m.p.register(func(ctx context.Context) error {
+		return f(ctx, observer{})
+	}), nil
+}
+
+func (m *meter) instrument(name string, kind instrumentKind) *instrument {
+	return &instrument{
+		m:    m.p.m,
+		key:  strings.Split(name, "."),
+		kind: kind,
+	}
+}
+
+func (m *meter) registerInt64(o *int64Observable, callbacks []metric.Int64Callback) {
+	for _, callback := range callbacks {
+		callback := callback
+		m.p.register(func(ctx context.Context) error {
+			return callback(ctx, int64Observer{o: o})
+		})
+	}
+}
+
+func (m *meter) registerFloat64(o *float64Observable, callbacks []metric.Float64Callback) {
+	for _, callback := range callbacks {
+		callback := callback
+		m.p.register(func(ctx context.Context) error {
+			return callback(ctx, float64Observer{o: o})
+		})
+	}
+}
+
+// instrumentKind is how the values of an instrument are emitted
+type instrumentKind int
+
+const (
+	counterKind instrumentKind = iota
+	upDownCounterKind
+	histogramKind
+	gaugeKind
+)
+
+// instrument emits the values of an instrument
+type instrument struct {
+	m    *metrics.Metrics
+	key  []string
+	kind instrumentKind
+
+	// values are the sums of up-down counters, and the last observations of
+	// observable counters, by attributes
+	lock   sync.Mutex
+	values map[attribute.Distinct]float64
+}
+
+// record emits a value recorded with attrs
+func (i *instrument) record(value float64, attrs attribute.Set) {
+	labels := make([]metrics.Label, 0, attrs.Len())
+	for iter := attrs.Iter(); iter.Next(); {
+		kv := iter.Attribute()
+		labels = append(labels, metrics.Label{Name: string(kv.Key), Value: kv.Value.Emit()})
+	}
+	switch i.kind {
+	case counterKind:
+		i.m.IncrCounterWithLabels(i.key, float32(value), labels)
+	case upDownCounterKind:
+		i.m.SetGaugeWithLabels(i.key, float32(i.add(attrs, value)), labels)
+	case histogramKind:
+		i.m.AddSampleWithLabels(i.key, float32(value), labels)
+	case gaugeKind:
+		i.m.SetGaugeWithLabels(i.key, float32(value), labels)
+	}
+}
+
+// observe emits a value observed with attrs
+func (i *instrument) observe(value float64, attrs attribute.Set) {
+	if i.kind == counterKind {
+		// Observable counters observe totals
+		value = i.delta(attrs, value)
+	}
+	i.record(value, attrs)
+}
+
+// add adds value to the sum of attrs and returns the sum
+func (i *instrument) add(attrs attribute.Set, value float64) float64 {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if i.values == nil {
+		i.values = make(map[attribute.Distinct]float64)
+	}
+	sum := i.values[attrs.Equivalent()] + value
+	i.values[attrs.Equivalent()] = sum
+	return sum
+}
+
+// delta returns the increase of the total of attrs since it was last
+// observed
+func (i *instrument) delta(attrs attribute.Set, total float64) float64 {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if i.values == nil {
+		i.values = make(map[attribute.Distinct]float64)
+	}
+	last := i.values[attrs.Equivalent()]
+	i.values[attrs.Equivalent()] = total
+	if total < last {
+		// The total was reset
+		return total
+	}
+	return total - last
+}
+
+type int64Adder struct {
+	embedded.Int64Counter
+	embedded.Int64UpDownCounter
+	*instrument
+}
+
+func (a *int64Adder) Add(_ context.Context, incr int64, options ...metric.AddOption) {
+	a.record(float64(incr), metric.NewAddConfig(options).Attributes())
+}
+
+type float64Adder struct {
+	embedded.Float64Counter
+	embedded.Float64UpDownCounter
+	*instrument
+}
+
+func (a *float64Adder) Add(_ context.Context, incr float64, options ...metric.AddOption) {
+	a.record(incr, metric.NewAddConfig(options).Attributes())
+}
+
+type int64Recorder struct {
+	embedded.Int64Histogram
+	embedded.Int64Gauge
+	*instrument
+}
+
+func (r *int64Recorder) Record(_ context.Context, value int64, options ...metric.RecordOption) {
+	r.record(float64(value), metric.NewRecordConfig(options).Attributes())
+}
+
+type float64Recorder struct {
+	embedded.Float64Histogram
+	embedded.Float64Gauge
+	*instrument
+}
+
+func (r *float64Recorder) Record(_ context.Context, value float64, options ...metric.RecordOption) {
+	r.record(value, metric.NewRecordConfig(options).Attributes())
+}
+
+// int64Observable implements all the Int64 observable instruments. The
+// embedded metric.Int64Observable is nil, it only provides the unexported
+// methods of the interface.
+type int64Observable struct {
+	metric.Int64Observable
+	embedded.Int64ObservableCounter
+	embedded.Int64ObservableUpDownCounter
+	embedded.Int64ObservableGauge
+	*instrument
+}
+
+type float64Observable struct {
+	metric.Float64Observable
+	embedded.Float64ObservableCounter
+	embedded.Float64ObservableUpDownCounter
+	embedded.Float64ObservableGauge
+	*instrument
+}
+
+type int64Observer struct {
+	embedded.Int64Observer
+
+	o *int64Observable
+}
+
+func (o int64Observer) Observe(value int64, options ...metric.ObserveOption) {
+	o.o.observe(float64(value), metric.NewObserveConfig(options).Attributes())
+}
+
+type float64Observer struct {
+	embedded.Float64Observer
+
+	o *float64Observable
+}
+
+func (o float64Observer) Observe(value float64, options ...metric.ObserveOption) {
+	o.o.observe(value, metric.NewObserveConfig(options).Attributes())
+}
+
+// observer is the Observer of the callbacks registered with RegisterCallback
+type observer struct {
+	embedded.Observer
+}
+
+func (observer) ObserveInt64(obsrv metric.Int64Observable, value int64, options ...metric.ObserveOption) {
+	if o, ok := obsrv.(*int64Observable); ok {
+		o.observe(float64(value), metric.NewObserveConfig(options).Attributes())
+	}
+}
+
+func (observer) ObserveFloat64(obsrv metric.Float64Observable, value float64, options ...metric.ObserveOption) {
+	if o, ok := obsrv.(*float64Observable); ok {
+		o.observe(value, metric.NewObserveConfig(options).Attributes())
+	}
+}
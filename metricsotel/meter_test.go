@@ -0,0 +1,92 @@
+package metricsotel
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+func TestMeterProvider(t *testing.T) {
+	inm := metrics.NewInmemSink(time.Minute, time.Minute)
+	cfg := metrics.DefaultConfig("")
+	cfg.EnableHostname = false
+	cfg.EnableRuntimeMetrics = false
+	m, err := metrics.New(cfg, inm)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	p := NewMeterProvider(m, time.Hour)
+	defer p.Shutdown()
+	meter := p.Meter("test")
+	ctx := context.Background()
+	attrs := metric.WithAttributes(attribute.String("route", "/users"))
+
+	requests, _ := meter.Int64Counter("http.requests")
+	requests.Add(ctx, 2, attrs)
+	requests.Add(ctx, 3, attrs)
+	inFlight, _ := meter.Int64UpDownCounter("http.in_flight")
+	inFlight.Add(ctx, 3, attrs)
+	inFlight.Add(ctx, -1, attrs)
+	duration, _ := meter.Float64Histogram("http.duration")
+	duration.Record(ctx, 0.5, attrs)
+	duration.Record(ctx, 1.5, attrs)
+	temperature, _ := meter.Float64Gauge("temperature")
+	temperature.Record(ctx, 21.5)
+
+	var total int64
+	_, err = meter.Int64ObservableCounter("jobs.processed", metric.WithInt64Callback(
+		func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(total)
+			return nil
+		}))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	queue, _ := meter.Float64ObservableGauge("jobs.queued")
+	reg, err := meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		o.ObserveFloat64(queue, 7)
+		return nil
+	}, queue)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	total = 10
+	p.collect()
+	total = 15
+	p.collect()
+	if err := reg.Unregister(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	total = 16
+	p.collect()
+
+	data := inm.Data()[0]
+	for key, want := range map[string]struct {
+		count int
+		sum   float64
+	}{
+		"http.requests;route=/users": {2, 5},
+		"jobs.processed":             {3, 16},
+	} {
+		if c, ok := data.Counters[key]; !ok || c.Count != want.count || c.Sum != want.sum {
+			t.Fatalf("bad %s: %v", key, data.Counters)
+		}
+	}
+	if s, ok := data.Samples["http.duration;route=/users"]; !ok || s.Count != 2 || s.Sum != 2 {
+		t.Fatalf("bad samples: %v", data.Samples)
+	}
+	for key, value := range map[string]float32{
+		"http.in_flight;route=/users": 2,
+		"temperature":                 21.5,
+		"jobs.queued":                 7,
+	} {
+		if g, ok := data.Gauges[key]; !ok || g.Value != value {
+			t.Fatalf("bad %s: %v", key, data.Gauges)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// Package metricsotlp receives metrics pushed over OTLP/gRPC by other
+// processes, e.g. sidecars instrumented with OpenTelemetry, and emits them to
+// go-metrics.
+package metricsotlp
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-metrics"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+)
+
+// Opts configures the metrics emitted by NewReceiverWithOpts
+type Opts struct {
+	// ResourceLabels are the attributes of the resources, i.e. the
+	// processes, pushing the metrics which are added to their labels.
+	// Defaults to service.name.
+	ResourceLabels []string
+}
+
+var defaultResourceLabels = []string{"service.name"}
+
+// Receiver is an OTLP metrics service emitting the metrics it receives. The
+// name of a metric, split on ".", is the key, and the attributes of its data
+// points are labels:
+//
+//	gauges - gauges
+//	monotonic sums - counters of the increase since the previous export
+//	other sums - gauges of the sum
+//	histograms - counters of the increase of the count and sum of values,
+//	    <name>_count and <name>_sum, and of the cumulative counts of the
+//	    buckets, <name>_bucket labeled with le
+//	exponential histograms - the counters of <name>_count and <name>_sum
+//	summaries - gauges of the quantiles labeled with quantile, and the
+//	    counters of <name>_count and <name>_sum
+//
+// For example:
+//
+//	srv := grpc.NewServer()
+//	collectorpb.RegisterMetricsServiceServer(srv, metricsotlp.NewReceiver(metrics.Default()))
+//	go srv.Serve(lis)
+type Receiver struct {
+	collectorpb.UnimplementedMetricsServiceServer
+
+	m              *metrics.Metrics
+	resourceLabels []string
+
+	// last holds the last values of cumulative series, and the sums of
+	// non-monotonic delta sums
+	lock sync.Mutex
+	last map[string]float64
+}
+
+// NewReceiver returns a Receiver emitting to m.
+func NewReceiver(m *metrics.Metrics) *Receiver {
+	return NewReceiverWithOpts(m, Opts{})
+}
+
+// NewReceiverWithOpts is NewReceiver configured by opts.
+func NewReceiverWithOpts(m *metrics.Metrics, opts Opts) *Receiver {
+	resourceLabels := opts.ResourceLabels
+	if resourceLabels == nil {
+		resourceLabels = defaultResourceLabels
+	}
+	return &Receiver{
+		m:              m,
+		resourceLabels: resourceLabels,
+		last:           make(map[string]float64),
+	}
+}
+
+// Export emits the metrics of req.
+func (r *Receiver) Export(_ context.Context, req *collectorpb.ExportMetricsServiceRequest) (*collectorpb.ExportMetricsServiceResponse, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, rm := range req.GetResourceMetrics() {
+		var resourceLabels []metrics.Label
+		for _, kv := range rm.GetResource().GetAttributes() {
+			for _, name := range r.resourceLabels {
+				if kv.GetKey() == name {
+					resourceLabels = append(resourceLabels, metrics.Label{Name: name, Value: anyValueString(kv.GetValue())})
+				}
+			}
+		}
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, metric := range sm.GetMetrics() {
+				r.emit(metric, resourceLabels)
+			}
+		}
+	}
+	return &collectorpb.ExportMetricsServiceResponse{}, nil
+}
+
+func (r *Receiver) emit(metric *metricspb.Metric, resourceLabels []metrics.Label) {
+	key := strings.Split(metric.GetName(), ".")
+	labelsOf := func(attrs []*commonpb.KeyValue) []metrics.Label {
+		labels := make([]metrics.Label, 0, len(resourceLabels)+len(attrs)+1)
+		labels = append(labels, resourceLabels...)
+		for _, kv := range attrs {
+			labels = append(labels, metrics.Label{Name: kv.GetKey(), Value: anyValueString(kv.GetValue())})
+		}
+		return labels
+	}
+
+	switch {
+	case metric.GetGauge() != nil:
+		for _, dp := range metric.GetGauge().GetDataPoints() {
+			r.m.SetGaugeWithLabels(key, float32(numberValue(dp)), labelsOf(dp.GetAttributes()))
+		}
+	case metric.GetSum() != nil:
+		sum := metric.GetSum()
+		delta := sum.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+		for _, dp := range sum.GetDataPoints() {
+			labels := labelsOf(dp.GetAttributes())
+			switch {
+			case sum.GetIsMonotonic():
+				r.counter(key, numberValue(dp), delta, labels)
+			case delta:
+				id := seriesID(key, labels)
+				r.last[id] += numberValue(dp)
+				r.m.SetGaugeWithLabels(key, float32(r.last[id]), labels)
+			default:
+				r.m.SetGaugeWithLabels(key, float32(numberValue(dp)), labels)
+			}
+		}
+	case metric.GetHistogram() != nil:
+		h := metric.GetHistogram()
+		delta := h.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+		for _, dp := range h.GetDataPoints() {
+			labels := labelsOf(dp.GetAttributes())
+			var cumulative uint64
+			for i, bound := range dp.GetExplicitBounds() {
+				if i >= len(dp.GetBucketCounts()) {
+					break
+				}
+				cumulative += dp.GetBucketCounts()[i]
+				le := strconv.FormatFloat(bound, 'g', -1, 64)
+				r.counter(withSuffix(key, "_bucket"), float64(cumulative), delta,
+					append(labels[:len(labels):len(labels)], metrics.Label{Name: "le", Value: le}))
+			}
+			r.counter(withSuffix(key, "_count"), float64(dp.GetCount()), delta, labels)
+			r.counter(withSuffix(key, "_sum"), dp.GetSum(), delta, labels)
+		}
+	case metric.GetExponentialHistogram() != nil:
+		h := metric.GetExponentialHistogram()
+		delta := h.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+		for _, dp := range h.GetDataPoints() {
+			labels := labelsOf(dp.GetAttributes())
+			r.counter(withSuffix(key, "_count"), float64(dp.GetCount()), delta, labels)
+			r.counter(withSuffix(key, "_sum"), dp.GetSum(), delta, labels)
+		}
+	case metric.GetSummary() != nil:
+		for _, dp := range metric.GetSummary().GetDataPoints() {
+			labels := labelsOf(dp.GetAttributes())
+			for _, q := range dp.GetQuantileValues() {
+				quantile := strconv.FormatFloat(q.GetQuantile(), 'g', -1, 64)
+				r.m.SetGaugeWithLabels(key, float32(q.GetValue()),
+					append(labels[:len(labels):len(labels)], metrics.Label{Name: "quantile", Value: quantile}))
+			}
+			// Summaries are always cumulative
+			r.counter(withSuffix(key, "_count"), float64(dp.GetCount()), false, labels)
+			r.counter(withSuffix(key, "_sum"), dp.GetSum(), false, labels)
+		}
+	}
+}
+
+// counter emits the increase of a counter: value itself for delta
+// temporality, otherwise the increase since its previous export. Totals
+// which dropped were reset, e.g. by a restart of the process.
+func (r *Receiver) counter(key []string, value float64, delta bool, labels []metrics.Label) {
+	if !delta {
+		id := seriesID(key, labels)
+		total := value
+		if last, ok := r.last[id]; ok && total >= last {
+			value = total - last
+		}
+		r.last[id] = total
+	}
+	if value > 0 {
+		r.m.IncrCounterWithLabels(key, float32(value), labels)
+	}
+}
+
+// numberValue returns the value of dp
+func numberValue(dp *metricspb.NumberDataPoint) float64 {
+	if v, ok := dp.GetValue().(*metricspb.NumberDataPoint_AsInt); ok {
+		return float64(v.AsInt)
+	}
+	return dp.GetAsDouble()
+}
+
+// anyValueString returns the string of scalar attribute values. Other values
+// are empty.
+func anyValueString(v *commonpb.AnyValue) string {
+	switch v := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(v.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(v.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(v.DoubleValue, 'g', -1, 64)
+	}
+	return ""
+}
+
+// withSuffix returns a copy of key with suffix appended to its last part
+func withSuffix(key []string, suffix string) []string {
+	key = append([]string(nil), key...)
+	key[len(key)-1] += suffix
+	return key
+}
+
+// seriesID returns a string identifying the series of key with labels
+func seriesID(key []string, labels []metrics.Label) string {
+	var b strings.Builder
+	b.WriteString(strings.Join(key, "."))
+	for _, l := range labels {
+		b.WriteByte(0)
+		b.WriteString(l.Name)
+		b.WriteByte(0)
+		b.WriteString(l.Value)
+	}
+	return b.String()
+}
@@ -0,0 +1,104 @@
+package metricsotlp
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func stringKV(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+// request returns a request of the sidecar service with the cumulative
+// total of requests, the number in flight and a histogram of latencies
+func request(total int64, inflight float64, latencies []uint64, sum float64) *collectorpb.ExportMetricsServiceRequest {
+	attrs := []*commonpb.KeyValue{stringKV("method", "GET")}
+	var count uint64
+	for _, c := range latencies {
+		count += c
+	}
+	return &collectorpb.ExportMetricsServiceRequest{ResourceMetrics: []*metricspb.ResourceMetrics{{
+		Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{stringKV("service.name", "sidecar"), stringKV("host.name", "a")}},
+		ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{
+			{Name: "http.requests", Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+				IsMonotonic:            true,
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				DataPoints: []*metricspb.NumberDataPoint{{
+					Attributes: attrs, Value: &metricspb.NumberDataPoint_AsInt{AsInt: total},
+				}},
+			}}},
+			{Name: "http.inflight", Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+				DataPoints: []*metricspb.NumberDataPoint{{Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: inflight}}},
+			}}},
+			{Name: "http.latency", Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+				DataPoints: []*metricspb.HistogramDataPoint{{
+					Count: count, Sum: &sum, ExplicitBounds: []float64{10}, BucketCounts: latencies,
+				}},
+			}}},
+		}}},
+	}}}
+}
+
+func TestReceiver(t *testing.T) {
+	inm := metrics.NewInmemSink(time.Minute, time.Minute)
+	cfg := metrics.DefaultConfig("")
+	cfg.EnableHostname = false
+	cfg.EnableRuntimeMetrics = false
+	m, err := metrics.New(cfg, inm)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	collectorpb.RegisterMetricsServiceServer(srv, NewReceiver(m))
+	go srv.Serve(lis)
+	defer srv.Stop()
+	conn, err := grpc.Dial("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer conn.Close()
+	client := collectorpb.NewMetricsServiceClient(conn)
+
+	for _, req := range []*collectorpb.ExportMetricsServiceRequest{
+		request(3, 2, []uint64{1, 0}, 5),
+		request(5, 4, []uint64{1, 1}, 25),
+	} {
+		if _, err := client.Export(context.Background(), req); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	data := inm.Data()[0]
+	for key, want := range map[string]struct {
+		count int
+		sum   float64
+	}{
+		"http.requests;service.name=sidecar;method=GET":  {2, 5},
+		"http.latency_count;service.name=sidecar":        {2, 3},
+		"http.latency_sum;service.name=sidecar":          {2, 30},
+		"http.latency_bucket;service.name=sidecar;le=10": {2, 2},
+	} {
+		if c, ok := data.Counters[key]; !ok || c.Count != want.count || c.Sum != want.sum {
+			t.Fatalf("bad %s: %v", key, data.Counters)
+		}
+	}
+	if g, ok := data.Gauges["http.inflight;service.name=sidecar"]; !ok || g.Value != 4 {
+		t.Fatalf("bad gauges: %v", data.Gauges)
+	}
+}
@@ -0,0 +1,115 @@
+// Package metricsrcrowley republishes the metrics of registries of
+// github.com/rcrowley/go-metrics, for code migrating from it, or using
+// libraries instrumented with it such as sarama.
+package metricsrcrowley
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+	gometrics "github.com/rcrowley/go-metrics"
+)
+
+// Opts configures the metrics emitted by EmitWithOpts
+type Opts struct {
+	// Prefix is prepended to the keys of the metrics. Defaults to none.
+	Prefix []string
+
+	// Percentiles are the percentiles of histograms and timers emitted.
+	// Defaults to 0.5, 0.75, 0.95 and 0.99.
+	Percentiles []float64
+}
+
+var defaultPercentiles = []float64{0.5, 0.75, 0.95, 0.99}
+
+// Emit emits the current values of the metrics of r as gauges. The name of a
+// metric, split on ".", is the leading part of their keys:
+//
+//	<name>.count - counters, and the counts of histograms, meters and timers
+//	<name>.value - gauges
+//	<name>.min, <name>.max, <name>.mean, <name>.std_dev, <name>.p<percentile>
+//	    - the statistics of the samples of histograms, and of timers in
+//	    milliseconds, e.g. <name>.p99
+//	<name>.rate1, <name>.rate5, <name>.rate15, <name>.rate_mean - the rates
+//	    per second of meters and timers
+//
+// Health checks and other metrics are skipped.
+func Emit(m *metrics.Metrics, r gometrics.Registry) {
+	EmitWithOpts(m, r, Opts{})
+}
+
+// EmitWithOpts is Emit configured by opts.
+func EmitWithOpts(m *metrics.Metrics, r gometrics.Registry, opts Opts) {
+	emitRegistry(m, r, opts)
+}
+
+// Poll emits the metrics of r every interval, see EmitWithOpts and
+// metrics.Metrics.Poll. For example:
+//
+//	stop := metricsrcrowley.Poll(metrics.Default(), gometrics.DefaultRegistry, 10*time.Second, metricsrcrowley.Opts{})
+func Poll(m *metrics.Metrics, r gometrics.Registry, interval time.Duration, opts Opts) (stop func()) {
+	return m.Poll(interval, func(emit metrics.GaugeEmitter) {
+		emitRegistry(emit, r, opts)
+	})
+}
+
+func emitRegistry(emit metrics.GaugeEmitter, r gometrics.Registry, opts Opts) {
+	percentiles := opts.Percentiles
+	if percentiles == nil {
+		percentiles = defaultPercentiles
+	}
+	r.Each(func(name string, i interface{}) {
+		key := append(opts.Prefix[:len(opts.Prefix):len(opts.Prefix)], strings.Split(name, ".")...)
+		gauge := func(name string, value float64) {
+			emit.SetGauge(appendKey(key, name), float32(value))
+		}
+		switch metric := i.(type) {
+		case gometrics.Counter:
+			gauge("count", float64(metric.Count()))
+		case gometrics.Gauge:
+			gauge("value", float64(metric.Value()))
+		case gometrics.GaugeFloat64:
+			gauge("value", metric.Value())
+		case gometrics.Histogram:
+			h := metric.Snapshot()
+			gauge("count", float64(h.Count()))
+			emitSample(gauge, float64(h.Min()), float64(h.Max()), h.Mean(), h.StdDev(),
+				percentiles, h.Percentiles(percentiles), 1)
+		case gometrics.Meter:
+			s := metric.Snapshot()
+			gauge("count", float64(s.Count()))
+			emitRates(gauge, s.Rate1(), s.Rate5(), s.Rate15(), s.RateMean())
+		case gometrics.Timer:
+			t := metric.Snapshot()
+			gauge("count", float64(t.Count()))
+			emitSample(gauge, float64(t.Min()), float64(t.Max()), t.Mean(), t.StdDev(),
+				percentiles, t.Percentiles(percentiles), float64(time.Millisecond))
+			emitRates(gauge, t.Rate1(), t.Rate5(), t.Rate15(), t.RateMean())
+		}
+	})
+}
+
+// emitSample emits the statistics of a sample, divided by unit
+func emitSample(gauge func(string, float64), min, max, mean, stdDev float64, percentiles, values []float64, unit float64) {
+	gauge("min", min/unit)
+	gauge("max", max/unit)
+	gauge("mean", mean/unit)
+	gauge("std_dev", stdDev/unit)
+	for i, p := range percentiles {
+		gauge("p"+strings.Replace(strconv.FormatFloat(p*100, 'f', -1, 64), ".", "", 1), values[i]/unit)
+	}
+}
+
+func emitRates(gauge func(string, float64), rate1, rate5, rate15, rateMean float64) {
+	gauge("rate1", rate1)
+	gauge("rate5", rate5)
+	gauge("rate15", rate15)
+	gauge("rate_mean", rateMean)
+}
+
+// appendKey returns a copy of prefix with name appended
+func appendKey(prefix []string, name string) []string {
+	return append(prefix[:len(prefix):len(prefix)], name)
+}
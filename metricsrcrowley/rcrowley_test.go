@@ -0,0 +1,53 @@
+package metricsrcrowley
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+	gometrics "github.com/rcrowley/go-metrics"
+)
+
+func TestPoll(t *testing.T) {
+	inm := metrics.NewInmemSink(time.Minute, time.Minute)
+	cfg := metrics.DefaultConfig("")
+	cfg.EnableHostname = false
+	cfg.EnableRuntimeMetrics = false
+	m, err := metrics.New(cfg, inm)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	r := gometrics.NewRegistry()
+	gometrics.GetOrRegisterCounter("jobs.done", r).Inc(3)
+	gometrics.GetOrRegisterGaugeFloat64("ratio", r).Update(0.5)
+	h := gometrics.GetOrRegisterHistogram("batch", r, gometrics.NewUniformSample(100))
+	h.Update(10)
+	h.Update(20)
+	timer := gometrics.GetOrRegisterTimer("request", r)
+	timer.Update(2 * time.Millisecond)
+	timer.Update(4 * time.Millisecond)
+
+	Poll(m, r, time.Hour, Opts{Prefix: []string{"legacy"}, Percentiles: []float64{0.5, 0.999}})()
+
+	gauges := inm.Data()[0].Gauges
+	for key, value := range map[string]float32{
+		"legacy.jobs.done.count": 3,
+		"legacy.ratio.value":     0.5,
+		"legacy.batch.count":     2,
+		"legacy.batch.min":       10,
+		"legacy.batch.max":       20,
+		"legacy.batch.mean":      15,
+		"legacy.batch.p999":      20,
+		"legacy.request.count":   2,
+		"legacy.request.max":     4,
+		"legacy.request.p50":     3,
+	} {
+		if g, ok := gauges[key]; !ok || g.Value != value {
+			t.Fatalf("bad %s: %v", key, gauges)
+		}
+	}
+	if _, ok := gauges["legacy.request.rate1"]; !ok {
+		t.Fatalf("missing rates: %v", gauges)
+	}
+}
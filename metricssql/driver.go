@@ -0,0 +1,365 @@
+// Package metricssql instruments database/sql drivers and connection pools.
+package metricssql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+// DriverOpts configures the metrics emitted by WrapWithOpts
+type DriverOpts struct {
+	// Prefix is prepended to the keys of the metrics. Defaults to sql.
+	Prefix []string
+
+	// Labels are added to all metrics, e.g. the name of the database.
+	Labels []metrics.Label
+}
+
+// Wrap wraps d to emit the metrics of the statements run over its connections
+// to m:
+//
+//	sql.statement_duration - sample of the time taken by statements
+//	sql.errors - counter of the statements which failed
+//
+// Both are labeled with the operation, one of exec, query, prepare, begin,
+// commit or rollback. Queries last until their rows are returned, not until
+// the rows are read. For example:
+//
+//	sql.Register("postgres-metrics", metricssql.Wrap(metrics.Default(), &pq.Driver{}))
+//	db, err := sql.Open("postgres-metrics", dsn)
+//
+// Drivers relying on the deprecated driver.ColumnConverter have their
+// arguments converted by the defaults of database/sql instead.
+func Wrap(m *metrics.Metrics, d driver.Driver) driver.Driver {
+	return WrapWithOpts(m, d, DriverOpts{})
+}
+
+// WrapWithOpts is Wrap configured by opts.
+func WrapWithOpts(m *metrics.Metrics, d driver.Driver, opts DriverOpts) driver.Driver {
+	return &wrappedDriver{Driver: d, o: newObserver(m, opts)}
+}
+
+// WrapConnector is Wrap for drivers providing a driver.Connector, e.g.
+//
+//	db := sql.OpenDB(metricssql.WrapConnector(metrics.Default(), connector))
+func WrapConnector(m *metrics.Metrics, c driver.Connector) driver.Connector {
+	return WrapConnectorWithOpts(m, c, DriverOpts{})
+}
+
+// WrapConnectorWithOpts is WrapConnector configured by opts.
+func WrapConnectorWithOpts(m *metrics.Metrics, c driver.Connector, opts DriverOpts) driver.Connector {
+	o := newObserver(m, opts)
+	return &connector{
+		Connector: c,
+		driver:    &wrappedDriver{Driver: c.Driver(), o: o},
+		o:         o,
+	}
+}
+
+// observer emits the metrics of statements
+type observer struct {
+	m           *metrics.Metrics
+	labels      []metrics.Label
+	durationKey []string
+	errorsKey   []string
+}
+
+func newObserver(m *metrics.Metrics, opts DriverOpts) *observer {
+	prefix := opts.Prefix
+	if prefix == nil {
+		prefix = []string{"sql"}
+	}
+	return &observer{
+		m:           m,
+		labels:      opts.Labels,
+		durationKey: appendKey(prefix, "statement_duration"),
+		errorsKey:   appendKey(prefix, "errors"),
+	}
+}
+
+// observe emits the duration of an operation which returned err. Operations
+// skipped with driver.ErrSkip are retried another way by database/sql, so
+// they aren't counted.
+func (o *observer) observe(op string, start time.Time, err error) {
+	if err == driver.ErrSkip {
+		return
+	}
+	labels := append(o.labels[:len(o.labels):len(o.labels)], metrics.Label{Name: "operation", Value: op})
+	o.m.MeasureSinceWithLabels(o.durationKey, start, labels)
+	if err != nil {
+		o.m.IncrCounterWithLabels(o.errorsKey, 1, labels)
+	}
+}
+
+type wrappedDriver struct {
+	driver.Driver
+	o *observer
+}
+
+func (d *wrappedDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: c, o: d.o}, nil
+}
+
+// OpenConnector lets database/sql use the connector of the wrapped driver, if
+// it has one
+func (d *wrappedDriver) OpenConnector(name string) (driver.Connector, error) {
+	dc, ok := d.Driver.(driver.DriverContext)
+	if !ok {
+		return &dsnConnector{dsn: name, driver: d}, nil
+	}
+	c, err := dc.OpenConnector(name)
+	if err != nil {
+		return nil, err
+	}
+	return &connector{Connector: c, driver: d, o: d.o}, nil
+}
+
+// dsnConnector is the connector of drivers which don't have one
+type dsnConnector struct {
+	dsn    string
+	driver *wrappedDriver
+}
+
+func (c *dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+type connector struct {
+	driver.Connector
+	driver *wrappedDriver
+	o      *observer
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	dc, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: dc, o: c.o}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Close closes the wrapped connector when the sql.DB is closed
+func (c *connector) Close() error {
+	if closer, ok := c.Connector.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// conn implements the optional interfaces of driver.Conn, falling back to
+// what database/sql does for drivers which don't.
+type conn struct {
+	driver.Conn
+	o *observer
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	start := time.Now()
+	var s driver.Stmt
+	var err error
+	if cp, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		s, err = cp.PrepareContext(ctx, query)
+	} else {
+		s, err = c.Conn.Prepare(query)
+	}
+	c.o.observe("prepare", start, err)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{Stmt: s, conn: c.Conn, o: c.o}, nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	start := time.Now()
+	var t driver.Tx
+	var err error
+	if cb, ok := c.Conn.(driver.ConnBeginTx); ok {
+		t, err = cb.BeginTx(ctx, opts)
+	} else if opts.Isolation != driver.IsolationLevel(0) {
+		return nil, errors.New("sql: driver does not support non-default isolation level")
+	} else if opts.ReadOnly {
+		return nil, errors.New("sql: driver does not support read-only transactions")
+	} else {
+		t, err = c.Conn.Begin()
+	}
+	c.o.observe("begin", start, err)
+	if err != nil {
+		return nil, err
+	}
+	return &tx{Tx: t, o: c.o}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ec, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		// database/sql prepares the statement instead
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	r, err := ec.ExecContext(ctx, query, args)
+	c.o.observe("exec", start, err)
+	return r, err
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	qc, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	r, err := qc.QueryContext(ctx, query, args)
+	c.o.observe("query", start, err)
+	return r, err
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *conn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nvc, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return nvc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+type stmt struct {
+	driver.Stmt
+	conn driver.Conn
+	o    *observer
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	start := time.Now()
+	r, err := s.Stmt.Exec(args)
+	s.o.observe("exec", start, err)
+	return r, err
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	start := time.Now()
+	r, err := s.Stmt.Query(args)
+	s.o.observe("query", start, err)
+	return r, err
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	sc, ok := s.Stmt.(driver.StmtExecContext)
+	if !ok {
+		values, err := namedValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Exec(values)
+	}
+	start := time.Now()
+	r, err := sc.ExecContext(ctx, args)
+	s.o.observe("exec", start, err)
+	return r, err
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	sc, ok := s.Stmt.(driver.StmtQueryContext)
+	if !ok {
+		values, err := namedValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Query(values)
+	}
+	start := time.Now()
+	r, err := sc.QueryContext(ctx, args)
+	s.o.observe("query", start, err)
+	return r, err
+}
+
+// CheckNamedValue checks arguments like database/sql would: database/sql only
+// asks the connection if the statement can't check them.
+func (s *stmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if nvc, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return nvc.CheckNamedValue(nv)
+	}
+	if nvc, ok := s.conn.(driver.NamedValueChecker); ok {
+		return nvc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+type tx struct {
+	driver.Tx
+	o *observer
+}
+
+func (t *tx) Commit() error {
+	start := time.Now()
+	err := t.Tx.Commit()
+	t.o.observe("commit", start, err)
+	return err
+}
+
+func (t *tx) Rollback() error {
+	start := time.Now()
+	err := t.Tx.Rollback()
+	t.o.observe("rollback", start, err)
+	return err
+}
+
+// namedValues returns the values of args for drivers which don't support
+// named arguments
+func namedValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, errors.New("sql: driver does not support the use of Named Parameters")
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
+
+// appendKey returns a copy of prefix with name appended
+func appendKey(prefix []string, name string) []string {
+	return append(prefix[:len(prefix):len(prefix)], name)
+}
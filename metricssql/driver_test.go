@@ -0,0 +1,187 @@
+package metricssql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+// newTestMetrics returns Metrics emitting to the returned InmemSink without
+// any prefixes
+func newTestMetrics(t *testing.T) (*metrics.Metrics, *metrics.InmemSink) {
+	inm := metrics.NewInmemSink(time.Minute, time.Minute)
+	cfg := metrics.DefaultConfig("")
+	cfg.EnableHostname = false
+	cfg.EnableRuntimeMetrics = false
+	m, err := metrics.New(cfg, inm)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return m, inm
+}
+
+var errFail = errors.New("failed")
+
+// fakeDriver opens connections implementing only the required methods. With
+// context set, they implement ExecerContext and QueryerContext as well.
+type fakeDriver struct {
+	context bool
+}
+
+func (d fakeDriver) Open(string) (driver.Conn, error) {
+	if d.context {
+		return contextConn{}, nil
+	}
+	return fakeConn{}, nil
+}
+
+// fakeConnector connects to a fakeDriver
+type fakeConnector struct {
+	driver fakeDriver
+}
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return c.driver.Open("") }
+func (c fakeConnector) Driver() driver.Driver                        { return c.driver }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) {
+	if query == "invalid" {
+		return nil, errFail
+	}
+	return fakeStmt{query: query}, nil
+}
+
+func (fakeConn) Close() error              { return nil }
+func (fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+type contextConn struct {
+	fakeConn
+}
+
+func (contextConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	return fakeStmt{query: query}.Exec(nil)
+}
+
+func (contextConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	return fakeStmt{query: query}.Query(nil)
+}
+
+type fakeStmt struct {
+	query string
+}
+
+func (fakeStmt) Close() error  { return nil }
+func (fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	if s.query == "fail" {
+		return nil, errFail
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	if s.query == "fail" {
+		return nil, errFail
+	}
+	return &fakeRows{}, nil
+}
+
+// fakeRows returns a single row
+type fakeRows struct {
+	done bool
+}
+
+func (*fakeRows) Columns() []string { return []string{"n"} }
+func (*fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = int64(1)
+	return nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return errFail }
+
+func TestWrap(t *testing.T) {
+	for name, d := range map[string]fakeDriver{
+		"prepared": {},
+		"context":  {context: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			m, inm := newTestMetrics(t)
+			db := sql.OpenDB(WrapConnectorWithOpts(m, fakeConnector{d}, DriverOpts{
+				Labels: []metrics.Label{{Name: "db", Value: "test"}},
+			}))
+			defer db.Close()
+
+			if _, err := db.Exec("insert", 1); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if _, err := db.Exec("fail"); err != errFail {
+				t.Fatalf("bad err: %v", err)
+			}
+			var n int
+			if err := db.QueryRow("select", 1).Scan(&n); err != nil || n != 1 {
+				t.Fatalf("bad row: %d %v", n, err)
+			}
+			if _, err := db.Prepare("invalid"); err != errFail {
+				t.Fatalf("bad err: %v", err)
+			}
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			tx.Commit()
+			tx, _ = db.Begin()
+			tx.Rollback()
+
+			data := inm.Data()[0]
+			for op, count := range map[string]int{"exec": 2, "query": 1, "begin": 2, "commit": 1, "rollback": 1} {
+				key := "sql.statement_duration;db=test;operation=" + op
+				if s, ok := data.Samples[key]; !ok || s.Count != count {
+					t.Fatalf("bad %s: %v", key, data.Samples)
+				}
+			}
+			for op, count := range map[string]int{"exec": 1, "prepare": 1, "rollback": 1} {
+				key := "sql.errors;db=test;operation=" + op
+				if c, ok := data.Counters[key]; !ok || c.Count != count {
+					t.Fatalf("bad %s: %v", key, data.Counters)
+				}
+			}
+			if len(data.Counters) != 3 {
+				t.Fatalf("bad errors: %v", data.Counters)
+			}
+		})
+	}
+}
+
+func TestWrap_OpenConnector(t *testing.T) {
+	m, inm := newTestMetrics(t)
+	// This is what sql.Open does with registered drivers
+	c, err := Wrap(m, fakeDriver{context: true}).(driver.DriverContext).OpenConnector("")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+	if _, err := db.Exec("insert"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if s, ok := inm.Data()[0].Samples["sql.statement_duration;operation=exec"]; !ok || s.Count != 1 {
+		t.Fatalf("bad samples: %v", inm.Data()[0].Samples)
+	}
+}
@@ -0,0 +1,63 @@
+package metricssql
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+// EmitDBStats emits the statistics of the connection pool of db to m as
+// gauges labeled with labels:
+//
+//	sql.connections.max_open - the maximum number of open connections
+//	sql.connections.open - the connections open
+//	sql.connections.in_use - the connections in use
+//	sql.connections.idle - the idle connections
+//	sql.connections.wait_count - the total number of waits for a connection
+//	sql.connections.wait_duration_ms - the total time waited for connections
+//	sql.connections.max_idle_closed - the total number of connections closed
+//	    due to SetMaxIdleConns
+//	sql.connections.max_idle_time_closed - the total number of connections
+//	    closed due to SetConnMaxIdleTime
+//	sql.connections.max_lifetime_closed - the total number of connections
+//	    closed due to SetConnMaxLifetime
+func EmitDBStats(m *metrics.Metrics, db *sql.DB, labels []metrics.Label) {
+	stats := db.Stats()
+	gauge := func(name string, value float32) {
+		m.SetGaugeWithLabels([]string{"sql", "connections", name}, value, labels)
+	}
+	gauge("max_open", float32(stats.MaxOpenConnections))
+	gauge("open", float32(stats.OpenConnections))
+	gauge("in_use", float32(stats.InUse))
+	gauge("idle", float32(stats.Idle))
+	gauge("wait_count", float32(stats.WaitCount))
+	gauge("wait_duration_ms", float32(stats.WaitDuration.Seconds()*1000))
+	gauge("max_idle_closed", float32(stats.MaxIdleClosed))
+	gauge("max_idle_time_closed", float32(stats.MaxIdleTimeClosed))
+	gauge("max_lifetime_closed", float32(stats.MaxLifetimeClosed))
+}
+
+// EmitDBStatsEvery emits the statistics of db every interval, see
+// EmitDBStats, until the returned function is called to stop it.
+func EmitDBStatsEvery(m *metrics.Metrics, db *sql.DB, labels []metrics.Label, interval time.Duration) (stop func()) {
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			EmitDBStats(m, db, labels)
+			select {
+			case <-ticker.C:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stopCh)
+		<-doneCh
+	}
+}
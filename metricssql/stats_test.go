@@ -0,0 +1,36 @@
+package metricssql
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+func TestEmitDBStats(t *testing.T) {
+	m, inm := newTestMetrics(t)
+	db := sql.OpenDB(WrapConnector(m, fakeConnector{}))
+	defer db.Close()
+	db.SetMaxOpenConns(3)
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer conn.Close()
+	db.Ping()
+
+	labels := []metrics.Label{{Name: "db", Value: "test"}}
+	stop := EmitDBStatsEvery(m, db, labels, time.Hour)
+	stop()
+
+	gauges := inm.Data()[0].Gauges
+	for name, value := range map[string]float32{"max_open": 3, "open": 2, "in_use": 1, "idle": 1, "wait_count": 0} {
+		key := "sql.connections." + name + ";db=test"
+		if g, ok := gauges[key]; !ok || g.Value != value {
+			t.Fatalf("bad %s: %v", key, gauges)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+module github.com/hashicorp/go-metrics/metricstally
+
+go 1.21
+
+require (
+	github.com/hashicorp/go-metrics v0.5.4
+	github.com/uber-go/tally/v4 v4.1.16
+)
+
+require (
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/golang/mock v1.6.0 // indirect
+	github.com/hashicorp/go-immutable-radix v1.3.1 // indirect
+	github.com/hashicorp/golang-lru v0.5.4 // indirect
+	github.com/klauspost/compress v1.17.11 // indirect
+	github.com/twmb/murmur3 v1.1.5 // indirect
+	go.uber.org/atomic v1.9.0 // indirect
+	google.golang.org/protobuf v1.36.1 // indirect
+)
+
+replace github.com/hashicorp/go-metrics => ../
@@ -0,0 +1,132 @@
+// Package metricstally implements the Scope of tally with go-metrics, so
+// libraries instrumented with tally emit to the configured sinks.
+package metricstally
+
+import (
+	"sort"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+	"github.com/uber-go/tally/v4"
+)
+
+// NewScope returns a tally.Scope emitting to m without buffering:
+//
+//	Counter - counter
+//	Gauge - gauge
+//	Timer - sample of the durations in milliseconds
+//	Histogram - sample of the values, or of the durations in milliseconds
+//
+// The prefix and the names of sub-scopes are the leading parts of the keys,
+// and tags are labels. Buckets are left to the sinks, e.g. as configured with
+// SetHistogramBuckets. For example:
+//
+//	scope := metricstally.NewScope(metrics.Default(), "rpc")
+//	scope.Tagged(map[string]string{"method": "get"}).Counter("calls").Inc(1)
+func NewScope(m *metrics.Metrics, prefix ...string) tally.Scope {
+	return &scope{m: m, prefix: prefix}
+}
+
+type scope struct {
+	m      *metrics.Metrics
+	prefix []string
+	labels []metrics.Label
+}
+
+func (s *scope) key(name string) []string {
+	return append(s.prefix[:len(s.prefix):len(s.prefix)], name)
+}
+
+func (s *scope) Counter(name string) tally.Counter {
+	return &counter{m: s.m, key: s.key(name), labels: s.labels}
+}
+
+func (s *scope) Gauge(name string) tally.Gauge {
+	return &gauge{m: s.m, key: s.key(name), labels: s.labels}
+}
+
+func (s *scope) Timer(name string) tally.Timer {
+	return &sample{m: s.m, key: s.key(name), labels: s.labels}
+}
+
+func (s *scope) Histogram(name string, _ tally.Buckets) tally.Histogram {
+	return &sample{m: s.m, key: s.key(name), labels: s.labels}
+}
+
+// Tagged returns a scope with tags added to its labels, sorted by name. Tags
+// replace the labels of the same name.
+func (s *scope) Tagged(tags map[string]string) tally.Scope {
+	merged := make(map[string]string, len(s.labels)+len(tags))
+	for _, l := range s.labels {
+		merged[l.Name] = l.Value
+	}
+	for name, value := range tags {
+		merged[name] = value
+	}
+	labels := make([]metrics.Label, 0, len(merged))
+	for name, value := range merged {
+		labels = append(labels, metrics.Label{Name: name, Value: value})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+	return &scope{m: s.m, prefix: s.prefix, labels: labels}
+}
+
+func (s *scope) SubScope(name string) tally.Scope {
+	return &scope{m: s.m, prefix: s.key(name), labels: s.labels}
+}
+
+func (s *scope) Capabilities() tally.Capabilities {
+	return capabilities{}
+}
+
+type capabilities struct{}
+
+func (capabilities) Reporting() bool { return true }
+func (capabilities) Tagging() bool   { return true }
+
+type counter struct {
+	m      *metrics.Metrics
+	key    []string
+	labels []metrics.Label
+}
+
+func (c *counter) Inc(delta int64) {
+	c.m.IncrCounterWithLabels(c.key, float32(delta), c.labels)
+}
+
+type gauge struct {
+	m      *metrics.Metrics
+	key    []string
+	labels []metrics.Label
+}
+
+func (g *gauge) Update(value float64) {
+	g.m.SetGaugeWithLabels(g.key, float32(value), g.labels)
+}
+
+// sample implements both tally.Timer and tally.Histogram
+type sample struct {
+	m      *metrics.Metrics
+	key    []string
+	labels []metrics.Label
+}
+
+func (s *sample) Record(value time.Duration) {
+	s.RecordDuration(value)
+}
+
+func (s *sample) RecordValue(value float64) {
+	s.m.AddSampleWithLabels(s.key, float32(value), s.labels)
+}
+
+func (s *sample) RecordDuration(value time.Duration) {
+	s.m.AddSampleWithLabels(s.key, float32(value)/float32(time.Millisecond), s.labels)
+}
+
+func (s *sample) Start() tally.Stopwatch {
+	return tally.NewStopwatch(time.Now(), s)
+}
+
+func (s *sample) RecordStopwatch(start time.Time) {
+	s.m.MeasureSinceWithLabels(s.key, start, s.labels)
+}
@@ -0,0 +1,47 @@
+package metricstally
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+func TestScope(t *testing.T) {
+	inm := metrics.NewInmemSink(time.Minute, time.Minute)
+	cfg := metrics.DefaultConfig("")
+	cfg.EnableHostname = false
+	cfg.EnableRuntimeMetrics = false
+	m, err := metrics.New(cfg, inm)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	scope := NewScope(m, "rpc")
+	tagged := scope.Tagged(map[string]string{"method": "get", "zone": "a"}).Tagged(map[string]string{"zone": "b"})
+	tagged.Counter("calls").Inc(2)
+	tagged.Counter("calls").Inc(1)
+	scope.SubScope("pool").Gauge("size").Update(8)
+	scope.Timer("latency").Record(1500 * time.Microsecond)
+	scope.Histogram("size", nil).RecordValue(100)
+	scope.Timer("blocked").Start().Stop()
+
+	data := inm.Data()[0]
+	if c, ok := data.Counters["rpc.calls;method=get;zone=b"]; !ok || c.Count != 2 || c.Sum != 3 {
+		t.Fatalf("bad counters: %v", data.Counters)
+	}
+	if g, ok := data.Gauges["rpc.pool.size"]; !ok || g.Value != 8 {
+		t.Fatalf("bad gauges: %v", data.Gauges)
+	}
+	for key, sum := range map[string]float64{
+		"rpc.latency": 1.5,
+		"rpc.size":    100,
+	} {
+		if s, ok := data.Samples[key]; !ok || s.Count != 1 || s.Sum != sum {
+			t.Fatalf("bad %s: %v", key, data.Samples)
+		}
+	}
+	if s, ok := data.Samples["rpc.blocked"]; !ok || s.Count != 1 {
+		t.Fatalf("bad samples: %v", data.Samples)
+	}
+}
@@ -0,0 +1,116 @@
+package metricstest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+// Clock is a metrics.Clock whose time only moves when advanced, for
+// deterministic tests of timers and interval roll-over:
+//
+//	clock := metricstest.NewClock(time.Unix(0, 0))
+//	inm := metrics.NewInmemSink(10*time.Second, time.Minute)
+//	inm.SetClock(clock)
+//	...
+//	clock.Advance(10 * time.Second)
+//
+// A Clock is safe for concurrent use.
+type Clock struct {
+	lock   sync.Mutex
+	now    time.Time
+	timers []*timer
+}
+
+// timer delivers the time on ch at at, and every period after it if period
+// isn't 0
+type timer struct {
+	at     time.Time
+	period time.Duration
+	ch     chan time.Time
+	clock  *Clock
+}
+
+// NewClock returns a Clock at now.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the time of the clock.
+func (c *Clock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// After returns a channel receiving the time once the clock was advanced by
+// d.
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := &timer{at: c.now.Add(d), ch: make(chan time.Time, 1), clock: c}
+	if d <= 0 {
+		t.ch <- c.now
+		return t.ch
+	}
+	c.timers = append(c.timers, t)
+	return t.ch
+}
+
+// NewTicker returns a Ticker ticking every d the clock is advanced by. Like
+// those of time.Ticker, ticks are dropped while the previous one wasn't
+// received. It panics if d isn't positive.
+func (c *Clock) NewTicker(d time.Duration) metrics.Ticker {
+	if d <= 0 {
+		panic("metricstest: non-positive interval for NewTicker")
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := &timer{at: c.now.Add(d), period: d, ch: make(chan time.Time, 1), clock: c}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (t *timer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *timer) Stop() {
+	c := t.clock
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for n, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:n], c.timers[n+1:]...)
+			return
+		}
+	}
+}
+
+// Advance moves the clock forward by d, firing the timers and tickers due
+// in order, with the clock set to the time each of them is due.
+func (c *Clock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	end := c.now.Add(d)
+	for {
+		sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].at.Before(c.timers[j].at) })
+		if len(c.timers) == 0 || c.timers[0].at.After(end) {
+			break
+		}
+		t := c.timers[0]
+		c.now = t.at
+		select {
+		case t.ch <- t.at:
+		default:
+		}
+		if t.period > 0 {
+			t.at = t.at.Add(t.period)
+		} else {
+			c.timers = c.timers[1:]
+		}
+	}
+	c.now = end
+}
@@ -0,0 +1,97 @@
+package metricstest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+var _ metrics.Clock = &Clock{}
+
+func TestClock(t *testing.T) {
+	start := time.Unix(100, 0)
+	clock := NewClock(start)
+	ticker := clock.NewTicker(time.Second)
+	after := clock.After(1500 * time.Millisecond)
+
+	clock.Advance(999 * time.Millisecond)
+	select {
+	case <-ticker.C():
+		t.Fatalf("ticked early")
+	case <-after:
+		t.Fatalf("fired early")
+	default:
+	}
+
+	// The second tick is dropped as the first wasn't received
+	clock.Advance(2 * time.Second)
+	if got := <-ticker.C(); !got.Equal(start.Add(time.Second)) {
+		t.Fatalf("bad tick: %v", got)
+	}
+	if got := <-after; !got.Equal(start.Add(1500 * time.Millisecond)) {
+		t.Fatalf("bad after: %v", got)
+	}
+	select {
+	case got := <-ticker.C():
+		t.Fatalf("unexpected tick: %v", got)
+	default:
+	}
+	if got := clock.Now(); !got.Equal(start.Add(2999 * time.Millisecond)) {
+		t.Fatalf("bad now: %v", got)
+	}
+
+	ticker.Stop()
+	clock.Advance(time.Minute)
+	select {
+	case got := <-ticker.C():
+		t.Fatalf("tick after stop: %v", got)
+	default:
+	}
+}
+
+func TestClock_Metrics(t *testing.T) {
+	clock := NewClock(time.Unix(0, 0))
+	inm := metrics.NewInmemSink(10*time.Second, time.Minute)
+	inm.SetClock(clock)
+	cfg := metrics.DefaultConfig("")
+	cfg.EnableHostname = false
+	cfg.EnableRuntimeMetrics = false
+	cfg.Clock = clock
+	m, err := metrics.New(cfg, inm)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	start := m.Now()
+	clock.Advance(250 * time.Millisecond)
+	m.MeasureSince([]string{"op"}, start)
+	clock.Advance(10 * time.Second)
+	m.IncrCounter([]string{"requests"}, 1)
+
+	data := inm.Data()
+	if len(data) != 2 || !data[1].Interval.Equal(time.Unix(10, 0)) {
+		t.Fatalf("bad intervals: %v", data)
+	}
+	if s, ok := data[0].Samples["op"]; !ok || s.Sum != 250 {
+		t.Fatalf("bad samples: %v", data[0].Samples)
+	}
+	if c, ok := data[1].Counters["requests"]; !ok || c.Count != 1 {
+		t.Fatalf("bad counters: %v", data[1].Counters)
+	}
+}
+
+func TestClock_MeasureSinceWallClock(t *testing.T) {
+	clock := NewClock(time.Unix(0, 0))
+	m, s := New(t)
+	m.Clock = clock
+
+	// A start of time.Now is measured with the system clock, not against
+	// the time of the fake clock
+	start := time.Now()
+	clock.Advance(time.Hour)
+	m.MeasureSince([]string{"op"}, start)
+	if got := s.Samples("op"); len(got) != 1 || got[0] < 0 || got[0] > float64(time.Minute/time.Millisecond) {
+		t.Fatalf("bad samples: %v", got)
+	}
+}
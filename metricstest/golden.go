@@ -0,0 +1,98 @@
+package metricstest
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("metricstest.update", false, "update the golden files of AssertGolden")
+
+// Golden returns a normalized text representation of the metrics received so
+// far, one line per series sorted by kind, name and labels, with the labels
+// sorted by name:
+//
+//	counter <name>;<label>=<value>... <sum>
+//	gauge <name>;<label>=<value>... <last value>
+//	key <name> count=<points>
+//	sample <name>;<label>=<value>... count=<samples>
+//
+// Samples and points from EmitKey are represented by their number only, as
+// their values, e.g. durations, often vary between runs.
+func (s *Sink) Golden() string {
+	type series struct {
+		kind  Kind
+		name  string
+		value float64
+		count int
+	}
+	bySig := make(map[string]*series)
+	for _, e := range s.Emissions() {
+		labels := append(e.Labels[:0:0], e.Labels...)
+		sort.SliceStable(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+		var b strings.Builder
+		b.WriteString(e.Name)
+		for _, l := range labels {
+			fmt.Fprintf(&b, ";%s=%s", l.Name, l.Value)
+		}
+		name := b.String()
+		sig := e.Kind.String() + " " + name
+		ser, ok := bySig[sig]
+		if !ok {
+			ser = &series{kind: e.Kind, name: name}
+			bySig[sig] = ser
+		}
+		ser.count++
+		switch e.Kind {
+		case Counter:
+			ser.value += e.Value
+		case Gauge:
+			ser.value = e.Value
+		}
+	}
+
+	lines := make([]string, 0, len(bySig))
+	for sig, ser := range bySig {
+		switch ser.kind {
+		case Counter, Gauge:
+			lines = append(lines, fmt.Sprintf("%s %g", sig, ser.value))
+		default:
+			lines = append(lines, fmt.Sprintf("%s count=%d", sig, ser.count))
+		}
+	}
+	sort.Strings(lines)
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// AssertGolden fails the test unless Golden matches the contents of the
+// golden file at path, e.g. testdata/checkout.golden. Running the tests with
+// -metricstest.update writes the file instead:
+//
+//	go test ./... -metricstest.update
+func (s *Sink) AssertGolden(t testing.TB, path string) {
+	t.Helper()
+	got := s.Golden()
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("metricstest: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatalf("metricstest: %v", err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("metricstest: %v; run with -metricstest.update to create it", err)
+	}
+	if got != string(want) {
+		t.Errorf("metrics differ from %s, run with -metricstest.update to update it:\ngot:\n%swant:\n%s", path, got, want)
+	}
+}
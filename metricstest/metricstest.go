@@ -0,0 +1,299 @@
+// Package metricstest provides a sink recording the metrics it receives,
+// with assertions for unit tests of instrumented code.
+package metricstest
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+// Kind is the kind of an Emission
+type Kind int
+
+const (
+	Gauge Kind = iota
+	Key
+	Counter
+	Sample
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Gauge:
+		return "gauge"
+	case Key:
+		return "key"
+	case Counter:
+		return "counter"
+	case Sample:
+		return "sample"
+	default:
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+}
+
+// Emission is a metric received by a Sink
+type Emission struct {
+	Kind Kind
+
+	// Key is the key of the metric, and Name the key joined by "."
+	Key  []string
+	Name string
+
+	Value  float64
+	Labels []metrics.Label
+}
+
+func (e Emission) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s", e.Kind, e.Name)
+	for _, l := range e.Labels {
+		fmt.Fprintf(&b, ";%s=%s", l.Name, l.Value)
+	}
+	fmt.Fprintf(&b, " %g", e.Value)
+	return b.String()
+}
+
+// Matcher selects emissions by their labels
+type Matcher func(labels []metrics.Label) bool
+
+// WithLabel matches emissions with the label name set to value.
+func WithLabel(name, value string) Matcher {
+	return func(labels []metrics.Label) bool {
+		for _, l := range labels {
+			if l.Name == name {
+				return l.Value == value
+			}
+		}
+		return false
+	}
+}
+
+// WithoutLabel matches emissions without the label name.
+func WithoutLabel(name string) Matcher {
+	return func(labels []metrics.Label) bool {
+		for _, l := range labels {
+			if l.Name == name {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// WithLabels matches emissions with exactly labels, in any order.
+func WithLabels(labels ...metrics.Label) Matcher {
+	return func(got []metrics.Label) bool {
+		if len(got) != len(labels) {
+			return false
+		}
+		for _, l := range labels {
+			if !WithLabel(l.Name, l.Value)(got) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Sink is a metrics.MetricSink recording every metric it receives, in
+// order. Names are the keys as received by the sink, i.e. including the
+// service name and hostname if the metrics.Config adds them, see New.
+// A Sink is safe for concurrent use.
+type Sink struct {
+	lock      sync.Mutex
+	emissions []Emission
+}
+
+// NewSink returns an empty Sink.
+func NewSink() *Sink {
+	return &Sink{}
+}
+
+// New returns a metrics.Metrics emitting to a new Sink, without a service
+// name, hostname or runtime metrics, so names are the keys as emitted.
+func New(t testing.TB) (*metrics.Metrics, *Sink) {
+	t.Helper()
+	s := NewSink()
+	cfg := metrics.DefaultConfig("")
+	cfg.EnableHostname = false
+	cfg.EnableRuntimeMetrics = false
+	m, err := metrics.New(cfg, s)
+	if err != nil {
+		t.Fatalf("metricstest: %v", err)
+	}
+	return m, s
+}
+
+func (s *Sink) record(kind Kind, key []string, val float64, labels []metrics.Label) {
+	e := Emission{
+		Kind:   kind,
+		Key:    append([]string(nil), key...),
+		Name:   strings.Join(key, "."),
+		Value:  val,
+		Labels: append([]metrics.Label(nil), labels...),
+	}
+	s.lock.Lock()
+	s.emissions = append(s.emissions, e)
+	s.lock.Unlock()
+}
+
+func (s *Sink) SetGauge(key []string, val float32) {
+	s.record(Gauge, key, float64(val), nil)
+}
+
+func (s *Sink) SetGaugeWithLabels(key []string, val float32, labels []metrics.Label) {
+	s.record(Gauge, key, float64(val), labels)
+}
+
+func (s *Sink) EmitKey(key []string, val float32) {
+	s.record(Key, key, float64(val), nil)
+}
+
+func (s *Sink) IncrCounter(key []string, val float32) {
+	s.record(Counter, key, float64(val), nil)
+}
+
+func (s *Sink) IncrCounterWithLabels(key []string, val float32, labels []metrics.Label) {
+	s.record(Counter, key, float64(val), labels)
+}
+
+func (s *Sink) AddSample(key []string, val float32) {
+	s.record(Sample, key, float64(val), nil)
+}
+
+func (s *Sink) AddSampleWithLabels(key []string, val float32, labels []metrics.Label) {
+	s.record(Sample, key, float64(val), labels)
+}
+
+func (s *Sink) AddPrecisionSample(key []string, val float64) {
+	s.record(Sample, key, val, nil)
+}
+
+func (s *Sink) AddPrecisionSampleWithLabels(key []string, val float64, labels []metrics.Label) {
+	s.record(Sample, key, val, labels)
+}
+
+// Emissions returns the metrics received so far, in order.
+func (s *Sink) Emissions() []Emission {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Emission(nil), s.emissions...)
+}
+
+// Reset forgets the metrics received so far.
+func (s *Sink) Reset() {
+	s.lock.Lock()
+	s.emissions = nil
+	s.lock.Unlock()
+}
+
+// Find returns the emissions of kind named name with labels matching all
+// matchers, in order.
+func (s *Sink) Find(kind Kind, name string, matchers ...Matcher) []Emission {
+	var found []Emission
+	for _, e := range s.Emissions() {
+		if e.Kind != kind || e.Name != name {
+			continue
+		}
+		matches := true
+		for _, m := range matchers {
+			if !m(e.Labels) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+// Counter returns the sum of the increments of the counter name, across all
+// its labels matching matchers.
+func (s *Sink) Counter(name string, matchers ...Matcher) float64 {
+	var sum float64
+	for _, e := range s.Find(Counter, name, matchers...) {
+		sum += e.Value
+	}
+	return sum
+}
+
+// Gauge returns the last value of the gauge name with labels matching
+// matchers, and whether it was set at all.
+func (s *Sink) Gauge(name string, matchers ...Matcher) (float64, bool) {
+	found := s.Find(Gauge, name, matchers...)
+	if len(found) == 0 {
+		return 0, false
+	}
+	return found[len(found)-1].Value, true
+}
+
+// Samples returns the values of the samples name with labels matching
+// matchers, in order.
+func (s *Sink) Samples(name string, matchers ...Matcher) []float64 {
+	var values []float64
+	for _, e := range s.Find(Sample, name, matchers...) {
+		values = append(values, e.Value)
+	}
+	return values
+}
+
+// AssertCounterEquals fails the test unless the counter name, with labels
+// matching matchers, was incremented by want in total. As values are
+// received as float32, want is compared with that precision.
+func (s *Sink) AssertCounterEquals(t testing.TB, name string, want float64, matchers ...Matcher) {
+	t.Helper()
+	if got := s.Counter(name, matchers...); float32(got) != float32(want) {
+		t.Errorf("counter %s is %g, want %g; received:\n%s", name, got, want, s)
+	}
+}
+
+// AssertGaugeEquals fails the test unless the gauge name, with labels
+// matching matchers, was last set to want.
+func (s *Sink) AssertGaugeEquals(t testing.TB, name string, want float64, matchers ...Matcher) {
+	t.Helper()
+	got, ok := s.Gauge(name, matchers...)
+	if !ok {
+		t.Errorf("gauge %s was not set; received:\n%s", name, s)
+	} else if float32(got) != float32(want) {
+		t.Errorf("gauge %s is %g, want %g; received:\n%s", name, got, want, s)
+	}
+}
+
+// AssertSampleCount fails the test unless want samples name, with labels
+// matching matchers, were added.
+func (s *Sink) AssertSampleCount(t testing.TB, name string, want int, matchers ...Matcher) {
+	t.Helper()
+	if got := len(s.Samples(name, matchers...)); got != want {
+		t.Errorf("sample %s was added %d times, want %d; received:\n%s", name, got, want, s)
+	}
+}
+
+// AssertNotEmitted fails the test if any metric named name, with labels
+// matching matchers, was received.
+func (s *Sink) AssertNotEmitted(t testing.TB, name string, matchers ...Matcher) {
+	t.Helper()
+	for _, kind := range []Kind{Gauge, Key, Counter, Sample} {
+		if found := s.Find(kind, name, matchers...); len(found) > 0 {
+			t.Errorf("%s was emitted: %v", name, found)
+			return
+		}
+	}
+}
+
+// String returns the metrics received so far, one per line.
+func (s *Sink) String() string {
+	var b strings.Builder
+	for _, e := range s.Emissions() {
+		b.WriteString("\t")
+		b.WriteString(e.String())
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,79 @@
+package metricstest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+var _ metrics.PrecisionSampleSink = &Sink{}
+
+// recordingT records the errors of assertions instead of failing the test
+type recordingT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *recordingT) Helper() {}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestSink(t *testing.T) {
+	m, s := New(t)
+	m.IncrCounterWithLabels([]string{"api", "requests"}, 1, []metrics.Label{{Name: "method", Value: "GET"}})
+	m.IncrCounterWithLabels([]string{"api", "requests"}, 2, []metrics.Label{{Name: "method", Value: "POST"}})
+	m.SetGauge([]string{"api", "inflight"}, 3)
+	m.SetGauge([]string{"api", "inflight"}, 1)
+	m.MeasureSinceWithLabels([]string{"api", "latency"}, time.Now(), []metrics.Label{{Name: "method", Value: "GET"}})
+	m.EmitKey([]string{"api", "key"}, 4)
+
+	s.AssertCounterEquals(t, "api.requests", 3)
+	s.AssertCounterEquals(t, "api.requests", 2, WithLabel("method", "POST"))
+	s.AssertCounterEquals(t, "api.requests", 1, WithLabels(metrics.Label{Name: "method", Value: "GET"}))
+	s.AssertGaugeEquals(t, "api.inflight", 1, WithoutLabel("method"))
+	s.AssertSampleCount(t, "api.latency", 1, WithLabel("method", "GET"))
+	s.AssertNotEmitted(t, "api.errors")
+	if got := s.Find(Key, "api.key"); len(got) != 1 || got[0].Value != 4 {
+		t.Fatalf("bad keys: %v", got)
+	}
+
+	rt := &recordingT{TB: t}
+	s.AssertCounterEquals(rt, "api.requests", 4)
+	s.AssertGaugeEquals(rt, "api.missing", 1)
+	s.AssertSampleCount(rt, "api.latency", 1, WithLabel("method", "POST"))
+	s.AssertNotEmitted(rt, "api.inflight")
+	if len(rt.errors) != 4 || !strings.HasPrefix(rt.errors[0], "counter api.requests is 3, want 4") ||
+		!strings.Contains(rt.errors[0], "\tcounter api.requests;method=GET 1\n") {
+		t.Fatalf("bad errors: %q", rt.errors)
+	}
+
+	s.Reset()
+	if len(s.Emissions()) != 0 {
+		t.Fatalf("emissions after reset: %v", s.Emissions())
+	}
+}
+
+func TestSink_Golden(t *testing.T) {
+	m, s := New(t)
+	m.IncrCounterWithLabels([]string{"checkout", "items"}, 2, []metrics.Label{{Name: "step", Value: "cart"}, {Name: "region", Value: "eu"}})
+	m.IncrCounterWithLabels([]string{"checkout", "items"}, 1, []metrics.Label{{Name: "region", Value: "eu"}, {Name: "step", Value: "cart"}})
+	m.SetGauge([]string{"checkout", "open"}, 5)
+	m.SetGauge([]string{"checkout", "open"}, 4)
+	m.MeasureSince([]string{"checkout", "latency"}, time.Now())
+	m.MeasureSince([]string{"checkout", "latency"}, time.Now())
+	m.EmitKey([]string{"checkout", "debug"}, 7)
+
+	s.AssertGolden(t, "testdata/checkout.golden")
+
+	rt := &recordingT{TB: t}
+	m.SetGauge([]string{"checkout", "open"}, 3)
+	s.AssertGolden(rt, "testdata/checkout.golden")
+	if len(rt.errors) != 1 || !strings.Contains(rt.errors[0], "gauge checkout.open 3\n") {
+		t.Fatalf("bad errors: %q", rt.errors)
+	}
+}
@@ -0,0 +1,185 @@
+package metricstest
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics/statsdproto"
+)
+
+// StatsdServer is a fake statsd or statsite server recording the lines it
+// receives, to integration test the network sinks:
+//
+//	srv := metricstest.NewStatsdServer(t, "udp")
+//	sink, _ := metrics.NewStatsdSink(srv.Addr())
+//	...
+//	lines := srv.WaitForLines(t, 3)
+//
+// Malformed lines fail the test.
+type StatsdServer struct {
+	t        testing.TB
+	packet   net.PacketConn
+	listener net.Listener
+
+	lock   sync.Mutex
+	lines  []statsdproto.Line
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// StatsdServerTimeout is how long WaitForLines waits for lines
+var StatsdServerTimeout = 5 * time.Second
+
+// NewStatsdServer returns a StatsdServer listening on an ephemeral port of
+// 127.0.0.1, over udp like statsd or tcp like statsite. It is closed when the
+// test and its subtests completed.
+func NewStatsdServer(t testing.TB, network string) *StatsdServer {
+	t.Helper()
+	s := &StatsdServer{t: t, conns: make(map[net.Conn]struct{})}
+	switch network {
+	case "udp":
+		packet, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("metricstest: %v", err)
+		}
+		s.packet = packet
+		s.wg.Add(1)
+		go s.readPackets()
+	case "tcp":
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("metricstest: %v", err)
+		}
+		s.listener = listener
+		s.wg.Add(1)
+		go s.accept()
+	default:
+		t.Fatalf("metricstest: unsupported network %q", network)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Addr returns the address the server listens on, e.g. 127.0.0.1:41234.
+func (s *StatsdServer) Addr() string {
+	if s.packet != nil {
+		return s.packet.LocalAddr().String()
+	}
+	return s.listener.Addr().String()
+}
+
+// Close stops the server and waits for the lines received to be recorded.
+func (s *StatsdServer) Close() {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return
+	}
+	s.closed = true
+	if s.packet != nil {
+		s.packet.Close()
+	} else {
+		s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.lock.Unlock()
+	s.wg.Wait()
+}
+
+// Lines returns the lines received so far, in order.
+func (s *StatsdServer) Lines() []statsdproto.Line {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]statsdproto.Line(nil), s.lines...)
+}
+
+// Reset forgets the lines received so far.
+func (s *StatsdServer) Reset() {
+	s.lock.Lock()
+	s.lines = nil
+	s.lock.Unlock()
+}
+
+// WaitForLines waits until at least n lines were received and returns them,
+// failing the test once StatsdServerTimeout passed.
+func (s *StatsdServer) WaitForLines(t testing.TB, n int) []statsdproto.Line {
+	t.Helper()
+	deadline := time.Now().Add(StatsdServerTimeout)
+	for {
+		lines := s.Lines()
+		if len(lines) >= n {
+			return lines
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("metricstest: received %d statsd lines, want %d: %v", len(lines), n, lines)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (s *StatsdServer) readPackets() {
+	defer s.wg.Done()
+	buf := make([]byte, 65535)
+	for {
+		n, _, err := s.packet.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		for _, line := range bytes.Split(buf[:n], []byte{'\n'}) {
+			s.record(string(line))
+		}
+	}
+}
+
+func (s *StatsdServer) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.lock.Lock()
+		if s.closed {
+			s.lock.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.lock.Unlock()
+		go s.readConn(conn)
+	}
+}
+
+func (s *StatsdServer) readConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		s.record(scanner.Text())
+	}
+}
+
+// record records a line, or fails the test if it's malformed
+func (s *StatsdServer) record(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	l, err := statsdproto.Parse(line)
+	if err != nil {
+		s.t.Errorf("metricstest: malformed statsd line %q: %v", line, err)
+		return
+	}
+	s.lock.Lock()
+	s.lines = append(s.lines, l)
+	s.lock.Unlock()
+}
@@ -0,0 +1,48 @@
+package metricstest
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-metrics"
+	"github.com/hashicorp/go-metrics/statsdproto"
+)
+
+func TestStatsdServer(t *testing.T) {
+	for _, tc := range []struct {
+		network string
+		newSink func(addr string) (metrics.ShutdownSink, error)
+	}{
+		{"udp", func(addr string) (metrics.ShutdownSink, error) { return metrics.NewStatsdSink(addr) }},
+		{"tcp", func(addr string) (metrics.ShutdownSink, error) { return metrics.NewStatsiteSink(addr) }},
+	} {
+		t.Run(tc.network, func(t *testing.T) {
+			srv := NewStatsdServer(t, tc.network)
+			sink, err := tc.newSink(srv.Addr())
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			// The sinks drop what they didn't flush yet when shut down
+			defer sink.Shutdown()
+			sink.IncrCounter([]string{"api", "requests"}, 2)
+			sink.SetGauge([]string{"api", "inflight"}, 3)
+			sink.AddSample([]string{"api", "latency"}, 1.5)
+
+			lines := srv.WaitForLines(t, 3)
+			want := []statsdproto.Line{
+				{Name: "api.requests", Type: statsdproto.Counter, Value: 2},
+				{Name: "api.inflight", Type: statsdproto.Gauge, Value: 3},
+				{Name: "api.latency", Type: statsdproto.Timer, Value: 1.5},
+			}
+			for i, l := range want {
+				if lines[i].String() != l.String() {
+					t.Fatalf("line %d is %s, want %s", i, lines[i], l)
+				}
+			}
+
+			srv.Reset()
+			if len(srv.Lines()) != 0 {
+				t.Fatalf("lines after reset: %v", srv.Lines())
+			}
+		})
+	}
+}
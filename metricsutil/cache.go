@@ -0,0 +1,56 @@
+package metricsutil
+
+import (
+	"github.com/hashicorp/go-metrics"
+)
+
+// Cache emits the metrics of a cache, labeled with the name of the cache:
+//
+//	cache.hits - counter of the lookups which found an entry
+//	cache.misses - counter of the lookups which found none
+//	cache.evictions - counter of the entries evicted
+//	cache.size - gauge of the entries in the cache
+//
+// The hit ratio is hits / (hits + misses). A Cache is safe for concurrent
+// use.
+type Cache struct {
+	m      *metrics.Metrics
+	labels []metrics.Label
+}
+
+// NewCache returns a Cache emitting to m, labeled with cache=name and labels.
+func NewCache(m *metrics.Metrics, name string, labels ...metrics.Label) *Cache {
+	return &Cache{m: m, labels: withName("cache", name, labels)}
+}
+
+// Hit records a lookup which found an entry.
+func (c *Cache) Hit() {
+	c.m.IncrCounterWithLabels([]string{"cache", "hits"}, 1, c.labels)
+}
+
+// Miss records a lookup which found no entry.
+func (c *Cache) Miss() {
+	c.m.IncrCounterWithLabels([]string{"cache", "misses"}, 1, c.labels)
+}
+
+// Lookup records a hit if found is true and a miss otherwise, e.g.
+//
+//	v, ok := entries[key]
+//	cache.Lookup(ok)
+func (c *Cache) Lookup(found bool) {
+	if found {
+		c.Hit()
+	} else {
+		c.Miss()
+	}
+}
+
+// Evicted records that n entries were evicted.
+func (c *Cache) Evicted(n int) {
+	c.m.IncrCounterWithLabels([]string{"cache", "evictions"}, float32(n), c.labels)
+}
+
+// SetSize emits the number of entries in the cache.
+func (c *Cache) SetSize(n int) {
+	c.m.SetGaugeWithLabels([]string{"cache", "size"}, float32(n), c.labels)
+}
@@ -0,0 +1,107 @@
+package metricsutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+// newTestMetrics returns Metrics emitting to the returned InmemSink without
+// any prefixes
+func newTestMetrics(t *testing.T) (*metrics.Metrics, *metrics.InmemSink) {
+	inm := metrics.NewInmemSink(time.Minute, time.Minute)
+	cfg := metrics.DefaultConfig("")
+	cfg.EnableHostname = false
+	cfg.EnableRuntimeMetrics = false
+	m, err := metrics.New(cfg, inm)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return m, inm
+}
+
+func TestPool(t *testing.T) {
+	m, inm := newTestMetrics(t)
+	p := NewPool(m, "workers", metrics.Label{Name: "region", Value: "eu"})
+	p.SetSize(4)
+	start := time.Now()
+	p.Acquired(start)
+	p.Acquired(start)
+	p.Released()
+	p.TimedOut(start)
+
+	data := inm.Data()[0]
+	const labels = ";pool=workers;region=eu"
+	if g := data.Gauges["pool.size"+labels]; g.Value != 4 {
+		t.Fatalf("bad size: %v", data.Gauges)
+	}
+	if g := data.Gauges["pool.in_use"+labels]; g.Value != 1 {
+		t.Fatalf("bad in_use: %v", data.Gauges)
+	}
+	if s, ok := data.Samples["pool.wait_duration"+labels]; !ok || s.Count != 3 {
+		t.Fatalf("bad wait_duration: %v", data.Samples)
+	}
+	if c, ok := data.Counters["pool.timeouts"+labels]; !ok || c.Count != 1 {
+		t.Fatalf("bad timeouts: %v", data.Counters)
+	}
+
+	p.SetInUse(3)
+	p.Released()
+	if g := inm.Data()[0].Gauges["pool.in_use"+labels]; g.Value != 2 {
+		t.Fatalf("bad in_use: %v", g)
+	}
+}
+
+func TestCache(t *testing.T) {
+	m, inm := newTestMetrics(t)
+	c := NewCache(m, "users")
+	c.Lookup(true)
+	c.Lookup(false)
+	c.Hit()
+	c.Evicted(5)
+	c.SetSize(10)
+
+	data := inm.Data()[0]
+	for key, sum := range map[string]float64{
+		"cache.hits;cache=users":      2,
+		"cache.misses;cache=users":    1,
+		"cache.evictions;cache=users": 5,
+	} {
+		if c, ok := data.Counters[key]; !ok || c.Sum != sum {
+			t.Fatalf("bad %s: %v", key, data.Counters)
+		}
+	}
+	if g := data.Gauges["cache.size;cache=users"]; g.Value != 10 {
+		t.Fatalf("bad size: %v", data.Gauges)
+	}
+}
+
+// fakeQueue is an unbounded Queue
+type fakeQueue struct{}
+
+func (fakeQueue) Len() int { return 7 }
+func (fakeQueue) Cap() int { return 0 }
+
+func TestPollQueue(t *testing.T) {
+	m, inm := newTestMetrics(t)
+	jobs := make(chan int, 4)
+	jobs <- 1
+	PollQueue(m, "jobs", Chan(jobs), time.Hour)()
+	PollQueue(m, "events", fakeQueue{}, time.Hour, metrics.Label{Name: "region", Value: "eu"})()
+
+	gauges := inm.Data()[0].Gauges
+	for key, value := range map[string]float32{
+		"queue.depth;queue=jobs":             1,
+		"queue.capacity;queue=jobs":          4,
+		"queue.saturation;queue=jobs":        0.25,
+		"queue.depth;queue=events;region=eu": 7,
+	} {
+		if g, ok := gauges[key]; !ok || g.Value != value {
+			t.Fatalf("bad %s: %v", key, gauges)
+		}
+	}
+	if _, ok := gauges["queue.capacity;queue=events;region=eu"]; ok {
+		t.Fatalf("unbounded queue has a capacity: %v", gauges)
+	}
+}
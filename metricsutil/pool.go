@@ -0,0 +1,70 @@
+// Package metricsutil provides helpers emitting the metrics of common
+// resources, such as pools and caches, with consistent names, so libraries
+// embedding go-metrics report them alike.
+package metricsutil
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+// Pool emits the metrics of a pool of resources, such as connections or
+// workers, labeled with the name of the pool:
+//
+//	pool.size - gauge of the resources in the pool
+//	pool.in_use - gauge of the resources acquired from the pool
+//	pool.wait_duration - sample of the time waited to acquire resources
+//	pool.timeouts - counter of the acquisitions which timed out
+//
+// A Pool is safe for concurrent use.
+type Pool struct {
+	m      *metrics.Metrics
+	labels []metrics.Label
+	inUse  int64
+}
+
+// NewPool returns a Pool emitting to m, labeled with pool=name and labels.
+func NewPool(m *metrics.Metrics, name string, labels ...metrics.Label) *Pool {
+	return &Pool{m: m, labels: withName("pool", name, labels)}
+}
+
+// SetSize emits the number of resources in the pool.
+func (p *Pool) SetSize(n int) {
+	p.m.SetGaugeWithLabels([]string{"pool", "size"}, float32(n), p.labels)
+}
+
+// SetInUse emits the number of resources acquired from the pool, for pools
+// which count them themselves. Otherwise use Acquired and Released.
+func (p *Pool) SetInUse(n int) {
+	atomic.StoreInt64(&p.inUse, int64(n))
+	p.setInUse(n)
+}
+
+// Acquired records that a resource was acquired after waiting since start.
+func (p *Pool) Acquired(start time.Time) {
+	p.m.MeasureSinceWithLabels([]string{"pool", "wait_duration"}, start, p.labels)
+	p.setInUse(int(atomic.AddInt64(&p.inUse, 1)))
+}
+
+// Released records that a resource was returned to the pool.
+func (p *Pool) Released() {
+	p.setInUse(int(atomic.AddInt64(&p.inUse, -1)))
+}
+
+// TimedOut records that acquiring a resource timed out after waiting since
+// start.
+func (p *Pool) TimedOut(start time.Time) {
+	p.m.MeasureSinceWithLabels([]string{"pool", "wait_duration"}, start, p.labels)
+	p.m.IncrCounterWithLabels([]string{"pool", "timeouts"}, 1, p.labels)
+}
+
+func (p *Pool) setInUse(n int) {
+	p.m.SetGaugeWithLabels([]string{"pool", "in_use"}, float32(n), p.labels)
+}
+
+// withName returns labels with the label of the name of a resource first
+func withName(kind, name string, labels []metrics.Label) []metrics.Label {
+	return append([]metrics.Label{{Name: kind, Value: name}}, labels...)
+}
@@ -0,0 +1,56 @@
+package metricsutil
+
+import (
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+// Queue is implemented by queues and pools whose usage is polled by
+// PollQueue, e.g. a worker pool returning its busy workers from Len and its
+// workers from Cap.
+type Queue interface {
+	// Len returns the items in the queue
+	Len() int
+
+	// Cap returns the items the queue can hold, or 0 if it is unbounded
+	Cap() int
+}
+
+// Chan returns the Queue of the items buffered in ch.
+func Chan[T any](ch <-chan T) Queue {
+	return chanQueue[T]{ch}
+}
+
+type chanQueue[T any] struct {
+	ch <-chan T
+}
+
+func (q chanQueue[T]) Len() int { return len(q.ch) }
+func (q chanQueue[T]) Cap() int { return cap(q.ch) }
+
+// PollQueue emits the usage of q every interval, labeled with queue=name and
+// labels:
+//
+//	queue.depth - gauge of the items in the queue
+//	queue.capacity - gauge of the items the queue can hold
+//	queue.saturation - gauge of depth / capacity, from 0 to 1
+//
+// The capacity and saturation of unbounded queues are not emitted. Polling
+// ends when the returned function is called or m is shut down, see
+// metrics.Metrics.Poll. For example:
+//
+//	jobs := make(chan Job, 100)
+//	stop := metricsutil.PollQueue(metrics.Default(), "jobs", metricsutil.Chan(jobs), 10*time.Second)
+func PollQueue(m *metrics.Metrics, name string, q Queue, interval time.Duration, labels ...metrics.Label) (stop func()) {
+	labels = withName("queue", name, labels)
+	return m.Poll(interval, func(emit metrics.GaugeEmitter) {
+		depth, capacity := q.Len(), q.Cap()
+		emit.SetGaugeWithLabels([]string{"queue", "depth"}, float32(depth), labels)
+		if capacity <= 0 {
+			return
+		}
+		emit.SetGaugeWithLabels([]string{"queue", "capacity"}, float32(capacity), labels)
+		emit.SetGaugeWithLabels([]string{"queue", "saturation"}, float32(depth)/float32(capacity), labels)
+	})
+}
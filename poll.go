@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// GaugeEmitter emits gauges, see Poll
+type GaugeEmitter interface {
+	SetGauge(key []string, val float32)
+	SetGaugeWithLabels(key []string, val float32, labels []Label)
+}
+
+// poller calls a function polling gauges until it is stopped
+type poller struct {
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+}
+
+// stop stops the poller and waits for its last call to return
+func (p *poller) stop() {
+	p.stopOnce.Do(func() {
+		close(p.stopCh)
+	})
+	<-p.doneCh
+}
+
+// Poll calls fn right away and then every interval to emit gauges derived
+// from the state of the application, e.g.
+//
+//	stop := m.Poll(10*time.Second, func(emit metrics.GaugeEmitter) {
+//		emit.SetGauge([]string{"queue", "depth"}, float32(queue.Len()))
+//	})
+//
+// Polling ends when the returned function is called or the Metrics are shut
+// down. The function must not be called from fn.
+func (m *Metrics) Poll(interval time.Duration, fn func(emit GaugeEmitter)) (stop func()) {
+	p := &poller{
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+	m.pollLock.Lock()
+	if m.pollers == nil {
+		m.pollers = make(map[*poller]struct{})
+	}
+	m.pollers[p] = struct{}{}
+	m.pollLock.Unlock()
+
+	go func() {
+		defer close(p.doneCh)
+		ticker := m.clock().NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			fn(m)
+			select {
+			case <-ticker.C():
+			case <-p.stopCh:
+				return
+			}
+		}
+	}()
+	return func() {
+		m.pollLock.Lock()
+		delete(m.pollers, p)
+		m.pollLock.Unlock()
+		p.stop()
+	}
+}
+
+// stopPollers stops all the pollers started with Poll
+func (m *Metrics) stopPollers() {
+	m.pollLock.Lock()
+	pollers := m.pollers
+	m.pollers = nil
+	m.pollLock.Unlock()
+	for p := range pollers {
+		p.stop()
+	}
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMetrics_Poll(t *testing.T) {
+	inm := NewInmemSink(time.Minute, time.Minute)
+	met := &Metrics{Config: Config{FilterDefault: true}, sink: inm}
+
+	var polls int32
+	stop := met.Poll(time.Millisecond, func(emit GaugeEmitter) {
+		n := atomic.AddInt32(&polls, 1)
+		emit.SetGaugeWithLabels([]string{"queue", "depth"}, float32(n), []Label{{Name: "queue", Value: "jobs"}})
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&polls) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("not polled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+	stopped := atomic.LoadInt32(&polls)
+	if g := inm.Data()[0].Gauges["queue.depth;queue=jobs"]; g.Value != float32(stopped) {
+		t.Fatalf("bad gauge: %v", g)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n := atomic.LoadInt32(&polls); n != stopped {
+		t.Fatalf("polled after stop: %d", n)
+	}
+	if len(met.pollers) != 0 {
+		t.Fatalf("poller not removed")
+	}
+}
+
+func TestMetrics_Poll_Shutdown(t *testing.T) {
+	met := &Metrics{Config: Config{FilterDefault: true}, sink: &BlackholeSink{}}
+	var polls int32
+	stop := met.Poll(time.Hour, func(GaugeEmitter) {
+		atomic.AddInt32(&polls, 1)
+	})
+	met.Shutdown()
+	// The poller already stopped
+	stop()
+	if n := atomic.LoadInt32(&polls); n != 1 {
+		t.Fatalf("bad polls: %d", n)
+	}
+}
@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"context"
+	"runtime/pprof"
+	"strings"
+)
+
+// Do calls fn like pprof.Do, with the pprof labels of key and labels set
+// for the duration of fn, then emits the duration of fn as a sample to key.
+// CPU profiles can thus be sliced by the same keys and labels as the
+// metrics: the key joined with "." is the pprof label "metric", e.g.
+//
+//	m.Do(ctx, []string{"api", "render"}, []metrics.Label{{Name: "page", Value: "home"}}, func(ctx context.Context) {
+//		render(ctx)
+//	})
+//
+// profiles the samples of render with metric=api.render and page=home.
+// Goroutines started by fn inherit the pprof labels.
+func (m *Metrics) Do(ctx context.Context, key []string, labels []Label, fn func(context.Context)) {
+	args := make([]string, 0, 2+2*len(labels))
+	args = append(args, "metric", strings.Join(key, "."))
+	for _, label := range labels {
+		args = append(args, label.Name, label.Value)
+	}
+	start := m.Now()
+	pprof.Do(ctx, pprof.Labels(args...), fn)
+	m.MeasureSinceWithLabels(key, start, labels)
+}
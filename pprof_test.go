@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"context"
+	"runtime/pprof"
+	"testing"
+	"time"
+)
+
+func TestMetrics_Do(t *testing.T) {
+	inm := NewInmemSink(time.Minute, time.Minute)
+	met := &Metrics{Config: Config{FilterDefault: true, TimerGranularity: time.Millisecond}, sink: inm}
+
+	called := false
+	met.Do(context.Background(), []string{"api", "render"}, []Label{{Name: "page", Value: "home"}}, func(ctx context.Context) {
+		called = true
+		for name, expect := range map[string]string{"metric": "api.render", "page": "home"} {
+			if v, ok := pprof.Label(ctx, name); !ok || v != expect {
+				t.Fatalf("bad pprof label %s: %q", name, v)
+			}
+		}
+	})
+	if !called {
+		t.Fatalf("not called")
+	}
+	if s, ok := inm.Data()[0].Samples["api.render;page=home"]; !ok || s.Count != 1 {
+		t.Fatalf("bad samples: %v", inm.Data()[0].Samples)
+	}
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"time"
+)
+
+// processStats are the statistics of the process read from the OS. Not all
+// are available on every platform.
+type processStats struct {
+	cpuSeconds float64
+
+	hasMemory     bool
+	residentBytes uint64
+	virtualBytes  uint64
+
+	// maxResidentBytes is reported instead of the resident memory where the
+	// current one isn't available
+	maxResidentBytes uint64
+
+	startTime time.Time
+
+	hasSwitches         bool
+	voluntarySwitches   uint64
+	involuntarySwitches uint64
+}
+
+// EmitProcessStats emits the statistics of the process, like the process
+// collector of Prometheus but to any sink:
+//
+//	process.cpu_seconds - gauge of the user and system CPU time used
+//	process.resident_memory_bytes - gauge of the resident memory, on Linux
+//	    and Windows
+//	process.virtual_memory_bytes - gauge of the virtual memory, on Linux and
+//	    of the committed memory on Windows
+//	process.max_resident_memory_bytes - gauge of the largest resident
+//	    memory, on macOS and BSDs
+//	process.start_time_seconds - gauge of the start time since the Unix
+//	    epoch, on Linux and Windows
+//	process.voluntary_context_switches - gauge of the context switches
+//	    while waiting, on platforms other than Windows
+//	process.involuntary_context_switches - gauge of the preemptions, on
+//	    platforms other than Windows
+//
+// They are emitted every ProfileInterval if EnableProcessMetrics is set.
+func (m *Metrics) EmitProcessStats() {
+	stats, err := readProcessStats()
+	if err != nil {
+		LogError("Error reading process stats", "class", "read", "error", err)
+		return
+	}
+	m.SetGauge([]string{"process", "cpu_seconds"}, float32(stats.cpuSeconds))
+	if stats.hasMemory {
+		m.SetGauge([]string{"process", "resident_memory_bytes"}, float32(stats.residentBytes))
+		m.SetGauge([]string{"process", "virtual_memory_bytes"}, float32(stats.virtualBytes))
+	}
+	if stats.maxResidentBytes != 0 {
+		m.SetGauge([]string{"process", "max_resident_memory_bytes"}, float32(stats.maxResidentBytes))
+	}
+	if !stats.startTime.IsZero() {
+		m.SetGauge([]string{"process", "start_time_seconds"}, float32(stats.startTime.Unix()))
+	}
+	if stats.hasSwitches {
+		m.SetGauge([]string{"process", "voluntary_context_switches"}, float32(stats.voluntarySwitches))
+		m.SetGauge([]string{"process", "involuntary_context_switches"}, float32(stats.involuntarySwitches))
+	}
+}
@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// userHZ is the unit of the CPU times in /proc, in ticks per second. It is
+// 100 on all architectures.
+const userHZ = 100
+
+func readProcessStats() (processStats, error) {
+	var stats processStats
+	data, err := os.ReadFile("/proc/self/stat")
+	if err != nil {
+		return stats, err
+	}
+	// The command may contain spaces and parentheses, so the fields start
+	// after the last parenthesis, with the third field, the state
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return stats, fmt.Errorf("malformed /proc/self/stat")
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 22 {
+		return stats, fmt.Errorf("malformed /proc/self/stat")
+	}
+	field := func(n int) uint64 {
+		v, _ := strconv.ParseUint(fields[n-3], 10, 64)
+		return v
+	}
+	stats.cpuSeconds = float64(field(14)+field(15)) / userHZ
+	stats.hasMemory = true
+	stats.virtualBytes = field(23)
+	stats.residentBytes = field(24) * uint64(os.Getpagesize())
+
+	if bootTime, err := readBootTime(); err == nil {
+		start := float64(bootTime) + float64(field(22))/userHZ
+		stats.startTime = time.Unix(0, int64(start*float64(time.Second)))
+	}
+
+	status, err := os.Open("/proc/self/status")
+	if err != nil {
+		return stats, nil
+	}
+	defer status.Close()
+	scanner := bufio.NewScanner(status)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		switch name {
+		case "voluntary_ctxt_switches":
+			stats.voluntarySwitches, _ = strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+			stats.hasSwitches = true
+		case "nonvoluntary_ctxt_switches":
+			stats.involuntarySwitches, _ = strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		}
+	}
+	return stats, nil
+}
+
+// readBootTime returns the time the system booted, in seconds since the Unix
+// epoch
+func readBootTime() (uint64, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "btime "); ok {
+			return strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		}
+	}
+	return 0, fmt.Errorf("no btime in /proc/stat")
+}
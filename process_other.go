@@ -0,0 +1,13 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package metrics
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func readProcessStats() (processStats, error) {
+	return processStats{}, fmt.Errorf("process stats are not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,29 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package metrics
+
+import (
+	"runtime"
+	"syscall"
+	"time"
+)
+
+func readProcessStats() (processStats, error) {
+	var stats processStats
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return stats, err
+	}
+	cpu := time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+	stats.cpuSeconds = cpu.Seconds()
+	// The largest resident memory is in bytes on macOS, and in KiB on BSDs
+	stats.maxResidentBytes = uint64(usage.Maxrss)
+	if runtime.GOOS != "darwin" {
+		stats.maxResidentBytes *= 1024
+	}
+	stats.hasSwitches = true
+	stats.voluntarySwitches = uint64(usage.Nvcsw)
+	stats.involuntarySwitches = uint64(usage.Nivcsw)
+	return stats, nil
+}
@@ -0,0 +1,54 @@
+//go:build windows
+// +build windows
+
+package metrics
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var procGetProcessMemoryInfo = syscall.NewLazyDLL("kernel32.dll").NewProc("K32GetProcessMemoryInfo")
+
+// processMemoryCounters is PROCESS_MEMORY_COUNTERS
+type processMemoryCounters struct {
+	cb                         uint32
+	pageFaultCount             uint32
+	peakWorkingSetSize         uintptr
+	workingSetSize             uintptr
+	quotaPeakPagedPoolUsage    uintptr
+	quotaPagedPoolUsage        uintptr
+	quotaPeakNonPagedPoolUsage uintptr
+	quotaNonPagedPoolUsage     uintptr
+	pagefileUsage              uintptr
+	peakPagefileUsage          uintptr
+}
+
+func readProcessStats() (processStats, error) {
+	var stats processStats
+	h, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return stats, err
+	}
+	var creation, exit, kernel, user syscall.Filetime
+	if err := syscall.GetProcessTimes(h, &creation, &exit, &kernel, &user); err != nil {
+		return stats, err
+	}
+	stats.cpuSeconds = (filetimeDuration(kernel) + filetimeDuration(user)).Seconds()
+	stats.startTime = time.Unix(0, creation.Nanoseconds())
+
+	var counters processMemoryCounters
+	counters.cb = uint32(unsafe.Sizeof(counters))
+	if ok, _, _ := procGetProcessMemoryInfo.Call(uintptr(h), uintptr(unsafe.Pointer(&counters)), uintptr(counters.cb)); ok != 0 {
+		stats.hasMemory = true
+		stats.residentBytes = uint64(counters.workingSetSize)
+		stats.virtualBytes = uint64(counters.pagefileUsage)
+	}
+	return stats, nil
+}
+
+// filetimeDuration returns a duration held in a Filetime, in 100ns units
+func filetimeDuration(ft syscall.Filetime) time.Duration {
+	return time.Duration(uint64(ft.HighDateTime)<<32|uint64(ft.LowDateTime)) * 100
+}
@@ -0,0 +1,130 @@
+//go:build go1.9
+// +build go1.9
+
+package prometheus
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// ForwardGatherer gathers the metrics of g, such as the collectors
+// registered by third-party libraries, every interval and emits them to m
+// until the returned function is called to stop it. The name of a family is
+// the key of its metrics, and the labels of a series are their labels:
+//
+//	gauges and untyped metrics are emitted as gauges
+//	counters are emitted as counters of their increase since the previous
+//	    gather
+//	summaries emit their quantiles as gauges labeled with quantile, and the
+//	    increase of their count and sum as counters <name>_count and
+//	    <name>_sum
+//	histograms emit the increase of their count, sum and buckets as
+//	    counters <name>_count, <name>_sum and <name>_bucket labeled with le
+//
+// Gathering a registry the PrometheusSink of m also registers with emits
+// its metrics twice, so only the collectors of other registries should be
+// forwarded. For example:
+//
+//	reg := prometheus.NewRegistry()
+//	reg.MustRegister(collectors.NewGoCollector())
+//	stop := prometheus.ForwardGatherer(metrics.Default(), reg, 10*time.Second)
+func ForwardGatherer(m *metrics.Metrics, g prometheus.Gatherer, interval time.Duration) (stop func()) {
+	f := &forwarder{m: m, g: g, last: make(map[string]float64)}
+	return m.Poll(interval, func(metrics.GaugeEmitter) {
+		f.forward()
+	})
+}
+
+// forwarder emits the gathered metrics, keeping the last values of the
+// cumulative ones
+type forwarder struct {
+	m *metrics.Metrics
+	g prometheus.Gatherer
+
+	lock sync.Mutex
+	last map[string]float64
+}
+
+func (f *forwarder) forward() {
+	mfs, err := f.g.Gather()
+	if err != nil {
+		// Gather returns what it could gather along with the error
+		metrics.LogError("Error gathering Prometheus metrics", "class", "gather", "error", err)
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	seen := make(map[string]float64, len(f.last))
+	for _, mf := range mfs {
+		name := mf.GetName()
+		for _, metric := range mf.GetMetric() {
+			labels := make([]metrics.Label, 0, len(metric.GetLabel())+1)
+			for _, lp := range metric.GetLabel() {
+				labels = append(labels, metrics.Label{Name: lp.GetName(), Value: lp.GetValue()})
+			}
+			counter := func(name string, total float64, labels []metrics.Label) {
+				id := seriesID(name, labels)
+				seen[id] = total
+				delta := total
+				if last, ok := f.last[id]; ok && total >= last {
+					delta = total - last
+				}
+				if delta > 0 {
+					f.m.IncrCounterWithLabels([]string{name}, float32(delta), labels)
+				}
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_GAUGE:
+				f.m.SetGaugeWithLabels([]string{name}, float32(metric.GetGauge().GetValue()), labels)
+			case dto.MetricType_UNTYPED:
+				f.m.SetGaugeWithLabels([]string{name}, float32(metric.GetUntyped().GetValue()), labels)
+			case dto.MetricType_COUNTER:
+				counter(name, metric.GetCounter().GetValue(), labels)
+			case dto.MetricType_SUMMARY:
+				s := metric.GetSummary()
+				for _, q := range s.GetQuantile() {
+					f.m.SetGaugeWithLabels([]string{name}, float32(q.GetValue()),
+						withLabel(labels, "quantile", strconv.FormatFloat(q.GetQuantile(), 'g', -1, 64)))
+				}
+				counter(name+"_count", float64(s.GetSampleCount()), labels)
+				counter(name+"_sum", s.GetSampleSum(), labels)
+			case dto.MetricType_HISTOGRAM:
+				h := metric.GetHistogram()
+				for _, b := range h.GetBucket() {
+					counter(name+"_bucket", float64(b.GetCumulativeCount()),
+						withLabel(labels, "le", strconv.FormatFloat(b.GetUpperBound(), 'g', -1, 64)))
+				}
+				counter(name+"_count", float64(h.GetSampleCount()), labels)
+				counter(name+"_sum", h.GetSampleSum(), labels)
+			}
+		}
+	}
+	// Forget the series which are gone, so they start over if they return
+	f.last = seen
+}
+
+// withLabel returns a copy of labels with a label appended
+func withLabel(labels []metrics.Label, name, value string) []metrics.Label {
+	return append(labels[:len(labels):len(labels)], metrics.Label{Name: name, Value: value})
+}
+
+// seriesID returns a string identifying the series of name with labels
+func seriesID(name string, labels []metrics.Label) string {
+	var b strings.Builder
+	b.WriteString(name)
+	for _, l := range labels {
+		b.WriteByte(0)
+		b.WriteString(l.Name)
+		b.WriteByte(0)
+		b.WriteString(l.Value)
+	}
+	return b.String()
+}
@@ -0,0 +1,54 @@
+package prometheus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestForwardGatherer(t *testing.T) {
+	inm := metrics.NewInmemSink(time.Minute, time.Minute)
+	cfg := metrics.DefaultConfig("")
+	cfg.EnableHostname = false
+	cfg.EnableRuntimeMetrics = false
+	m, err := metrics.New(cfg, inm)
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+
+	reg := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "lib_requests_total"}, []string{"method"})
+	inflight := prometheus.NewGauge(prometheus.GaugeOpts{Name: "lib_inflight"})
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "lib_latency", Buckets: []float64{1}})
+	reg.MustRegister(requests, inflight, latency)
+
+	f := &forwarder{m: m, g: reg, last: make(map[string]float64)}
+	requests.WithLabelValues("GET").Add(3)
+	latency.Observe(0.5)
+	f.forward()
+	requests.WithLabelValues("GET").Add(2)
+	inflight.Set(4)
+	latency.Observe(5)
+	f.forward()
+	f.forward()
+
+	data := inm.Data()[0]
+	for key, want := range map[string]struct {
+		count int
+		sum   float64
+	}{
+		"lib_requests_total;method=GET": {2, 5},
+		"lib_latency_count":             {2, 2},
+		"lib_latency_sum":               {2, 5.5},
+		"lib_latency_bucket;le=1":       {1, 1},
+	} {
+		if c, ok := data.Counters[key]; !ok || c.Count != want.count || c.Sum != want.sum {
+			t.Fatalf("bad %s: %v", key, data.Counters)
+		}
+	}
+	if g, ok := data.Gauges["lib_inflight"]; !ok || g.Value != 4 {
+		t.Fatalf("bad gauges: %v", data.Gauges)
+	}
+}
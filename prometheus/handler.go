@@ -0,0 +1,107 @@
+//go:build go1.9
+// +build go1.9
+
+package prometheus
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HandlerAuth configures the credentials scrapers must present to
+// AuthHandler. If both basic auth and a bearer token are configured, either
+// is accepted. If neither is, requests aren't authenticated.
+type HandlerAuth struct {
+	// Username and Password are the credentials of HTTP basic
+	// authentication. Either both or neither must be set.
+	Username string
+	Password string
+
+	// BearerToken, if set, is the token of an "Authorization: Bearer"
+	// header, as sent by Prometheus' authorization scrape config
+	BearerToken string
+}
+
+func (a HandlerAuth) enabled() bool {
+	return a.Username != "" || a.BearerToken != ""
+}
+
+// validate returns an error if only one of Username and Password is set,
+// which would otherwise leave basic auth half configured
+func (a HandlerAuth) validate() error {
+	if (a.Username == "") != (a.Password == "") {
+		return errors.New("prometheus: HandlerAuth needs both a Username and a Password")
+	}
+	return nil
+}
+
+// authorized returns whether req presents the configured credentials.
+// Credentials are compared in constant time.
+func (a HandlerAuth) authorized(req *http.Request) bool {
+	if !a.enabled() {
+		return true
+	}
+	if a.Username != "" {
+		if user, pass, ok := req.BasicAuth(); ok {
+			userOK := subtle.ConstantTimeCompare([]byte(user), []byte(a.Username)) == 1
+			passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(a.Password)) == 1
+			if userOK && passOK {
+				return true
+			}
+		}
+	}
+	if a.BearerToken != "" {
+		header := req.Header.Get("Authorization")
+		if token := strings.TrimPrefix(header, "Bearer "); token != header {
+			return subtle.ConstantTimeCompare([]byte(token), []byte(a.BearerToken)) == 1
+		}
+	}
+	return false
+}
+
+// AuthHandler returns Handler, rejecting requests which don't present the
+// credentials of auth with 401 Unauthorized. It returns an error if auth has
+// only one of a Username and a Password.
+func (p *PrometheusSink) AuthHandler(auth HandlerAuth) (http.Handler, error) {
+	if err := auth.validate(); err != nil {
+		return nil, err
+	}
+	handler := p.Handler()
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if !auth.authorized(req) {
+			if auth.Username != "" {
+				resp.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+			} else {
+				resp.Header().Set("WWW-Authenticate", "Bearer")
+			}
+			http.Error(resp, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(resp, req)
+	}), nil
+}
+
+// NewMetricsServer returns an http.Server listening on addr which serves
+// AuthHandler at /metrics. If tlsConfig is set the server uses it, and
+// should be started with ListenAndServeTLS, passing empty file names if
+// tlsConfig holds the certificates. Basic auth and bearer tokens should only
+// be used over TLS, as they are sent in the clear otherwise. Invalid auth is
+// reported as by AuthHandler.
+func (p *PrometheusSink) NewMetricsServer(addr string, auth HandlerAuth, tlsConfig *tls.Config) (*http.Server, error) {
+	handler, err := p.AuthHandler(auth)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	return &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+	}, nil
+}
@@ -0,0 +1,79 @@
+package prometheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPrometheusSink_AuthHandler(t *testing.T) {
+	sink, err := NewPrometheusSinkFrom(PrometheusOpts{Registerer: prometheus.NewRegistry()})
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	sink.IncrCounter([]string{"requests"}, 1)
+
+	auth := HandlerAuth{Username: "prometheus", Password: "secret", BearerToken: "token"}
+	server, err := sink.NewMetricsServer("", auth, nil)
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	srv := httptest.NewTLSServer(server.Handler)
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		name   string
+		path   string
+		setup  func(*http.Request)
+		expect int
+	}{
+		{"none", "/metrics", func(*http.Request) {}, http.StatusUnauthorized},
+		{"basic", "/metrics", func(r *http.Request) { r.SetBasicAuth("prometheus", "secret") }, http.StatusOK},
+		{"bad password", "/metrics", func(r *http.Request) { r.SetBasicAuth("prometheus", "guess") }, http.StatusUnauthorized},
+		{"bearer", "/metrics", func(r *http.Request) { r.Header.Set("Authorization", "Bearer token") }, http.StatusOK},
+		{"bad token", "/metrics", func(r *http.Request) { r.Header.Set("Authorization", "Bearer guess") }, http.StatusUnauthorized},
+		{"other path", "/", func(r *http.Request) { r.SetBasicAuth("prometheus", "secret") }, http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", srv.URL+tc.path, nil)
+			if err != nil {
+				t.Fatalf("err = %v, want nil", err)
+			}
+			tc.setup(req)
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatalf("err = %v, want nil", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.expect {
+				t.Fatalf("bad status: %d, want %d", resp.StatusCode, tc.expect)
+			}
+			if resp.StatusCode == http.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") == "" {
+				t.Fatalf("missing WWW-Authenticate header")
+			}
+		})
+	}
+
+	// Without credentials configured, requests are let through
+	handler, err := sink.AuthHandler(HandlerAuth{})
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/metrics", nil))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("bad status: %d", resp.Code)
+	}
+
+	// Half configured basic auth is rejected rather than disabling auth
+	for _, auth := range []HandlerAuth{{Username: "prometheus"}, {Password: "secret", BearerToken: "token"}} {
+		if _, err := sink.AuthHandler(auth); err == nil {
+			t.Fatalf("AuthHandler(%+v) err = nil, want error", auth)
+		}
+		if _, err := sink.NewMetricsServer("", auth, nil); err == nil {
+			t.Fatalf("NewMetricsServer(%+v) err = nil, want error", auth)
+		}
+	}
+}
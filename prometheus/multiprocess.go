@@ -0,0 +1,369 @@
+//go:build go1.9
+// +build go1.9
+
+package prometheus
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// multiProcessPattern matches the files MultiProcessSinks write to their
+// directory. Files of sinks which have been shut down end in
+// multiProcessExited instead of only .pb.
+const (
+	multiProcessPattern = "metrics-*.pb"
+	multiProcessExited  = ".exited.pb"
+)
+
+// GaugeMerge is how NewMultiProcessGatherer combines the values of a gauge
+// reported by several processes.
+type GaugeMerge int
+
+const (
+	// GaugeMergeSum adds up the values of all processes, including those
+	// which have exited, like counters.
+	GaugeMergeSum GaugeMerge = iota
+
+	// GaugeMergeMax exports the largest value of all processes.
+	GaugeMergeMax
+
+	// GaugeMergeMin exports the smallest value of all processes.
+	GaugeMergeMin
+
+	// GaugeMergeLive adds up the values of the processes whose sinks have
+	// not been shut down, for gauges such as in-flight requests which
+	// should drop when a process exits.
+	GaugeMergeLive
+)
+
+// MultiProcessSink is a PrometheusSink for applications running as several
+// processes on a host, such as pre-forked workers, CGI programs or plugins,
+// that can't each be scraped. Every process writes its metrics to its own
+// file in a directory shared by all of them, from which a single exporter
+// serves the merged metrics using NewMultiProcessGatherer.
+type MultiProcessSink struct {
+	*PrometheusSink
+	path     string
+	interval time.Duration
+	stopChan chan struct{}
+	doneChan chan struct{}
+}
+
+// NewMultiProcessSink creates a MultiProcessSink writing its metrics to dir
+// every interval. The file is named after the process ID and the time the
+// sink was created, so a process reusing the ID of one which has exited
+// doesn't replace its file. The sink's metrics are only registered with
+// opts.Registerer if it is set.
+func NewMultiProcessSink(dir string, interval time.Duration, opts PrometheusOpts) (*MultiProcessSink, error) {
+	if opts.Registerer == nil {
+		opts.Registerer = prometheus.NewRegistry()
+	}
+	promSink, err := NewPrometheusSinkFrom(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	sink := &MultiProcessSink{
+		PrometheusSink: promSink,
+		path:           filepath.Join(dir, fmt.Sprintf("metrics-%d-%d.pb", os.Getpid(), time.Now().UnixNano())),
+		interval:       interval,
+		stopChan:       make(chan struct{}),
+		doneChan:       make(chan struct{}),
+	}
+	if err := sink.write(); err != nil {
+		return nil, err
+	}
+	go sink.flushMetrics()
+	return sink, nil
+}
+
+func (s *MultiProcessSink) flushMetrics() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	defer close(s.doneChan)
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.write(); err != nil {
+				metrics.LogError("Error writing Prometheus metrics", "sink", "prometheus", "path", s.path, "class", "write", "error", err)
+			}
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// write replaces the file of the process with the current metrics. The file
+// is renamed into place so the exporter never reads a partial one.
+func (s *MultiProcessSink) write() error {
+	mfs, err := s.Gatherer().Gather()
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	enc := expfmt.NewEncoder(f, expfmt.NewFormat(expfmt.TypeProtoDelim))
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Shutdown stops the MultiProcessSink after writing its metrics one last
+// time. The file is kept, so the counters of the process remain part of the
+// merged totals, but marked as exited for GaugeMergeLive.
+func (s *MultiProcessSink) Shutdown() {
+	close(s.stopChan)
+	<-s.doneChan
+
+	live := s.path
+	s.path = strings.TrimSuffix(live, ".pb") + multiProcessExited
+	if err := s.write(); err != nil {
+		metrics.LogError("Error writing Prometheus metrics", "sink", "prometheus", "path", s.path, "class", "write", "error", err)
+		return
+	}
+	os.Remove(live)
+}
+
+// NewMultiProcessGatherer returns a Gatherer merging the metrics written to
+// dir by MultiProcessSinks, to be served by the exporter of the host, e.g.
+// with promhttp.HandlerFor. Series with the same name and labels are
+// combined across processes: counters, and the counts, sums and buckets of
+// histograms are added up, gauges are added up as well unless another
+// GaugeMerge is given to NewMultiProcessGathererWithMerge. Exemplars and
+// creation times are
+// dropped. Quantiles of summaries can't be merged and are left out, as are
+// native histogram buckets, so histograms should be preferred. All processes
+// should use the same buckets. Files not written to for longer than
+// expiration, such as those of processes that exited long ago, are ignored
+// and removed. If expiration is zero they are kept forever.
+func NewMultiProcessGatherer(dir string, expiration time.Duration) prometheus.Gatherer {
+	return NewMultiProcessGathererWithMerge(dir, expiration, GaugeMergeSum)
+}
+
+// NewMultiProcessGathererWithMerge is NewMultiProcessGatherer combining the
+// values of gauges as given by merge.
+func NewMultiProcessGathererWithMerge(dir string, expiration time.Duration, merge GaugeMerge) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return gatherMultiProcess(dir, expiration, merge, time.Now())
+	})
+}
+
+func gatherMultiProcess(dir string, expiration time.Duration, merge GaugeMerge, now time.Time) ([]*dto.MetricFamily, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, multiProcessPattern))
+	if err != nil {
+		return nil, err
+	}
+
+	var errs prometheus.MultiError
+	merged := make(map[string]*mergedFamily)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			// The file may have been removed since the glob
+			continue
+		}
+		if expiration != 0 && info.ModTime().Add(expiration).Before(now) {
+			os.Remove(path)
+			continue
+		}
+		exited := strings.HasSuffix(path, multiProcessExited)
+		if err := readMultiProcess(path, merge, exited, merged); err != nil {
+			errs.Append(fmt.Errorf("reading %s: %v", path, err))
+		}
+	}
+
+	mfs := make([]*dto.MetricFamily, 0, len(merged))
+	for _, f := range merged {
+		if len(f.metrics) > 0 {
+			mfs = append(mfs, f.family())
+		}
+	}
+	sort.Slice(mfs, func(i, j int) bool { return mfs[i].GetName() < mfs[j].GetName() })
+	return mfs, errs.MaybeUnwrap()
+}
+
+func readMultiProcess(path string, merge GaugeMerge, exited bool, merged map[string]*mergedFamily) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := expfmt.NewDecoder(f, expfmt.NewFormat(expfmt.TypeProtoDelim))
+	for {
+		var mf dto.MetricFamily
+		if err := dec.Decode(&mf); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		m, ok := merged[mf.GetName()]
+		if !ok {
+			m = &mergedFamily{
+				name:    mf.GetName(),
+				help:    mf.GetHelp(),
+				typ:     mf.GetType(),
+				merge:   merge,
+				metrics: make(map[string]*dto.Metric),
+			}
+			merged[mf.GetName()] = m
+		}
+		if mf.GetType() != m.typ {
+			return fmt.Errorf("%s is a %s, but a %s in another process", mf.GetName(), mf.GetType(), m.typ)
+		}
+		if m.typ == dto.MetricType_GAUGE && merge == GaugeMergeLive && exited {
+			continue
+		}
+		for _, metric := range mf.GetMetric() {
+			m.add(metric)
+		}
+	}
+}
+
+// mergedFamily is a metric family combined from the files of all processes
+type mergedFamily struct {
+	name    string
+	help    string
+	typ     dto.MetricType
+	merge   GaugeMerge
+	metrics map[string]*dto.Metric
+}
+
+func (f *mergedFamily) add(metric *dto.Metric) {
+	parts := make([]string, 0, len(metric.GetLabel()))
+	for _, lp := range metric.GetLabel() {
+		parts = append(parts, lp.GetName()+"="+lp.GetValue())
+	}
+	sort.Strings(parts)
+	sig := strings.Join(parts, ";")
+
+	m, ok := f.metrics[sig]
+	if !ok {
+		m = &dto.Metric{Label: metric.GetLabel()}
+		f.metrics[sig] = m
+	}
+
+	switch f.typ {
+	case dto.MetricType_COUNTER:
+		if m.Counter == nil {
+			m.Counter = &dto.Counter{}
+		}
+		m.Counter.Value = addFloat(m.Counter.Value, metric.GetCounter().GetValue())
+	case dto.MetricType_GAUGE:
+		if m.Gauge == nil {
+			m.Gauge = &dto.Gauge{}
+		}
+		m.Gauge.Value = mergeGauge(m.Gauge.Value, metric.GetGauge().GetValue(), f.merge)
+	case dto.MetricType_UNTYPED:
+		if m.Untyped == nil {
+			m.Untyped = &dto.Untyped{}
+		}
+		m.Untyped.Value = addFloat(m.Untyped.Value, metric.GetUntyped().GetValue())
+	case dto.MetricType_SUMMARY:
+		if m.Summary == nil {
+			m.Summary = &dto.Summary{}
+		}
+		m.Summary.SampleCount = addUint(m.Summary.SampleCount, metric.GetSummary().GetSampleCount())
+		m.Summary.SampleSum = addFloat(m.Summary.SampleSum, metric.GetSummary().GetSampleSum())
+	case dto.MetricType_HISTOGRAM:
+		if m.Histogram == nil {
+			m.Histogram = &dto.Histogram{}
+		}
+		h := metric.GetHistogram()
+		m.Histogram.SampleCount = addUint(m.Histogram.SampleCount, h.GetSampleCount())
+		m.Histogram.SampleSum = addFloat(m.Histogram.SampleSum, h.GetSampleSum())
+		m.Histogram.Bucket = mergeBuckets(m.Histogram.Bucket, h.GetBucket())
+	}
+}
+
+func (f *mergedFamily) family() *dto.MetricFamily {
+	sigs := make([]string, 0, len(f.metrics))
+	for sig := range f.metrics {
+		sigs = append(sigs, sig)
+	}
+	sort.Strings(sigs)
+
+	mf := &dto.MetricFamily{
+		Name: &f.name,
+		Help: &f.help,
+		Type: f.typ.Enum(),
+	}
+	for _, sig := range sigs {
+		mf.Metric = append(mf.Metric, f.metrics[sig])
+	}
+	return mf
+}
+
+// mergeBuckets adds the cumulative counts of the buckets in src to those with
+// the same upper bound in dst
+func mergeBuckets(dst, src []*dto.Bucket) []*dto.Bucket {
+	for _, b := range src {
+		i := sort.Search(len(dst), func(i int) bool { return dst[i].GetUpperBound() >= b.GetUpperBound() })
+		if i < len(dst) && dst[i].GetUpperBound() == b.GetUpperBound() {
+			dst[i].CumulativeCount = addUint(dst[i].CumulativeCount, b.GetCumulativeCount())
+			continue
+		}
+		bucket := &dto.Bucket{UpperBound: b.UpperBound, CumulativeCount: b.CumulativeCount}
+		dst = append(dst, nil)
+		copy(dst[i+1:], dst[i:])
+		dst[i] = bucket
+	}
+	return dst
+}
+
+// mergeGauge combines the value of a gauge with those of other processes
+func mergeGauge(merged *float64, v float64, merge GaugeMerge) *float64 {
+	if merged == nil {
+		return &v
+	}
+	switch merge {
+	case GaugeMergeMax:
+		v = math.Max(*merged, v)
+	case GaugeMergeMin:
+		v = math.Min(*merged, v)
+	default:
+		v += *merged
+	}
+	return &v
+}
+
+func addFloat(sum *float64, v float64) *float64 {
+	if sum != nil {
+		v += *sum
+	}
+	return &v
+}
+
+func addUint(sum *uint64, v uint64) *uint64 {
+	if sum != nil {
+		v += *sum
+	}
+	return &v
+}
@@ -0,0 +1,120 @@
+package prometheus
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestMultiProcess(t *testing.T) {
+	dir := t.TempDir()
+	opts := PrometheusOpts{
+		HistogramBuckets: metrics.NewHistogramBuckets([]float64{1, 10}, nil),
+	}
+	var sinks []*MultiProcessSink
+	for n := 0; n < 2; n++ {
+		sink, err := NewMultiProcessSink(dir, time.Hour, opts)
+		if err != nil {
+			t.Fatalf("err = %v, want nil", err)
+		}
+		// Pretend the sinks run in different processes
+		os.Remove(sink.path)
+		sink.path = filepath.Join(dir, fmt.Sprintf("metrics-%d.pb", n))
+		sinks = append(sinks, sink)
+	}
+
+	labels := []metrics.Label{{Name: "method", Value: "GET"}}
+	sinks[0].IncrCounterWithLabels([]string{"requests"}, 2, labels)
+	sinks[1].IncrCounterWithLabels([]string{"requests"}, 3, labels)
+	sinks[1].IncrCounter([]string{"requests"}, 1)
+	sinks[0].SetGauge([]string{"inflight"}, 1)
+	sinks[1].SetGauge([]string{"inflight"}, 4)
+	sinks[0].AddSample([]string{"latency"}, 0.5)
+	sinks[1].AddSample([]string{"latency"}, 5)
+	for _, sink := range sinks {
+		sink.Shutdown()
+	}
+
+	// A file which has not been written to for too long is removed
+	stale := filepath.Join(dir, "metrics-stale.pb")
+	if err := os.WriteFile(stale, nil, 0644); err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(stale, old, old)
+
+	mfs, err := NewMultiProcessGatherer(dir, time.Minute).Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatalf("stale file was not removed: %v", err)
+	}
+
+	got := make(map[string][]*dto.Metric)
+	for _, mf := range mfs {
+		got[mf.GetName()] = mf.GetMetric()
+	}
+	if len(got) != 3 {
+		t.Fatalf("bad families: %v", mfs)
+	}
+	requests := got["requests"]
+	if len(requests) != 2 || requests[0].GetCounter().GetValue() != 1 || requests[1].GetCounter().GetValue() != 5 {
+		t.Fatalf("bad requests: %v", requests)
+	}
+	if v := got["inflight"][0].GetGauge().GetValue(); v != 5 {
+		t.Fatalf("bad inflight: %v", v)
+	}
+	h := got["latency"][0].GetHistogram()
+	var buckets []uint64
+	for _, b := range h.GetBucket() {
+		buckets = append(buckets, b.GetCumulativeCount())
+	}
+	if h.GetSampleCount() != 2 || h.GetSampleSum() != 5.5 || !reflect.DeepEqual(buckets, []uint64{1, 2}) {
+		t.Fatalf("bad latency: %v", h)
+	}
+}
+
+func TestMultiProcessGaugeMerge(t *testing.T) {
+	dir := t.TempDir()
+	var sinks []*MultiProcessSink
+	for n := 0; n < 2; n++ {
+		sink, err := NewMultiProcessSink(dir, time.Hour, PrometheusOpts{})
+		if err != nil {
+			t.Fatalf("err = %v, want nil", err)
+		}
+		sinks = append(sinks, sink)
+	}
+	if sinks[0].path == sinks[1].path {
+		t.Fatalf("sinks share the file %s", sinks[0].path)
+	}
+
+	sinks[0].SetGauge([]string{"inflight"}, 1)
+	sinks[1].SetGauge([]string{"inflight"}, 4)
+	sinks[0].Shutdown()
+	if err := sinks[1].write(); err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	defer sinks[1].Shutdown()
+
+	for merge, want := range map[GaugeMerge]float64{
+		GaugeMergeSum:  5,
+		GaugeMergeMax:  4,
+		GaugeMergeMin:  1,
+		GaugeMergeLive: 4,
+	} {
+		mfs, err := NewMultiProcessGathererWithMerge(dir, 0, merge).Gather()
+		if err != nil {
+			t.Fatalf("gather failed: %v", err)
+		}
+		if len(mfs) != 1 || mfs[0].GetMetric()[0].GetGauge().GetValue() != want {
+			t.Fatalf("merge %d: bad families: %v", merge, mfs)
+		}
+	}
+}
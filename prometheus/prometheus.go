@@ -5,16 +5,29 @@ package prometheus
 
 import (
 	"fmt"
-	"log"
+	"net/http"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
+	iradix "github.com/hashicorp/go-immutable-radix"
 	"github.com/hashicorp/go-metrics"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/client_golang/prometheus/push"
 )
 
+// sampleLabels are the label names summaries and histograms reserve for their
+// quantiles and buckets
+var sampleLabels = []string{"quantile", "le"}
+
+// defaultNativeHistogramMaxBuckets is the default of
+// PrometheusOpts.NativeHistogramMaxBuckets
+const defaultNativeHistogramMaxBuckets = 160
+
 var (
 	// DefaultPrometheusOpts is the default set of options used when creating a
 	// PrometheusSink.
@@ -22,6 +35,11 @@ var (
 		Expiration: 60 * time.Second,
 		Name:       "default_prometheus_sink",
 	}
+
+	// DefaultSummaryObjectives are the quantiles, mapped to their allowed
+	// absolute error, summaries are exported with unless
+	// PrometheusOpts.SummaryObjectives is set.
+	DefaultSummaryObjectives = map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001}
 )
 
 // PrometheusOpts is used to configure the Prometheus Sink
@@ -29,8 +47,65 @@ type PrometheusOpts struct {
 	// Expiration is the duration a metric is valid for, after which it will be
 	// untracked. If the value is zero, a metric is never expired.
 	Expiration time.Duration
+
+	// Registerer is the registry the sink registers itself with, so its
+	// metrics are exposed along with the application's own. It defaults to
+	// prometheus.DefaultRegisterer.
 	Registerer prometheus.Registerer
 
+	// Gatherer is the registry Gatherer and Handler expose the metrics of.
+	// It only needs to be set if Registerer is not a prometheus.Gatherer
+	// itself, e.g. because it was wrapped with prometheus.WrapRegistererWith,
+	// in which case it should be the registry that was wrapped.
+	Gatherer prometheus.Gatherer
+
+	// ExpirationPolicy, if set, takes precedence over Expiration and allows
+	// the expiration to be configured per key prefix.
+	ExpirationPolicy *metrics.ExpirationPolicy
+
+	// ExpirationOverrides and NeverExpire are a shorthand for an
+	// ExpirationPolicy applying Expiration to all metrics except those
+	// matching a key prefix in ExpirationOverrides, which get its duration,
+	// or in NeverExpire, which are never expired. Prefixes use '.' as the
+	// separator and the longest matching one wins. They are ignored if
+	// ExpirationPolicy is set.
+	ExpirationOverrides map[string]time.Duration
+	NeverExpire         []string
+
+	// Help maps the names of metrics, as exported to Prometheus, to the text
+	// of their HELP line, e.g. "http_requests": "Requests served". The help
+	// of metrics declared in GaugeDefinitions, SummaryDefinitions and
+	// CounterDefinitions takes precedence. Counters of CounterTypeCounter
+	// match with or without the _total suffix appended on export. Metrics
+	// without help use their name.
+	Help map[string]string
+
+	// Namespace and Subsystem are prepended to the names of all metrics of
+	// the sink, separated by underscores, following the Prometheus
+	// namespace_subsystem_name convention, e.g. a Namespace of "consul" and
+	// Subsystem of "raft" export the key "commit.time" as
+	// consul_raft_commit_time. They are applied on export, so keys, Help and
+	// the definitions don't include them.
+	Namespace string
+	Subsystem string
+
+	// ConstLabels are attached to every metric of the sink, e.g. the region
+	// or cluster the application runs in, without having to be passed to
+	// every call. Labels of the same name emitted with a metric are renamed
+	// with an exported_ prefix.
+	ConstLabels []metrics.Label
+
+	// CounterTypes declares how keys emitted with IncrCounter are exported,
+	// by key prefix, with '.' as the separator and the longest matching
+	// prefix winning. The empty prefix matches every key. Keys without a
+	// type are exported as counters named after the key.
+	//
+	// Ex: CounterTypes: map[string]CounterType{
+	//     "":                 CounterTypeCounter, // http_requests_total
+	//     "raft.queue.depth": CounterTypeGauge,
+	// }
+	CounterTypes map[string]CounterType
+
 	// Gauges, Summaries, and Counters allow us to pre-declare metrics by giving
 	// their Name, Help, and ConstLabels to the PrometheusSink when it is created.
 	// Metrics declared in this way will be initialized at zero and will not be
@@ -50,6 +125,38 @@ type PrometheusOpts struct {
 	SummaryDefinitions []SummaryDefinition
 	CounterDefinitions []CounterDefinition
 	Name               string
+
+	// Sanitizer normalizes metric and label names into the character set
+	// Prometheus accepts. Defaults to DefaultSanitizer.
+	Sanitizer metrics.Sanitizer
+
+	// SummaryObjectives are the quantiles summaries are exported with,
+	// mapped to their allowed absolute error, e.g. 0.999: 0.0001 for the
+	// 99.9th percentile. A higher precision costs memory and CPU time per
+	// observation. An empty map exports summaries with just their sum and
+	// count. Defaults to DefaultSummaryObjectives.
+	SummaryObjectives map[float64]float64
+
+	// HistogramBuckets, if set, makes samples whose keys it has buckets for
+	// be exported as histograms instead of summaries. Unlike the quantiles
+	// of summaries, histogram buckets can be aggregated across instances.
+	// Samples declared in SummaryDefinitions remain summaries.
+	HistogramBuckets *metrics.HistogramBuckets
+
+	// NativeHistogramBucketFactor, if greater than 1, makes samples be
+	// exported as native histograms, whose exponential buckets each cover a
+	// range at most this factor wide, e.g. 1.1. A native histogram is a
+	// single series and about as cheap as a summary. Samples which also have
+	// HistogramBuckets get both the native and the classic buckets. Native
+	// histograms are only exposed in the protobuf exposition format, and
+	// scraped by Prometheus 2.40 and later with native histograms enabled.
+	// Samples declared in SummaryDefinitions remain summaries.
+	NativeHistogramBucketFactor float64
+
+	// NativeHistogramMaxBuckets limits the number of buckets of a native
+	// histogram. Once exceeded, the resolution of the histogram is reduced.
+	// Defaults to 160.
+	NativeHistogramMaxBuckets uint32
 }
 
 type PrometheusSink struct {
@@ -57,9 +164,26 @@ type PrometheusSink struct {
 	gauges     sync.Map
 	summaries  sync.Map
 	counters   sync.Map
+	histograms sync.Map
 	expiration time.Duration
+	policy     *metrics.ExpirationPolicy
+	sanitizer  metrics.Sanitizer
 	help       map[string]string
+	metricHelp map[string]string
+	types      *iradix.Tree
+	objectives map[float64]float64
+	labels     prometheus.Labels
+	prefix     string
 	name       string
+
+	nativeFactor     float64
+	nativeMaxBuckets uint32
+
+	// buckets holds the *metrics.HistogramBuckets, see SetHistogramBuckets
+	buckets atomic.Value
+
+	gatherer     prometheus.Gatherer
+	gathererOnce sync.Once
 }
 
 // GaugeDefinition can be provided to PrometheusOpts to declare a constant gauge that is not deleted on expiry.
@@ -72,6 +196,7 @@ type GaugeDefinition struct {
 type gauge struct {
 	prometheus.Gauge
 	updatedAt time.Time
+	ttl       time.Duration
 	// canDelete is set if the metric is created during runtime so we know it's ephemeral and can delete it on expiry.
 	canDelete bool
 }
@@ -81,11 +206,22 @@ type SummaryDefinition struct {
 	Name        []string
 	ConstLabels []metrics.Label
 	Help        string
+
+	// Objectives overrides PrometheusOpts.SummaryObjectives for this summary
+	Objectives map[float64]float64
 }
 
 type summary struct {
 	prometheus.Summary
 	updatedAt time.Time
+	ttl       time.Duration
+	canDelete bool
+}
+
+type histogram struct {
+	prometheus.Histogram
+	updatedAt time.Time
+	ttl       time.Duration
 	canDelete bool
 }
 
@@ -96,9 +232,29 @@ type CounterDefinition struct {
 	Help        string
 }
 
+// CounterType is how the values of a key emitted with IncrCounter are
+// exported, see PrometheusOpts.CounterTypes.
+type CounterType int
+
+const (
+	// CounterTypeDefault exports a counter named after the key, as is done
+	// for keys without a type.
+	CounterTypeDefault CounterType = iota
+
+	// CounterTypeCounter exports a monotonic counter whose name ends in
+	// _total, following the Prometheus and OpenMetrics naming conventions.
+	// Negative increments are dropped.
+	CounterTypeCounter
+
+	// CounterTypeGauge exports a gauge the increments are added to, for
+	// values which go down as well as up, such as the depth of a queue.
+	CounterTypeGauge
+)
+
 type counter struct {
 	prometheus.Counter
 	updatedAt time.Time
+	ttl       time.Duration
 	canDelete bool
 }
 
@@ -113,25 +269,85 @@ func NewPrometheusSinkFrom(opts PrometheusOpts) (*PrometheusSink, error) {
 	if name == "" {
 		name = "default_prometheus_sink"
 	}
+	sanitizer := opts.Sanitizer
+	if sanitizer == nil {
+		sanitizer = DefaultSanitizer
+	}
 	sink := &PrometheusSink{
 		gauges:     sync.Map{},
 		summaries:  sync.Map{},
 		counters:   sync.Map{},
 		expiration: opts.Expiration,
+		policy:     expirationPolicy(opts),
+		sanitizer:  sanitizer,
 		help:       make(map[string]string),
+		metricHelp: opts.Help,
+		types:      counterTypes(opts.CounterTypes),
+		objectives: opts.SummaryObjectives,
+		labels:     prometheusLabels(sanitizeLabels(sanitizer, opts.ConstLabels)),
+		prefix:     namePrefix(sanitizer, opts.Namespace, opts.Subsystem),
 		name:       name,
+
+		nativeFactor:     opts.NativeHistogramBucketFactor,
+		nativeMaxBuckets: opts.NativeHistogramMaxBuckets,
 	}
+	if sink.nativeMaxBuckets == 0 {
+		sink.nativeMaxBuckets = defaultNativeHistogramMaxBuckets
+	}
+	sink.SetHistogramBuckets(opts.HistogramBuckets)
 
-	initGauges(&sink.gauges, opts.GaugeDefinitions, sink.help)
-	initSummaries(&sink.summaries, opts.SummaryDefinitions, sink.help)
-	initCounters(&sink.counters, opts.CounterDefinitions, sink.help)
+	initGauges(&sink.gauges, opts.GaugeDefinitions, sink.help, sanitizer)
+	initSummaries(&sink.summaries, opts.SummaryDefinitions, sink.help, sanitizer, sink.summaryObjectives())
+	initCounters(&sink.counters, opts.CounterDefinitions, sink.help, sanitizer, sink.types)
 
 	reg := opts.Registerer
 	if reg == nil {
 		reg = prometheus.DefaultRegisterer
 	}
+	sink.gatherer = opts.Gatherer
+	if sink.gatherer == nil {
+		sink.gatherer, _ = reg.(prometheus.Gatherer)
+	}
+
+	return sink, sink.wrapRegisterer(reg).Register(sink)
+}
+
+// Gatherer returns the registry the sink's metrics are gathered from, see
+// PrometheusOpts.Gatherer. If the sink's registry is unknown, such as for a
+// PrometheusPushSink, it is a registry holding just the sink.
+func (p *PrometheusSink) Gatherer() prometheus.Gatherer {
+	p.gathererOnce.Do(func() {
+		if p.gatherer == nil {
+			reg := prometheus.NewRegistry()
+			p.wrapRegisterer(reg).MustRegister(p)
+			p.gatherer = reg
+		}
+	})
+	return p.gatherer
+}
+
+// wrapRegisterer wraps reg to attach the ConstLabels and the Namespace and
+// Subsystem prefix to the sink's metrics
+func (p *PrometheusSink) wrapRegisterer(reg prometheus.Registerer) prometheus.Registerer {
+	if p.prefix != "" {
+		reg = prometheus.WrapRegistererWithPrefix(p.prefix, reg)
+	}
+	if len(p.labels) != 0 {
+		reg = prometheus.WrapRegistererWith(p.labels, reg)
+	}
+	return reg
+}
 
-	return sink, reg.Register(sink)
+// Handler returns an http.Handler exposing the metrics of Gatherer, so they
+// can be scraped without setting up promhttp. Scrapers which ask for the
+// OpenMetrics format get it, including exemplars and the creation time of
+// counters, summaries and histograms as _created samples. Others get the
+// Prometheus text format.
+func (p *PrometheusSink) Handler() http.Handler {
+	return promhttp.HandlerFor(p.Gatherer(), promhttp.HandlerOpts{
+		EnableOpenMetrics:                   true,
+		EnableOpenMetricsTextCreatedSamples: true,
+	})
 }
 
 // Describe sends a Collector.Describe value from the descriptor created around PrometheusSink.Name
@@ -155,19 +371,22 @@ func (p *PrometheusSink) Collect(c chan<- prometheus.Metric) {
 // collectAtTime allows internal testing of the expiry based logic here without
 // mocking clocks or making tests timing sensitive.
 func (p *PrometheusSink) collectAtTime(c chan<- prometheus.Metric, t time.Time) {
-	expire := p.expiration != 0
+	families := newFamilies()
 	p.gauges.Range(func(k, v interface{}) bool {
 		if v == nil {
 			return true
 		}
 		g := v.(*gauge)
 		lastUpdate := g.updatedAt
-		if expire && lastUpdate.Add(p.expiration).Before(t) {
+		if g.ttl != 0 && lastUpdate.Add(g.ttl).Before(t) {
 			if g.canDelete {
 				p.gauges.Delete(k)
 				return true
 			}
 		}
+		if !families.claim(k.(string), "gauge") {
+			return true
+		}
 		g.Collect(c)
 		return true
 	})
@@ -177,81 +396,420 @@ func (p *PrometheusSink) collectAtTime(c chan<- prometheus.Metric, t time.Time)
 		}
 		s := v.(*summary)
 		lastUpdate := s.updatedAt
-		if expire && lastUpdate.Add(p.expiration).Before(t) {
+		if s.ttl != 0 && lastUpdate.Add(s.ttl).Before(t) {
 			if s.canDelete {
 				p.summaries.Delete(k)
 				return true
 			}
 		}
+		if !families.claim(k.(string), "summary") {
+			return true
+		}
 		s.Collect(c)
 		return true
 	})
+	p.histograms.Range(func(k, v interface{}) bool {
+		if v == nil {
+			return true
+		}
+		h := v.(*histogram)
+		lastUpdate := h.updatedAt
+		if h.ttl != 0 && lastUpdate.Add(h.ttl).Before(t) {
+			if h.canDelete {
+				p.histograms.Delete(k)
+				return true
+			}
+		}
+		if !families.claim(k.(string), "histogram") {
+			return true
+		}
+		h.Collect(c)
+		return true
+	})
 	p.counters.Range(func(k, v interface{}) bool {
 		if v == nil {
 			return true
 		}
 		count := v.(*counter)
 		lastUpdate := count.updatedAt
-		if expire && lastUpdate.Add(p.expiration).Before(t) {
+		if count.ttl != 0 && lastUpdate.Add(count.ttl).Before(t) {
 			if count.canDelete {
 				p.counters.Delete(k)
 				return true
 			}
 		}
+		if !families.claim(k.(string), "counter") {
+			return true
+		}
 		count.Collect(c)
 		return true
 	})
+
+	if families.collisions > 0 {
+		c <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(p.name+"_collisions", collisionsHelp, nil, nil),
+			prometheus.GaugeValue,
+			float64(families.collisions),
+		)
+	}
+}
+
+// Delete removes the series of the given key and labels, whatever its type,
+// so it is no longer exported, e.g. once the peer it describes has left.
+// Prometheus marks series that disappear from a scrape as stale, so they end
+// right away instead of after the expiration. Metrics declared in the definitions
+// are not deleted. Delete returns whether a series was removed.
+func (p *PrometheusSink) Delete(parts []string, labels []metrics.Label) bool {
+	_, hash := flattenKey(p.sanitizer, parts, p.seriesLabels(labels))
+	_, sampleHash := flattenKey(p.sanitizer, parts, p.seriesLabels(labels, sampleLabels...))
+	_, counterHash := counterKey(p.sanitizer, p.types, parts, p.seriesLabels(labels))
+
+	deleted := false
+	for _, m := range []struct {
+		metrics *sync.Map
+		hash    string
+	}{
+		{&p.gauges, hash},
+		{&p.summaries, sampleHash},
+		{&p.histograms, sampleHash},
+		{&p.counters, counterHash},
+	} {
+		v, ok := m.metrics.Load(m.hash)
+		if !ok || !deletable(v) {
+			continue
+		}
+		m.metrics.Delete(m.hash)
+		deleted = true
+	}
+	return deleted
+}
+
+// deletable returns whether a metric was created at runtime, rather than
+// declared in the definitions
+func deletable(v interface{}) bool {
+	switch m := v.(type) {
+	case *gauge:
+		return m.canDelete
+	case *summary:
+		return m.canDelete
+	case *histogram:
+		return m.canDelete
+	case *counter:
+		return m.canDelete
+	}
+	return false
 }
 
-func initGauges(m *sync.Map, gauges []GaugeDefinition, help map[string]string) {
+const collisionsHelp = "Series left out of the scrape because their name is used by a metric of another type"
+
+// families tracks the types of the metric families collected by a scrape.
+// Metrics of the same name but different types, or whose names clash with
+// the _sum, _count and _bucket series of summaries and histograms, would make
+// the whole scrape fail, so only the first type collected for a name is
+// exported and the others are counted as collisions.
+type families struct {
+	types      map[string]string
+	collisions int
+}
+
+func newFamilies() *families {
+	return &families{types: make(map[string]string)}
+}
+
+// claim returns whether the series with the given hash and type may be
+// collected
+func (f *families) claim(hash, typ string) bool {
+	name := hash
+	if i := strings.IndexByte(hash, ';'); i >= 0 {
+		name = hash[:i]
+	}
+	if existing, ok := f.types[name]; ok {
+		if existing != typ {
+			f.collisions++
+			return false
+		}
+		return true
+	}
+	for _, suffix := range []string{"_sum", "_count", "_bucket"} {
+		if base := strings.TrimSuffix(name, suffix); base != name {
+			if t := f.types[base]; t == "summary" || t == "histogram" {
+				f.collisions++
+				return false
+			}
+		}
+		if _, ok := f.types[name+suffix]; ok && (typ == "summary" || typ == "histogram") {
+			f.collisions++
+			return false
+		}
+	}
+	f.types[name] = typ
+	return true
+}
+
+// SetHistogramBuckets sets the buckets of the histograms samples are exported
+// as, see PrometheusOpts.HistogramBuckets. It applies to keys first seen after
+// the call.
+func (p *PrometheusSink) SetHistogramBuckets(buckets *metrics.HistogramBuckets) {
+	p.buckets.Store(buckets)
+}
+
+// histogramBuckets returns the buckets of a histogram for the given key, or
+// nil if its samples should be exported as a summary
+func (p *PrometheusSink) histogramBuckets(parts []string) []float64 {
+	buckets, _ := p.buckets.Load().(*metrics.HistogramBuckets)
+	if buckets == nil {
+		return nil
+	}
+	return buckets.For(parts)
+}
+
+// expirationPolicy returns the ExpirationPolicy configured by opts, or nil if
+// Expiration applies to all metrics
+func expirationPolicy(opts PrometheusOpts) *metrics.ExpirationPolicy {
+	if opts.ExpirationPolicy != nil {
+		return opts.ExpirationPolicy
+	}
+	if len(opts.ExpirationOverrides) == 0 && len(opts.NeverExpire) == 0 {
+		return nil
+	}
+	rules := make(map[string]time.Duration, len(opts.ExpirationOverrides)+len(opts.NeverExpire))
+	for prefix, ttl := range opts.ExpirationOverrides {
+		rules[prefix] = ttl
+	}
+	for _, prefix := range opts.NeverExpire {
+		rules[prefix] = 0
+	}
+	return metrics.NewExpirationPolicy(opts.Expiration, rules)
+}
+
+func counterTypes(types map[string]CounterType) *iradix.Tree {
+	tree := iradix.New()
+	for prefix, typ := range types {
+		tree, _, _ = tree.Insert([]byte(prefix), typ)
+	}
+	return tree
+}
+
+// counterType returns the CounterType of the given key in types
+func counterType(types *iradix.Tree, parts []string) CounterType {
+	if types == nil || types.Len() == 0 {
+		return CounterTypeDefault
+	}
+	_, typ, ok := types.Root().LongestPrefix([]byte(strings.Join(parts, ".")))
+	if !ok {
+		return CounterTypeDefault
+	}
+	return typ.(CounterType)
+}
+
+// counterKey is flattenKey for counters, which appends _total to the name
+// of counters of type CounterTypeCounter
+func counterKey(sanitizer metrics.Sanitizer, types *iradix.Tree, parts []string, labels []metrics.Label) (string, string) {
+	key, hash := flattenKey(sanitizer, parts, labels)
+	if counterType(types, parts) == CounterTypeCounter && !strings.HasSuffix(key, "_total") {
+		hash = key + "_total" + hash[len(key):]
+		key += "_total"
+	}
+	return key, hash
+}
+
+// namePrefix returns the sanitized prefix of the Namespace and Subsystem
+func namePrefix(sanitizer metrics.Sanitizer, namespace, subsystem string) string {
+	var prefix string
+	for _, part := range []string{namespace, subsystem} {
+		if part != "" {
+			prefix += sanitizer.SanitizeName(part) + "_"
+		}
+	}
+	return prefix
+}
+
+// summaryObjectives returns the objectives of summaries created at runtime
+func (p *PrometheusSink) summaryObjectives() map[float64]float64 {
+	if p.objectives == nil {
+		return DefaultSummaryObjectives
+	}
+	return p.objectives
+}
+
+// helpFor returns the help text of a metric of the given type created at
+// runtime
+func (p *PrometheusSink) helpFor(typ, key string) string {
+	if help, ok := p.help[typ+"."+key]; ok {
+		return help
+	}
+	if help, ok := p.metricHelp[key]; ok {
+		return help
+	}
+	// Help may name a counter without the _total suffix added by counterKey
+	if typ == "counter" {
+		if help, ok := p.metricHelp[strings.TrimSuffix(key, "_total")]; ok {
+			return help
+		}
+	}
+	return key
+}
+
+// ttl returns the expiration for a metric created at runtime with the given key
+func (p *PrometheusSink) ttl(parts []string) time.Duration {
+	if p.policy != nil {
+		return p.policy.TTL(parts)
+	}
+	return p.expiration
+}
+
+func initGauges(m *sync.Map, gauges []GaugeDefinition, help map[string]string, sanitizer metrics.Sanitizer) {
 	for _, g := range gauges {
-		key, hash := flattenKey(g.Name, g.ConstLabels)
+		labels := sanitizeLabels(sanitizer, g.ConstLabels)
+		key, hash := flattenKey(sanitizer, g.Name, labels)
 		help[fmt.Sprintf("gauge.%s", key)] = g.Help
 		pG := prometheus.NewGauge(prometheus.GaugeOpts{
 			Name:        key,
 			Help:        g.Help,
-			ConstLabels: prometheusLabels(g.ConstLabels),
+			ConstLabels: prometheusLabels(labels),
 		})
 		m.Store(hash, &gauge{Gauge: pG})
 	}
 	return
 }
 
-func initSummaries(m *sync.Map, summaries []SummaryDefinition, help map[string]string) {
+func initSummaries(m *sync.Map, summaries []SummaryDefinition, help map[string]string, sanitizer metrics.Sanitizer, objectives map[float64]float64) {
 	for _, s := range summaries {
-		key, hash := flattenKey(s.Name, s.ConstLabels)
+		objs := objectives
+		if s.Objectives != nil {
+			objs = s.Objectives
+		}
+		labels := sanitizeLabels(sanitizer, s.ConstLabels)
+		key, hash := flattenKey(sanitizer, s.Name, labels)
 		help[fmt.Sprintf("summary.%s", key)] = s.Help
 		pS := prometheus.NewSummary(prometheus.SummaryOpts{
 			Name:        key,
 			Help:        s.Help,
 			MaxAge:      10 * time.Second,
-			ConstLabels: prometheusLabels(s.ConstLabels),
-			Objectives:  map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+			ConstLabels: prometheusLabels(labels),
+			Objectives:  objs,
 		})
 		m.Store(hash, &summary{Summary: pS})
 	}
 	return
 }
 
-func initCounters(m *sync.Map, counters []CounterDefinition, help map[string]string) {
+func initCounters(m *sync.Map, counters []CounterDefinition, help map[string]string, sanitizer metrics.Sanitizer, types *iradix.Tree) {
 	for _, c := range counters {
-		key, hash := flattenKey(c.Name, c.ConstLabels)
+		labels := sanitizeLabels(sanitizer, c.ConstLabels)
+		key, hash := counterKey(sanitizer, types, c.Name, labels)
 		help[fmt.Sprintf("counter.%s", key)] = c.Help
 		pC := prometheus.NewCounter(prometheus.CounterOpts{
 			Name:        key,
 			Help:        c.Help,
-			ConstLabels: prometheusLabels(c.ConstLabels),
+			ConstLabels: prometheusLabels(labels),
 		})
 		m.Store(hash, &counter{Counter: pC})
 	}
 	return
 }
 
-var forbiddenCharsReplacer = strings.NewReplacer(" ", "_", ".", "_", "=", "_", "-", "_", "/", "_")
+// DefaultSanitizer is the Sanitizer used by a PrometheusSink unless one is
+// given in PrometheusOpts. Every rune that is not allowed in a Prometheus
+// metric or label name is replaced with an underscore, names starting with a
+// digit are prefixed with one, and invalid UTF-8 in label values is replaced.
+var DefaultSanitizer metrics.Sanitizer = prometheusSanitizer{}
+
+type prometheusSanitizer struct{}
+
+func (prometheusSanitizer) SanitizeName(name string) string {
+	return sanitizeName(name, true)
+}
+
+func (prometheusSanitizer) SanitizeLabel(label metrics.Label) metrics.Label {
+	label.Name = sanitizeName(label.Name, false)
+	// Names starting with __ are reserved for internal use
+	for strings.HasPrefix(label.Name, "__") {
+		label.Name = label.Name[1:]
+	}
+	if !utf8.ValidString(label.Value) {
+		label.Value = strings.Map(func(r rune) rune { return r }, label.Value)
+	}
+	return label
+}
+
+// sanitizeName replaces all runes not matching [a-zA-Z_:][a-zA-Z0-9_:]* for
+// metric names, or [a-zA-Z_][a-zA-Z0-9_]* for label names, with underscores
+func sanitizeName(name string, allowColons bool) string {
+	if name == "" {
+		return "_"
+	}
+	sanitized := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r == ':' && allowColons:
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+	if sanitized[0] >= '0' && sanitized[0] <= '9' {
+		sanitized = "_" + sanitized
+	}
+	return sanitized
+}
+
+// sanitizeLabels returns a sanitized copy of labels, sorted by name. Of labels
+// whose names are equal after sanitization the last one is kept, so a series
+// is the same whatever the order of its labels, and never has two labels of
+// the same name.
+func sanitizeLabels(sanitizer metrics.Sanitizer, labels []metrics.Label) []metrics.Label {
+	if len(labels) == 0 {
+		return labels
+	}
+	sanitized := make([]metrics.Label, len(labels))
+	for i, label := range labels {
+		sanitized[i] = sanitizer.SanitizeLabel(label)
+	}
+	return sortLabels(sanitized)
+}
 
-func flattenKey(parts []string, labels []metrics.Label) (string, string) {
+// sortLabels sorts labels by name in place, keeping only the last of labels
+// with the same name
+func sortLabels(labels []metrics.Label) []metrics.Label {
+	sort.SliceStable(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+	out := labels[:0]
+	for i, label := range labels {
+		if i+1 < len(labels) && labels[i+1].Name == label.Name {
+			continue
+		}
+		out = append(out, label)
+	}
+	return out
+}
+
+// seriesLabels returns the sanitized labels of a series. Labels named like
+// one of the ConstLabels or one of the reserved label names of its type are
+// renamed with an exported_ prefix, as Prometheus does for labels clashing
+// with its target labels.
+func (p *PrometheusSink) seriesLabels(labels []metrics.Label, reserved ...string) []metrics.Label {
+	labels = sanitizeLabels(p.sanitizer, labels)
+	renamed := false
+	for i, label := range labels {
+		_, clash := p.labels[label.Name]
+		for _, name := range reserved {
+			clash = clash || label.Name == name
+		}
+		if clash {
+			labels[i].Name = "exported_" + label.Name
+			renamed = true
+		}
+	}
+	if renamed {
+		labels = sortLabels(labels)
+	}
+	return labels
+}
+
+func flattenKey(sanitizer metrics.Sanitizer, parts []string, labels []metrics.Label) (string, string) {
 	key := strings.Join(parts, "_")
-	key = forbiddenCharsReplacer.Replace(key)
+	key = sanitizer.SanitizeName(key)
 
 	hash := key
 	for _, label := range labels {
@@ -274,7 +832,14 @@ func (p *PrometheusSink) SetGauge(parts []string, val float32) {
 }
 
 func (p *PrometheusSink) SetGaugeWithLabels(parts []string, val float32, labels []metrics.Label) {
-	key, hash := flattenKey(parts, labels)
+	p.updateGauge(parts, labels, func(g prometheus.Gauge) { g.Set(float64(val)) })
+}
+
+// updateGauge applies update to the gauge of the given key, creating it if
+// needed
+func (p *PrometheusSink) updateGauge(parts []string, labels []metrics.Label, update func(prometheus.Gauge)) {
+	labels = p.seriesLabels(labels)
+	key, hash := flattenKey(p.sanitizer, parts, labels)
 	pg, ok := p.gauges.Load(hash)
 
 	// The sync.Map underlying gauges stores pointers to our structs. If we need to make updates,
@@ -285,26 +850,23 @@ func (p *PrometheusSink) SetGaugeWithLabels(parts []string, val float32, labels
 	// value, but since we're always setting it to time.Now(), it doesn't really matter.
 	if ok {
 		localGauge := *pg.(*gauge)
-		localGauge.Set(float64(val))
+		update(localGauge.Gauge)
 		localGauge.updatedAt = time.Now()
 		p.gauges.Store(hash, &localGauge)
 
 		// The gauge does not exist, create the gauge and allow it to be deleted
 	} else {
-		help := key
-		existingHelp, ok := p.help[fmt.Sprintf("gauge.%s", key)]
-		if ok {
-			help = existingHelp
-		}
+		help := p.helpFor("gauge", key)
 		g := prometheus.NewGauge(prometheus.GaugeOpts{
 			Name:        key,
 			Help:        help,
 			ConstLabels: prometheusLabels(labels),
 		})
-		g.Set(float64(val))
+		update(g)
 		pg = &gauge{
 			Gauge:     g,
 			updatedAt: time.Now(),
+			ttl:       p.ttl(parts),
 			canDelete: true,
 		}
 		p.gauges.Store(hash, pg)
@@ -316,34 +878,81 @@ func (p *PrometheusSink) AddSample(parts []string, val float32) {
 }
 
 func (p *PrometheusSink) AddSampleWithLabels(parts []string, val float32, labels []metrics.Label) {
-	key, hash := flattenKey(parts, labels)
+	p.AddPrecisionSampleWithLabels(parts, float64(val), labels)
+}
+
+func (p *PrometheusSink) AddPrecisionSample(parts []string, val float64) {
+	p.AddPrecisionSampleWithLabels(parts, val, nil)
+}
+
+func (p *PrometheusSink) AddPrecisionSampleWithLabels(parts []string, val float64, labels []metrics.Label) {
+	p.observe(parts, val, labels, nil)
+}
+
+// AddSampleWithExemplar adds the sample along with the exemplar, if the sample
+// is exported as a histogram. Summaries don't support exemplars. Exemplars are
+// only exposed in the OpenMetrics exposition format.
+func (p *PrometheusSink) AddSampleWithExemplar(parts []string, val float32, labels []metrics.Label, exemplar []metrics.Label) {
+	p.observe(parts, float64(val), labels, exemplar)
+}
+
+func (p *PrometheusSink) observe(parts []string, val float64, labels []metrics.Label, exemplar []metrics.Label) {
+	labels = p.seriesLabels(labels, sampleLabels...)
+	key, hash := flattenKey(p.sanitizer, parts, labels)
 	ps, ok := p.summaries.Load(hash)
 
 	// Does the summary already exist for this sample type?
 	if ok {
 		localSummary := *ps.(*summary)
-		localSummary.Observe(float64(val))
+		localSummary.Observe(val)
 		localSummary.updatedAt = time.Now()
 		p.summaries.Store(hash, &localSummary)
+		return
+	}
+
+	// Does a histogram exist, or should one be created?
+	if ph, ok := p.histograms.Load(hash); ok {
+		localHistogram := *ph.(*histogram)
+		observeWithExemplar(localHistogram.Histogram, val, p.exemplarLabels(exemplar))
+		localHistogram.updatedAt = time.Now()
+		p.histograms.Store(hash, &localHistogram)
+	} else if buckets := p.histogramBuckets(parts); buckets != nil || p.nativeFactor > 1 {
+		opts := prometheus.HistogramOpts{
+			Name:        key,
+			Help:        p.helpFor("histogram", key),
+			ConstLabels: prometheusLabels(labels),
+			Buckets:     buckets,
+		}
+		if p.nativeFactor > 1 {
+			opts.NativeHistogramBucketFactor = p.nativeFactor
+			opts.NativeHistogramMaxBucketNumber = p.nativeMaxBuckets
+			opts.NativeHistogramMinResetDuration = time.Hour
+		}
+		h := prometheus.NewHistogram(opts)
+		observeWithExemplar(h, val, p.exemplarLabels(exemplar))
+		ph = &histogram{
+			Histogram: h,
+			updatedAt: time.Now(),
+			ttl:       p.ttl(parts),
+			canDelete: true,
+		}
+		p.histograms.Store(hash, ph)
 
 		// The summary does not exist, create the Summary and allow it to be deleted
 	} else {
-		help := key
-		existingHelp, ok := p.help[fmt.Sprintf("summary.%s", key)]
-		if ok {
-			help = existingHelp
-		}
+		help := p.helpFor("summary", key)
 		s := prometheus.NewSummary(prometheus.SummaryOpts{
 			Name:        key,
 			Help:        help,
 			MaxAge:      10 * time.Second,
 			ConstLabels: prometheusLabels(labels),
-			Objectives:  map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+			Objectives:  p.summaryObjectives(),
 		})
-		s.Observe(float64(val))
+		s.Observe(val)
 		ps = &summary{
 			Summary:   s,
 			updatedAt: time.Now(),
+			ttl:       p.ttl(parts),
 			canDelete: true,
 		}
 		p.summaries.Store(hash, ps)
@@ -361,38 +970,85 @@ func (p *PrometheusSink) IncrCounter(parts []string, val float32) {
 }
 
 func (p *PrometheusSink) IncrCounterWithLabels(parts []string, val float32, labels []metrics.Label) {
-	key, hash := flattenKey(parts, labels)
+	p.IncrCounterWithExemplar(parts, val, labels, nil)
+}
+
+// IncrCounterWithExemplar increments the counter and attaches the exemplar to
+// it. Exemplars are only exposed in the OpenMetrics exposition format.
+func (p *PrometheusSink) IncrCounterWithExemplar(parts []string, val float32, labels []metrics.Label, exemplar []metrics.Label) {
+	switch counterType(p.types, parts) {
+	case CounterTypeGauge:
+		p.updateGauge(parts, labels, func(g prometheus.Gauge) { g.Add(float64(val)) })
+		return
+	case CounterTypeCounter:
+		if val < 0 {
+			return
+		}
+	}
+
+	labels = p.seriesLabels(labels)
+	key, hash := counterKey(p.sanitizer, p.types, parts, labels)
 	pc, ok := p.counters.Load(hash)
 
 	// Does the counter exist?
 	if ok {
 		localCounter := *pc.(*counter)
-		localCounter.Add(float64(val))
+		addWithExemplar(localCounter.Counter, float64(val), p.exemplarLabels(exemplar))
 		localCounter.updatedAt = time.Now()
 		p.counters.Store(hash, &localCounter)
 
 		// The counter does not exist yet, create it and allow it to be deleted
 	} else {
-		help := key
-		existingHelp, ok := p.help[fmt.Sprintf("counter.%s", key)]
-		if ok {
-			help = existingHelp
-		}
+		help := p.helpFor("counter", key)
 		c := prometheus.NewCounter(prometheus.CounterOpts{
 			Name:        key,
 			Help:        help,
 			ConstLabels: prometheusLabels(labels),
 		})
-		c.Add(float64(val))
+		addWithExemplar(c, float64(val), p.exemplarLabels(exemplar))
 		pc = &counter{
 			Counter:   c,
 			updatedAt: time.Now(),
+			ttl:       p.ttl(parts),
 			canDelete: true,
 		}
 		p.counters.Store(hash, pc)
 	}
 }
 
+// exemplarLabels returns the sanitized exemplar as prometheus.Labels, or nil
+// if there is none or it is longer than Prometheus allows.
+func (p *PrometheusSink) exemplarLabels(exemplar []metrics.Label) prometheus.Labels {
+	if len(exemplar) == 0 {
+		return nil
+	}
+	exemplar = sanitizeLabels(p.sanitizer, exemplar)
+	runes := 0
+	for _, label := range exemplar {
+		runes += utf8.RuneCountInString(label.Name) + utf8.RuneCountInString(label.Value)
+	}
+	if runes > prometheus.ExemplarMaxRunes {
+		return nil
+	}
+	return prometheusLabels(exemplar)
+}
+
+func addWithExemplar(c prometheus.Counter, val float64, exemplar prometheus.Labels) {
+	if ea, ok := c.(prometheus.ExemplarAdder); ok && exemplar != nil {
+		ea.AddWithExemplar(val, exemplar)
+		return
+	}
+	c.Add(val)
+}
+
+func observeWithExemplar(h prometheus.Histogram, val float64, exemplar prometheus.Labels) {
+	if eo, ok := h.(prometheus.ExemplarObserver); ok && exemplar != nil {
+		eo.ObserveWithExemplar(val, exemplar)
+		return
+	}
+	h.Observe(val)
+}
+
 // PrometheusPushSink wraps a normal prometheus sink and provides an address and facilities to export it to an address
 // on an interval.
 type PrometheusPushSink struct {
@@ -410,6 +1066,7 @@ func NewPrometheusPushSink(address string, pushInterval time.Duration, name stri
 		summaries:  sync.Map{},
 		counters:   sync.Map{},
 		expiration: 60 * time.Second,
+		sanitizer:  DefaultSanitizer,
 		name:       "default_prometheus_sink",
 	}
 
@@ -436,7 +1093,7 @@ func (s *PrometheusPushSink) flushMetrics() {
 			case <-ticker.C:
 				err := s.pusher.Push()
 				if err != nil {
-					log.Printf("[ERR] Error pushing to Prometheus! Err: %s", err)
+					metrics.LogError("Error pushing to Prometheus", "sink", "prometheus", "addr", s.address, "class", "push", "error", err)
 				}
 			case <-s.stopChan:
 				ticker.Stop()
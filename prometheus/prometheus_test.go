@@ -3,6 +3,7 @@ package prometheus
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
@@ -42,6 +43,98 @@ func TestNewPrometheusSinkFrom(t *testing.T) {
 	}
 }
 
+func TestPrometheusSink_Gatherer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	app := prometheus.NewCounter(prometheus.CounterOpts{Name: "app_counter", Help: "app"})
+	reg.MustRegister(app)
+
+	sink, err := NewPrometheusSinkFrom(PrometheusOpts{Registerer: reg})
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	if sink.Gatherer() != reg {
+		t.Fatalf("expected the registry to be the gatherer")
+	}
+	sink.IncrCounter([]string{"sink", "counter"}, 1)
+
+	srv := httptest.NewServer(sink.Handler())
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	for _, name := range []string{"app_counter 0", "sink_counter 1"} {
+		if !strings.Contains(string(body), name) {
+			t.Fatalf("expected %q in output:\n%s", name, body)
+		}
+	}
+
+	// A wrapped registerer needs the registry passed explicitly
+	wrapped, err := NewPrometheusSinkFrom(PrometheusOpts{
+		Name:       "wrapped",
+		Registerer: prometheus.WrapRegistererWithPrefix("wrapped_", reg),
+		Gatherer:   reg,
+	})
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	if wrapped.Gatherer() != reg {
+		t.Fatalf("expected the registry to be the gatherer")
+	}
+
+	// Without a known registry the sink gets its own
+	push, err := NewPrometheusPushSink("localhost:0", time.Hour, "push")
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	defer close(push.stopChan)
+	push.IncrCounter([]string{"pushed"}, 1)
+	mfs, err := push.Gatherer().Gather()
+	if err != nil || len(mfs) != 1 || mfs[0].GetName() != "pushed" {
+		t.Fatalf("bad: %v %v", mfs, err)
+	}
+}
+
+func TestPrometheusSink_HandlerOpenMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	sink, err := NewPrometheusSinkFrom(PrometheusOpts{Registerer: reg})
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	sink.IncrCounterWithExemplar([]string{"requests", "total"}, 1, nil, []metrics.Label{{Name: "trace_id", Value: "abc"}})
+	srv := httptest.NewServer(sink.Handler())
+	defer srv.Close()
+
+	scrape := func(accept string) (string, string) {
+		req, _ := http.NewRequest("GET", srv.URL, nil)
+		req.Header.Set("Accept", accept)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.Header.Get("Content-Type"), string(body)
+	}
+
+	contentType, body := scrape("application/openmetrics-text; version=1.0.0")
+	if !strings.HasPrefix(contentType, "application/openmetrics-text") {
+		t.Fatalf("bad content type: %s", contentType)
+	}
+	for _, line := range []string{"# TYPE requests counter", `requests_total 1.0 # {trace_id="abc"} 1.0`, "requests_created ", "# EOF"} {
+		if !strings.Contains(body, line) {
+			t.Fatalf("expected %q in output:\n%s", line, body)
+		}
+	}
+
+	contentType, body = scrape("text/plain")
+	if !strings.HasPrefix(contentType, "text/plain") || strings.Contains(body, "_created") {
+		t.Fatalf("bad output %s:\n%s", contentType, body)
+	}
+}
+
 func TestNewPrometheusSink(t *testing.T) {
 	sink, err := NewPrometheusSink()
 	if err != nil {
@@ -142,21 +235,21 @@ func TestDefinitions(t *testing.T) {
 	// definition matches the key we have for the map entry. Should fail if any metrics exist that aren't defined, or if
 	// the defined metrics don't exist.
 	sink.gauges.Range(func(key, value interface{}) bool {
-		name, _ := flattenKey(gaugeDef.Name, gaugeDef.ConstLabels)
+		name, _ := flattenKey(DefaultSanitizer, gaugeDef.Name, gaugeDef.ConstLabels)
 		if name != key {
 			t.Fatalf("expected my_test_gauge, got #{name}")
 		}
 		return true
 	})
 	sink.summaries.Range(func(key, value interface{}) bool {
-		name, _ := flattenKey(summaryDef.Name, summaryDef.ConstLabels)
+		name, _ := flattenKey(DefaultSanitizer, summaryDef.Name, summaryDef.ConstLabels)
 		if name != key {
 			t.Fatalf("expected my_test_summary, got #{name}")
 		}
 		return true
 	})
 	sink.counters.Range(func(key, value interface{}) bool {
-		name, _ := flattenKey(counterDef.Name, counterDef.ConstLabels)
+		name, _ := flattenKey(DefaultSanitizer, counterDef.Name, counterDef.ConstLabels)
 		if name != key {
 			t.Fatalf("expected my_test_counter, got #{name}")
 		}
@@ -227,7 +320,7 @@ func TestDefinitions(t *testing.T) {
 					t.Fatalf("expected defined summary sum to have value 42 after expiring, got %f", *pb.Summary.SampleSum)
 				}
 			default:
-				t.Fatalf("unexpected metric type %v", pb)
+				t.Fatalf("unexpected metric type %v", &pb)
 			}
 		case <-time.After(100 * time.Millisecond):
 			t.Fatalf("Timed out waiting to collect expected metric. Got %d, want %d", i, expectedNum)
@@ -235,6 +328,570 @@ func TestDefinitions(t *testing.T) {
 	}
 }
 
+func TestExpirationPolicy(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	sink, err := NewPrometheusSinkFrom(PrometheusOpts{
+		Registerer: reg,
+		Expiration: time.Minute,
+		ExpirationPolicy: metrics.NewExpirationPolicy(5*time.Second, map[string]time.Duration{
+			"forever": 0,
+		}),
+	})
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+
+	sink.SetGauge([]string{"forever", "gauge"}, 1)
+	sink.SetGauge([]string{"short", "gauge"}, 1)
+	sink.IncrCounter([]string{"short", "counter"}, 1)
+	sink.AddSample([]string{"forever", "sample"}, 1)
+
+	ch := make(chan prometheus.Metric, 10)
+	sink.collectAtTime(ch, time.Now().Add(10*time.Second))
+	close(ch)
+
+	var names []string
+	for m := range ch {
+		names = append(names, m.Desc().String())
+	}
+	if len(names) != 2 {
+		t.Fatalf("expected 2 metrics to survive expiry, got %v", names)
+	}
+	for _, name := range names {
+		if !strings.Contains(name, "forever_") {
+			t.Fatalf("unexpected metric survived expiry: %s", name)
+		}
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	sink, err := NewPrometheusSinkFrom(PrometheusOpts{
+		Registerer: reg,
+		Expiration: time.Minute,
+		HistogramBuckets: metrics.NewHistogramBuckets(nil, map[string][]float64{
+			"latency": {0.1, 1, 10},
+		}),
+		SummaryDefinitions: []SummaryDefinition{{Name: []string{"latency", "declared"}, Help: "declared"}},
+	})
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	var hs metrics.HistogramSink = sink
+	_ = hs
+
+	sink.AddSampleWithLabels([]string{"latency", "http"}, 0.5, []metrics.Label{{Name: "method", Value: "GET"}})
+	sink.AddSampleWithLabels([]string{"latency", "http"}, 5, []metrics.Label{{Name: "method", Value: "GET"}})
+	sink.AddSample([]string{"latency", "declared"}, 1)
+	sink.AddSample([]string{"size"}, 1)
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+	types := make(map[string]dto.MetricType)
+	for _, mf := range mfs {
+		types[mf.GetName()] = mf.GetType()
+		if mf.GetName() != "latency_http" {
+			continue
+		}
+		h := mf.GetMetric()[0].GetHistogram()
+		if h.GetSampleCount() != 2 || h.GetSampleSum() != 5.5 {
+			t.Fatalf("bad histogram: %v", h)
+		}
+		var cumulative []uint64
+		for _, b := range h.GetBucket() {
+			cumulative = append(cumulative, b.GetCumulativeCount())
+		}
+		if !reflect.DeepEqual(cumulative, []uint64{0, 1, 2}) {
+			t.Fatalf("bad buckets: %v", h.GetBucket())
+		}
+	}
+	expect := map[string]dto.MetricType{
+		"latency_http":     dto.MetricType_HISTOGRAM,
+		"latency_declared": dto.MetricType_SUMMARY,
+		"size":             dto.MetricType_SUMMARY,
+	}
+	if !reflect.DeepEqual(types, expect) {
+		t.Fatalf("bad types: %v", types)
+	}
+
+	// Histograms expire like the other metrics
+	ch := make(chan prometheus.Metric, 10)
+	sink.collectAtTime(ch, time.Now().Add(2*time.Minute))
+	close(ch)
+	for m := range ch {
+		if strings.Contains(m.Desc().String(), "latency_http") {
+			t.Fatalf("expected histogram to expire")
+		}
+	}
+}
+
+func TestNativeHistograms(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	sink, err := NewPrometheusSinkFrom(PrometheusOpts{
+		Registerer:                  reg,
+		NativeHistogramBucketFactor: 1.1,
+		HistogramBuckets: metrics.NewHistogramBuckets(nil, map[string][]float64{
+			"both": {1},
+		}),
+	})
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+
+	for _, v := range []float64{0.5, 1, 2, 4} {
+		sink.AddPrecisionSample([]string{"native"}, v)
+		sink.AddPrecisionSample([]string{"both"}, v)
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+	if len(mfs) != 2 {
+		t.Fatalf("bad: %v", mfs)
+	}
+	for _, mf := range mfs {
+		if mf.GetType() != dto.MetricType_HISTOGRAM {
+			t.Fatalf("bad type for %s: %v", mf.GetName(), mf.GetType())
+		}
+		h := mf.GetMetric()[0].GetHistogram()
+		if h.GetSampleCount() != 4 || len(h.GetPositiveSpan()) == 0 {
+			t.Fatalf("expected native buckets for %s: %v", mf.GetName(), h)
+		}
+		classic := 0
+		if mf.GetName() == "both" {
+			classic = 1
+		}
+		if len(h.GetBucket()) != classic {
+			t.Fatalf("bad classic buckets for %s: %v", mf.GetName(), h.GetBucket())
+		}
+	}
+}
+
+func TestExemplars(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	sink, err := NewPrometheusSinkFrom(PrometheusOpts{
+		Registerer:       reg,
+		HistogramBuckets: metrics.NewHistogramBuckets([]float64{1}, nil),
+	})
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	var es metrics.ExemplarSink = sink
+	_ = es
+
+	exemplar := []metrics.Label{{Name: "trace-id", Value: "abc"}}
+	sink.IncrCounterWithExemplar([]string{"requests"}, 1, nil, exemplar)
+	sink.IncrCounterWithExemplar([]string{"requests"}, 2, nil, nil)
+	sink.AddSampleWithExemplar([]string{"latency"}, 0.5, nil, exemplar)
+	// Too long exemplars are dropped instead of making the client panic
+	sink.IncrCounterWithExemplar([]string{"long"}, 1, nil, []metrics.Label{{Name: "trace_id", Value: strings.Repeat("x", 200)}})
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+	for _, mf := range mfs {
+		m := mf.GetMetric()[0]
+		var e *dto.Exemplar
+		switch mf.GetName() {
+		case "requests":
+			if m.GetCounter().GetValue() != 3 {
+				t.Fatalf("bad counter: %v", m)
+			}
+			e = m.GetCounter().GetExemplar()
+		case "latency":
+			e = m.GetHistogram().GetBucket()[0].GetExemplar()
+		case "long":
+			if m.GetCounter().GetExemplar() != nil {
+				t.Fatalf("expected exemplar to be dropped: %v", m)
+			}
+			continue
+		}
+		if e == nil || len(e.GetLabel()) != 1 || e.GetLabel()[0].GetName() != "trace_id" || e.GetLabel()[0].GetValue() != "abc" {
+			t.Fatalf("bad exemplar for %s: %v", mf.GetName(), e)
+		}
+	}
+}
+
+func TestExpirationOverrides(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	sink, err := NewPrometheusSinkFrom(PrometheusOpts{
+		Registerer: reg,
+		Expiration: 5 * time.Second,
+		ExpirationOverrides: map[string]time.Duration{
+			"churny":      time.Second,
+			"slow":        time.Hour,
+			"slow.churny": time.Second,
+		},
+		NeverExpire: []string{"static"},
+	})
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+
+	for _, key := range []string{"default", "churny", "slow", "slow.churny", "static"} {
+		sink.SetGauge(strings.Split(key, "."), 1)
+	}
+	alive := func(at time.Duration) []string {
+		ch := make(chan prometheus.Metric, 10)
+		sink.collectAtTime(ch, time.Now().Add(at))
+		close(ch)
+		var names []string
+		for m := range ch {
+			names = append(names, m.Desc().String())
+		}
+		return names
+	}
+
+	if names := alive(2 * time.Second); len(names) != 3 {
+		t.Fatalf("expected default, slow and static gauges, got %v", names)
+	}
+	names := alive(2 * time.Hour)
+	if len(names) != 1 || !strings.Contains(names[0], `"static"`) {
+		t.Fatalf("expected only the static gauge, got %v", names)
+	}
+}
+
+func TestHelp(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	sink, err := NewPrometheusSinkFrom(PrometheusOpts{
+		Registerer:       reg,
+		HistogramBuckets: metrics.NewHistogramBuckets(nil, map[string][]float64{"latency": {1}}),
+		Help: map[string]string{
+			"http_requests": "Requests served",
+			"queue_depth":   "Queued jobs",
+			"latency":       "Request latency",
+			"declared":      "ignored",
+			"jobs_done":     "Jobs done",
+		},
+		CounterTypes:       map[string]CounterType{"jobs": CounterTypeCounter},
+		CounterDefinitions: []CounterDefinition{{Name: []string{"declared"}, Help: "Declared counter"}},
+	})
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	sink.IncrCounter([]string{"http", "requests"}, 1)
+	sink.IncrCounter([]string{"jobs", "done"}, 1)
+	sink.SetGauge([]string{"queue", "depth"}, 1)
+	sink.AddSample([]string{"latency"}, 1)
+	sink.AddSample([]string{"size"}, 1)
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+	help := make(map[string]string)
+	for _, mf := range mfs {
+		help[mf.GetName()] = mf.GetHelp()
+	}
+	expect := map[string]string{
+		"http_requests":   "Requests served",
+		"queue_depth":     "Queued jobs",
+		"latency":         "Request latency",
+		"declared":        "Declared counter",
+		"jobs_done_total": "Jobs done",
+		"size":            "size",
+	}
+	if !reflect.DeepEqual(help, expect) {
+		t.Fatalf("bad help: %v", help)
+	}
+}
+
+func TestPrometheusSink_Delete(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	sink, err := NewPrometheusSinkFrom(PrometheusOpts{
+		Registerer:       reg,
+		CounterTypes:     map[string]CounterType{"": CounterTypeCounter},
+		GaugeDefinitions: []GaugeDefinition{{Name: []string{"declared"}}},
+	})
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	peerA := []metrics.Label{{Name: "peer", Value: "a"}}
+	peerB := []metrics.Label{{Name: "peer", Value: "b"}}
+	for _, peer := range [][]metrics.Label{peerA, peerB} {
+		sink.SetGaugeWithLabels([]string{"peer", "lag"}, 1, peer)
+		sink.IncrCounterWithLabels([]string{"peer", "rpcs"}, 1, peer)
+		sink.AddSampleWithLabels([]string{"peer", "rtt"}, 1, peer)
+	}
+
+	for _, key := range [][]string{{"peer", "lag"}, {"peer", "rpcs"}, {"peer", "rtt"}} {
+		if !sink.Delete(key, peerA) {
+			t.Fatalf("%v was not deleted", key)
+		}
+	}
+	if sink.Delete([]string{"peer", "lag"}, peerA) {
+		t.Fatalf("deleted twice")
+	}
+	if sink.Delete([]string{"declared"}, nil) {
+		t.Fatalf("deleted declared gauge")
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+	got := make(map[string]int)
+	for _, mf := range mfs {
+		got[mf.GetName()] = len(mf.GetMetric())
+	}
+	expect := map[string]int{"peer_lag": 1, "peer_rpcs_total": 1, "peer_rtt": 1, "declared": 1}
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("bad series: %v", got)
+	}
+}
+
+func TestLabelAndNameCollisions(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	sink, err := NewPrometheusSinkFrom(PrometheusOpts{
+		Registerer:  reg,
+		Name:        "sink",
+		ConstLabels: []metrics.Label{{Name: "region", Value: "eu"}},
+	})
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	a, b := metrics.Label{Name: "a", Value: "1"}, metrics.Label{Name: "b.b", Value: "2"}
+	sink.IncrCounterWithLabels([]string{"requests"}, 1, []metrics.Label{a, b})
+	sink.IncrCounterWithLabels([]string{"requests"}, 1, []metrics.Label{{Name: "b_b", Value: "2"}, a})
+	sink.IncrCounterWithLabels([]string{"requests"}, 1, []metrics.Label{{Name: "a", Value: "0"}, b, a})
+	sink.AddSampleWithLabels([]string{"rtt"}, 1, []metrics.Label{{Name: "le", Value: "x"}})
+	sink.SetGaugeWithLabels([]string{"queue"}, 1, []metrics.Label{{Name: "region", Value: "us"}})
+
+	// Names used by metrics of other types. Gauges are collected first.
+	sink.IncrCounter([]string{"queue"}, 1)
+	sink.SetGauge([]string{"latency", "count"}, 1)
+	sink.AddSample([]string{"latency"}, 1)
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+	got := make(map[string][]string)
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			var labels []string
+			for _, lp := range m.GetLabel() {
+				labels = append(labels, lp.GetName()+"="+lp.GetValue())
+			}
+			series := strings.Join(labels, ",")
+			if mf.GetName() == "requests" {
+				series += fmt.Sprintf(" %v", m.GetCounter().GetValue())
+			}
+			if mf.GetName() == "sink_collisions" {
+				series += fmt.Sprintf(" %v", m.GetGauge().GetValue())
+			}
+			got[mf.GetName()] = append(got[mf.GetName()], series)
+		}
+	}
+	expect := map[string][]string{
+		"requests":        {"a=1,b_b=2,region=eu 3"},
+		"rtt":             {"exported_le=x,region=eu"},
+		"latency_count":   {"region=eu"},
+		"queue":           {"exported_region=us,region=eu"},
+		"sink_collisions": {"region=eu 2"},
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("bad series: %v", got)
+	}
+}
+
+func TestConstLabels(t *testing.T) {
+	// The wrapped registry is not a Gatherer, so the sink's Gatherer is a
+	// private registry
+	reg := prometheus.NewRegistry()
+	sink, err := NewPrometheusSinkFrom(PrometheusOpts{
+		Registerer:  prometheus.WrapRegistererWith(nil, reg),
+		ConstLabels: []metrics.Label{{Name: "region", Value: "eu-west"}, {Name: "cluster.name", Value: "blue"}},
+		GaugeDefinitions: []GaugeDefinition{
+			{Name: []string{"declared"}, ConstLabels: []metrics.Label{{Name: "kind", Value: "static"}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	sink.IncrCounterWithLabels([]string{"requests"}, 1, []metrics.Label{{Name: "method", Value: "GET"}})
+
+	for _, g := range []prometheus.Gatherer{reg, sink.Gatherer()} {
+		mfs, err := g.Gather()
+		if err != nil {
+			t.Fatalf("gather failed: %v", err)
+		}
+		got := make(map[string]map[string]string)
+		for _, mf := range mfs {
+			labels := make(map[string]string)
+			for _, lp := range mf.GetMetric()[0].GetLabel() {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+			got[mf.GetName()] = labels
+		}
+		expect := map[string]map[string]string{
+			"requests": {"region": "eu-west", "cluster_name": "blue", "method": "GET"},
+			"declared": {"region": "eu-west", "cluster_name": "blue", "kind": "static"},
+		}
+		if !reflect.DeepEqual(got, expect) {
+			t.Fatalf("bad labels: %v", got)
+		}
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	sink, err := NewPrometheusSinkFrom(PrometheusOpts{
+		Registerer:       reg,
+		Namespace:        "consul",
+		Subsystem:        "raft",
+		Help:             map[string]string{"commit_time": "Time to commit"},
+		GaugeDefinitions: []GaugeDefinition{{Name: []string{"peers"}, Help: "Known peers"}},
+	})
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	sink.AddSample([]string{"commit", "time"}, 1)
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+	help := make(map[string]string)
+	for _, mf := range mfs {
+		help[mf.GetName()] = mf.GetHelp()
+	}
+	expect := map[string]string{
+		"consul_raft_commit_time": "Time to commit",
+		"consul_raft_peers":       "Known peers",
+	}
+	if !reflect.DeepEqual(help, expect) {
+		t.Fatalf("bad metrics: %v", help)
+	}
+}
+
+func TestSummaryObjectives(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	sink, err := NewPrometheusSinkFrom(PrometheusOpts{
+		Registerer:        reg,
+		SummaryObjectives: map[float64]float64{0.5: 0.05, 0.999: 0.0001},
+		SummaryDefinitions: []SummaryDefinition{
+			{Name: []string{"declared"}, Help: "Declared summary", Objectives: map[float64]float64{}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	sink.AddSample([]string{"latency"}, 1)
+	sink.AddSample([]string{"declared"}, 1)
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+	quantiles := make(map[string][]float64)
+	for _, mf := range mfs {
+		quantiles[mf.GetName()] = []float64{}
+		for _, q := range mf.GetMetric()[0].GetSummary().GetQuantile() {
+			quantiles[mf.GetName()] = append(quantiles[mf.GetName()], q.GetQuantile())
+		}
+	}
+	expect := map[string][]float64{
+		"latency":  {0.5, 0.999},
+		"declared": {},
+	}
+	if !reflect.DeepEqual(quantiles, expect) {
+		t.Fatalf("bad quantiles: %v", quantiles)
+	}
+}
+
+func TestCounterTypes(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	sink, err := NewPrometheusSinkFrom(PrometheusOpts{
+		Registerer: reg,
+		CounterTypes: map[string]CounterType{
+			"":            CounterTypeCounter,
+			"queue.depth": CounterTypeGauge,
+			"legacy":      CounterTypeDefault,
+		},
+		CounterDefinitions: []CounterDefinition{{Name: []string{"declared"}, Help: "Declared counter"}},
+	})
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	sink.IncrCounter([]string{"http", "requests"}, 2)
+	sink.IncrCounter([]string{"http", "requests"}, -1)
+	sink.IncrCounter([]string{"errors", "total"}, 1)
+	sink.IncrCounter([]string{"declared"}, 1)
+	sink.IncrCounter([]string{"queue", "depth"}, 3)
+	sink.IncrCounter([]string{"queue", "depth"}, -1)
+	sink.IncrCounter([]string{"legacy"}, 1)
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+	got := make(map[string]string)
+	for _, mf := range mfs {
+		m := mf.GetMetric()[0]
+		value := m.GetCounter().GetValue()
+		if mf.GetType() == dto.MetricType_GAUGE {
+			value = m.GetGauge().GetValue()
+		}
+		got[mf.GetName()] = fmt.Sprintf("%s %v", mf.GetType(), value)
+	}
+	expect := map[string]string{
+		"http_requests_total": "COUNTER 2",
+		"errors_total":        "COUNTER 1",
+		"declared_total":      "COUNTER 1",
+		"queue_depth":         "GAUGE 2",
+		"legacy":              "COUNTER 1",
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("bad metrics: %v", got)
+	}
+}
+
+func TestSanitizedNamesAreGathered(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	sink, err := NewPrometheusSinkFrom(PrometheusOpts{
+		Registerer: reg,
+	})
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+
+	labels := []metrics.Label{
+		{Name: "peer-id", Value: "a"},
+		{Name: "0shard", Value: "b"},
+		{Name: "__reserved", Value: "c"},
+		{Name: "bad\xffutf8", Value: "\xff"},
+	}
+	sink.SetGaugeWithLabels([]string{"9lives", "état"}, 1, labels)
+	sink.IncrCounterWithLabels([]string{"requests+total"}, 1, labels)
+	sink.AddSampleWithLabels([]string{"latency ms"}, 1, labels)
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+	var names []string
+	for _, mf := range mfs {
+		names = append(names, mf.GetName())
+	}
+	expected := []string{"_9lives__tat", "latency_ms", "requests_total"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("got %v want %v", names, expected)
+	}
+	var labelNames []string
+	for _, lp := range mfs[0].Metric[0].Label {
+		labelNames = append(labelNames, lp.GetName())
+	}
+	expectedLabels := []string{"_0shard", "_reserved", "bad_utf8", "peer_id"}
+	if !reflect.DeepEqual(labelNames, expectedLabels) {
+		t.Fatalf("got %v want %v", labelNames, expectedLabels)
+	}
+}
+
 func MockGetHostname() string {
 	return TestHostname
 }
@@ -265,7 +922,7 @@ func fakeServer(q chan string) *httptest.Server {
 				},
 			},
 		}
-		if !reflect.DeepEqual(m, expectedm) {
+		if !proto.Equal(m, expectedm) {
 			msg := fmt.Sprintf("Unexpected samples extracted, got: %+v, want: %+v", m, expectedm)
 			q <- errors.New(msg).Error()
 		} else {
@@ -440,7 +1097,7 @@ func Test_flattenKey(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(b *testing.T) {
-			actualKey, actualHash := flattenKey(tc.inputParts, tc.inputLabels)
+			actualKey, actualHash := flattenKey(DefaultSanitizer, tc.inputParts, tc.inputLabels)
 			if actualKey != tc.expectedOutputKey {
 				t.Fatalf("expected key %s, got %s", tc.expectedOutputKey, actualKey)
 			}
@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"math"
+	rmetrics "runtime/metrics"
+	"strings"
+	"sync"
+)
+
+const (
+	schedLatencies  = "/sched/latencies:seconds"
+	gcCPUSeconds    = "/cpu/classes/gc/total:cpu-seconds"
+	totalCPUSeconds = "/cpu/classes/total:cpu-seconds"
+	gcHeapGoal      = "/gc/heap/goal:bytes"
+	gcHeapLive      = "/gc/heap/live:bytes"
+	gcGOGC          = "/gc/gogc:percent"
+	gcMemoryLimit   = "/gc/gomemlimit:bytes"
+	memoryClasses   = "/memory/classes/"
+)
+
+// schedLatencyQuantiles are the quantiles of the scheduler latency emitted
+var schedLatencyQuantiles = []struct {
+	name     string
+	quantile float64
+}{
+	{"p50", 0.5},
+	{"p90", 0.9},
+	{"p99", 0.99},
+}
+
+// runtimeSampler reads the metrics of the runtime/metrics package enabled in
+// the Config. Cumulative metrics are emitted for the time since the previous
+// read.
+type runtimeSampler struct {
+	lock    sync.Mutex
+	samples []rmetrics.Sample
+
+	lastSchedCounts []uint64
+	lastGCCPU       float64
+	lastTotalCPU    float64
+}
+
+// emitRuntimeMetrics emits the metrics of the runtime/metrics package
+// enabled in the Config, see EmitRuntimeStats
+func (m *Metrics) emitRuntimeMetrics() {
+	if !m.EnableRuntimeSchedulerMetrics && !m.EnableRuntimeGCMetrics && !m.EnableRuntimeMemoryClasses {
+		return
+	}
+
+	s := &m.sampler
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.samples == nil {
+		s.samples = m.runtimeSamples()
+	}
+	rmetrics.Read(s.samples)
+
+	var gcCPU, totalCPU float64
+	for _, sample := range s.samples {
+		switch name := sample.Name; {
+		case sample.Value.Kind() == rmetrics.KindBad:
+			// Not supported by this Go release
+		case name == schedLatencies:
+			s.emitSchedLatencies(m, sample.Value.Float64Histogram())
+		case name == gcCPUSeconds:
+			gcCPU = sample.Value.Float64()
+		case name == totalCPUSeconds:
+			totalCPU = sample.Value.Float64()
+		case name == gcHeapGoal:
+			m.SetGauge([]string{"runtime", "gc_heap_goal_bytes"}, float32(sample.Value.Uint64()))
+		case name == gcHeapLive:
+			m.SetGauge([]string{"runtime", "gc_heap_live_bytes"}, float32(sample.Value.Uint64()))
+		case name == gcGOGC:
+			m.SetGauge([]string{"runtime", "gc_gogc_percent"}, float32(sample.Value.Uint64()))
+		case name == gcMemoryLimit:
+			m.SetGauge([]string{"runtime", "gc_memory_limit_bytes"}, float32(sample.Value.Uint64()))
+		case strings.HasPrefix(name, memoryClasses):
+			m.SetGauge(memoryClassKey(name), float32(sample.Value.Uint64()))
+		}
+	}
+
+	if m.EnableRuntimeGCMetrics && totalCPU > s.lastTotalCPU {
+		fraction := (gcCPU - s.lastGCCPU) / (totalCPU - s.lastTotalCPU)
+		m.SetGauge([]string{"runtime", "gc_cpu_fraction"}, float32(fraction))
+	}
+	s.lastGCCPU, s.lastTotalCPU = gcCPU, totalCPU
+}
+
+// runtimeSamples returns the samples of the enabled metrics
+func (m *Metrics) runtimeSamples() []rmetrics.Sample {
+	var names []string
+	if m.EnableRuntimeSchedulerMetrics {
+		names = append(names, schedLatencies)
+	}
+	if m.EnableRuntimeGCMetrics {
+		names = append(names, gcCPUSeconds, totalCPUSeconds, gcHeapGoal, gcHeapLive, gcGOGC, gcMemoryLimit)
+	}
+	if m.EnableRuntimeMemoryClasses {
+		for _, desc := range rmetrics.All() {
+			if strings.HasPrefix(desc.Name, memoryClasses) {
+				names = append(names, desc.Name)
+			}
+		}
+	}
+	samples := make([]rmetrics.Sample, len(names))
+	for i, name := range names {
+		samples[i].Name = name
+	}
+	return samples
+}
+
+// emitSchedLatencies emits the quantiles of the scheduler latencies recorded
+// since the previous call
+func (s *runtimeSampler) emitSchedLatencies(m *Metrics, h *rmetrics.Float64Histogram) {
+	counts := make([]uint64, len(h.Counts))
+	var total uint64
+	for i, c := range h.Counts {
+		if i < len(s.lastSchedCounts) {
+			c -= s.lastSchedCounts[i]
+		}
+		counts[i] = c
+		total += c
+	}
+	s.lastSchedCounts = append(s.lastSchedCounts[:0], h.Counts...)
+	if total == 0 {
+		return
+	}
+	for _, q := range schedLatencyQuantiles {
+		latency := histogramQuantile(counts, h.Buckets, total, q.quantile)
+		m.SetGauge([]string{"runtime", "sched_latency_" + q.name + "_ns"}, float32(latency*1e9))
+	}
+}
+
+// histogramQuantile returns the upper bound of the bucket holding quantile q
+// of a histogram of total values, or its lower bound if it is unbounded
+func histogramQuantile(counts []uint64, buckets []float64, total uint64, q float64) float64 {
+	rank := uint64(math.Ceil(q * float64(total)))
+	var cumulative uint64
+	for i, c := range counts {
+		cumulative += c
+		if cumulative >= rank {
+			if math.IsInf(buckets[i+1], 1) {
+				return buckets[i]
+			}
+			return buckets[i+1]
+		}
+	}
+	return buckets[len(buckets)-1]
+}
+
+// memoryClassKey returns the key of a memory class, e.g.
+// runtime.memory.heap.objects_bytes for /memory/classes/heap/objects:bytes
+func memoryClassKey(name string) []string {
+	name = strings.TrimSuffix(strings.TrimPrefix(name, memoryClasses), ":bytes")
+	name = strings.ReplaceAll(name, "-", "_")
+	key := append([]string{"runtime", "memory"}, strings.Split(name, "/")...)
+	key[len(key)-1] += "_bytes"
+	return key
+}
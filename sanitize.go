@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"strings"
+)
+
+// Sanitizer normalizes metric names and labels into the character set a
+// backend accepts. Every sink applies its Sanitizer before handing metrics
+// to its backend, so invalid runes are replaced in one place per backend
+// instead of by each caller. Most sinks allow their default Sanitizer to be
+// replaced.
+type Sanitizer interface {
+	// SanitizeName returns the flattened metric name with any runes that
+	// are illegal for the backend replaced.
+	SanitizeName(name string) string
+
+	// SanitizeLabel returns the label with any runes that are illegal for
+	// the backend replaced in its name and value.
+	SanitizeLabel(label Label) Label
+}
+
+// RuneSanitizer is a Sanitizer mapping every rune of metric names, label names
+// and label values through the given functions, as strings.Map does. A nil
+// function leaves the string unchanged.
+type RuneSanitizer struct {
+	Name       func(r rune) rune
+	LabelName  func(r rune) rune
+	LabelValue func(r rune) rune
+}
+
+func (s *RuneSanitizer) SanitizeName(name string) string {
+	if s.Name == nil {
+		return name
+	}
+	return strings.Map(s.Name, name)
+}
+
+func (s *RuneSanitizer) SanitizeLabel(label Label) Label {
+	if s.LabelName != nil {
+		label.Name = strings.Map(s.LabelName, label.Name)
+	}
+	if s.LabelValue != nil {
+		label.Value = strings.Map(s.LabelValue, label.Value)
+	}
+	return label
+}
+
+// StatsdSanitizer is the default Sanitizer of the StatsdSink and StatsiteSink.
+// It replaces the ':' separator of the statsd line protocol and spaces with
+// underscores.
+var StatsdSanitizer Sanitizer = &RuneSanitizer{
+	Name:       replaceStatsdRunes,
+	LabelName:  replaceStatsdRunes,
+	LabelValue: replaceStatsdRunes,
+}
+
+func replaceStatsdRunes(r rune) rune {
+	switch r {
+	case ':':
+		fallthrough
+	case ' ':
+		return '_'
+	default:
+		return r
+	}
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"testing"
+)
+
+func TestRuneSanitizer(t *testing.T) {
+	s := &RuneSanitizer{
+		Name: func(r rune) rune {
+			if r == '/' {
+				return '_'
+			}
+			return r
+		},
+		LabelValue: func(r rune) rune {
+			if r == ',' {
+				return -1
+			}
+			return r
+		},
+	}
+
+	if got := s.SanitizeName("a/b.c"); got != "a_b.c" {
+		t.Fatalf("bad name: %s", got)
+	}
+	got := s.SanitizeLabel(Label{Name: "a/b", Value: "x,y"})
+	if got.Name != "a/b" || got.Value != "xy" {
+		t.Fatalf("bad label: %v", got)
+	}
+
+	empty := &RuneSanitizer{}
+	if got := empty.SanitizeName("a b"); got != "a b" {
+		t.Fatalf("bad name: %s", got)
+	}
+}
+
+func TestStatsdSanitizer(t *testing.T) {
+	if got := StatsdSanitizer.SanitizeName("a:b c.d"); got != "a_b_c.d" {
+		t.Fatalf("bad name: %s", got)
+	}
+	got := StatsdSanitizer.SanitizeLabel(Label{Name: "a b", Value: "c:d"})
+	if got.Name != "a_b" || got.Value != "c_d" {
+		t.Fatalf("bad label: %v", got)
+	}
+}
+
+func TestStatsdSink_SetSanitizer(t *testing.T) {
+	s := &StatsdSink{}
+	s.SetSanitizer(&RuneSanitizer{Name: func(r rune) rune {
+		if r == '-' {
+			return '_'
+		}
+		return r
+	}})
+	if got := s.flattenKeyLabels([]string{"a-b", "c"}, []Label{{"x", "y-z"}}); got != "a_b.c.y_z" {
+		t.Fatalf("bad key: %s", got)
+	}
+
+	site := &StatsiteSink{}
+	if got := site.flattenKey([]string{"a:b", "c d"}); got != "a_b.c_d" {
+		t.Fatalf("bad key: %s", got)
+	}
+}
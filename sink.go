@@ -3,6 +3,11 @@ package metrics
 import (
 	"fmt"
 	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+
+	iradix "github.com/hashicorp/go-immutable-radix"
 )
 
 // The MetricSink interface is used to transmit metrics information
@@ -33,16 +38,75 @@ type ShutdownSink interface {
 	Shutdown()
 }
 
+// PrecisionSampleSink is implemented by sinks which can record samples with
+// float64 precision. Timers from MeasureSince and AddDuration are handed to
+// such sinks at full precision, so sub-millisecond or nanosecond timings are
+// not rounded by the conversion to float32.
+type PrecisionSampleSink interface {
+	MetricSink
+
+	AddPrecisionSample(key []string, val float64)
+	AddPrecisionSampleWithLabels(key []string, val float64, labels []Label)
+}
+
+// addPrecisionSample adds the sample to sink at float64 precision if the sink
+// supports it, and as a float32 otherwise.
+func addPrecisionSample(sink MetricSink, key []string, val float64, labels []Label) {
+	if ps, ok := sink.(PrecisionSampleSink); ok {
+		ps.AddPrecisionSampleWithLabels(key, val, labels)
+		return
+	}
+	sink.AddSampleWithLabels(key, float32(val), labels)
+}
+
+// ExemplarSink is implemented by sinks which can attach an exemplar to a
+// counter increment or sample, such as the ID of the trace the observation was
+// made in, to link metrics to traces. The exemplar is a set of labels, e.g.
+// []Label{{"trace_id", id}}, which doesn't become part of the metric's key.
+type ExemplarSink interface {
+	MetricSink
+
+	IncrCounterWithExemplar(key []string, val float32, labels []Label, exemplar []Label)
+	AddSampleWithExemplar(key []string, val float32, labels []Label, exemplar []Label)
+}
+
+// incrCounterWithExemplar increments the counter in sink, along with the
+// exemplar if the sink supports exemplars.
+func incrCounterWithExemplar(sink MetricSink, key []string, val float32, labels []Label, exemplar []Label) {
+	if es, ok := sink.(ExemplarSink); ok && len(exemplar) > 0 {
+		es.IncrCounterWithExemplar(key, val, labels, exemplar)
+		return
+	}
+	sink.IncrCounterWithLabels(key, val, labels)
+}
+
+// addSampleWithExemplar adds the sample to sink, along with the exemplar if
+// the sink supports exemplars.
+func addSampleWithExemplar(sink MetricSink, key []string, val float32, labels []Label, exemplar []Label) {
+	if es, ok := sink.(ExemplarSink); ok && len(exemplar) > 0 {
+		es.AddSampleWithExemplar(key, val, labels, exemplar)
+		return
+	}
+	sink.AddSampleWithLabels(key, val, labels)
+}
+
 // BlackholeSink is used to just blackhole messages
 type BlackholeSink struct{}
 
-func (*BlackholeSink) SetGauge(key []string, val float32)                              {}
-func (*BlackholeSink) SetGaugeWithLabels(key []string, val float32, labels []Label)    {}
-func (*BlackholeSink) EmitKey(key []string, val float32)                               {}
-func (*BlackholeSink) IncrCounter(key []string, val float32)                           {}
-func (*BlackholeSink) IncrCounterWithLabels(key []string, val float32, labels []Label) {}
-func (*BlackholeSink) AddSample(key []string, val float32)                             {}
-func (*BlackholeSink) AddSampleWithLabels(key []string, val float32, labels []Label)   {}
+func (*BlackholeSink) SetGauge(key []string, val float32)                                     {}
+func (*BlackholeSink) SetGaugeWithLabels(key []string, val float32, labels []Label)           {}
+func (*BlackholeSink) EmitKey(key []string, val float32)                                      {}
+func (*BlackholeSink) IncrCounter(key []string, val float32)                                  {}
+func (*BlackholeSink) IncrCounterWithLabels(key []string, val float32, labels []Label)        {}
+func (*BlackholeSink) AddSample(key []string, val float32)                                    {}
+func (*BlackholeSink) AddSampleWithLabels(key []string, val float32, labels []Label)          {}
+func (*BlackholeSink) AddPrecisionSample(key []string, val float64)                           {}
+func (*BlackholeSink) AddPrecisionSampleWithLabels(key []string, val float64, labels []Label) {}
+
+func (*BlackholeSink) IncrCounterWithExemplar(key []string, val float32, labels []Label, exemplar []Label) {
+}
+func (*BlackholeSink) AddSampleWithExemplar(key []string, val float32, labels []Label, exemplar []Label) {
+}
 
 // FanoutSink is used to sink to fanout values to multiple sinks
 type FanoutSink []MetricSink
@@ -83,6 +147,35 @@ func (fh FanoutSink) AddSampleWithLabels(key []string, val float32, labels []Lab
 	}
 }
 
+func (fh FanoutSink) AddPrecisionSample(key []string, val float64) {
+	fh.AddPrecisionSampleWithLabels(key, val, nil)
+}
+
+func (fh FanoutSink) AddPrecisionSampleWithLabels(key []string, val float64, labels []Label) {
+	for _, s := range fh {
+		addPrecisionSample(s, key, val, labels)
+	}
+}
+
+func (fh FanoutSink) IncrCounterWithExemplar(key []string, val float32, labels []Label, exemplar []Label) {
+	for _, s := range fh {
+		incrCounterWithExemplar(s, key, val, labels, exemplar)
+	}
+}
+
+func (fh FanoutSink) AddSampleWithExemplar(key []string, val float32, labels []Label, exemplar []Label) {
+	for _, s := range fh {
+		addSampleWithExemplar(s, key, val, labels, exemplar)
+	}
+}
+
+// SetHistogramBuckets passes the buckets on to every sink supporting histograms
+func (fh FanoutSink) SetHistogramBuckets(buckets *HistogramBuckets) {
+	for _, s := range fh {
+		configureHistogramBuckets(s, buckets)
+	}
+}
+
 func (fh FanoutSink) Shutdown() {
 	for _, s := range fh {
 		if ss, ok := s.(ShutdownSink); ok {
@@ -91,6 +184,222 @@ func (fh FanoutSink) Shutdown() {
 	}
 }
 
+// OrderedFanoutSink sinks to multiple sinks like FanoutSink, but delivers one
+// metric at a time: a metric has been passed to every sink, in order, before
+// the next one is, even when they are emitted concurrently. All sinks thus
+// receive the metrics in the same order, and sinks which aren't safe for
+// concurrent use can be used, which makes tests asserting on several sinks
+// deterministic. Metrics are delivered synchronously, so a slow sink holds up
+// every emitting goroutine.
+type OrderedFanoutSink struct {
+	lock  sync.Mutex
+	sinks FanoutSink
+}
+
+// NewOrderedFanoutSink returns an OrderedFanoutSink delivering to sinks, in
+// order.
+func NewOrderedFanoutSink(sinks ...MetricSink) *OrderedFanoutSink {
+	return &OrderedFanoutSink{sinks: FanoutSink(sinks)}
+}
+
+func (o *OrderedFanoutSink) SetGauge(key []string, val float32) {
+	o.SetGaugeWithLabels(key, val, nil)
+}
+
+func (o *OrderedFanoutSink) SetGaugeWithLabels(key []string, val float32, labels []Label) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.sinks.SetGaugeWithLabels(key, val, labels)
+}
+
+func (o *OrderedFanoutSink) EmitKey(key []string, val float32) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.sinks.EmitKey(key, val)
+}
+
+func (o *OrderedFanoutSink) IncrCounter(key []string, val float32) {
+	o.IncrCounterWithLabels(key, val, nil)
+}
+
+func (o *OrderedFanoutSink) IncrCounterWithLabels(key []string, val float32, labels []Label) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.sinks.IncrCounterWithLabels(key, val, labels)
+}
+
+func (o *OrderedFanoutSink) AddSample(key []string, val float32) {
+	o.AddSampleWithLabels(key, val, nil)
+}
+
+func (o *OrderedFanoutSink) AddSampleWithLabels(key []string, val float32, labels []Label) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.sinks.AddSampleWithLabels(key, val, labels)
+}
+
+func (o *OrderedFanoutSink) AddPrecisionSample(key []string, val float64) {
+	o.AddPrecisionSampleWithLabels(key, val, nil)
+}
+
+func (o *OrderedFanoutSink) AddPrecisionSampleWithLabels(key []string, val float64, labels []Label) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.sinks.AddPrecisionSampleWithLabels(key, val, labels)
+}
+
+func (o *OrderedFanoutSink) IncrCounterWithExemplar(key []string, val float32, labels []Label, exemplar []Label) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.sinks.IncrCounterWithExemplar(key, val, labels, exemplar)
+}
+
+func (o *OrderedFanoutSink) AddSampleWithExemplar(key []string, val float32, labels []Label, exemplar []Label) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.sinks.AddSampleWithExemplar(key, val, labels, exemplar)
+}
+
+// SetHistogramBuckets passes the buckets on to every sink supporting histograms
+func (o *OrderedFanoutSink) SetHistogramBuckets(buckets *HistogramBuckets) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.sinks.SetHistogramBuckets(buckets)
+}
+
+func (o *OrderedFanoutSink) Shutdown() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.sinks.Shutdown()
+}
+
+// RoutingSink is used to send metrics only to some of the configured sinks,
+// depending on their key. Routes are keyed by metric prefix, with '.' as the
+// separator, and the longest matching prefix decides which sinks receive a
+// metric. Metrics that don't match any route go to the default sinks.
+//
+// This allows, for example, high volume debug timers to go only to an
+// InmemSink while business counters are also sent to Datadog.
+type RoutingSink struct {
+	lock     sync.RWMutex
+	routes   *iradix.Tree
+	defaults FanoutSink
+}
+
+// NewRoutingSink creates a RoutingSink which sends metrics that do not match
+// any route to the given default sinks.
+func NewRoutingSink(defaults ...MetricSink) *RoutingSink {
+	return &RoutingSink{
+		routes:   iradix.New(),
+		defaults: FanoutSink(defaults),
+	}
+}
+
+// Route sends all metrics with the given prefix to sinks, replacing any
+// previous route for the same prefix. Routing a prefix to no sinks drops the
+// matching metrics.
+func (r *RoutingSink) Route(prefix string, sinks ...MetricSink) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.routes, _, _ = r.routes.Insert([]byte(prefix), FanoutSink(sinks))
+}
+
+// sinksFor returns the sinks the given key is routed to
+func (r *RoutingSink) sinksFor(key []string) FanoutSink {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if r.routes.Len() == 0 {
+		return r.defaults
+	}
+	_, sinks, ok := r.routes.Root().LongestPrefix([]byte(strings.Join(key, ".")))
+	if !ok {
+		return r.defaults
+	}
+	return sinks.(FanoutSink)
+}
+
+func (r *RoutingSink) SetGauge(key []string, val float32) {
+	r.SetGaugeWithLabels(key, val, nil)
+}
+
+func (r *RoutingSink) SetGaugeWithLabels(key []string, val float32, labels []Label) {
+	r.sinksFor(key).SetGaugeWithLabels(key, val, labels)
+}
+
+func (r *RoutingSink) EmitKey(key []string, val float32) {
+	r.sinksFor(key).EmitKey(key, val)
+}
+
+func (r *RoutingSink) IncrCounter(key []string, val float32) {
+	r.IncrCounterWithLabels(key, val, nil)
+}
+
+func (r *RoutingSink) IncrCounterWithLabels(key []string, val float32, labels []Label) {
+	r.sinksFor(key).IncrCounterWithLabels(key, val, labels)
+}
+
+func (r *RoutingSink) AddSample(key []string, val float32) {
+	r.AddSampleWithLabels(key, val, nil)
+}
+
+func (r *RoutingSink) AddSampleWithLabels(key []string, val float32, labels []Label) {
+	r.sinksFor(key).AddSampleWithLabels(key, val, labels)
+}
+
+func (r *RoutingSink) AddPrecisionSample(key []string, val float64) {
+	r.AddPrecisionSampleWithLabels(key, val, nil)
+}
+
+func (r *RoutingSink) AddPrecisionSampleWithLabels(key []string, val float64, labels []Label) {
+	r.sinksFor(key).AddPrecisionSampleWithLabels(key, val, labels)
+}
+
+func (r *RoutingSink) IncrCounterWithExemplar(key []string, val float32, labels []Label, exemplar []Label) {
+	r.sinksFor(key).IncrCounterWithExemplar(key, val, labels, exemplar)
+}
+
+func (r *RoutingSink) AddSampleWithExemplar(key []string, val float32, labels []Label, exemplar []Label) {
+	r.sinksFor(key).AddSampleWithExemplar(key, val, labels, exemplar)
+}
+
+// SetHistogramBuckets passes the buckets on to every sink supporting
+// histograms that is a default or part of a route.
+func (r *RoutingSink) SetHistogramBuckets(buckets *HistogramBuckets) {
+	r.allSinks().SetHistogramBuckets(buckets)
+}
+
+// Shutdown shuts down every sink that is a default or part of a route. Sinks
+// that appear in several routes are only shut down once.
+func (r *RoutingSink) Shutdown() {
+	r.allSinks().Shutdown()
+}
+
+// allSinks returns every sink that is a default or part of a route, once
+func (r *RoutingSink) allSinks() FanoutSink {
+	r.lock.RLock()
+	all := append(FanoutSink{}, r.defaults...)
+	r.routes.Root().Walk(func(k []byte, v interface{}) bool {
+		all = append(all, v.(FanoutSink)...)
+		return false
+	})
+	r.lock.RUnlock()
+
+	seen := make(map[MetricSink]bool)
+	var unique FanoutSink
+	for _, s := range all {
+		// Sinks such as FanoutSink can't be used as map keys
+		if reflect.TypeOf(s).Comparable() {
+			if seen[s] {
+				continue
+			}
+			seen[s] = true
+		}
+		unique = append(unique, s)
+	}
+	return unique
+}
+
 // sinkURLFactoryFunc is an generic interface around the *SinkFromURL() function provided
 // by each sink type
 type sinkURLFactoryFunc func(*url.URL) (MetricSink, error)
@@ -103,6 +412,33 @@ var sinkRegistry = map[string]sinkURLFactoryFunc{
 	"inmem":    NewInmemSinkFromURL,
 }
 
+// sinkRegistryLock guards sinkRegistry against schemes registered while
+// sinks are created
+var sinkRegistryLock sync.RWMutex
+
+// RegisterSinkScheme makes the sinks created by factory available to
+// NewMetricSinkFromURL under the URL scheme, so external sink implementations
+// can be selected by configuration. It is typically called from the init
+// function of the package implementing the sink:
+//
+//	func init() {
+//		metrics.RegisterSinkScheme("myco", NewMycoSinkFromURL)
+//	}
+//
+// RegisterSinkScheme panics if the scheme is already registered, including
+// the built-in statsd, statsite and inmem schemes, or if factory is nil.
+func RegisterSinkScheme(scheme string, factory func(*url.URL) (MetricSink, error)) {
+	sinkRegistryLock.Lock()
+	defer sinkRegistryLock.Unlock()
+	if factory == nil {
+		panic("metrics: RegisterSinkScheme factory is nil")
+	}
+	if _, ok := sinkRegistry[scheme]; ok {
+		panic("metrics: RegisterSinkScheme called twice for scheme " + scheme)
+	}
+	sinkRegistry[scheme] = factory
+}
+
 // NewMetricSinkFromURL allows a generic URL input to configure any of the
 // supported sinks. The scheme of the URL identifies the type of the sink, the
 // and query parameters are used to set options.
@@ -115,14 +451,19 @@ var sinkRegistry = map[string]sinkURLFactoryFunc{
 //
 // "inmem://" - Initializes an InmemSink. The host and port are ignored. The
 // "interval" and "duration" query parameters must be specified with valid
-// durations, see NewInmemSink for details.
+// durations, see NewInmemSink for details. The optional "reservoir" parameter
+// sets the number of raw values retained per sample, see SetReservoirSize.
+//
+// Other schemes are those registered with RegisterSinkScheme.
 func NewMetricSinkFromURL(urlStr string) (MetricSink, error) {
 	u, err := url.Parse(urlStr)
 	if err != nil {
 		return nil, err
 	}
 
+	sinkRegistryLock.RLock()
 	sinkURLFactoryFunc := sinkRegistry[u.Scheme]
+	sinkRegistryLock.RUnlock()
 	if sinkURLFactoryFunc == nil {
 		return nil, fmt.Errorf(
 			"cannot create metric sink, unrecognized sink name: %q", u.Scheme)
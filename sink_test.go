@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"net/url"
 	"reflect"
 	"strings"
 	"sync"
@@ -253,6 +254,37 @@ func TestFanoutSink_Sample_Labels(t *testing.T) {
 	}
 }
 
+// unsafeSink records the values of counters without synchronization
+type unsafeSink struct {
+	BlackholeSink
+	vals []float32
+}
+
+func (u *unsafeSink) IncrCounterWithLabels(key []string, val float32, labels []Label) {
+	u.vals = append(u.vals, val)
+}
+
+func TestOrderedFanoutSink(t *testing.T) {
+	s1, s2 := &unsafeSink{}, &unsafeSink{}
+	fh := NewOrderedFanoutSink(s1, s2)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				fh.IncrCounter([]string{"foo"}, float32(g*100+n))
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if len(s1.vals) != 800 || !reflect.DeepEqual(s1.vals, s2.vals) {
+		t.Fatalf("sinks received %d and %d values in different orders", len(s1.vals), len(s2.vals))
+	}
+}
+
 func TestNewMetricSinkFromURL(t *testing.T) {
 	for _, tc := range []struct {
 		desc      string
@@ -299,3 +331,88 @@ func TestNewMetricSinkFromURL(t *testing.T) {
 		})
 	}
 }
+
+func TestRegisterSinkScheme(t *testing.T) {
+	mock := &MockSink{}
+	RegisterSinkScheme("mocksink", func(u *url.URL) (MetricSink, error) {
+		if u.Host != "somewhere" {
+			t.Fatalf("bad host: %s", u.Host)
+		}
+		return mock, nil
+	})
+	defer func() {
+		sinkRegistryLock.Lock()
+		delete(sinkRegistry, "mocksink")
+		sinkRegistryLock.Unlock()
+	}()
+
+	ms, err := NewMetricSinkFromURL("mocksink://somewhere")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if ms != mock {
+		t.Fatalf("bad sink: %#v", ms)
+	}
+
+	for _, scheme := range []string{"mocksink", "statsd"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expected a panic registering %s twice", scheme)
+				}
+			}()
+			RegisterSinkScheme(scheme, NewInmemSinkFromURL)
+		}()
+	}
+}
+
+func TestRoutingSink(t *testing.T) {
+	inmem := &MockSink{}
+	datadog := &MockSink{}
+	r := NewRoutingSink(inmem, datadog)
+	r.Route("debug", inmem)
+	r.Route("debug.important", inmem, datadog)
+	r.Route("dropped")
+
+	r.IncrCounter([]string{"business", "orders"}, 1)
+	r.AddSample([]string{"debug", "timer"}, 2)
+	r.SetGaugeWithLabels([]string{"debug", "important", "gauge"}, 3, []Label{{"a", "b"}})
+	r.EmitKey([]string{"dropped", "key"}, 4)
+	r.IncrCounterWithLabels([]string{"debugging"}, 5, nil)
+
+	expectInmem := [][]string{
+		{"business", "orders"},
+		{"debug", "timer"},
+		{"debug", "important", "gauge"},
+		{"debugging"},
+	}
+	if !reflect.DeepEqual(inmem.getKeys(), expectInmem) {
+		t.Fatalf("got %v want %v", inmem.getKeys(), expectInmem)
+	}
+	// "debugging" shares the "debug" prefix, just like the prefix filters
+	expectDatadog := [][]string{
+		{"business", "orders"},
+		{"debug", "important", "gauge"},
+	}
+	if !reflect.DeepEqual(datadog.getKeys(), expectDatadog) {
+		t.Fatalf("got %v want %v", datadog.getKeys(), expectDatadog)
+	}
+	if !reflect.DeepEqual(datadog.labels[1], []Label{{"a", "b"}}) {
+		t.Fatalf("labels not equal")
+	}
+
+	r.Shutdown()
+	if !inmem.shutdown || !datadog.shutdown {
+		t.Fatalf("expected all sinks to be shut down")
+	}
+}
+
+func TestRoutingSink_ShutdownFanout(t *testing.T) {
+	m := &MockSink{}
+	r := NewRoutingSink(FanoutSink{m})
+	r.Route("a", FanoutSink{m})
+	r.Shutdown()
+	if !m.shutdown {
+		t.Fatalf("expected sink to be shut down")
+	}
+}
@@ -0,0 +1,141 @@
+// Package sinktest provides a conformance test suite for implementations of
+// metrics.MetricSink.
+package sinktest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+// ShutdownTimeout is how long the Shutdown of a metrics.ShutdownSink may
+// take
+var ShutdownTimeout = 10 * time.Second
+
+// weirdKeys are keys with runes sinks must sanitize or escape rather than
+// choke on
+var weirdKeys = [][]string{
+	{"with space", "and:colon"},
+	{"pipe|at@hash#", "comma,semi;equals="},
+	{"new\nline", "tab\t", "quote\"back\\slash"},
+	{"ünïcödé", "日本語", "emoji😀"},
+	{"", "empty", ""},
+	{"dots.in.part"},
+}
+
+// weirdLabels are labels with runes sinks must sanitize or escape
+var weirdLabels = []metrics.Label{
+	{Name: "with space", Value: "a value"},
+	{Name: "colon:", Value: "pipe|comma,"},
+	{Name: "quote\"", Value: "new\nline\\"},
+	{Name: "ünïcödé", Value: "日本語😀"},
+	{Name: "empty", Value: ""},
+	{Name: "", Value: "no name"},
+}
+
+// Conformance tests the sinks created by factory, a new one per subtest:
+//
+//	Methods - every method of metrics.MetricSink, with and without labels,
+//	    and those of metrics.PrecisionSampleSink and metrics.ExemplarSink if
+//	    implemented
+//	WeirdRunes - keys and labels with spaces, separators of common
+//	    protocols, newlines, quotes, non-ASCII runes and empty parts
+//	Concurrency - every method called from several goroutines, which the
+//	    race detector checks with go test -race
+//	Shutdown - metrics.ShutdownSink.Shutdown returns within ShutdownTimeout
+//	    after metrics were emitted
+//
+// Sinks must neither panic nor fail the test, e.g. through a fake server
+// the factory started. For example:
+//
+//	func TestMySink(t *testing.T) {
+//		sinktest.Conformance(t, func(t *testing.T) metrics.MetricSink {
+//			return NewMySink()
+//		})
+//	}
+func Conformance(t *testing.T, factory func(t *testing.T) metrics.MetricSink) {
+	t.Run("Methods", func(t *testing.T) {
+		sink := factory(t)
+		defer shutdown(t, sink)
+		emitAll(sink, []string{"sinktest", "plain"}, nil)
+		emitAll(sink, []string{"sinktest", "labels"}, []metrics.Label{{Name: "a", Value: "b"}, {Name: "c", Value: "d"}})
+		emitAll(sink, []string{"sinktest", "empty"}, []metrics.Label{})
+	})
+
+	t.Run("WeirdRunes", func(t *testing.T) {
+		sink := factory(t)
+		defer shutdown(t, sink)
+		for _, key := range weirdKeys {
+			emitAll(sink, key, weirdLabels)
+		}
+	})
+
+	t.Run("Concurrency", func(t *testing.T) {
+		sink := factory(t)
+		defer shutdown(t, sink)
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for n := 0; n < 50; n++ {
+					key := []string{"sinktest", fmt.Sprintf("concurrent%d", n%5)}
+					emitAll(sink, key, []metrics.Label{{Name: "goroutine", Value: fmt.Sprint(g)}})
+				}
+			}(g)
+		}
+		wg.Wait()
+	})
+
+	t.Run("Shutdown", func(t *testing.T) {
+		sink := factory(t)
+		if _, ok := sink.(metrics.ShutdownSink); !ok {
+			t.Skip("sink doesn't implement metrics.ShutdownSink")
+		}
+		emitAll(sink, []string{"sinktest", "shutdown"}, nil)
+		shutdown(t, sink)
+	})
+}
+
+// emitAll calls every method of sink with key, and labels if they take any
+func emitAll(sink metrics.MetricSink, key []string, labels []metrics.Label) {
+	sink.SetGauge(key, 1.5)
+	sink.SetGaugeWithLabels(key, -2, labels)
+	sink.EmitKey(key, 3)
+	sink.IncrCounter(key, 1)
+	sink.IncrCounterWithLabels(key, 0.5, labels)
+	sink.AddSample(key, 42)
+	sink.AddSampleWithLabels(key, 0, labels)
+	if ps, ok := sink.(metrics.PrecisionSampleSink); ok {
+		ps.AddPrecisionSample(key, 1e-9)
+		ps.AddPrecisionSampleWithLabels(key, 1e12, labels)
+	}
+	if es, ok := sink.(metrics.ExemplarSink); ok {
+		exemplar := []metrics.Label{{Name: "trace_id", Value: "4bf92f3577b34da6a3ce929d0e0e4736"}}
+		es.IncrCounterWithExemplar(key, 1, labels, exemplar)
+		es.AddSampleWithExemplar(key, 2, labels, exemplar)
+	}
+}
+
+// shutdown shuts sink down if it's a metrics.ShutdownSink, failing the test
+// if that takes longer than ShutdownTimeout
+func shutdown(t *testing.T, sink metrics.MetricSink) {
+	t.Helper()
+	ss, ok := sink.(metrics.ShutdownSink)
+	if !ok {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ss.Shutdown()
+	}()
+	select {
+	case <-done:
+	case <-time.After(ShutdownTimeout):
+		t.Fatalf("Shutdown didn't return within %s", ShutdownTimeout)
+	}
+}
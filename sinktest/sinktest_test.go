@@ -0,0 +1,44 @@
+package sinktest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+	"github.com/hashicorp/go-metrics/metricstest"
+)
+
+func TestConformance(t *testing.T) {
+	for name, factory := range map[string]func(t *testing.T) metrics.MetricSink{
+		"Inmem": func(t *testing.T) metrics.MetricSink {
+			return metrics.NewInmemSink(time.Minute, time.Minute)
+		},
+		"Blackhole": func(t *testing.T) metrics.MetricSink {
+			return &metrics.BlackholeSink{}
+		},
+		"Fanout": func(t *testing.T) metrics.MetricSink {
+			return metrics.FanoutSink{metrics.NewInmemSink(time.Minute, time.Minute), metricstest.NewSink()}
+		},
+		"OrderedFanout": func(t *testing.T) metrics.MetricSink {
+			return metrics.NewOrderedFanoutSink(metrics.NewInmemSink(time.Minute, time.Minute), metricstest.NewSink())
+		},
+		"Statsd": func(t *testing.T) metrics.MetricSink {
+			sink, err := metrics.NewStatsdSink(metricstest.NewStatsdServer(t, "udp").Addr())
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			return sink
+		},
+		"Statsite": func(t *testing.T) metrics.MetricSink {
+			sink, err := metrics.NewStatsiteSink(metricstest.NewStatsdServer(t, "tcp").Addr())
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			return sink
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			Conformance(t, factory)
+		})
+	}
+}
@@ -1,12 +1,11 @@
 package metrics
 
 import (
+	"context"
 	"os"
 	"sync"
 	"sync/atomic"
 	"time"
-
-	iradix "github.com/hashicorp/go-immutable-radix"
 )
 
 // Config is used to configure metrics settings
@@ -21,23 +20,37 @@ type Config struct {
 	TimerGranularity     time.Duration // Granularity of timers.
 	ProfileInterval      time.Duration // Interval to profile runtime metrics
 
+	// More runtime metrics, see EmitRuntimeStats
+	EnableRuntimeSchedulerMetrics bool // Enables quantiles of the scheduler latency
+	EnableRuntimeGCMetrics        bool // Enables the GC CPU fraction, heap goal and GOGC and GOMEMLIMIT tuning
+	EnableRuntimeMemoryClasses    bool // Enables the breakdown of memory by class
+	EnableRuntimeThreadMetrics    bool // Enables the count of OS threads
+	EnableRuntimeFDMetrics        bool // Enables the count and limit of open file descriptors
+	EnableProcessMetrics          bool // Enables the CPU, memory and start time of the process, see EmitProcessStats
+	EnableBuildInfo               bool // Enables the build.info gauge, see EmitBuildInfo
+
 	AllowedPrefixes []string // A list of metric prefixes to allow, with '.' as the separator
 	BlockedPrefixes []string // A list of metric prefixes to block, with '.' as the separator
 	AllowedLabels   []string // A list of metric labels to allow, with '.' as the separator
 	BlockedLabels   []string // A list of metric labels to block, with '.' as the separator
 	FilterDefault   bool     // Whether to allow metrics by default
+
+	HistogramBuckets *HistogramBuckets // Buckets for sinks recording samples into histograms
+
+	Clock Clock // Clock of Now, MeasureSince and periodic collection, SystemClock if nil
 }
 
 // Metrics represents an instance of a metrics sink that can
 // be used to emit
 type Metrics struct {
 	Config
-	lastNumGC     uint32
-	sink          MetricSink
-	filter        *iradix.Tree
-	allowedLabels map[string]bool
-	blockedLabels map[string]bool
-	filterLock    sync.RWMutex // Lock filters and allowedLabels/blockedLabels access
+	lastNumGC  uint32
+	sampler    runtimeSampler // Reads the runtime/metrics package
+	sink       MetricSink
+	filters    atomic.Value // *metricFilters, read on every emission without locking
+	filterLock sync.Mutex   // Serializes updates of filters
+	pollLock   sync.Mutex   // Lock pollers access
+	pollers    map[*poller]struct{}
 }
 
 // Shared global metrics instance
@@ -78,9 +91,12 @@ func New(conf *Config, sink MetricSink) (*Metrics, error) {
 	met.Config = *conf
 	met.sink = sink
 	met.UpdateFilterAndLabels(conf.AllowedPrefixes, conf.BlockedPrefixes, conf.AllowedLabels, conf.BlockedLabels)
+	if conf.HistogramBuckets != nil {
+		configureHistogramBuckets(sink, conf.HistogramBuckets)
+	}
 
 	// Start the runtime collector
-	if conf.EnableRuntimeMetrics {
+	if conf.EnableRuntimeMetrics || conf.EnableProcessMetrics || conf.EnableBuildInfo {
 		go met.collectStats()
 	}
 	return met, nil
@@ -117,6 +133,10 @@ func IncrCounterWithLabels(key []string, val float32, labels []Label) {
 	globalMetrics.Load().(*Metrics).IncrCounterWithLabels(key, val, labels)
 }
 
+func IncrCounterWithExemplar(key []string, val float32, labels []Label, exemplar []Label) {
+	globalMetrics.Load().(*Metrics).IncrCounterWithExemplar(key, val, labels, exemplar)
+}
+
 func AddSample(key []string, val float32) {
 	globalMetrics.Load().(*Metrics).AddSample(key, val)
 }
@@ -125,6 +145,10 @@ func AddSampleWithLabels(key []string, val float32, labels []Label) {
 	globalMetrics.Load().(*Metrics).AddSampleWithLabels(key, val, labels)
 }
 
+func AddSampleWithExemplar(key []string, val float32, labels []Label, exemplar []Label) {
+	globalMetrics.Load().(*Metrics).AddSampleWithExemplar(key, val, labels, exemplar)
+}
+
 func MeasureSince(key []string, start time.Time) {
 	globalMetrics.Load().(*Metrics).MeasureSince(key, start)
 }
@@ -133,6 +157,42 @@ func MeasureSinceWithLabels(key []string, start time.Time, labels []Label) {
 	globalMetrics.Load().(*Metrics).MeasureSinceWithLabels(key, start, labels)
 }
 
+func AddDuration(key []string, d time.Duration) {
+	globalMetrics.Load().(*Metrics).AddDuration(key, d)
+}
+
+func AddDurationWithLabels(key []string, d time.Duration, labels []Label) {
+	globalMetrics.Load().(*Metrics).AddDurationWithLabels(key, d, labels)
+}
+
+func SetGaugeWithLabelsFunc(key []string, val float32, labelsFunc func() []Label) {
+	globalMetrics.Load().(*Metrics).SetGaugeWithLabelsFunc(key, val, labelsFunc)
+}
+
+func IncrCounterWithLabelsFunc(key []string, val float32, labelsFunc func() []Label) {
+	globalMetrics.Load().(*Metrics).IncrCounterWithLabelsFunc(key, val, labelsFunc)
+}
+
+func AddSampleWithLabelsFunc(key []string, val float32, labelsFunc func() []Label) {
+	globalMetrics.Load().(*Metrics).AddSampleWithLabelsFunc(key, val, labelsFunc)
+}
+
+func MeasureSinceWithLabelsFunc(key []string, start time.Time, labelsFunc func() []Label) {
+	globalMetrics.Load().(*Metrics).MeasureSinceWithLabelsFunc(key, start, labelsFunc)
+}
+
+func AddDurationWithLabelsFunc(key []string, d time.Duration, labelsFunc func() []Label) {
+	globalMetrics.Load().(*Metrics).AddDurationWithLabelsFunc(key, d, labelsFunc)
+}
+
+func ObserveCtx(ctx context.Context, key []string) func(err error) {
+	return globalMetrics.Load().(*Metrics).ObserveCtx(ctx, key)
+}
+
+func Do(ctx context.Context, key []string, labels []Label, fn func(context.Context)) {
+	globalMetrics.Load().(*Metrics).Do(ctx, key, labels, fn)
+}
+
 func UpdateFilter(allow, block []string) {
 	globalMetrics.Load().(*Metrics).UpdateFilter(allow, block)
 }
@@ -145,6 +205,11 @@ func UpdateFilterAndLabels(allow, block, allowedLabels, blockedLabels []string)
 	globalMetrics.Load().(*Metrics).UpdateFilterAndLabels(allow, block, allowedLabels, blockedLabels)
 }
 
+// Poll is used to poll gauges with the global metrics, see Metrics.Poll
+func Poll(interval time.Duration, fn func(emit GaugeEmitter)) (stop func()) {
+	return globalMetrics.Load().(*Metrics).Poll(interval, fn)
+}
+
 // Shutdown disables metric collection, then blocks while attempting to flush metrics to storage.
 // WARNING: Not all MetricSink backends support this functionality, and calling this will cause them to leak resources.
 // This is intended for use immediately prior to application exit.
@@ -162,6 +162,33 @@ func Test_GlobalMetrics_MeasureSince(t *testing.T) {
 	}
 }
 
+func Test_GlobalMetrics_AddDuration(t *testing.T) {
+	s := &MockSink{}
+	m := &Metrics{sink: s, Config: Config{TimerGranularity: time.Millisecond, FilterDefault: true}}
+	globalMetrics.Store(m)
+
+	k := []string{"test"}
+	AddDuration(k, 3*time.Millisecond)
+	if !reflect.DeepEqual(s.keys[0], k) {
+		t.Fatalf("key not equal")
+	}
+	if s.vals[0] != 3 {
+		t.Fatalf("bad val %v", s.vals[0])
+	}
+
+	labels := []Label{{"a", "b"}}
+	AddDurationWithLabels(k, 4*time.Millisecond, labels)
+	if got, want := s.keys[1], k; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got key %s want %s", got, want)
+	}
+	if s.vals[1] != 4 {
+		t.Fatalf("bad val %v", s.vals[1])
+	}
+	if got, want := s.labels[1], labels; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got val %s want %s", got, want)
+	}
+}
+
 func Test_GlobalMetrics_UpdateFilter(t *testing.T) {
 	globalMetrics.Store(&Metrics{Config: Config{
 		AllowedPrefixes: []string{"a"},
@@ -184,11 +211,11 @@ func Test_GlobalMetrics_UpdateFilter(t *testing.T) {
 	if m.BlockedLabels[0] != "4" {
 		t.Fatalf("bad: %v", m.AllowedPrefixes)
 	}
-	if _, ok := m.allowedLabels["3"]; !ok {
-		t.Fatalf("bad: %v", m.allowedLabels)
+	if _, ok := m.loadFilters().allowedLabels["3"]; !ok {
+		t.Fatalf("bad: %v", m.loadFilters().allowedLabels)
 	}
-	if _, ok := m.blockedLabels["4"]; !ok {
-		t.Fatalf("bad: %v", m.blockedLabels)
+	if _, ok := m.loadFilters().blockedLabels["4"]; !ok {
+		t.Fatalf("bad: %v", m.loadFilters().blockedLabels)
 	}
 }
 
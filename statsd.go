@@ -3,7 +3,6 @@ package metrics
 import (
 	"bytes"
 	"fmt"
-	"log"
 	"net"
 	"net/url"
 	"strings"
@@ -22,6 +21,9 @@ const (
 type StatsdSink struct {
 	addr        string
 	metricQueue chan string
+	sanitizer   Sanitizer
+
+	graphiteTags bool
 }
 
 // NewStatsdSinkFromURL creates an StatsdSink from a URL. It is used
@@ -80,29 +82,76 @@ func (s *StatsdSink) AddSampleWithLabels(key []string, val float32, labels []Lab
 	s.pushMetric(fmt.Sprintf("%s:%f|ms\n", flatKey, val))
 }
 
+func (s *StatsdSink) AddPrecisionSample(key []string, val float64) {
+	flatKey := s.flattenKey(key)
+	s.pushMetric(fmt.Sprintf("%s:%f|ms\n", flatKey, val))
+}
+
+func (s *StatsdSink) AddPrecisionSampleWithLabels(key []string, val float64, labels []Label) {
+	flatKey := s.flattenKeyLabels(key, labels)
+	s.pushMetric(fmt.Sprintf("%s:%f|ms\n", flatKey, val))
+}
+
+// SetSanitizer replaces the StatsdSanitizer used to normalize keys. It must
+// be called before any metrics are emitted.
+func (s *StatsdSink) SetSanitizer(sanitizer Sanitizer) {
+	s.sanitizer = sanitizer
+}
+
+// EnableGraphiteTags appends labels to keys in the tag syntax of Graphite,
+// e.g. requests;method=GET, instead of as dotted segments of their values,
+// for servers forwarding to Graphite 1.1 or later. It must be called before
+// any metrics are emitted.
+func (s *StatsdSink) EnableGraphiteTags() {
+	s.graphiteTags = true
+}
+
 // Flattens the key for formatting, removes spaces
 func (s *StatsdSink) flattenKey(parts []string) string {
 	joined := strings.Join(parts, ".")
-	return strings.Map(func(r rune) rune {
-		switch r {
-		case ':':
-			fallthrough
-		case ' ':
-			return '_'
-		default:
-			return r
-		}
-	}, joined)
+	if s.sanitizer == nil {
+		return StatsdSanitizer.SanitizeName(joined)
+	}
+	return s.sanitizer.SanitizeName(joined)
 }
 
 // Flattens the key along with labels for formatting, removes spaces
 func (s *StatsdSink) flattenKeyLabels(parts []string, labels []Label) string {
+	if s.graphiteTags {
+		return s.flattenKey(parts) + graphiteTags(s.sanitizer, labels)
+	}
 	for _, label := range labels {
 		parts = append(parts, label.Value)
 	}
 	return s.flattenKey(parts)
 }
 
+// graphiteTags formats labels in the tag syntax of Graphite, replacing the
+// runes Graphite doesn't allow with underscores. Labels with empty values,
+// which Graphite doesn't allow either, are skipped.
+func graphiteTags(sanitizer Sanitizer, labels []Label) string {
+	if sanitizer == nil {
+		sanitizer = StatsdSanitizer
+	}
+	var b strings.Builder
+	for _, label := range labels {
+		label = sanitizer.SanitizeLabel(label)
+		if label.Name == "" || label.Value == "" {
+			continue
+		}
+		b.WriteByte(';')
+		b.WriteString(graphiteTagNameReplacer.Replace(label.Name))
+		b.WriteByte('=')
+		b.WriteString(graphiteTagValueReplacer.Replace(label.Value))
+	}
+	return b.String()
+}
+
+var (
+	graphiteTagNameReplacer  = strings.NewReplacer(";", "_", "!", "_", "^", "_", "=", "_")
+	graphiteTagValueReplacer = strings.NewReplacer(";", "_", "~", "_")
+)
+
 // Does a non-blocking push to the metrics queue
 func (s *StatsdSink) pushMetric(m string) {
 	select {
@@ -126,7 +175,7 @@ CONNECT:
 	// Attempt to connect
 	sock, err = net.Dial("udp", s.addr)
 	if err != nil {
-		log.Printf("[ERR] Error connecting to statsd! Err: %s", err)
+		LogError("Error connecting to statsd", "sink", "statsd", "addr", s.addr, "class", "connect", "error", err)
 		goto WAIT
 	}
 	defer sock.Close()
@@ -144,7 +193,7 @@ CONNECT:
 				_, err := sock.Write(buf.Bytes())
 				buf.Reset()
 				if err != nil {
-					log.Printf("[ERR] Error writing to statsd! Err: %s", err)
+					LogError("Error writing to statsd", "sink", "statsd", "addr", s.addr, "class", "write", "error", err)
 					goto WAIT
 				}
 			}
@@ -160,7 +209,7 @@ CONNECT:
 			_, err := sock.Write(buf.Bytes())
 			buf.Reset()
 			if err != nil {
-				log.Printf("[ERR] Error flushing to statsd! Err: %s", err)
+				LogError("Error flushing to statsd", "sink", "statsd", "addr", s.addr, "class", "flush", "error", err)
 				goto WAIT
 			}
 		}
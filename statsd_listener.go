@@ -0,0 +1,195 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-metrics/statsdproto"
+)
+
+// StatsdListener receives metrics in the statsd line protocol over UDP or
+// TCP and emits them to a sink, so a service can relay the metrics of
+// sidecar processes through its own sinks. Lines are parsed with
+// statsdproto.Parse; their type is one of c (counter), g (gauge), ms, h or d
+// (sample) and kv (key/value). The name, split on ".", is the key, and the tags of DogStatsD
+// are labels; tags without a value have an empty one. Counters are scaled by
+// their sample rate, and gauges whose value starts with + or - are changed by
+// it rather than set. Sets and malformed lines are dropped.
+type StatsdListener struct {
+	sink     MetricSink
+	packet   net.PacketConn
+	listener net.Listener
+
+	gaugeLock sync.Mutex
+	gauges    map[string]float64
+
+	connLock sync.Mutex
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewStatsdListener listens on addr of network, one of udp, udp4, udp6, tcp,
+// tcp4 or tcp6, and emits the metrics received to sink until it is shut
+// down. For example, to relay to the global metrics:
+//
+//	l, err := metrics.NewStatsdListener("udp", "127.0.0.1:8125", metrics.Default())
+func NewStatsdListener(network, addr string, sink MetricSink) (*StatsdListener, error) {
+	l := &StatsdListener{
+		sink:   sink,
+		gauges: make(map[string]float64),
+		conns:  make(map[net.Conn]struct{}),
+	}
+	switch network {
+	case "udp", "udp4", "udp6":
+		packet, err := net.ListenPacket(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		l.packet = packet
+		l.wg.Add(1)
+		go l.readPackets()
+	case "tcp", "tcp4", "tcp6":
+		listener, err := net.Listen(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		l.listener = listener
+		l.wg.Add(1)
+		go l.accept()
+	default:
+		return nil, fmt.Errorf("unsupported network %q", network)
+	}
+	return l, nil
+}
+
+// Addr returns the address the listener is bound to, e.g. to find the port
+// chosen for ":0".
+func (l *StatsdListener) Addr() net.Addr {
+	if l.packet != nil {
+		return l.packet.LocalAddr()
+	}
+	return l.listener.Addr()
+}
+
+// Shutdown stops listening, closes the TCP connections and waits for the
+// metrics received to be emitted.
+func (l *StatsdListener) Shutdown() {
+	l.connLock.Lock()
+	l.closed = true
+	if l.packet != nil {
+		l.packet.Close()
+	} else {
+		l.listener.Close()
+	}
+	for conn := range l.conns {
+		conn.Close()
+	}
+	l.connLock.Unlock()
+	l.wg.Wait()
+}
+
+func (l *StatsdListener) readPackets() {
+	defer l.wg.Done()
+	buf := make([]byte, 65535)
+	for {
+		n, _, err := l.packet.ReadFrom(buf)
+		if err != nil {
+			if !l.isClosed() {
+				LogError("Error reading statsd packet", "addr", l.Addr(), "class", "read", "error", err)
+			}
+			return
+		}
+		for _, line := range bytes.Split(buf[:n], []byte{'\n'}) {
+			l.handleLine(string(line))
+		}
+	}
+}
+
+func (l *StatsdListener) accept() {
+	defer l.wg.Done()
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			if !l.isClosed() {
+				LogError("Error accepting statsd connection", "addr", l.Addr(), "class", "accept", "error", err)
+			}
+			return
+		}
+		l.connLock.Lock()
+		if l.closed {
+			l.connLock.Unlock()
+			conn.Close()
+			return
+		}
+		l.conns[conn] = struct{}{}
+		l.wg.Add(1)
+		l.connLock.Unlock()
+		go l.readConn(conn)
+	}
+}
+
+func (l *StatsdListener) readConn(conn net.Conn) {
+	defer l.wg.Done()
+	defer func() {
+		l.connLock.Lock()
+		delete(l.conns, conn)
+		l.connLock.Unlock()
+		conn.Close()
+	}()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		l.handleLine(scanner.Text())
+	}
+}
+
+func (l *StatsdListener) isClosed() bool {
+	l.connLock.Lock()
+	defer l.connLock.Unlock()
+	return l.closed
+}
+
+// handleLine emits the metric of a line
+func (l *StatsdListener) handleLine(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	sl, err := statsdproto.Parse(line)
+	if err != nil {
+		LogWarn("Dropping statsd line", "addr", l.Addr(), "line", line, "class", "parse", "error", err)
+		return
+	}
+
+	key := strings.Split(sl.Name, ".")
+	labels := make([]Label, len(sl.Tags))
+	for i, tag := range sl.Tags {
+		labels[i] = Label{Name: tag.Name, Value: tag.Value}
+	}
+	switch sl.Type {
+	case statsdproto.Counter:
+		value := sl.Value
+		if sl.SampleRate != 0 {
+			value /= sl.SampleRate
+		}
+		l.sink.IncrCounterWithLabels(key, float32(value), labels)
+	case statsdproto.Gauge:
+		id := string(statsdproto.Append(nil, statsdproto.Line{Name: sl.Name, Tags: sl.Tags}))
+		value := sl.Value
+		l.gaugeLock.Lock()
+		if sl.Relative {
+			value += l.gauges[id]
+		}
+		l.gauges[id] = value
+		l.gaugeLock.Unlock()
+		l.sink.SetGaugeWithLabels(key, float32(value), labels)
+	case statsdproto.Timer, statsdproto.Histogram, statsdproto.Distribution:
+		l.sink.AddSampleWithLabels(key, float32(sl.Value), labels)
+	case statsdproto.KeyValue:
+		l.sink.EmitKey(key, float32(sl.Value))
+	}
+}
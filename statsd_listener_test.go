@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStatsdListener(t *testing.T) {
+	for _, network := range []string{"udp", "tcp"} {
+		t.Run(network, func(t *testing.T) {
+			inm := NewInmemSink(time.Minute, time.Minute)
+			l, err := NewStatsdListener(network, "127.0.0.1:0", inm)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			conn, err := net.Dial(network, l.Addr().String())
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			_, err = conn.Write([]byte("api.requests:1|c|@0.5|#method:GET\n" +
+				"api.inflight:4|g\napi.inflight:-1|g\n" +
+				"api.latency:12.5|ms\napi.users:alice|s\nbad line\n"))
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			conn.Close()
+
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				data := inm.Data()[0]
+				data.RLock()
+				c, cok := data.Counters["api.requests;method=GET"]
+				g, gok := data.Gauges["api.inflight"]
+				s, sok := data.Samples["api.latency"]
+				done := cok && gok && sok && c.Sum == 2 && g.Value == 3 && s.Sum == 12.5
+				data.RUnlock()
+				if done {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("metrics not received: %v %v %v", data.Counters, data.Gauges, data.Samples)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			l.Shutdown()
+		})
+	}
+}
@@ -18,6 +18,16 @@ func TestStatsd_Flatten(t *testing.T) {
 	}
 }
 
+func TestStatsd_GraphiteTags(t *testing.T) {
+	s := &StatsdSink{}
+	s.EnableGraphiteTags()
+	labels := []Label{{"method", "GET"}, {"empty", ""}, {"a;b", "c d;~"}}
+	flat := s.flattenKeyLabels([]string{"api", "requests"}, labels)
+	if flat != "api.requests;method=GET;a_b=c_d__" {
+		t.Fatalf("bad flat %q", flat)
+	}
+}
+
 func TestStatsd_PushFullQueue(t *testing.T) {
 	q := make(chan string, 1)
 	q <- "full"
@@ -0,0 +1,147 @@
+// Package statsdproto encodes and decodes the lines of the statsd protocol,
+// with the sample rates of statsd and the tags of DogStatsD:
+//
+//	<name>:<value>|<type>[|@<sample rate>][|#<tag>[:<value>],...]
+package statsdproto
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Type is the type of a metric
+type Type string
+
+const (
+	Counter      Type = "c"
+	Gauge        Type = "g"
+	Timer        Type = "ms"
+	Histogram    Type = "h"
+	Distribution Type = "d"
+	Set          Type = "s"
+
+	// KeyValue is the key/value type of statsite
+	KeyValue Type = "kv"
+)
+
+// ErrUnknownType is returned by Parse for lines of an unknown type
+var ErrUnknownType = errors.New("unknown metric type")
+
+// Tag is a DogStatsD tag. Tags without a value have an empty Value.
+type Tag struct {
+	Name  string
+	Value string
+}
+
+// Line is a line of the statsd protocol
+type Line struct {
+	Name string
+	Type Type
+
+	// Value is the value of metrics other than sets
+	Value float64
+
+	// Relative is set for gauges changed by Value rather than set to it,
+	// whose values start with + or -
+	Relative bool
+
+	// Member is the value of sets
+	Member string
+
+	// SampleRate is the rate at which the metric is sampled, between 0 and
+	// 1. Zero means 1, and isn't encoded.
+	SampleRate float64
+
+	Tags []Tag
+}
+
+// Parse parses a line, without its trailing newline.
+func Parse(line string) (Line, error) {
+	colon := strings.LastIndexByte(line, ':')
+	if i := strings.IndexByte(line, '|'); i >= 0 {
+		// Tags contain colons as well
+		colon = strings.LastIndexByte(line[:i], ':')
+	}
+	if colon <= 0 {
+		return Line{}, errors.New("missing value")
+	}
+	l := Line{Name: line[:colon]}
+	fields := strings.Split(line[colon+1:], "|")
+	if len(fields) < 2 {
+		return Line{}, errors.New("missing type")
+	}
+
+	l.Type = Type(fields[1])
+	switch l.Type {
+	case Counter, Gauge, Timer, Histogram, Distribution, KeyValue:
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return Line{}, fmt.Errorf("bad value: %w", err)
+		}
+		l.Value = value
+		l.Relative = l.Type == Gauge && (fields[0][0] == '+' || fields[0][0] == '-')
+	case Set:
+		l.Member = fields[0]
+	default:
+		return Line{}, fmt.Errorf("%w %q", ErrUnknownType, fields[1])
+	}
+
+	for _, field := range fields[2:] {
+		switch {
+		case strings.HasPrefix(field, "@"):
+			rate, err := strconv.ParseFloat(field[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return Line{}, fmt.Errorf("bad sample rate %q", field)
+			}
+			l.SampleRate = rate
+		case strings.HasPrefix(field, "#"):
+			for _, tag := range strings.Split(field[1:], ",") {
+				name, value, _ := strings.Cut(tag, ":")
+				if name != "" {
+					l.Tags = append(l.Tags, Tag{Name: name, Value: value})
+				}
+			}
+		}
+	}
+	return l, nil
+}
+
+// Append appends the encoding of l, without a trailing newline, to dst.
+func Append(dst []byte, l Line) []byte {
+	dst = append(dst, l.Name...)
+	dst = append(dst, ':')
+	if l.Type == Set {
+		dst = append(dst, l.Member...)
+	} else {
+		if l.Relative && l.Value >= 0 {
+			dst = append(dst, '+')
+		}
+		dst = strconv.AppendFloat(dst, l.Value, 'f', -1, 64)
+	}
+	dst = append(dst, '|')
+	dst = append(dst, l.Type...)
+	if l.SampleRate != 0 && l.SampleRate != 1 {
+		dst = append(dst, "|@"...)
+		dst = strconv.AppendFloat(dst, l.SampleRate, 'f', -1, 64)
+	}
+	for i, tag := range l.Tags {
+		if i == 0 {
+			dst = append(dst, "|#"...)
+		} else {
+			dst = append(dst, ',')
+		}
+		dst = append(dst, tag.Name...)
+		if tag.Value != "" {
+			dst = append(dst, ':')
+			dst = append(dst, tag.Value...)
+		}
+	}
+	return dst
+}
+
+// String returns the encoding of l.
+func (l Line) String() string {
+	return string(Append(nil, l))
+}
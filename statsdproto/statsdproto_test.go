@@ -0,0 +1,50 @@
+package statsdproto
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		line string
+		want Line
+	}{
+		{"api.requests:2|c|@0.5|#method:GET,canary", Line{
+			Name: "api.requests", Type: Counter, Value: 2, SampleRate: 0.5,
+			Tags: []Tag{{"method", "GET"}, {"canary", ""}},
+		}},
+		{"api.inflight:-1|g", Line{Name: "api.inflight", Type: Gauge, Value: -1, Relative: true}},
+		{"api.latency:12.5|ms", Line{Name: "api.latency", Type: Timer, Value: 12.5}},
+		{"api.users:alice|s", Line{Name: "api.users", Type: Set, Member: "alice"}},
+	}
+	for _, c := range cases {
+		l, err := Parse(c.line)
+		if err != nil {
+			t.Fatalf("%q: err: %v", c.line, err)
+		}
+		if !reflect.DeepEqual(l, c.want) {
+			t.Fatalf("%q: got %#v, want %#v", c.line, l, c.want)
+		}
+		if s := l.String(); s != c.line {
+			t.Fatalf("got %q, want %q", s, c.line)
+		}
+	}
+
+	for _, line := range []string{"api.requests", "api.requests:1", "api.requests:x|c", "api:1|c|@2"} {
+		if _, err := Parse(line); err == nil {
+			t.Fatalf("expected an error for %q", line)
+		}
+	}
+	if _, err := Parse("api:1|x"); !errors.Is(err, ErrUnknownType) {
+		t.Fatalf("err = %v, want ErrUnknownType", err)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	l := Line{Name: "api.inflight", Type: Gauge, Value: 3, Relative: true, SampleRate: 1}
+	if s := string(Append([]byte("x\n"), l)); s != "x\napi.inflight:+3|g" {
+		t.Fatalf("bad line: %q", s)
+	}
+}
@@ -3,7 +3,6 @@ package metrics
 import (
 	"bufio"
 	"fmt"
-	"log"
 	"net"
 	"net/url"
 	"strings"
@@ -28,6 +27,9 @@ func NewStatsiteSinkFromURL(u *url.URL) (MetricSink, error) {
 type StatsiteSink struct {
 	addr        string
 	metricQueue chan string
+	sanitizer   Sanitizer
+
+	graphiteTags bool
 }
 
 // NewStatsiteSink is used to create a new StatsiteSink
@@ -80,23 +82,44 @@ func (s *StatsiteSink) AddSampleWithLabels(key []string, val float32, labels []L
 	s.pushMetric(fmt.Sprintf("%s:%f|ms\n", flatKey, val))
 }
 
+func (s *StatsiteSink) AddPrecisionSample(key []string, val float64) {
+	flatKey := s.flattenKey(key)
+	s.pushMetric(fmt.Sprintf("%s:%f|ms\n", flatKey, val))
+}
+
+func (s *StatsiteSink) AddPrecisionSampleWithLabels(key []string, val float64, labels []Label) {
+	flatKey := s.flattenKeyLabels(key, labels)
+	s.pushMetric(fmt.Sprintf("%s:%f|ms\n", flatKey, val))
+}
+
+// SetSanitizer replaces the StatsdSanitizer used to normalize keys. It must
+// be called before any metrics are emitted.
+func (s *StatsiteSink) SetSanitizer(sanitizer Sanitizer) {
+	s.sanitizer = sanitizer
+}
+
+// EnableGraphiteTags appends labels to keys in the tag syntax of Graphite,
+// e.g. requests;method=GET, instead of as dotted segments of their values,
+// for servers forwarding to Graphite 1.1 or later. It must be called before
+// any metrics are emitted.
+func (s *StatsiteSink) EnableGraphiteTags() {
+	s.graphiteTags = true
+}
+
 // Flattens the key for formatting, removes spaces
 func (s *StatsiteSink) flattenKey(parts []string) string {
 	joined := strings.Join(parts, ".")
-	return strings.Map(func(r rune) rune {
-		switch r {
-		case ':':
-			fallthrough
-		case ' ':
-			return '_'
-		default:
-			return r
-		}
-	}, joined)
+	if s.sanitizer == nil {
+		return StatsdSanitizer.SanitizeName(joined)
+	}
+	return s.sanitizer.SanitizeName(joined)
 }
 
 // Flattens the key along with labels for formatting, removes spaces
 func (s *StatsiteSink) flattenKeyLabels(parts []string, labels []Label) string {
+	if s.graphiteTags {
+		return s.flattenKey(parts) + graphiteTags(s.sanitizer, labels)
+	}
 	for _, label := range labels {
 		parts = append(parts, label.Value)
 	}
@@ -124,7 +147,7 @@ CONNECT:
 	// Attempt to connect
 	sock, err = net.Dial("tcp", s.addr)
 	if err != nil {
-		log.Printf("[ERR] Error connecting to statsite! Err: %s", err)
+		LogError("Error connecting to statsite", "sink", "statsite", "addr", s.addr, "class", "connect", "error", err)
 		goto WAIT
 	}
 	defer sock.Close()
@@ -143,12 +166,12 @@ CONNECT:
 			// Try to send to statsite
 			_, err := buffered.Write([]byte(metric))
 			if err != nil {
-				log.Printf("[ERR] Error writing to statsite! Err: %s", err)
+				LogError("Error writing to statsite", "sink", "statsite", "addr", s.addr, "class", "write", "error", err)
 				goto WAIT
 			}
 		case <-ticker.C:
 			if err := buffered.Flush(); err != nil {
-				log.Printf("[ERR] Error flushing to statsite! Err: %s", err)
+				LogError("Error flushing to statsite", "sink", "statsite", "addr", s.addr, "class", "flush", "error", err)
 				goto WAIT
 			}
 		}
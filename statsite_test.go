@@ -17,6 +17,16 @@ func TestStatsite_Flatten(t *testing.T) {
 	}
 }
 
+func TestStatsite_GraphiteTags(t *testing.T) {
+	s := &StatsiteSink{}
+	s.EnableGraphiteTags()
+	labels := []Label{{"method", "GET"}, {"empty", ""}, {"a;b", "c d;~"}}
+	flat := s.flattenKeyLabels([]string{"api", "requests"}, labels)
+	if flat != "api.requests;method=GET;a_b=c_d__" {
+		t.Fatalf("bad flat %q", flat)
+	}
+}
+
 func TestStatsite_PushFullQueue(t *testing.T) {
 	q := make(chan string, 1)
 	q <- "full"